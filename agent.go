@@ -5,13 +5,13 @@ package gocbcore
 import (
 	"crypto/tls"
 	"crypto/x509"
-	"errors"
 	"fmt"
 	"io/ioutil"
 	"net"
 	"net/http"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/couchbaselabs/gocbconnstr"
@@ -36,7 +36,12 @@ type Agent struct {
 	useCompression       bool
 	useDurations         bool
 	disableDecompression bool
+	usePooledBuffers     bool
 	useCollections       bool
+	useClusterMapNotifs  bool
+	useForwardVbMap      bool
+
+	preferredServerGroup string
 
 	compressionMinSize  int
 	compressionMinRatio float64
@@ -44,6 +49,20 @@ type Agent struct {
 	closeNotify       chan struct{}
 	cccpLooperDoneSig chan struct{}
 	httpLooperDoneSig chan struct{}
+	bootstrapDoneSig  chan struct{}
+
+	configCache           ConfigCache
+	configCacheKey        string
+	configCacheLock       sync.Mutex
+	configCacheRev        int64
+	configCacheUUID       string
+	bootstrappedFromCache bool
+
+	connStr            string
+	seedMemdAddrs      []string
+	seedHttpAddrs      []string
+	clusterLostTimeout time.Duration
+	lastConfigTime     int64
 
 	configLock  sync.Mutex
 	routingInfo routeDataPtr
@@ -63,12 +82,32 @@ type Agent struct {
 	confCccpMaxWait      time.Duration
 	confCccpPollPeriod   time.Duration
 
+	confCccpFallbackPollPeriod time.Duration
+	clusterMapNotifsActive     int32
+
 	serverConnectTimeout time.Duration
 	serverWaitTimeout    time.Duration
 	nmvRetryDelay        time.Duration
 	kvPoolSize           int
 	maxQueueSize         int
 
+	kvKeepAliveInterval  time.Duration
+	kvKeepAliveMaxMissed int
+
+	circuitBreakers *circuitBreakerSet
+
+	retryStrategy RetryStrategy
+	transcoder    Transcoder
+
+	lockHandlesLock           sync.Mutex
+	lockHandles               map[*LockHandle]struct{}
+	lockReleaseOnCloseTimeout time.Duration
+
+	topologyLock        sync.Mutex
+	topologyListeners   []TopologyListener
+	topologyEvents      []*TopologyEvent
+	topologyDispatching bool
+
 	zombieLock      sync.RWMutex
 	zombieOps       []*zombieLogEntry
 	useZombieLogger bool
@@ -123,6 +162,24 @@ type AgentConfig struct {
 	DisableDecompression bool
 	UseCollections       bool
 
+	// UsePooledBuffers makes the values of get results be read into buffers taken from
+	// a pool, rather than freshly allocated ones.  The buffer is reused once Release is
+	// called on the result, after which its value must no longer be referenced.
+	UsePooledBuffers bool
+
+	// UseClusterMapNotifications enables duplex mode, allowing the server to push new
+	// cluster configurations to the client rather than relying on polling.
+	UseClusterMapNotifications bool
+
+	// UseForwardVbucketMap routes requests using the fast-forward vbucket map whenever a
+	// rebalance is in progress, rather than only after a not-my-vbucket response.
+	UseForwardVbucketMap bool
+
+	// PreferredServerGroup is the server group, or availability zone, which the client
+	// is running in.  Replica reads using ReplicaReadModePreferServerGroup or
+	// ReplicaReadModeServerGroupOnly read the copies stored in this group first.
+	PreferredServerGroup string
+
 	CompressionMinSize  int
 	CompressionMinRatio float64
 
@@ -131,11 +188,32 @@ type AgentConfig struct {
 	CccpMaxWait      time.Duration
 	CccpPollPeriod   time.Duration
 
+	// CccpFallbackPollPeriod is the period to wait between CCCP config polling when
+	// the server is pushing cluster configurations to the client.
+	CccpFallbackPollPeriod time.Duration
+
 	ConnectTimeout       time.Duration
 	ServerConnectTimeout time.Duration
 	NmvRetryDelay        time.Duration
 	KvPoolSize           int
 	MaxQueueSize         int
+	RetryStrategy        RetryStrategy
+	Transcoder           Transcoder
+
+	// KvKeepAliveInterval is how long a memcached connection may go without receiving
+	// anything from the server before a NOOP is sent to check that it is still alive.
+	KvKeepAliveInterval time.Duration
+
+	// KvKeepAliveMaxMissed is the number of NOOPs in a row which may go unanswered
+	// before the connection is declared dead and reconnected.
+	KvKeepAliveMaxMissed int
+
+	// DisableKvKeepAlive disables sending NOOPs over idle memcached connections.
+	DisableKvKeepAlive bool
+
+	// CircuitBreakerConfig configures the circuit breakers which make requests to an
+	// unhealthy memcached node or HTTP endpoint fail fast with ErrCircuitBreakerOpen.
+	CircuitBreakerConfig CircuitBreakerConfig
 
 	HttpMaxIdleConns        int
 	HttpMaxIdleConnsPerHost int
@@ -151,6 +229,24 @@ type AgentConfig struct {
 	UseDcpExpiry     bool
 
 	EnableStreamId bool
+
+	// ConfigCache enables caching of the bucket configuration.  When a cached configuration
+	// is available the agent starts routing operations with it immediately, while the
+	// current configuration is fetched from the cluster in the background.
+	ConfigCache ConfigCache
+
+	// ClusterLostTimeout is how long the agent must be unable to reach any node or
+	// fetch a config before it re-resolves its seed nodes and bootstraps again.
+	ClusterLostTimeout time.Duration
+
+	// LockReleaseOnCloseTimeout is how long Close waits for the locks which are still held
+	// to be released.  If zero, the unlocks are dispatched without waiting for them, in
+	// which case the server may only release the locks once their lock time expires.
+	LockReleaseOnCloseTimeout time.Duration
+
+	// connStr is the connection string the config was populated from, if any, which
+	// is resolved again to find the seed nodes when re-bootstrapping.
+	connStr string
 }
 
 // FromConnStr populates the AgentConfig with information from a
@@ -165,8 +261,16 @@ type AgentConfig struct {
 //   http_retry_delay (int) - Period to wait between retrying nodes for HTTP config in ms.
 //   config_poll_floor_interval (int) - Minimum time to wait between fetching configs via CCCP in ms.
 //   config_poll_interval (int) - Period to wait between CCCP config polling in ms.
+//   config_poll_fallback_interval (int) - Period to wait between CCCP config polling in ms when configs are pushed by the server.
+//   cluster_map_notifications (bool) - Whether to have the server push new cluster configurations to the client.
+//   use_forward_vbucket_map (bool) - Whether to route to the future owners of vbuckets as soon as a rebalance starts.
+//   preferred_server_group (string) - The server group to read replicas from first.
 //   kv_pool_size (int) - The number of connections to establish per node.
 //   max_queue_size (int) - The maximum size of the operation queues per node.
+//   kv_keepalive (bool) - Whether to send NOOPs over idle memcached connections.
+//   kv_keepalive_interval (int) - Period without receiving anything on a connection before a NOOP is sent in ms.
+//   kv_keepalive_max_missed (int) - Number of unanswered NOOPs after which a connection is reconnected.
+//   circuit_breaker (bool) - Whether to fail requests fast to nodes and endpoints which keep failing.
 //   use_kverrmaps (bool) - Whether to enable error maps from the server.
 //   use_enhanced_errors (bool) - Whether to enable enhanced error information.
 //   fetch_mutation_tokens (bool) - Whether to fetch mutation tokens for operations.
@@ -178,6 +282,9 @@ type AgentConfig struct {
 //   http_max_idle_conns_per_host (int) - Maximum number of idle http connections in the pool per host.
 //   http_idle_conn_timeout (int) - Maximum length of time for an idle connection to stay in the pool in ms.
 //   network (string) - The network type to use
+//   config_cache_dir (string) - Directory in which to cache bucket configurations.
+//   cluster_lost_timeout (int) - Period without contact with the cluster after which the seed nodes are re-resolved in ms.
+//   lock_release_on_close_timeout (int) - Maximum period to wait for held locks to be released when closing in ms.
 func (config *AgentConfig) FromConnStr(connStr string) error {
 	baseSpec, err := gocbconnstr.Parse(connStr)
 	if err != nil {
@@ -197,38 +304,13 @@ func (config *AgentConfig) FromConnStr(connStr string) error {
 		return optValue[len(optValue)-1], true
 	}
 
-	// Grab the resolved hostnames into a set of string arrays
-	var httpHosts []string
-	for _, specHost := range spec.HttpHosts {
-		httpHosts = append(httpHosts, fmt.Sprintf("%s:%d", specHost.Host, specHost.Port))
-	}
-
-	var memdHosts []string
-	for _, specHost := range spec.MemdHosts {
-		memdHosts = append(memdHosts, fmt.Sprintf("%s:%d", specHost.Host, specHost.Port))
-	}
-
-	// Get bootstrap_on option to determine which, if any, of the bootstrap nodes should be cleared
-	switch val, _ := fetchOption("bootstrap_on"); val {
-	case "http":
-		memdHosts = nil
-		if len(httpHosts) == 0 {
-			return errors.New("bootstrap_on=http but no HTTP hosts in connection string")
-		}
-	case "cccp":
-		httpHosts = nil
-		if len(memdHosts) == 0 {
-			return errors.New("bootstrap_on=cccp but no CCCP/Memcached hosts in connection string")
-		}
-	case "both":
-	case "":
-		// Do nothing
-		break
-	default:
-		return errors.New("bootstrap_on={http,cccp,both}")
+	memdHosts, httpHosts, err := seedAddrsFromSpec(spec)
+	if err != nil {
+		return err
 	}
 	config.MemdAddrs = memdHosts
 	config.HttpAddrs = httpHosts
+	config.connStr = connStr
 
 	var tlsConfig *tls.Config
 	if spec.UseSsl {
@@ -331,6 +413,34 @@ func (config *AgentConfig) FromConnStr(connStr string) error {
 		config.CccpPollPeriod = time.Duration(val) * time.Millisecond
 	}
 
+	if valStr, ok := fetchOption("config_poll_fallback_interval"); ok {
+		val, err := strconv.ParseInt(valStr, 10, 64)
+		if err != nil {
+			return fmt.Errorf("config poll fallback interval option must be a number")
+		}
+		config.CccpFallbackPollPeriod = time.Duration(val) * time.Millisecond
+	}
+
+	if valStr, ok := fetchOption("use_forward_vbucket_map"); ok {
+		val, err := strconv.ParseBool(valStr)
+		if err != nil {
+			return fmt.Errorf("use_forward_vbucket_map option must be a boolean")
+		}
+		config.UseForwardVbucketMap = val
+	}
+
+	if valStr, ok := fetchOption("preferred_server_group"); ok {
+		config.PreferredServerGroup = valStr
+	}
+
+	if valStr, ok := fetchOption("cluster_map_notifications"); ok {
+		val, err := strconv.ParseBool(valStr)
+		if err != nil {
+			return fmt.Errorf("cluster_map_notifications option must be a boolean")
+		}
+		config.UseClusterMapNotifications = val
+	}
+
 	// This option is experimental
 	if valStr, ok := fetchOption("kv_pool_size"); ok {
 		val, err := strconv.ParseInt(valStr, 10, 64)
@@ -340,6 +450,38 @@ func (config *AgentConfig) FromConnStr(connStr string) error {
 		config.KvPoolSize = int(val)
 	}
 
+	if valStr, ok := fetchOption("kv_keepalive"); ok {
+		val, err := strconv.ParseBool(valStr)
+		if err != nil {
+			return fmt.Errorf("kv_keepalive option must be a boolean")
+		}
+		config.DisableKvKeepAlive = !val
+	}
+
+	if valStr, ok := fetchOption("circuit_breaker"); ok {
+		val, err := strconv.ParseBool(valStr)
+		if err != nil {
+			return fmt.Errorf("circuit_breaker option must be a boolean")
+		}
+		config.CircuitBreakerConfig.Disabled = !val
+	}
+
+	if valStr, ok := fetchOption("kv_keepalive_interval"); ok {
+		val, err := strconv.ParseInt(valStr, 10, 64)
+		if err != nil {
+			return fmt.Errorf("kv keepalive interval option must be a number")
+		}
+		config.KvKeepAliveInterval = time.Duration(val) * time.Millisecond
+	}
+
+	if valStr, ok := fetchOption("kv_keepalive_max_missed"); ok {
+		val, err := strconv.ParseInt(valStr, 10, 64)
+		if err != nil {
+			return fmt.Errorf("kv keepalive max missed option must be a number")
+		}
+		config.KvKeepAliveMaxMissed = int(val)
+	}
+
 	// This option is experimental
 	if valStr, ok := fetchOption("max_queue_size"); ok {
 		val, err := strconv.ParseInt(valStr, 10, 64)
@@ -461,6 +603,26 @@ func (config *AgentConfig) FromConnStr(connStr string) error {
 		config.NetworkType = valStr
 	}
 
+	if valStr, ok := fetchOption("config_cache_dir"); ok {
+		config.ConfigCache = NewFileConfigCache(valStr)
+	}
+
+	if valStr, ok := fetchOption("cluster_lost_timeout"); ok {
+		val, err := strconv.ParseInt(valStr, 10, 64)
+		if err != nil {
+			return fmt.Errorf("cluster lost timeout option must be a number")
+		}
+		config.ClusterLostTimeout = time.Duration(val) * time.Millisecond
+	}
+
+	if valStr, ok := fetchOption("lock_release_on_close_timeout"); ok {
+		val, err := strconv.ParseInt(valStr, 10, 64)
+		if err != nil {
+			return fmt.Errorf("lock release on close timeout option must be a number")
+		}
+		config.LockReleaseOnCloseTimeout = time.Duration(val) * time.Millisecond
+	}
+
 	if valStr, ok := fetchOption("dcp_priority"); ok {
 		var priority DcpAgentPriority
 		switch valStr {
@@ -502,7 +664,7 @@ func makeDefaultAuthHandler(authProvider AuthProvider, bucketName string) AuthFu
 			}
 		}
 
-		if client.SupportsFeature(FeatureSelectBucket) {
+		if bucketName != "" && client.SupportsFeature(FeatureSelectBucket) {
 			if err := client.ExecSelectBucket([]byte(bucketName), deadline); err != nil {
 				return err
 			}
@@ -621,32 +783,52 @@ func createAgent(config *AgentConfig, initFn memdInitFunc) (*Agent, error) {
 		httpCli: &http.Client{
 			Transport: httpTransport,
 		},
-		closeNotify:           make(chan struct{}),
-		useZombieLogger:       config.UseZombieLogger,
-		tracer:                tracer,
-		useMutationTokens:     config.UseMutationTokens,
-		useKvErrorMaps:        config.UseKvErrorMaps,
-		useEnhancedErrors:     config.UseEnhancedErrors,
-		useCompression:        config.UseCompression,
-		compressionMinSize:    32,
-		compressionMinRatio:   0.83,
-		useDurations:          config.UseDurations,
-		noRootTraceSpans:      config.NoRootTraceSpans,
-		useCollections:        config.UseCollections,
-		serverFailures:        make(map[string]time.Time),
-		serverConnectTimeout:  7000 * time.Millisecond,
-		serverWaitTimeout:     5 * time.Second,
-		nmvRetryDelay:         100 * time.Millisecond,
-		kvPoolSize:            1,
-		maxQueueSize:          maxQueueSize,
-		confHttpRetryDelay:    10 * time.Second,
-		confHttpRedialPeriod:  10 * time.Second,
-		confCccpMaxWait:       3 * time.Second,
-		confCccpPollPeriod:    2500 * time.Millisecond,
-		dcpPriority:           config.DcpAgentPriority,
-		disableDecompression:  config.DisableDecompression,
-		useDcpExpiry:          config.UseDcpExpiry,
-		durabilityLevelStatus: durabilityLevelStatusUnknown,
+		closeNotify:                make(chan struct{}),
+		useZombieLogger:            config.UseZombieLogger,
+		tracer:                     tracer,
+		useMutationTokens:          config.UseMutationTokens,
+		useKvErrorMaps:             config.UseKvErrorMaps,
+		useEnhancedErrors:          config.UseEnhancedErrors,
+		useCompression:             config.UseCompression,
+		compressionMinSize:         32,
+		compressionMinRatio:        0.83,
+		useDurations:               config.UseDurations,
+		noRootTraceSpans:           config.NoRootTraceSpans,
+		useCollections:             config.UseCollections,
+		useClusterMapNotifs:        config.UseClusterMapNotifications,
+		useForwardVbMap:            config.UseForwardVbucketMap,
+		preferredServerGroup:       config.PreferredServerGroup,
+		serverFailures:             make(map[string]time.Time),
+		serverConnectTimeout:       7000 * time.Millisecond,
+		serverWaitTimeout:          5 * time.Second,
+		nmvRetryDelay:              100 * time.Millisecond,
+		kvPoolSize:                 1,
+		maxQueueSize:               maxQueueSize,
+		kvKeepAliveInterval:        30 * time.Second,
+		kvKeepAliveMaxMissed:       2,
+		circuitBreakers:            newCircuitBreakerSet(config.CircuitBreakerConfig),
+		confHttpRetryDelay:         10 * time.Second,
+		confHttpRedialPeriod:       10 * time.Second,
+		confCccpMaxWait:            3 * time.Second,
+		confCccpPollPeriod:         2500 * time.Millisecond,
+		confCccpFallbackPollPeriod: 30 * time.Second,
+		connStr:                    config.connStr,
+		seedMemdAddrs:              config.MemdAddrs,
+		seedHttpAddrs:              config.HttpAddrs,
+		clusterLostTimeout:         30 * time.Second,
+		dcpPriority:                config.DcpAgentPriority,
+		disableDecompression:       config.DisableDecompression,
+		usePooledBuffers:           config.UsePooledBuffers,
+		useDcpExpiry:               config.UseDcpExpiry,
+		durabilityLevelStatus:      durabilityLevelStatusUnknown,
+		retryStrategy:              config.RetryStrategy,
+		transcoder:                 config.Transcoder,
+	}
+	if c.retryStrategy == nil {
+		c.retryStrategy = &errMapRetryStrategy{}
+	}
+	if c.transcoder == nil {
+		c.transcoder = NewJSONTranscoder()
 	}
 	c.cidMgr = newCollectionIdManager(c, maxQueueSize)
 
@@ -667,6 +849,15 @@ func createAgent(config *AgentConfig, initFn memdInitFunc) (*Agent, error) {
 	if config.MaxQueueSize > 0 {
 		c.maxQueueSize = config.MaxQueueSize
 	}
+	if config.KvKeepAliveInterval > 0 {
+		c.kvKeepAliveInterval = config.KvKeepAliveInterval
+	}
+	if config.KvKeepAliveMaxMissed > 0 {
+		c.kvKeepAliveMaxMissed = config.KvKeepAliveMaxMissed
+	}
+	if config.DisableKvKeepAlive {
+		c.kvKeepAliveInterval = 0
+	}
 	if config.HttpRetryDelay > 0 {
 		c.confHttpRetryDelay = config.HttpRetryDelay
 	}
@@ -679,6 +870,15 @@ func createAgent(config *AgentConfig, initFn memdInitFunc) (*Agent, error) {
 	if config.CccpPollPeriod > 0 {
 		c.confCccpPollPeriod = config.CccpPollPeriod
 	}
+	if config.CccpFallbackPollPeriod > 0 {
+		c.confCccpFallbackPollPeriod = config.CccpFallbackPollPeriod
+	}
+	if config.ClusterLostTimeout > 0 {
+		c.clusterLostTimeout = config.ClusterLostTimeout
+	}
+	if config.LockReleaseOnCloseTimeout > 0 {
+		c.lockReleaseOnCloseTimeout = config.LockReleaseOnCloseTimeout
+	}
 	if config.CompressionMinSize > 0 {
 		c.compressionMinSize = config.CompressionMinSize
 	}
@@ -690,8 +890,15 @@ func createAgent(config *AgentConfig, initFn memdInitFunc) (*Agent, error) {
 	}
 
 	deadline := time.Now().Add(connectTimeout)
-	if err := c.connect(config.MemdAddrs, config.HttpAddrs, deadline); err != nil {
-		return nil, err
+	if config.ConfigCache != nil {
+		c.configCache = config.ConfigCache
+		c.configCacheKey = makeConfigCacheKey(config.BucketName, config.MemdAddrs, config.HttpAddrs)
+	}
+	c.markConfigReceived()
+	if c.configCache == nil || !c.bootstrapFromCache(config.MemdAddrs, config.HttpAddrs, deadline) {
+		if err := c.connect(config.MemdAddrs, config.HttpAddrs, deadline); err != nil {
+			return nil, err
+		}
 	}
 
 	if config.UseZombieLogger {
@@ -717,6 +924,13 @@ func (agent *Agent) connect(memdAddrs, httpAddrs []string, deadline time.Time) e
 	logDebugf("Attempting to connect...")
 
 	for _, thisHostPort := range memdAddrs {
+		// A background bootstrap must stop as soon as the agent is closed.
+		select {
+		case <-agent.closeNotify:
+			return ErrShutdown
+		default:
+		}
+
 		logDebugf("Trying server at %s", thisHostPort)
 
 		srvDeadlineTm := time.Now().Add(agent.serverConnectTimeout)
@@ -734,15 +948,23 @@ func (agent *Agent) connect(memdAddrs, httpAddrs []string, deadline time.Time) e
 			continue
 		}
 
-		if agent.useCollections && !checkSupportsFeature(client.features, FeatureCollections) {
-			logDebugf("Disabling collections as unsupported")
-			agent.useCollections = false
+		// When bootstrapping from a cached config these were already determined from
+		// it, and operations may be using them.
+		if !agent.bootstrappedFromCache {
+			if agent.useCollections && !checkSupportsFeature(client.features, FeatureCollections) {
+				logDebugf("Disabling collections as unsupported")
+				agent.useCollections = false
+			}
+
+			if checkSupportsFeature(client.features, FeatureEnhancedDurability) {
+				agent.durabilityLevelStatus = durabilityLevelStatusSupported
+			} else {
+				agent.durabilityLevelStatus = durabilityLevelStatusUnsupported
+			}
 		}
 
-		if checkSupportsFeature(client.features, FeatureEnhancedDurability) {
-			agent.durabilityLevelStatus = durabilityLevelStatusSupported
-		} else {
-			agent.durabilityLevelStatus = durabilityLevelStatusUnsupported
+		if checkSupportsFeature(client.features, FeatureClusterMapNotif) {
+			atomic.StoreInt32(&agent.clusterMapNotifsActive, 1)
 		}
 
 		disconnectClient := func() {
@@ -753,7 +975,8 @@ func (agent *Agent) connect(memdAddrs, httpAddrs []string, deadline time.Time) e
 		}
 
 		syncCli := syncClient{
-			client: client,
+			client:      client,
+			closeNotify: agent.closeNotify,
 		}
 
 		logDebugf("Attempting to request CCCP configuration")
@@ -778,7 +1001,8 @@ func (agent *Agent) connect(memdAddrs, httpAddrs []string, deadline time.Time) e
 			continue
 		}
 
-		if !bk.supportsCccp() {
+		// Cluster configs are only available over CCCP from servers which support it.
+		if agent.bucket != "" && !bk.supportsCccp() {
 			logDebugf("Bucket does not support CCCP")
 			disconnectClient()
 			break
@@ -794,22 +1018,26 @@ func (agent *Agent) connect(memdAddrs, httpAddrs []string, deadline time.Time) e
 
 		logDebugf("Successfully connected")
 
-		// Build some fake routing data, this is used to indicate that
-		//  client is "alive".  A nil routeData causes immediate shutdown.
-		agent.routingInfo.Update(nil, &routeData{
-			revId: -1,
-		})
-
 		// TODO(brett19): Save the client that we build for bootstrap
 		disconnectClient()
 
-		if routeCfg.vbMap != nil {
-			agent.numVbuckets = routeCfg.vbMap.NumVbuckets()
-		} else {
-			agent.numVbuckets = 0
+		if !agent.bootstrappedFromCache {
+			// Build some fake routing data, this is used to indicate that
+			//  client is "alive".  A nil routeData causes immediate shutdown.
+			agent.routingInfo.Update(nil, &routeData{
+				revId: -1,
+			})
+
+			if routeCfg.vbMap != nil {
+				agent.numVbuckets = routeCfg.vbMap.NumVbuckets()
+			} else {
+				agent.numVbuckets = 0
+			}
 		}
 
+		agent.markConfigReceived()
 		agent.applyConfig(routeCfg)
+		agent.storeCachedConfig(bk)
 
 		agent.cccpLooperDoneSig = make(chan struct{})
 		go agent.cccpLooper()
@@ -827,30 +1055,35 @@ func (agent *Agent) connect(memdAddrs, httpAddrs []string, deadline time.Time) e
 			epList = append(epList, fmt.Sprintf("https://%s", hostPort))
 		}
 	}
-	agent.routingInfo.Update(nil, &routeData{
-		revId:      -1,
-		mgmtEpList: epList,
-	})
+	if !agent.bootstrappedFromCache {
+		agent.routingInfo.Update(nil, &routeData{
+			revId:      -1,
+			mgmtEpList: epList,
+		})
+	}
 
 	var routeCfg *routeConfig
+	var routeBk *cfgBucket
 
 	logDebugf("Starting HTTP looper! %v", epList)
 	agent.httpLooperDoneSig = make(chan struct{})
-	go agent.httpLooper(func(cfg *cfgBucket, srcServer string, err error) bool {
+	go agent.httpLooper(epList, func(cfg *cfgBucket, srcServer string, err error) bool {
 		if err != nil {
 			signal <- err
 			return true
 		}
 
-		if agent.useCollections && !cfg.supports("collections") {
-			logDebugf("Disabling collections as unsupported")
-			agent.useCollections = false
-		}
+		if !agent.bootstrappedFromCache {
+			if agent.useCollections && !cfg.supports("collections") {
+				logDebugf("Disabling collections as unsupported")
+				agent.useCollections = false
+			}
 
-		if cfg.supports("syncreplication") {
-			agent.durabilityLevelStatus = durabilityLevelStatusSupported
-		} else {
-			agent.durabilityLevelStatus = durabilityLevelStatusUnsupported
+			if cfg.supports("syncreplication") {
+				agent.durabilityLevelStatus = durabilityLevelStatusSupported
+			} else {
+				agent.durabilityLevelStatus = durabilityLevelStatusUnsupported
+			}
 		}
 
 		newRouteCfg := agent.buildFirstRouteConfig(cfg, srcServer)
@@ -860,22 +1093,32 @@ func (agent *Agent) connect(memdAddrs, httpAddrs []string, deadline time.Time) e
 		}
 
 		routeCfg = newRouteCfg
+		routeBk = cfg
 		signal <- nil
 		return true
 	})
 
-	err := <-signal
+	var err error
+	select {
+	case err = <-signal:
+	case <-agent.closeNotify:
+		return ErrShutdown
+	}
 	if err != nil {
 		return err
 	}
 
-	if routeCfg.vbMap != nil {
-		agent.numVbuckets = routeCfg.vbMap.NumVbuckets()
-	} else {
-		agent.numVbuckets = 0
+	if !agent.bootstrappedFromCache {
+		if routeCfg.vbMap != nil {
+			agent.numVbuckets = routeCfg.vbMap.NumVbuckets()
+		} else {
+			agent.numVbuckets = 0
+		}
 	}
 
+	agent.markConfigReceived()
 	agent.applyConfig(routeCfg)
+	agent.storeCachedConfig(routeBk)
 
 	return nil
 }
@@ -919,6 +1162,9 @@ func (agent *Agent) buildFirstRouteConfig(bk *cfgBucket, srcServer string) *rout
 // Close shuts down the agent, disconnecting from all servers and failing
 // any outstanding operations with ErrShutdown.
 func (agent *Agent) Close() error {
+	// Release any locks which are still held before we stop accepting operations.
+	agent.releaseLockHandles()
+
 	agent.configLock.Lock()
 
 	// Clear the routingInfo so no new operations are performed
@@ -944,6 +1190,11 @@ func (agent *Agent) Close() error {
 
 	agent.configLock.Unlock()
 
+	// A background bootstrap may still be starting the loopers below.
+	if agent.bootstrapDoneSig != nil {
+		<-agent.bootstrapDoneSig
+	}
+
 	// Wait for our external looper goroutines to finish, note that if the
 	// specific looper wasn't used, it will be a nil value otherwise it
 	// will be an open channel till its closed to signal completion.
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"strconv"
 	"sync"
+	"time"
 
 	"github.com/opentracing/opentracing-go"
 )
@@ -103,6 +104,10 @@ type ManifestCallback func(manifest []byte, err error)
 // GetCollectionManifest fetches the current server manifest. This function will not update the client's collection
 // id cache.
 func (agent *Agent) GetCollectionManifest(cb ManifestCallback) (PendingOp, error) {
+	return agent.getCollectionManifest(time.Time{}, cb)
+}
+
+func (agent *Agent) getCollectionManifest(deadline time.Time, cb ManifestCallback) (PendingOp, error) {
 	handler := func(resp *memdQResponse, req *memdQRequest, err error) {
 		if err != nil {
 			cb(nil, err)
@@ -123,6 +128,8 @@ func (agent *Agent) GetCollectionManifest(cb ManifestCallback) (PendingOp, error
 			Value:    nil,
 		},
 		Callback: handler,
+		Deadline: deadline,
+		Priority: RequestPriorityCritical,
 	}
 	return agent.dispatchOp(req)
 }
@@ -132,7 +139,10 @@ type CollectionIdCallback func(manifestID uint64, collectionID uint32, err error
 
 // GetCollectionIDOptions are the options available to the GetCollectionID command.
 type GetCollectionIDOptions struct {
-	TraceContext opentracing.SpanContext
+	TraceContext  opentracing.SpanContext
+	Deadline      time.Time
+	RetryStrategy RetryStrategy
+	Priority      RequestPriority
 }
 
 // GetCollectionID fetches the collection id and manifest id that the collection belongs to, given a scope name
@@ -182,6 +192,9 @@ func (agent *Agent) GetCollectionID(scopeName string, collectionName string, opt
 		},
 		RootTraceContext: opts.TraceContext,
 		ReplicaIdx:       -1,
+		Deadline:         opts.Deadline,
+		RetryStrategy:    opts.RetryStrategy,
+		Priority:         opts.Priority,
 	}
 
 	req.Callback = handler
@@ -366,6 +379,40 @@ func (cidMgr *collectionIdManager) dispatch(req *memdQRequest) (PendingOp, error
 	return req, nil
 }
 
+// resolveDirect attempts to assign the collection id for a request without going
+// through the id cache queueing.  It returns false if the request must instead be
+// dispatched via the collection id manager.
+func (cidMgr *collectionIdManager) resolveDirect(req *memdQRequest) bool {
+	if req.CollectionName == "" && req.ScopeName == "" {
+		return true
+	}
+
+	if req.CollectionName == "_default" && req.ScopeName == "_default" {
+		req.CollectionID = 0
+		return true
+	}
+
+	if !cidMgr.agent.HasCollectionsSupport() {
+		return false
+	}
+
+	cidCache, ok := cidMgr.Get(req.ScopeName, req.CollectionName)
+	if !ok {
+		return false
+	}
+
+	cidCache.lock.Lock()
+	id := cidCache.id
+	cidCache.lock.Unlock()
+
+	if id == unknownCid || id == pendingCid || id == invalidCid {
+		return false
+	}
+
+	req.CollectionID = id
+	return true
+}
+
 func (cidMgr *collectionIdManager) requeue(req *memdQRequest) {
 	cidCache, ok := cidMgr.Get(req.ScopeName, req.CollectionName)
 	if !ok {
@@ -55,7 +55,10 @@ func (pop *pingOp) handledOneLocked() {
 
 // PingKvOptions encapsulates the parameters for a PingKvEx operation.
 type PingKvOptions struct {
-	TraceContext opentracing.SpanContext
+	TraceContext  opentracing.SpanContext
+	Deadline      time.Time
+	RetryStrategy RetryStrategy
+	Priority      RequestPriority
 }
 
 // PingKvResult encapsulates the result of a PingKvEx operation.
@@ -81,9 +84,7 @@ func (agent *Agent) PingKvEx(opts PingKvOptions, cb PingKvExCallback) (PendingOp
 
 	pingStartTime := time.Now()
 
-	kvHandler := func(resp *memdQResponse, req *memdQRequest, err error) {
-		serverAddress := resp.sourceAddr
-
+	kvHandler := func(serverAddress string, err error) {
 		pingLatency := time.Now().Sub(pingStartTime)
 
 		op.lock.Lock()
@@ -109,7 +110,12 @@ func (agent *Agent) PingKvEx(opts PingKvOptions, cb PingKvExCallback) (PendingOp
 				Key:      nil,
 				Value:    nil,
 			},
-			Callback: kvHandler,
+			Callback: func(resp *memdQResponse, req *memdQRequest, err error) {
+				kvHandler(serverAddress, err)
+			},
+			Deadline:      opts.Deadline,
+			RetryStrategy: opts.RetryStrategy,
+			Priority:      opts.Priority,
 		}
 
 		curOp, err := agent.dispatchOpToAddress(req, serverAddress)
@@ -154,8 +160,9 @@ type MemdConnInfo struct {
 // DiagnosticInfo is returned by the Diagnostics method and includes
 // information about the overall health of the clients connections.
 type DiagnosticInfo struct {
-	ConfigRev int64
-	MemdConns []MemdConnInfo
+	ConfigRev       int64
+	MemdConns       []MemdConnInfo
+	CircuitBreakers []CircuitBreakerInfo
 }
 
 // Diagnostics returns diagnostics information about the client.
@@ -200,8 +207,9 @@ func (agent *Agent) Diagnostics() (*DiagnosticInfo, error) {
 		endConfig := agent.routingInfo.Get()
 		if endConfig == config {
 			return &DiagnosticInfo{
-				ConfigRev: config.revId,
-				MemdConns: conns,
+				ConfigRev:       config.revId,
+				MemdConns:       conns,
+				CircuitBreakers: agent.circuitBreakers.Info(),
 			}, nil
 		}
 	}
@@ -7,6 +7,7 @@ import (
 	"io"
 	"io/ioutil"
 	"math/rand"
+	"net"
 	"net/http"
 )
 
@@ -54,44 +55,105 @@ func injectJsonCreds(body []byte, creds []UserPassPair) []byte {
 	return body
 }
 
-func (agent *Agent) getMgmtEp() (string, error) {
-	mgmtEps := agent.MgmtEps()
-	if len(mgmtEps) == 0 {
-		return "", ErrNoMgmtService
+// pickHttpEp picks a random endpoint from eps, skipping any endpoint whose circuit
+// breaker is not allowing requests.
+func (agent *Agent) pickHttpEp(service ServiceType, eps []string, noServiceErr error) (string, error) {
+	if len(eps) == 0 {
+		return "", noServiceErr
 	}
-	return mgmtEps[rand.Intn(len(mgmtEps))], nil
+
+	offset := rand.Intn(len(eps))
+	for i := range eps {
+		ep := eps[(offset+i)%len(eps)]
+		breaker := agent.httpCircuitBreaker(service, ep)
+		if breaker == nil || breaker.AllowsRequest() {
+			return ep, nil
+		}
+	}
+
+	return "", ErrCircuitBreakerOpen
+}
+
+func (agent *Agent) getMgmtEp() (string, error) {
+	return agent.pickHttpEp(MgmtService, agent.MgmtEps(), ErrNoMgmtService)
 }
 
 func (agent *Agent) getCapiEp() (string, error) {
-	capiEps := agent.CapiEps()
-	if len(capiEps) == 0 {
-		return "", ErrNoMgmtService
-	}
-	return capiEps[rand.Intn(len(capiEps))], nil
+	return agent.pickHttpEp(CapiService, agent.CapiEps(), ErrNoMgmtService)
 }
 
 func (agent *Agent) getN1qlEp() (string, error) {
-	n1qlEps := agent.N1qlEps()
-	if len(n1qlEps) == 0 {
-		return "", ErrNoN1qlService
-	}
-	return n1qlEps[rand.Intn(len(n1qlEps))], nil
+	return agent.pickHttpEp(N1qlService, agent.N1qlEps(), ErrNoN1qlService)
 }
 
 func (agent *Agent) getFtsEp() (string, error) {
-	ftsEps := agent.FtsEps()
-	if len(ftsEps) == 0 {
-		return "", ErrNoFtsService
-	}
-	return ftsEps[rand.Intn(len(ftsEps))], nil
+	return agent.pickHttpEp(FtsService, agent.FtsEps(), ErrNoFtsService)
 }
 
 func (agent *Agent) getCbasEp() (string, error) {
-	cbasEps := agent.CbasEps()
-	if len(cbasEps) == 0 {
-		return "", ErrNoCbasService
+	return agent.pickHttpEp(CbasService, agent.CbasEps(), ErrNoCbasService)
+}
+
+// httpCircuitBreaker returns the circuit breaker of an HTTP endpoint, or nil if circuit
+// breakers are disabled.
+func (agent *Agent) httpCircuitBreaker(service ServiceType, endpoint string) *circuitBreaker {
+	return agent.circuitBreakers.Get(service, endpoint, func(done func(error)) {
+		go agent.sendHttpCanary(service, endpoint, done)
+	})
+}
+
+// sendHttpCanary checks whether an HTTP endpoint is reachable again.  Any response at
+// all counts as success, the canary is only interested in the connection.
+func (agent *Agent) sendHttpCanary(service ServiceType, endpoint string, done func(error)) {
+	path := "/"
+	switch service {
+	case MgmtService:
+		path = "/pools"
+	case N1qlService, CbasService:
+		path = "/admin/ping"
+	case FtsService:
+		path = "/api/ping"
 	}
-	return cbasEps[rand.Intn(len(cbasEps))], nil
+
+	ctx, cancel := context.WithTimeout(context.Background(), agent.circuitBreakers.config.CanaryTimeout)
+	defer cancel()
+
+	hreq, err := http.NewRequest("GET", endpoint+path, nil)
+	if err != nil {
+		done(err)
+		return
+	}
+
+	hresp, err := agent.httpCli.Do(hreq.WithContext(ctx))
+	if err != nil {
+		done(httpCircuitBreakerError(ctx, err))
+		return
+	}
+
+	_, _ = io.Copy(ioutil.Discard, hresp.Body)
+	_ = hresp.Body.Close()
+	done(nil)
+}
+
+// httpCircuitBreakerError translates the error returned by the HTTP client into one
+// which is understood by the circuit breakers.
+func httpCircuitBreakerError(ctx context.Context, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	switch ctx.Err() {
+	case context.Canceled:
+		return ErrCancelled
+	case context.DeadlineExceeded:
+		return ErrTimeout
+	}
+
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		return ErrTimeout
+	}
+
+	return ErrNetwork
 }
 
 // DoHttpRequest will perform an HTTP request against one of the HTTP
@@ -122,6 +184,8 @@ func (agent *Agent) DoHttpRequest(req *HttpRequest) (*HttpResponse, error) {
 		}
 
 		req.Endpoint = endpoint
+	} else if breaker := agent.httpCircuitBreaker(req.Service, endpoint); breaker != nil && !breaker.AllowsRequest() {
+		return nil, ErrCircuitBreakerOpen
 	}
 
 	// Generate a request URI
@@ -182,6 +246,9 @@ func (agent *Agent) DoHttpRequest(req *HttpRequest) (*HttpResponse, error) {
 	}
 
 	hresp, err := agent.httpCli.Do(hreq)
+	if breaker := agent.httpCircuitBreaker(req.Service, endpoint); breaker != nil {
+		breaker.MarkCompleted(httpCircuitBreakerError(req.Context, err))
+	}
 	if err != nil {
 		return nil, err
 	}
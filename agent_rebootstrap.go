@@ -0,0 +1,237 @@
+package gocbcore
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/couchbaselabs/gocbconnstr"
+)
+
+// seedAddrsFromSpec builds the memcached and http addresses to bootstrap against from
+// a resolved connection string.
+func seedAddrsFromSpec(spec gocbconnstr.ResolvedConnSpec) ([]string, []string, error) {
+	// Grab the resolved hostnames into a set of string arrays
+	var httpHosts []string
+	for _, specHost := range spec.HttpHosts {
+		httpHosts = append(httpHosts, fmt.Sprintf("%s:%d", specHost.Host, specHost.Port))
+	}
+
+	var memdHosts []string
+	for _, specHost := range spec.MemdHosts {
+		memdHosts = append(memdHosts, fmt.Sprintf("%s:%d", specHost.Host, specHost.Port))
+	}
+
+	var bootstrapOn string
+	if optValue := spec.Options["bootstrap_on"]; len(optValue) > 0 {
+		bootstrapOn = optValue[len(optValue)-1]
+	}
+
+	// Get bootstrap_on option to determine which, if any, of the bootstrap nodes should be cleared
+	switch bootstrapOn {
+	case "http":
+		memdHosts = nil
+		if len(httpHosts) == 0 {
+			return nil, nil, errors.New("bootstrap_on=http but no HTTP hosts in connection string")
+		}
+	case "cccp":
+		httpHosts = nil
+		if len(memdHosts) == 0 {
+			return nil, nil, errors.New("bootstrap_on=cccp but no CCCP/Memcached hosts in connection string")
+		}
+	case "both":
+	case "":
+		// Do nothing
+		break
+	default:
+		return nil, nil, errors.New("bootstrap_on={http,cccp,both}")
+	}
+
+	return memdHosts, httpHosts, nil
+}
+
+// markConfigReceived records that a config has just been received from the cluster.
+func (agent *Agent) markConfigReceived() {
+	atomic.StoreInt64(&agent.lastConfigTime, time.Now().UnixNano())
+}
+
+// isClusterLost returns whether the agent has lost contact with the cluster, meaning no
+// config has been received for the cluster lost timeout and no node is connected.
+func (agent *Agent) isClusterLost() bool {
+	lastConfigTime := time.Unix(0, atomic.LoadInt64(&agent.lastConfigTime))
+	if time.Since(lastConfigTime) < agent.clusterLostTimeout {
+		return false
+	}
+
+	routingInfo := agent.routingInfo.Get()
+	if routingInfo == nil || routingInfo.clientMux == nil {
+		return false
+	}
+
+	return !routingInfo.clientMux.HasConnectedClients()
+}
+
+// resolveSeedAddrs returns the addresses to bootstrap against.  If the agent was
+// configured from a connection string it is resolved again, so that DNS SRV records
+// are looked up afresh.
+func (agent *Agent) resolveSeedAddrs() ([]string, []string, error) {
+	if agent.connStr == "" {
+		return agent.seedMemdAddrs, agent.seedHttpAddrs, nil
+	}
+
+	baseSpec, err := gocbconnstr.Parse(agent.connStr)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	spec, err := gocbconnstr.Resolve(baseSpec)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return seedAddrsFromSpec(spec)
+}
+
+func (agent *Agent) fetchMemdSeedConfig(address string) (*cfgBucket, error) {
+	client, err := agent.dialMemdClient(address)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := client.Close(); err != nil {
+			logErrorf("Failed to shut down client connection (%s)", err)
+		}
+	}()
+
+	syncCli := syncClient{
+		client: client,
+	}
+	cccpBytes, err := syncCli.ExecCccpRequest(time.Now().Add(agent.confCccpMaxWait))
+	if err != nil {
+		return nil, err
+	}
+
+	hostName, err := hostFromHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseConfig(cccpBytes, hostName)
+}
+
+func (agent *Agent) fetchHttpSeedConfig(address string) (*cfgBucket, error) {
+	scheme := "http"
+	if agent.IsSecure() {
+		scheme = "https"
+	}
+	endpoint := fmt.Sprintf("%s://%s", scheme, address)
+
+	uri := fmt.Sprintf("%s/pools/default/b/%s", endpoint, agent.bucket)
+	if agent.bucket == "" {
+		uri = fmt.Sprintf("%s/pools/default/nodeServices", endpoint)
+	}
+
+	req, err := http.NewRequest("GET", uri, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	creds, err := getMgmtAuthCreds(agent.auth, endpoint)
+	if err != nil {
+		return nil, err
+	}
+	req.SetBasicAuth(creds.Username, creds.Password)
+
+	httpCli := *agent.httpCli
+	httpCli.Timeout = agent.serverConnectTimeout
+	resp, err := httpCli.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			logErrorf("Failed to close config response body (%s)", err)
+		}
+	}()
+
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("unexpected status code %d fetching config", resp.StatusCode)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	hostName, err := hostFromHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseConfig(body, hostName)
+}
+
+// rebootstrap resolves the seed nodes again and replaces the current config with one
+// fetched from them, allowing the agent to recover after every node it knew of has
+// been replaced.
+func (agent *Agent) rebootstrap() error {
+	memdAddrs, httpAddrs, err := agent.resolveSeedAddrs()
+	if err != nil {
+		return err
+	}
+
+	var bk *cfgBucket
+	for _, address := range memdAddrs {
+		bk, err = agent.fetchMemdSeedConfig(address)
+		if err == nil {
+			break
+		}
+		logDebugf("Failed to fetch config from seed node %s (%s)", address, err)
+	}
+	if bk == nil {
+		for _, address := range httpAddrs {
+			bk, err = agent.fetchHttpSeedConfig(address)
+			if err == nil {
+				break
+			}
+			logDebugf("Failed to fetch config from seed node %s (%s)", address, err)
+		}
+	}
+	if bk == nil {
+		return ErrBadHosts
+	}
+
+	routeCfg := buildRouteConfig(bk, agent.IsSecure(), agent.networkType, false)
+	if !routeCfg.IsValid() {
+		return ErrBadHosts
+	}
+	routeCfg.forceApply = true
+
+	agent.markConfigReceived()
+	agent.applyConfig(routeCfg)
+
+	if agent.configCache != nil {
+		go agent.storeCachedConfig(bk)
+	}
+
+	return nil
+}
+
+// maybeRebootstrap bootstraps against the seed nodes again if contact with the
+// cluster has been lost.
+func (agent *Agent) maybeRebootstrap() {
+	if !agent.isClusterLost() {
+		return
+	}
+
+	logWarnf("Lost contact with the cluster, bootstrapping again from the seed nodes")
+	if err := agent.rebootstrap(); err != nil {
+		logWarnf("Failed to bootstrap again from the seed nodes (%s)", err)
+		return
+	}
+
+	logDebugf("Successfully bootstrapped again from the seed nodes")
+}
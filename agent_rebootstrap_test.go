@@ -0,0 +1,117 @@
+package gocbcore
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSeedAddrsFromConnStr(t *testing.T) {
+	config := &AgentConfig{}
+	connStr := "couchbase://10.0.0.1,10.0.0.2:11210?bootstrap_on=cccp"
+	if err := config.FromConnStr(connStr); err != nil {
+		t.Fatalf("Failed to parse connection string: %v", err)
+	}
+
+	if config.connStr != connStr {
+		t.Fatalf("Expected the connection string to be remembered")
+	}
+	if len(config.MemdAddrs) != 2 || config.MemdAddrs[0] != "10.0.0.1:11210" || len(config.HttpAddrs) != 0 {
+		t.Fatalf("Unexpected seed addresses %v, %v", config.MemdAddrs, config.HttpAddrs)
+	}
+
+	agent := &Agent{
+		connStr: connStr,
+	}
+	memdAddrs, httpAddrs, err := agent.resolveSeedAddrs()
+	if err != nil {
+		t.Fatalf("Failed to resolve seed addresses: %v", err)
+	}
+	if len(memdAddrs) != 2 || memdAddrs[1] != "10.0.0.2:11210" || len(httpAddrs) != 0 {
+		t.Fatalf("Unexpected resolved seed addresses %v, %v", memdAddrs, httpAddrs)
+	}
+
+	if err := config.FromConnStr("couchbase://10.0.0.1,10.0.0.2?bootstrap_on=bad"); err == nil {
+		t.Fatalf("Expected an invalid bootstrap_on option to fail")
+	}
+}
+
+func TestIsClusterLost(t *testing.T) {
+	agent := &Agent{
+		clusterLostTimeout: time.Minute,
+	}
+	agent.routingInfo.Update(nil, &routeData{
+		revId:     1,
+		clientMux: newMemdClientMux([]string{"10.0.0.1:11210"}, 0, 0, nil),
+	})
+
+	agent.markConfigReceived()
+	if agent.isClusterLost() {
+		t.Fatalf("Expected the cluster not to be lost after receiving a config")
+	}
+
+	agent.lastConfigTime = time.Now().Add(-2 * time.Minute).UnixNano()
+	if !agent.isClusterLost() {
+		t.Fatalf("Expected the cluster to be lost without configs or connected clients")
+	}
+}
+
+func TestRebootstrapFromHttpSeed(t *testing.T) {
+	filename := "testdata/bucket_config_with_external_addresses.json"
+	cfg := getConfig(t, filename)
+
+	// The replacement cluster has started again from a lower revision.
+	replacementCfg := loadConfigBytesWithRev(t, filename, 5)
+
+	var requestedPath string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestedPath = r.URL.Path
+		if user, pass, ok := r.BasicAuth(); !ok || user != "user" || pass != "pass" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_, err := w.Write(replacementCfg)
+		if err != nil {
+			t.Errorf("Failed to write config: %v", err)
+		}
+	}))
+	defer server.Close()
+
+	// A pool size of zero stops the pipelines from dialing the servers in the config.
+	agent := &Agent{
+		bucket:             "default",
+		auth:               &PasswordAuthProvider{Username: "user", Password: "pass"},
+		httpCli:            &http.Client{},
+		networkType:        "default",
+		numVbuckets:        cfg.vbMap.NumVbuckets(),
+		seedHttpAddrs:      []string{strings.TrimPrefix(server.URL, "http://")},
+		clusterLostTimeout: time.Minute,
+	}
+	agent.routingInfo.Update(nil, &routeData{
+		revId: -1,
+	})
+	agent.applyConfig(cfg)
+
+	// Contact with the cluster has not been lost, so nothing should happen.
+	agent.markConfigReceived()
+	agent.maybeRebootstrap()
+	if requestedPath != "" {
+		t.Fatalf("Expected no config to be fetched while the cluster is reachable")
+	}
+
+	agent.lastConfigTime = time.Now().Add(-2 * time.Minute).UnixNano()
+	agent.maybeRebootstrap()
+
+	if requestedPath != fmt.Sprintf("/pools/default/b/%s", agent.bucket) {
+		t.Fatalf("Unexpected config request path %s", requestedPath)
+	}
+	if agent.Topology().RevID != 5 {
+		t.Fatalf("Expected the config from the seed node to be applied, got revision %d", agent.Topology().RevID)
+	}
+	if agent.isClusterLost() {
+		t.Fatalf("Expected the cluster to be found again")
+	}
+}
@@ -2,6 +2,7 @@ package gocbcore
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"flag"
@@ -11,6 +12,7 @@ import (
 	"os"
 	"runtime"
 	"runtime/pprof"
+	"strconv"
 	"strings"
 	"sync/atomic"
 	"testing"
@@ -181,6 +183,240 @@ func TestBasicOps(t *testing.T) {
 	s.Wait(0)
 }
 
+func TestBasicOpsContext(t *testing.T) {
+	agent := getAgent()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	setRes, err := agent.SetContext(ctx, SetOptions{
+		Key:            []byte("testContext"),
+		Value:          []byte("{}"),
+		CollectionName: agent.CollectionName(),
+		ScopeName:      agent.ScopeName(),
+	})
+	if err != nil {
+		t.Fatalf("Set operation failed: %v", err)
+	}
+	if setRes.Cas == Cas(0) {
+		t.Fatalf("Invalid cas received")
+	}
+
+	getRes, err := agent.GetContext(ctx, GetOptions{
+		Key:            []byte("testContext"),
+		CollectionName: agent.CollectionName(),
+		ScopeName:      agent.ScopeName(),
+	})
+	if err != nil {
+		t.Fatalf("Get operation failed: %v", err)
+	}
+	if getRes.Cas != setRes.Cas {
+		t.Fatalf("GetContext returned a different cas to the SetContext")
+	}
+
+	cancelledCtx, cancelNow := context.WithCancel(context.Background())
+	cancelNow()
+
+	_, err = agent.GetContext(cancelledCtx, GetOptions{
+		Key:            []byte("testContext"),
+		CollectionName: agent.CollectionName(),
+		ScopeName:      agent.ScopeName(),
+	})
+	if err != ErrCancelled {
+		t.Fatalf("Get with a cancelled context should have failed with ErrCancelled: %v", err)
+	}
+}
+
+func TestBulkOps(t *testing.T) {
+	agent, s := getAgentnSignaler(t)
+
+	var items []SetMultiItem
+	var keys [][]byte
+	for i := 0; i < 50; i++ {
+		key := []byte(fmt.Sprintf("bulk-%d", i))
+		keys = append(keys, key)
+		items = append(items, SetMultiItem{
+			Key:   key,
+			Value: []byte(fmt.Sprintf("{\"i\":%d}", i)),
+		})
+	}
+
+	// SetMulti
+	s.PushOp(agent.SetMultiEx(SetMultiOptions{
+		Items:          items,
+		CollectionName: agent.CollectionName(),
+		ScopeName:      agent.ScopeName(),
+	}, func(res *StoreMultiResult, err error) {
+		s.Wrap(func() {
+			if err != nil {
+				s.Fatalf("SetMulti operation failed: %v", err)
+			}
+			if len(res.Items) != len(items) {
+				s.Fatalf("SetMulti returned the wrong number of results")
+			}
+			for i, item := range res.Items {
+				if item.Err != nil {
+					s.Fatalf("SetMulti item %d failed: %v", i, item.Err)
+				}
+				if item.Result.Cas == Cas(0) {
+					s.Fatalf("Invalid cas received")
+				}
+			}
+		})
+	}))
+	s.Wait(0)
+
+	// GetMulti, including a key which does not exist
+	s.PushOp(agent.GetMultiEx(GetMultiOptions{
+		Keys:           append(keys, []byte("bulk-missing")),
+		CollectionName: agent.CollectionName(),
+		ScopeName:      agent.ScopeName(),
+	}, func(res *GetMultiResult, err error) {
+		s.Wrap(func() {
+			if err != nil {
+				s.Fatalf("GetMulti operation failed: %v", err)
+			}
+			if len(res.Items) != len(keys)+1 {
+				s.Fatalf("GetMulti returned the wrong number of results")
+			}
+			for i, item := range res.Items[:len(keys)] {
+				if item.Err != nil {
+					s.Fatalf("GetMulti item %d failed: %v", i, item.Err)
+				}
+				if string(item.Result.Value) != string(items[i].Value) {
+					s.Fatalf("GetMulti item %d returned the wrong value", i)
+				}
+			}
+			if !IsErrorStatus(res.Items[len(keys)].Err, StatusKeyNotFound) {
+				s.Fatalf("GetMulti of a missing key should have failed: %v", res.Items[len(keys)].Err)
+			}
+		})
+	}))
+	s.Wait(0)
+
+	var deletes []DeleteMultiItem
+	for _, key := range keys {
+		deletes = append(deletes, DeleteMultiItem{Key: key})
+	}
+
+	// DeleteMulti
+	s.PushOp(agent.DeleteMultiEx(DeleteMultiOptions{
+		Items:          deletes,
+		CollectionName: agent.CollectionName(),
+		ScopeName:      agent.ScopeName(),
+	}, func(res *DeleteMultiResult, err error) {
+		s.Wrap(func() {
+			if err != nil {
+				s.Fatalf("DeleteMulti operation failed: %v", err)
+			}
+			for i, item := range res.Items {
+				if item.Err != nil {
+					s.Fatalf("DeleteMulti item %d failed: %v", i, item.Err)
+				}
+			}
+		})
+	}))
+	s.Wait(0)
+}
+
+func TestTypedOps(t *testing.T) {
+	agent, s := getAgentnSignaler(t)
+
+	type testDoc struct {
+		Name string `json:"name"`
+		Age  int    `json:"age"`
+	}
+	doc := testDoc{Name: "frank", Age: 42}
+
+	s.PushOp(agent.SetTypedEx(SetTypedOptions{
+		Key:            []byte("typed-json"),
+		Value:          doc,
+		CollectionName: agent.CollectionName(),
+		ScopeName:      agent.ScopeName(),
+	}, func(res *StoreResult, err error) {
+		s.Wrap(func() {
+			if err != nil {
+				s.Fatalf("SetTyped operation failed: %v", err)
+			}
+			if res.Cas == Cas(0) {
+				s.Fatalf("Invalid cas received")
+			}
+		})
+	}))
+	s.Wait(0)
+
+	var fetchedDoc testDoc
+	s.PushOp(agent.GetTypedEx(GetTypedOptions{
+		Key:            []byte("typed-json"),
+		ValuePtr:       &fetchedDoc,
+		CollectionName: agent.CollectionName(),
+		ScopeName:      agent.ScopeName(),
+	}, func(res *GetTypedResult, err error) {
+		s.Wrap(func() {
+			if err != nil {
+				s.Fatalf("GetTyped operation failed: %v", err)
+			}
+			if res.Flags != EncodeCommonFlags(JsonType, NoCompression) {
+				s.Fatalf("Document was stored with the wrong flags")
+			}
+			if fetchedDoc != doc {
+				s.Fatalf("GetTyped returned the wrong value")
+			}
+		})
+	}))
+	s.Wait(0)
+
+	s.PushOp(agent.SetTypedEx(SetTypedOptions{
+		Key:            []byte("typed-string"),
+		Value:          "a raw string",
+		Transcoder:     NewRawStringTranscoder(),
+		CollectionName: agent.CollectionName(),
+		ScopeName:      agent.ScopeName(),
+	}, func(res *StoreResult, err error) {
+		s.Wrap(func() {
+			if err != nil {
+				s.Fatalf("SetTyped operation failed: %v", err)
+			}
+		})
+	}))
+	s.Wait(0)
+
+	// Decoding a string document as JSON should fail
+	var badDoc testDoc
+	s.PushOp(agent.GetTypedEx(GetTypedOptions{
+		Key:            []byte("typed-string"),
+		ValuePtr:       &badDoc,
+		CollectionName: agent.CollectionName(),
+		ScopeName:      agent.ScopeName(),
+	}, func(res *GetTypedResult, err error) {
+		s.Wrap(func() {
+			if err != ErrUnsupportedTranscoderFormat {
+				s.Fatalf("Expected unsupported format error but got %v", err)
+			}
+		})
+	}))
+	s.Wait(0)
+
+	var fetchedStr string
+	s.PushOp(agent.GetTypedEx(GetTypedOptions{
+		Key:            []byte("typed-string"),
+		ValuePtr:       &fetchedStr,
+		Transcoder:     NewLegacyTranscoder(),
+		CollectionName: agent.CollectionName(),
+		ScopeName:      agent.ScopeName(),
+	}, func(res *GetTypedResult, err error) {
+		s.Wrap(func() {
+			if err != nil {
+				s.Fatalf("GetTyped operation failed: %v", err)
+			}
+			if fetchedStr != "a raw string" {
+				s.Fatalf("GetTyped returned the wrong value")
+			}
+		})
+	}))
+	s.Wait(0)
+}
+
 func TestGetReplica(t *testing.T) {
 	agent, s := getAgentnSignaler(t)
 
@@ -293,6 +529,102 @@ func TestGetAnyReplica(t *testing.T) {
 	}
 }
 
+func TestGetAnyReplicaEx(t *testing.T) {
+	agent, s := getAgentnSignaler(t)
+
+	s.PushOp(agent.SetEx(SetOptions{
+		Key:            []byte("testAnyReplicaEx"),
+		Value:          []byte("{}"),
+		CollectionName: agent.CollectionName(),
+		ScopeName:      agent.ScopeName(),
+	}, func(res *StoreResult, err error) {
+		s.Wrap(func() {
+			if err != nil {
+				s.Fatalf("Set operation failed: %v", err)
+			}
+		})
+	}))
+	s.Wait(0)
+
+	s.PushOp(agent.GetAnyReplicaEx(GetAnyReplicaOptions{
+		Key:            []byte("testAnyReplicaEx"),
+		CollectionName: agent.CollectionName(),
+		ScopeName:      agent.ScopeName(),
+	}, func(res *ReplicaReadResult, err error) {
+		s.Wrap(func() {
+			if err != nil {
+				s.Fatalf("GetAnyReplica operation failed: %v", err)
+			}
+			if res.Cas == Cas(0) {
+				s.Fatalf("Invalid cas received")
+			}
+			if res.ServerIdx < 0 {
+				s.Fatalf("Invalid server index received")
+			}
+		})
+	}))
+	s.Wait(0)
+
+	s.PushOp(agent.GetAnyReplicaEx(GetAnyReplicaOptions{
+		Key:            []byte("testAnyReplicaExMissing"),
+		CollectionName: agent.CollectionName(),
+		ScopeName:      agent.ScopeName(),
+	}, func(res *ReplicaReadResult, err error) {
+		s.Wrap(func() {
+			if !IsErrorStatus(err, StatusKeyNotFound) {
+				s.Fatalf("Expected key not found but got %v", err)
+			}
+		})
+	}))
+	s.Wait(0)
+}
+
+func TestGetAllReplicasEx(t *testing.T) {
+	agent, s := getAgentnSignaler(t)
+
+	s.PushOp(agent.SetEx(SetOptions{
+		Key:            []byte("testAllReplicasEx"),
+		Value:          []byte("{}"),
+		CollectionName: agent.CollectionName(),
+		ScopeName:      agent.ScopeName(),
+	}, func(res *StoreResult, err error) {
+		s.Wrap(func() {
+			if err != nil {
+				s.Fatalf("Set operation failed: %v", err)
+			}
+		})
+	}))
+	s.Wait(0)
+
+	var results []*ReplicaReadResult
+	s.PushOp(agent.GetAllReplicasEx(GetAllReplicasOptions{
+		Key:            []byte("testAllReplicasEx"),
+		CollectionName: agent.CollectionName(),
+		ScopeName:      agent.ScopeName(),
+	}, func(res *ReplicaReadResult) {
+		results = append(results, res)
+	}, func(err error) {
+		s.Wrap(func() {
+			if err != nil {
+				s.Fatalf("GetAllReplicas operation failed: %v", err)
+			}
+			if len(results) > agent.NumReplicas()+1 {
+				s.Fatalf("GetAllReplicas returned too many results")
+			}
+			numActive := 0
+			for _, res := range results {
+				if !res.IsReplica {
+					numActive++
+				}
+			}
+			if numActive != 1 {
+				s.Fatalf("Expected exactly one result from the active server but got %d", numActive)
+			}
+		})
+	}))
+	s.Wait(0)
+}
+
 func TestBasicReplace(t *testing.T) {
 	agent, s := getAgentnSignaler(t)
 
@@ -798,6 +1130,285 @@ func TestObserveSeqNo(t *testing.T) {
 	s.Wait(0)
 }
 
+func TestLockHandle(t *testing.T) {
+	agent, s := getAgentnSignaler(t)
+
+	s.PushOp(agent.SetEx(SetOptions{
+		Key:            []byte("testLockHandle"),
+		Value:          []byte("{\"x\":1}"),
+		CollectionName: agent.CollectionName(),
+		ScopeName:      agent.ScopeName(),
+	}, func(res *StoreResult, err error) {
+		s.Wrap(func() {
+			if err != nil {
+				s.Fatalf("Set operation failed: %v", err)
+			}
+		})
+	}))
+	s.Wait(0)
+
+	var handle *LockHandle
+	s.PushOp(agent.LockEx(LockOptions{
+		Key:            []byte("testLockHandle"),
+		LockTime:       10,
+		CollectionName: agent.CollectionName(),
+		ScopeName:      agent.ScopeName(),
+	}, func(lockHandle *LockHandle, err error) {
+		s.Wrap(func() {
+			if err != nil {
+				s.Fatalf("Lock operation failed: %v", err)
+			}
+			if string(lockHandle.Value()) != "{\"x\":1}" {
+				s.Fatalf("Lock operation returned the wrong value")
+			}
+			handle = lockHandle
+		})
+	}))
+	s.Wait(0)
+
+	// A second lock attempt should give up once its attempts are exhausted
+	s.PushOp(agent.LockEx(LockOptions{
+		Key:            []byte("testLockHandle"),
+		LockTime:       10,
+		MaxAttempts:    2,
+		CollectionName: agent.CollectionName(),
+		ScopeName:      agent.ScopeName(),
+	}, func(lockHandle *LockHandle, err error) {
+		s.Wrap(func() {
+			if !isLockedError(err) {
+				s.Fatalf("Expected locked error but got %v", err)
+			}
+		})
+	}))
+	s.Wait(0)
+
+	s.PushOp(handle.ExtendEx(LockExtendOptions{
+		LockTime: 10,
+	}, func(res *GetAndLockResult, err error) {
+		s.Wrap(func() {
+			if err != nil {
+				s.Fatalf("Extend operation failed: %v", err)
+			}
+			if res.Cas != handle.Cas() {
+				s.Fatalf("Extend operation did not update the lock cas")
+			}
+		})
+	}))
+	s.Wait(0)
+
+	s.PushOp(handle.ReplaceEx(ReplaceOptions{
+		Value: []byte("{\"x\":2}"),
+	}, func(res *StoreResult, err error) {
+		s.Wrap(func() {
+			if err != nil {
+				s.Fatalf("Replace operation failed: %v", err)
+			}
+			if !handle.IsReleased() {
+				s.Fatalf("Expected the lock to be released by the mutation")
+			}
+		})
+	}))
+	s.Wait(0)
+
+	s.PushOp(agent.LockEx(LockOptions{
+		Key:            []byte("testLockHandle"),
+		LockTime:       10,
+		CollectionName: agent.CollectionName(),
+		ScopeName:      agent.ScopeName(),
+	}, func(lockHandle *LockHandle, err error) {
+		s.Wrap(func() {
+			if err != nil {
+				s.Fatalf("Lock operation failed: %v", err)
+			}
+			handle = lockHandle
+		})
+	}))
+	s.Wait(0)
+
+	s.PushOp(handle.ReleaseEx(LockReleaseOptions{}, func(res *UnlockResult, err error) {
+		s.Wrap(func() {
+			if err != nil {
+				s.Fatalf("Release operation failed: %v", err)
+			}
+		})
+	}))
+	s.Wait(0)
+
+	_, err := handle.ReleaseEx(LockReleaseOptions{}, func(res *UnlockResult, err error) {})
+	if err != ErrLockReleased {
+		t.Fatalf("Expected ErrLockReleased but got %v", err)
+	}
+}
+
+func TestMutateWithCas(t *testing.T) {
+	agent, s := getAgentnSignaler(t)
+
+	increment := func(state *MutateWithCasState) (*MutateWithCasChange, error) {
+		count := 0
+		if state.Exists {
+			count, _ = strconv.Atoi(string(state.Value))
+		}
+		return &MutateWithCasChange{
+			Value: []byte(strconv.Itoa(count + 1)),
+		}, nil
+	}
+
+	s.PushOp(agent.DeleteEx(DeleteOptions{
+		Key:            []byte("testMutateWithCas"),
+		CollectionName: agent.CollectionName(),
+		ScopeName:      agent.ScopeName(),
+	}, func(res *DeleteResult, err error) {
+		s.Wrap(func() {})
+	}))
+	s.Wait(0)
+
+	// Missing documents fail unless inserts are allowed
+	s.PushOp(agent.MutateWithCasEx(MutateWithCasOptions{
+		Key:            []byte("testMutateWithCas"),
+		CollectionName: agent.CollectionName(),
+		ScopeName:      agent.ScopeName(),
+	}, increment, func(res *MutateWithCasResult, err error) {
+		s.Wrap(func() {
+			if !IsErrorStatus(err, StatusKeyNotFound) {
+				s.Fatalf("Expected key not found but got %v", err)
+			}
+		})
+	}))
+	s.Wait(0)
+
+	s.PushOp(agent.MutateWithCasEx(MutateWithCasOptions{
+		Key:             []byte("testMutateWithCas"),
+		InsertIfMissing: true,
+		CollectionName:  agent.CollectionName(),
+		ScopeName:       agent.ScopeName(),
+	}, increment, func(res *MutateWithCasResult, err error) {
+		s.Wrap(func() {
+			if err != nil {
+				s.Fatalf("MutateWithCas insert failed: %v", err)
+			}
+			if !res.Inserted {
+				s.Fatalf("Expected the document to be inserted")
+			}
+			if res.Cas == Cas(0) {
+				s.Fatalf("Invalid cas received")
+			}
+		})
+	}))
+	s.Wait(0)
+
+	// Concurrent writers should all eventually succeed
+	numWriters := 5
+	for i := 0; i < numWriters; i++ {
+		s.PushOp(agent.MutateWithCasEx(MutateWithCasOptions{
+			Key:            []byte("testMutateWithCas"),
+			MaxAttempts:    50,
+			CollectionName: agent.CollectionName(),
+			ScopeName:      agent.ScopeName(),
+		}, increment, func(res *MutateWithCasResult, err error) {
+			s.Wrap(func() {
+				if err != nil {
+					s.Fatalf("MutateWithCas failed: %v", err)
+				}
+			})
+		}))
+	}
+	for i := 0; i < numWriters; i++ {
+		s.Wait(0)
+	}
+
+	s.PushOp(agent.GetEx(GetOptions{
+		Key:            []byte("testMutateWithCas"),
+		CollectionName: agent.CollectionName(),
+		ScopeName:      agent.ScopeName(),
+	}, func(res *GetResult, err error) {
+		s.Wrap(func() {
+			if err != nil {
+				s.Fatalf("Get operation failed: %v", err)
+			}
+			if string(res.Value) != strconv.Itoa(numWriters+1) {
+				s.Fatalf("Expected counter to be %d but got %s", numWriters+1, res.Value)
+			}
+		})
+	}))
+	s.Wait(0)
+}
+
+func TestObserveDurability(t *testing.T) {
+	agent, s := getAgentnSignaler(t)
+
+	if agent.NumReplicas() < 1 {
+		t.Skipf("Observe durability requires at least one replica")
+	}
+
+	// A mutation which waits for its durability requirements directly
+	s.PushOp(agent.SetEx(SetOptions{
+		Key:            []byte("testObserveDurability"),
+		Value:          []byte("{}"),
+		CollectionName: agent.CollectionName(),
+		ScopeName:      agent.ScopeName(),
+		PersistTo:      1,
+		ReplicateTo:    1,
+		Deadline:       time.Now().Add(5 * time.Second),
+	}, func(res *StoreResult, err error) {
+		s.Wrap(func() {
+			if err != nil {
+				s.Fatalf("Set operation with durability failed: %v", err)
+			}
+			if res.Cas == Cas(0) {
+				s.Fatalf("Invalid cas received")
+			}
+		})
+	}))
+	s.Wait(0)
+
+	var cas Cas
+	s.PushOp(agent.SetEx(SetOptions{
+		Key:            []byte("testObserveDurability"),
+		Value:          []byte("{}"),
+		CollectionName: agent.CollectionName(),
+		ScopeName:      agent.ScopeName(),
+	}, func(res *StoreResult, err error) {
+		s.Wrap(func() {
+			if err != nil {
+				s.Fatalf("Set operation failed: %v", err)
+			}
+			cas = res.Cas
+		})
+	}))
+	s.Wait(0)
+
+	// Observing by cas alone
+	s.PushOp(agent.DurabilityEx(DurabilityOptions{
+		Key:            []byte("testObserveDurability"),
+		CollectionName: agent.CollectionName(),
+		ScopeName:      agent.ScopeName(),
+		Cas:            cas,
+		ReplicateTo:    1,
+		Deadline:       time.Now().Add(5 * time.Second),
+	}, func(res *DurabilityResult, err error) {
+		s.Wrap(func() {
+			if err != nil {
+				s.Fatalf("Durability operation failed: %v", err)
+			}
+			if res.NumReplicated < 1 {
+				s.Fatalf("Durability completed without meeting requirements")
+			}
+		})
+	}))
+	s.Wait(0)
+
+	_, err := agent.DurabilityEx(DurabilityOptions{
+		Key:         []byte("testObserveDurability"),
+		Cas:         cas,
+		ReplicateTo: uint(agent.NumReplicas() + 1),
+	}, func(res *DurabilityResult, err error) {
+		t.Fatalf("Callback should not have been invoked")
+	})
+	if err != ErrDurabilityImpossible {
+		t.Fatalf("Expected durability impossible error but got %v", err)
+	}
+}
+
 func TestRandomGet(t *testing.T) {
 	agent, s := getAgentnSignaler(t)
 
@@ -938,6 +1549,229 @@ func TestSubdocXattrs(t *testing.T) {
 	s.Wait(0)
 }
 
+func TestSubdocSplitOps(t *testing.T) {
+	agent, s := getAgentnSignaler(t)
+
+	s.PushOp(agent.SetEx(SetOptions{
+		Key:            []byte("testSubdocSplit"),
+		Value:          []byte("{}"),
+		CollectionName: agent.CollectionName(),
+		ScopeName:      agent.ScopeName(),
+	}, func(res *StoreResult, err error) {
+		s.Wrap(func() {
+			if err != nil {
+				s.Fatalf("Set operation failed: %v", err)
+			}
+		})
+	}))
+	s.Wait(0)
+
+	numOps := maxSubDocOps*2 + 3
+
+	var mutateOps []SubDocOp
+	var lookupOps []SubDocOp
+	for i := 0; i < numOps; i++ {
+		path := fmt.Sprintf("field%d", i)
+		mutateOps = append(mutateOps, SubDocOp{
+			Op:    SubDocOpDictSet,
+			Path:  path,
+			Value: []byte(fmt.Sprintf("%d", i)),
+		})
+		lookupOps = append(lookupOps, SubDocOp{
+			Op:   SubDocOpGet,
+			Path: path,
+		})
+	}
+
+	s.PushOp(agent.MutateInEx(MutateInOptions{
+		Key:            []byte("testSubdocSplit"),
+		Ops:            mutateOps,
+		AllowSplit:     true,
+		CollectionName: agent.CollectionName(),
+		ScopeName:      agent.ScopeName(),
+	}, func(res *MutateInResult, err error) {
+		s.Wrap(func() {
+			if err != nil {
+				s.Fatalf("Split mutate operation failed: %v", err)
+			}
+			if len(res.Ops) != numOps {
+				s.Fatalf("Split mutate returned the wrong number of results")
+			}
+		})
+	}))
+	s.Wait(0)
+
+	s.PushOp(agent.LookupInEx(LookupInOptions{
+		Key:            []byte("testSubdocSplit"),
+		Ops:            lookupOps,
+		CollectionName: agent.CollectionName(),
+		ScopeName:      agent.ScopeName(),
+	}, func(res *LookupInResult, err error) {
+		s.Wrap(func() {
+			if err != nil {
+				s.Fatalf("Split lookup operation failed: %v", err)
+			}
+			if len(res.Ops) != numOps {
+				s.Fatalf("Split lookup returned the wrong number of results")
+			}
+			for i, op := range res.Ops {
+				if op.Err != nil {
+					s.Fatalf("Split lookup operation %d failed: %v", i, op.Err)
+				}
+				if string(op.Value) != fmt.Sprintf("%d", i) {
+					s.Fatalf("Split lookup operation %d returned the wrong value", i)
+				}
+			}
+		})
+	}))
+	s.Wait(0)
+
+	// A failure in a later request should report the partially applied mutation.
+	failOps := append([]SubDocOp{}, mutateOps...)
+	failOps[maxSubDocOps+1] = SubDocOp{
+		Op:    SubDocOpReplace,
+		Path:  "missing",
+		Value: []byte("1"),
+	}
+	s.PushOp(agent.MutateInEx(MutateInOptions{
+		Key:            []byte("testSubdocSplit"),
+		Ops:            failOps,
+		AllowSplit:     true,
+		CollectionName: agent.CollectionName(),
+		ScopeName:      agent.ScopeName(),
+	}, func(res *MutateInResult, err error) {
+		s.Wrap(func() {
+			partialErr, ok := err.(SubDocPartialMutateError)
+			if !ok {
+				s.Fatalf("Expected a partial mutation error but got %v", err)
+			}
+			if partialErr.AppliedOps != maxSubDocOps {
+				s.Fatalf("Expected %d applied operations but got %d", maxSubDocOps, partialErr.AppliedOps)
+			}
+			mutErr, ok := partialErr.Err.(SubDocMutateError)
+			if !ok || mutErr.OpIndex != maxSubDocOps+1 {
+				s.Fatalf("Expected the failing operation index to be reported but got %v", partialErr.Err)
+			}
+		})
+	}))
+	s.Wait(0)
+}
+
+func TestSubdocSplitAddDoc(t *testing.T) {
+	agent, s := getAgentnSignaler(t)
+
+	numOps := maxSubDocOps + 4
+
+	var ops []SubDocOp
+	for i := 0; i < numOps; i++ {
+		ops = append(ops, SubDocOp{
+			Op:    SubDocOpDictSet,
+			Path:  fmt.Sprintf("field%d", i),
+			Value: []byte(fmt.Sprintf("%d", i)),
+		})
+	}
+
+	// Only the first request may add the document, later ones must update the
+	// document which it created.
+	s.PushOp(agent.MutateInEx(MutateInOptions{
+		Key:            []byte("testSubdocSplitAddDoc"),
+		Flags:          SubdocDocFlagAddDoc,
+		Ops:            ops,
+		AllowSplit:     true,
+		CollectionName: agent.CollectionName(),
+		ScopeName:      agent.ScopeName(),
+	}, func(res *MutateInResult, err error) {
+		s.Wrap(func() {
+			if err != nil {
+				s.Fatalf("Split add operation failed: %v", err)
+			}
+			if len(res.Ops) != numOps {
+				s.Fatalf("Split add returned the wrong number of results")
+			}
+		})
+	}))
+	s.Wait(0)
+
+	// Adding the document again must fail before anything is applied.
+	s.PushOp(agent.MutateInEx(MutateInOptions{
+		Key:            []byte("testSubdocSplitAddDoc"),
+		Flags:          SubdocDocFlagAddDoc,
+		Ops:            ops,
+		AllowSplit:     true,
+		CollectionName: agent.CollectionName(),
+		ScopeName:      agent.ScopeName(),
+	}, func(res *MutateInResult, err error) {
+		s.Wrap(func() {
+			if !IsErrorStatus(err, StatusKeyExists) {
+				s.Fatalf("Expected the split add to fail with key exists but got %v", err)
+			}
+		})
+	}))
+	s.Wait(0)
+}
+
+func TestGetProjected(t *testing.T) {
+	agent, s := getAgentnSignaler(t)
+
+	s.PushOp(agent.SetEx(SetOptions{
+		Key:            []byte("testProjected"),
+		Value:          []byte(`{"name":"frank","age":42,"address":{"city":"london","zip":"n1"},"tags":["a","b"]}`),
+		Expiry:         3600,
+		CollectionName: agent.CollectionName(),
+		ScopeName:      agent.ScopeName(),
+	}, func(res *StoreResult, err error) {
+		s.Wrap(func() {
+			if err != nil {
+				s.Fatalf("Set operation failed: %v", err)
+			}
+		})
+	}))
+	s.Wait(0)
+
+	s.PushOp(agent.GetProjectedEx(GetProjectedOptions{
+		Key:            []byte("testProjected"),
+		Paths:          []string{"name", "address.city", "missing"},
+		WithExpiry:     true,
+		CollectionName: agent.CollectionName(),
+		ScopeName:      agent.ScopeName(),
+	}, func(res *GetProjectedResult, err error) {
+		s.Wrap(func() {
+			if err != nil {
+				s.Fatalf("GetProjected operation failed: %v", err)
+			}
+			if string(res.Value) != `{"name":"frank","address":{"city":"london"}}` {
+				s.Fatalf("GetProjected returned the wrong value: %s", res.Value)
+			}
+			if res.Expiry == 0 {
+				s.Fatalf("GetProjected did not return the expiry")
+			}
+		})
+	}))
+	s.Wait(0)
+
+	// Too many paths for a single lookup falls back to a full get
+	paths := []string{"name", "tags[1]"}
+	for i := 0; i < maxSubDocOps; i++ {
+		paths = append(paths, fmt.Sprintf("missing%d", i))
+	}
+	s.PushOp(agent.GetProjectedEx(GetProjectedOptions{
+		Key:            []byte("testProjected"),
+		Paths:          paths,
+		CollectionName: agent.CollectionName(),
+		ScopeName:      agent.ScopeName(),
+	}, func(res *GetProjectedResult, err error) {
+		s.Wrap(func() {
+			if err != nil {
+				s.Fatalf("GetProjected operation failed: %v", err)
+			}
+			if string(res.Value) != `{"name":"frank","tags":["b"]}` {
+				s.Fatalf("GetProjected returned the wrong value: %s", res.Value)
+			}
+		})
+	}))
+	s.Wait(0)
+}
+
 func TestStats(t *testing.T) {
 	agent, s := getAgentnSignaler(t)
 
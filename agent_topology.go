@@ -0,0 +1,394 @@
+package gocbcore
+
+// TopologyNodeServices describes the services which were added to or removed from a node
+// which is present in both the old and new cluster configurations.
+type TopologyNodeServices struct {
+	Address         string
+	AddedServices   []ServiceType
+	RemovedServices []ServiceType
+}
+
+// TopologyVbucketMove describes a vbucket whose active copy has moved between nodes.  The
+// addresses are those of the memcached service, and are empty if the vbucket had no
+// active copy.
+type TopologyVbucketMove struct {
+	Vbucket    uint16
+	OldAddress string
+	NewAddress string
+}
+
+// TopologyEvent describes the differences between the previous and newly applied cluster
+// configurations.  Nodes are identified by the address of their management service.
+type TopologyEvent struct {
+	OldRevID        int64
+	NewRevID        int64
+	AddedNodes      []string
+	RemovedNodes    []string
+	ChangedServices []TopologyNodeServices
+	MovedVbuckets   []TopologyVbucketMove
+}
+
+// TopologyListener is notified of changes to the cluster topology.
+type TopologyListener interface {
+	// TopologyChanged is invoked whenever a new cluster configuration is applied.  Events
+	// are delivered in order from a separate goroutine, and the listener should avoid
+	// blocking as later events are held up until it returns.
+	TopologyChanged(event *TopologyEvent)
+}
+
+// AddTopologyListener registers a listener to be notified of changes to the cluster topology.
+// Listeners are identified by interface equality, so the listener must be of a comparable
+// type such as a pointer.
+func (agent *Agent) AddTopologyListener(listener TopologyListener) {
+	agent.topologyLock.Lock()
+	agent.topologyListeners = append(agent.topologyListeners, listener)
+	agent.topologyLock.Unlock()
+}
+
+// RemoveTopologyListener unregisters a listener previously added with AddTopologyListener.
+// The listener is compared against the registered listeners with ==, which panics if it
+// is not of a comparable type.
+func (agent *Agent) RemoveTopologyListener(listener TopologyListener) {
+	agent.topologyLock.Lock()
+	defer agent.topologyLock.Unlock()
+
+	for i, existing := range agent.topologyListeners {
+		if existing == listener {
+			agent.topologyListeners = append(agent.topologyListeners[:i:i], agent.topologyListeners[i+1:]...)
+			return
+		}
+	}
+}
+
+// hasTopologyListeners returns whether any topology listeners are registered.
+func (agent *Agent) hasTopologyListeners() bool {
+	agent.topologyLock.Lock()
+	defer agent.topologyLock.Unlock()
+
+	return len(agent.topologyListeners) > 0
+}
+
+// queueTopologyEvent queues an event to be delivered to the topology listeners.  This
+// never blocks, as it is called while the configuration is being applied.
+func (agent *Agent) queueTopologyEvent(event *TopologyEvent) {
+	agent.topologyLock.Lock()
+	defer agent.topologyLock.Unlock()
+
+	if len(agent.topologyListeners) == 0 {
+		return
+	}
+
+	agent.topologyEvents = append(agent.topologyEvents, event)
+	if !agent.topologyDispatching {
+		agent.topologyDispatching = true
+		go agent.dispatchTopologyEvents()
+	}
+}
+
+func (agent *Agent) dispatchTopologyEvents() {
+	for {
+		agent.topologyLock.Lock()
+		if len(agent.topologyEvents) == 0 {
+			agent.topologyDispatching = false
+			agent.topologyLock.Unlock()
+			return
+		}
+
+		event := agent.topologyEvents[0]
+		agent.topologyEvents = agent.topologyEvents[1:]
+		listeners := make([]TopologyListener, len(agent.topologyListeners))
+		copy(listeners, agent.topologyListeners)
+		agent.topologyLock.Unlock()
+
+		for _, listener := range listeners {
+			listener.TopologyChanged(event)
+		}
+	}
+}
+
+func diffServices(oldServices, newServices []ServiceType) ([]ServiceType, []ServiceType) {
+	contains := func(list []ServiceType, service ServiceType) bool {
+		for _, existing := range list {
+			if existing == service {
+				return true
+			}
+		}
+		return false
+	}
+
+	var added, removed []ServiceType
+	for _, service := range newServices {
+		if !contains(oldServices, service) {
+			added = append(added, service)
+		}
+	}
+	for _, service := range oldServices {
+		if !contains(newServices, service) {
+			removed = append(removed, service)
+		}
+	}
+	return added, removed
+}
+
+// activeVbucketAddress returns the memcached address of the active copy of a vbucket.
+func activeVbucketAddress(cfg *routeConfig, vbID uint16) string {
+	srvIdx, err := cfg.vbMap.NodeByVbucket(vbID, 0)
+	if err != nil || srvIdx < 0 || srvIdx >= len(cfg.kvServerList) {
+		return ""
+	}
+	return cfg.kvServerList[srvIdx]
+}
+
+// newTopologyEvent builds the event describing the changes from oldCfg to newCfg.  The
+// old configuration is nil when the first configuration is applied.
+func newTopologyEvent(oldCfg, newCfg *routeConfig) *TopologyEvent {
+	if oldCfg == nil {
+		oldCfg = &routeConfig{
+			revId: -1,
+		}
+	}
+
+	event := &TopologyEvent{
+		OldRevID: oldCfg.revId,
+		NewRevID: newCfg.revId,
+	}
+
+	oldNodes := make(map[string]routeNode)
+	for _, node := range oldCfg.nodes {
+		oldNodes[node.address] = node
+	}
+	newNodes := make(map[string]bool)
+
+	for _, node := range newCfg.nodes {
+		newNodes[node.address] = true
+
+		oldNode, ok := oldNodes[node.address]
+		if !ok {
+			event.AddedNodes = append(event.AddedNodes, node.address)
+			continue
+		}
+
+		added, removed := diffServices(oldNode.services, node.services)
+		if len(added) > 0 || len(removed) > 0 {
+			event.ChangedServices = append(event.ChangedServices, TopologyNodeServices{
+				Address:         node.address,
+				AddedServices:   added,
+				RemovedServices: removed,
+			})
+		}
+	}
+
+	for _, node := range oldCfg.nodes {
+		if !newNodes[node.address] {
+			event.RemovedNodes = append(event.RemovedNodes, node.address)
+		}
+	}
+
+	if oldCfg.vbMap != nil && newCfg.vbMap != nil && oldCfg.vbMap.NumVbuckets() == newCfg.vbMap.NumVbuckets() {
+		for vbID := 0; vbID < newCfg.vbMap.NumVbuckets(); vbID++ {
+			oldAddress := activeVbucketAddress(oldCfg, uint16(vbID))
+			newAddress := activeVbucketAddress(newCfg, uint16(vbID))
+			if oldAddress != newAddress {
+				event.MovedVbuckets = append(event.MovedVbuckets, TopologyVbucketMove{
+					Vbucket:    uint16(vbID),
+					OldAddress: oldAddress,
+					NewAddress: newAddress,
+				})
+			}
+		}
+	}
+
+	return event
+}
+
+// TopologyBucketType specifies the type of bucket described by a ClusterTopology.
+type TopologyBucketType int
+
+const (
+	// TopologyBucketTypeInvalid indicates that the bucket type could not be determined.
+	TopologyBucketTypeInvalid = TopologyBucketType(0)
+
+	// TopologyBucketTypeCouchbase indicates a couchbase or ephemeral bucket, which
+	// distributes keys using a vbucket map.
+	TopologyBucketTypeCouchbase = TopologyBucketType(1)
+
+	// TopologyBucketTypeMemcached indicates a memcached bucket, which distributes keys
+	// using a ketama continuum.
+	TopologyBucketTypeMemcached = TopologyBucketType(2)
+
+	// TopologyBucketTypeNone indicates a cluster config, which describes the nodes of
+	// the cluster but no bucket.
+	TopologyBucketTypeNone = TopologyBucketType(3)
+)
+
+// TopologyServicePorts holds the plain and TLS ports of each service on a node.  A port
+// of zero indicates that the service is not available.
+type TopologyServicePorts struct {
+	Kv      uint16
+	KvSsl   uint16
+	Capi    uint16
+	CapiSsl uint16
+	Mgmt    uint16
+	MgmtSsl uint16
+	N1ql    uint16
+	N1qlSsl uint16
+	Fts     uint16
+	FtsSsl  uint16
+	Cbas    uint16
+	CbasSsl uint16
+}
+
+// TopologyAlternateAddress describes the address a node is reachable at on an
+// alternate network.
+type TopologyAlternateAddress struct {
+	Hostname string
+	Ports    TopologyServicePorts
+}
+
+// TopologyNode describes a single node of the cluster.  Address is the address of the
+// management service on the network in use, matching the addresses in TopologyEvent,
+// while Hostname and Ports describe the node on the default network.  ServerGroup is
+// empty if the cluster does not report the server group of the node.
+type TopologyNode struct {
+	Address            string
+	Hostname           string
+	Services           []ServiceType
+	Ports              TopologyServicePorts
+	AlternateAddresses map[string]TopologyAlternateAddress
+	ServerGroup        string
+}
+
+// TopologyKetamaPoint is a single point of the ketama continuum, mapping a hash to the
+// index of a server in KvServers.
+type TopologyKetamaPoint struct {
+	Point       uint32
+	ServerIndex int
+}
+
+// ClusterTopology is an immutable snapshot of the cluster configuration in use by an
+// agent.  The server indexes in VbucketMap, VbucketMapForward and KetamaContinuum refer
+// to KvServers.  VbucketMapForward is only present while a rebalance is in progress.
+type ClusterTopology struct {
+	RevID        int64
+	BucketUUID   string
+	BucketType   TopologyBucketType
+	Capabilities []string
+	Nodes        []TopologyNode
+	KvServers    []string
+
+	NumReplicas       int
+	VbucketMap        [][]int
+	VbucketMapForward [][]int
+	KetamaContinuum   []TopologyKetamaPoint
+}
+
+// Topology returns a snapshot of the cluster configuration currently in use, or nil if
+// the agent has not yet received a configuration.  All of the information is taken
+// from the same configuration, and is copied so it is safe to use and modify freely.
+func (agent *Agent) Topology() *ClusterTopology {
+	routingInfo := agent.routingInfo.Get()
+	if routingInfo == nil || routingInfo.source == nil {
+		return nil
+	}
+
+	return newClusterTopology(routingInfo.source)
+}
+
+func newTopologyServicePorts(ports cfgNodeServices) TopologyServicePorts {
+	return TopologyServicePorts{
+		Kv:      ports.Kv,
+		KvSsl:   ports.KvSsl,
+		Capi:    ports.Capi,
+		CapiSsl: ports.CapiSsl,
+		Mgmt:    ports.Mgmt,
+		MgmtSsl: ports.MgmtSsl,
+		N1ql:    ports.N1ql,
+		N1qlSsl: ports.N1qlSsl,
+		Fts:     ports.Fts,
+		FtsSsl:  ports.FtsSsl,
+		Cbas:    ports.Cbas,
+		CbasSsl: ports.CbasSsl,
+	}
+}
+
+func newClusterTopology(cfg *routeConfig) *ClusterTopology {
+	topology := &ClusterTopology{
+		RevID:      cfg.revId,
+		BucketUUID: cfg.uuid,
+		BucketType: TopologyBucketTypeInvalid,
+	}
+
+	switch cfg.bktType {
+	case bktTypeCouchbase:
+		topology.BucketType = TopologyBucketTypeCouchbase
+	case bktTypeMemcached:
+		topology.BucketType = TopologyBucketTypeMemcached
+	case bktTypeNone:
+		topology.BucketType = TopologyBucketTypeNone
+	}
+
+	if cfg.capabilities != nil {
+		topology.Capabilities = make([]string, len(cfg.capabilities))
+		copy(topology.Capabilities, cfg.capabilities)
+	}
+
+	if cfg.kvServerList != nil {
+		topology.KvServers = make([]string, len(cfg.kvServerList))
+		copy(topology.KvServers, cfg.kvServerList)
+	}
+
+	for _, node := range cfg.nodes {
+		topoNode := TopologyNode{
+			Address:     node.address,
+			Hostname:    node.hostname,
+			Ports:       newTopologyServicePorts(node.ports),
+			ServerGroup: node.serverGroup,
+		}
+
+		if node.services != nil {
+			topoNode.Services = make([]ServiceType, len(node.services))
+			copy(topoNode.Services, node.services)
+		}
+
+		if len(node.altAddresses) > 0 {
+			topoNode.AlternateAddresses = make(map[string]TopologyAlternateAddress, len(node.altAddresses))
+			for network, altAddr := range node.altAddresses {
+				topoNode.AlternateAddresses[network] = TopologyAlternateAddress{
+					Hostname: altAddr.Hostname,
+					Ports:    newTopologyServicePorts(altAddr.Ports),
+				}
+			}
+		}
+
+		topology.Nodes = append(topology.Nodes, topoNode)
+	}
+
+	if cfg.vbMap != nil {
+		topology.NumReplicas = cfg.vbMap.numReplicas
+		topology.VbucketMap = make([][]int, len(cfg.vbMap.entries))
+		for vbID, entry := range cfg.vbMap.entries {
+			topology.VbucketMap[vbID] = make([]int, len(entry))
+			copy(topology.VbucketMap[vbID], entry)
+		}
+	}
+
+	if cfg.vbMapForward != nil {
+		topology.VbucketMapForward = make([][]int, len(cfg.vbMapForward.entries))
+		for vbID, entry := range cfg.vbMapForward.entries {
+			topology.VbucketMapForward[vbID] = make([]int, len(entry))
+			copy(topology.VbucketMapForward[vbID], entry)
+		}
+	}
+
+	if cfg.ketamaMap != nil {
+		topology.KetamaContinuum = make([]TopologyKetamaPoint, len(cfg.ketamaMap.entries))
+		for i, entry := range cfg.ketamaMap.entries {
+			topology.KetamaContinuum[i] = TopologyKetamaPoint{
+				Point:       entry.point,
+				ServerIndex: int(entry.index),
+			}
+		}
+	}
+
+	return topology
+}
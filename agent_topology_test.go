@@ -0,0 +1,185 @@
+package gocbcore
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTopologyEventDiff(t *testing.T) {
+	oldCfg := &routeConfig{
+		revId:        10,
+		kvServerList: []string{"a:11210", "b:11210"},
+		vbMap:        newVbucketMap([][]int{{0, 1}, {1, 0}, {0, 1}}, 1),
+		nodes: []routeNode{
+			{address: "a:8091", services: []ServiceType{MemdService, MgmtService}},
+			{address: "b:8091", services: []ServiceType{MemdService, MgmtService, N1qlService}},
+		},
+	}
+	newCfg := &routeConfig{
+		revId:        12,
+		kvServerList: []string{"a:11210", "c:11210"},
+		vbMap:        newVbucketMap([][]int{{0, 1}, {1, 0}, {1, 0}}, 1),
+		nodes: []routeNode{
+			{address: "a:8091", services: []ServiceType{MemdService, MgmtService, FtsService}},
+			{address: "c:8091", services: []ServiceType{MemdService, MgmtService}},
+		},
+	}
+
+	event := newTopologyEvent(oldCfg, newCfg)
+	if event.OldRevID != 10 || event.NewRevID != 12 {
+		t.Fatalf("Unexpected revisions %d and %d", event.OldRevID, event.NewRevID)
+	}
+	if len(event.AddedNodes) != 1 || event.AddedNodes[0] != "c:8091" {
+		t.Fatalf("Unexpected added nodes %v", event.AddedNodes)
+	}
+	if len(event.RemovedNodes) != 1 || event.RemovedNodes[0] != "b:8091" {
+		t.Fatalf("Unexpected removed nodes %v", event.RemovedNodes)
+	}
+	if len(event.ChangedServices) != 1 || event.ChangedServices[0].Address != "a:8091" ||
+		len(event.ChangedServices[0].AddedServices) != 1 || event.ChangedServices[0].AddedServices[0] != FtsService ||
+		len(event.ChangedServices[0].RemovedServices) != 0 {
+		t.Fatalf("Unexpected changed services %+v", event.ChangedServices)
+	}
+
+	// Vbucket 1 moves from b to c as the server list changed, and vbucket 2 from a to c.
+	if len(event.MovedVbuckets) != 2 {
+		t.Fatalf("Unexpected moved vbuckets %+v", event.MovedVbuckets)
+	}
+	if event.MovedVbuckets[0] != (TopologyVbucketMove{Vbucket: 1, OldAddress: "b:11210", NewAddress: "c:11210"}) {
+		t.Fatalf("Unexpected move %+v", event.MovedVbuckets[0])
+	}
+	if event.MovedVbuckets[1] != (TopologyVbucketMove{Vbucket: 2, OldAddress: "a:11210", NewAddress: "c:11210"}) {
+		t.Fatalf("Unexpected move %+v", event.MovedVbuckets[1])
+	}
+
+	first := newTopologyEvent(nil, newCfg)
+	if first.OldRevID != -1 || len(first.AddedNodes) != 2 || len(first.MovedVbuckets) != 0 {
+		t.Fatalf("Unexpected initial event %+v", first)
+	}
+}
+
+type testTopologyListener struct {
+	events chan *TopologyEvent
+}
+
+func (listener *testTopologyListener) TopologyChanged(event *TopologyEvent) {
+	listener.events <- event
+}
+
+func TestTopologyListeners(t *testing.T) {
+	agent := &Agent{}
+	listener := &testTopologyListener{
+		events: make(chan *TopologyEvent, 10),
+	}
+
+	// Events without any listeners are dropped.
+	agent.queueTopologyEvent(&TopologyEvent{NewRevID: 1})
+
+	if agent.hasTopologyListeners() {
+		t.Fatalf("Expected no topology listeners")
+	}
+
+	agent.AddTopologyListener(listener)
+	if !agent.hasTopologyListeners() {
+		t.Fatalf("Expected a topology listener to be registered")
+	}
+	agent.queueTopologyEvent(&TopologyEvent{NewRevID: 2})
+	agent.queueTopologyEvent(&TopologyEvent{NewRevID: 3})
+
+	for _, expectedRev := range []int64{2, 3} {
+		select {
+		case event := <-listener.events:
+			if event.NewRevID != expectedRev {
+				t.Fatalf("Expected revision %d but got %d", expectedRev, event.NewRevID)
+			}
+		case <-time.After(1 * time.Second):
+			t.Fatalf("Timed out waiting for topology event")
+		}
+	}
+
+	agent.RemoveTopologyListener(listener)
+	agent.queueTopologyEvent(&TopologyEvent{NewRevID: 4})
+
+	select {
+	case event := <-listener.events:
+		t.Fatalf("Unexpected event after removing listener %+v", event)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestClusterTopologySnapshot(t *testing.T) {
+	cfg := buildRouteConfig(loadConfigFromFile(t, "testdata/bucket_config_with_external_addresses.json"), false, "external", false)
+
+	agent := &Agent{}
+	if agent.Topology() != nil {
+		t.Fatalf("Expected no topology before a config is applied")
+	}
+
+	agent.routingInfo.Update(nil, &routeData{
+		revId:  cfg.revId,
+		vbMap:  cfg.vbMap,
+		source: cfg,
+	})
+
+	topology := agent.Topology()
+	if topology == nil {
+		t.Fatalf("Expected a topology")
+	}
+	if topology.RevID != 1073 || topology.BucketType != TopologyBucketTypeCouchbase {
+		t.Fatalf("Unexpected revision or bucket type %d, %d", topology.RevID, topology.BucketType)
+	}
+	if len(topology.Capabilities) == 0 || topology.Capabilities[0] != "couchapi" {
+		t.Fatalf("Unexpected capabilities %v", topology.Capabilities)
+	}
+	if len(topology.Nodes) == 0 || len(topology.Nodes) != len(topology.KvServers) {
+		t.Fatalf("Unexpected nodes %+v", topology.Nodes)
+	}
+
+	node := topology.Nodes[0]
+	if node.Address != "192.168.132.234:32790" || node.Hostname != "172.17.0.2" {
+		t.Fatalf("Unexpected node addresses %s, %s", node.Address, node.Hostname)
+	}
+	if node.Ports.Kv != 11210 || node.Ports.KvSsl != 11207 || node.Ports.Mgmt != 8091 {
+		t.Fatalf("Unexpected node ports %+v", node.Ports)
+	}
+	external, ok := node.AlternateAddresses["external"]
+	if !ok || external.Hostname != "192.168.132.234" || external.Ports.Kv != 32775 || external.Ports.KvSsl != 32776 {
+		t.Fatalf("Unexpected alternate addresses %+v", node.AlternateAddresses)
+	}
+
+	if topology.NumReplicas != cfg.vbMap.NumReplicas() || len(topology.VbucketMap) != cfg.vbMap.NumVbuckets() {
+		t.Fatalf("Unexpected vbucket map")
+	}
+	if len(topology.KetamaContinuum) != 0 {
+		t.Fatalf("Expected no ketama continuum for a couchbase bucket")
+	}
+
+	// Modifying the snapshot must not affect the agent's configuration.
+	topology.VbucketMap[0][0] = 99
+	topology.KvServers[0] = "changed"
+	if cfg.vbMap.entries[0][0] == 99 || cfg.kvServerList[0] == "changed" {
+		t.Fatalf("Snapshot shares state with the agent configuration")
+	}
+}
+
+func TestClusterTopologyKetama(t *testing.T) {
+	cfg := getConfig(t, "testdata/memd_4node.config.json")
+
+	topology := newClusterTopology(cfg)
+	if topology.BucketType != TopologyBucketTypeMemcached || topology.VbucketMap != nil {
+		t.Fatalf("Unexpected memcached topology %+v", topology)
+	}
+	if len(topology.KetamaContinuum) != len(cfg.ketamaMap.entries) || len(topology.KetamaContinuum) == 0 {
+		t.Fatalf("Unexpected ketama continuum length %d", len(topology.KetamaContinuum))
+	}
+	for _, point := range topology.KetamaContinuum {
+		if point.ServerIndex < 0 || point.ServerIndex >= len(topology.KvServers) {
+			t.Fatalf("Ketama point references unknown server %d", point.ServerIndex)
+		}
+	}
+	for _, node := range topology.Nodes {
+		if node.Ports.Kv == 0 || node.Ports.Mgmt == 0 {
+			t.Fatalf("Expected legacy node ports to be populated %+v", node)
+		}
+	}
+}
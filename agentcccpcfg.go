@@ -2,11 +2,50 @@ package gocbcore
 
 import (
 	"math/rand"
+	"sync/atomic"
 	"time"
 )
 
+// cccpPollPeriod returns how long to wait between polling for configs.  When the
+// server is pushing new configs to us, polling is only needed as a fallback.
+func (agent *Agent) cccpPollPeriod() time.Duration {
+	if atomic.LoadInt32(&agent.clusterMapNotifsActive) == 1 {
+		return agent.confCccpFallbackPollPeriod
+	}
+	return agent.confCccpPollPeriod
+}
+
+// handleClusterMapNotification applies a config which was pushed to us by the server.
+// Notifications without a key carry the global cluster config, which is only applied
+// by agents that are not bound to a bucket.
+func (agent *Agent) handleClusterMapNotification(req *memdQResponse) {
+	if string(req.Key) != agent.bucket {
+		logDebugf("CCCPPUSH: Ignoring config for bucket %q", req.Key)
+		return
+	}
+
+	if len(req.Value) == 0 {
+		logDebugf("CCCPPUSH: Ignoring notification without a config")
+		return
+	}
+
+	hostName, err := hostFromHostPort(req.sourceAddr)
+	if err != nil {
+		logErrorf("CCCPPUSH: Failed to parse source address. %v", err)
+		return
+	}
+
+	bk, err := parseConfig(req.Value, hostName)
+	if err != nil {
+		logDebugf("CCCPPUSH: Failed to parse config. %v", err)
+		return
+	}
+
+	logDebugf("CCCPPUSH: Received new config")
+	agent.updateConfig(bk)
+}
+
 func (agent *Agent) cccpLooper() {
-	tickTime := agent.confCccpPollPeriod
 	maxWaitTime := agent.confCccpMaxWait
 
 	logDebugf("CCCP Looper starting.")
@@ -15,7 +54,7 @@ func (agent *Agent) cccpLooper() {
 	for {
 		// Wait for either the agent to be shut down, or our tick time to expire
 		select {
-		case <-time.After(tickTime):
+		case <-time.After(agent.cccpPollPeriod()):
 		case <-agent.closeNotify:
 		}
 
@@ -28,6 +67,7 @@ func (agent *Agent) cccpLooper() {
 		numNodes := routingInfo.clientMux.NumPipelines()
 		if numNodes == 0 {
 			logDebugf("CCCPPOLL: No nodes available to poll")
+			agent.maybeRebootstrap()
 			continue
 		}
 
@@ -70,6 +110,7 @@ func (agent *Agent) cccpLooper() {
 
 		if foundConfig == nil {
 			logDebugf("CCCPPOLL: Failed to retrieve config from any node.")
+			agent.maybeRebootstrap()
 			continue
 		}
 
@@ -0,0 +1,112 @@
+package gocbcore
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newTestPipeMemdConns() (*memdTcpConn, *memdTcpConn) {
+	clientSide, serverSide := net.Pipe()
+
+	clientConn := newMemdTcpConn(clientSide, "127.0.0.1:50000", "127.0.0.1:11210")
+	serverConn := newMemdTcpConn(serverSide, "127.0.0.1:11210", "127.0.0.1:50000")
+
+	return clientConn, serverConn
+}
+
+func loadConfigBytesWithRev(t *testing.T, filename string, rev int64) []byte {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("Failed to read config: %v", err)
+	}
+
+	var cfg map[string]interface{}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+	cfg["rev"] = rev
+
+	data, err = json.Marshal(cfg)
+	if err != nil {
+		t.Fatalf("Failed to encode config: %v", err)
+	}
+	return data
+}
+
+func sendClusterMapNotification(t *testing.T, conn memdConn, bucket string, rev int64, config []byte) {
+	extras := make([]byte, 4)
+	binary.BigEndian.PutUint32(extras, uint32(rev))
+
+	err := conn.WritePacket(&memdPacket{
+		Magic:  srvReqMagic,
+		Opcode: srvCmdClustermapChangeNotification,
+		Extras: extras,
+		Key:    []byte(bucket),
+		Value:  config,
+	})
+	if err != nil {
+		t.Fatalf("Failed to send notification: %v", err)
+	}
+}
+
+func TestClusterMapNotification(t *testing.T) {
+	filename := "testdata/bucket_config_with_external_addresses.json"
+	cfg := getConfig(t, filename)
+
+	// A pool size of zero stops the pipelines from dialing the servers in the config.
+	agent := &Agent{
+		bucket:      "default",
+		clientId:    "test",
+		numVbuckets: cfg.vbMap.NumVbuckets(),
+		networkType: "default",
+		kvPoolSize:  0,
+	}
+	agent.routingInfo.Update(nil, &routeData{
+		revId: -1,
+	})
+	agent.applyConfig(cfg)
+
+	clientConn, serverConn := newTestPipeMemdConns()
+	client := newMemdClient(agent, clientConn)
+	defer func() {
+		if err := client.Close(); err != nil {
+			t.Fatalf("Failed to close client: %v", err)
+		}
+		<-client.CloseNotify()
+	}()
+
+	// The notifications for another bucket and for the cluster must be ignored, so the
+	// later notification with a lower revision is the one which is applied.
+	sendClusterMapNotification(t, serverConn, "other", 1080, loadConfigBytesWithRev(t, filename, 1080))
+	sendClusterMapNotification(t, serverConn, "", 1081, loadConfigBytesWithRev(t, filename, 1081))
+	sendClusterMapNotification(t, serverConn, "default", 1074, loadConfigBytesWithRev(t, filename, 1074))
+
+	deadline := time.Now().Add(5 * time.Second)
+	for agent.Topology().RevID != 1074 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Pushed config was not applied, revision is %d", agent.Topology().RevID)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestCccpPollPeriodFallback(t *testing.T) {
+	agent := &Agent{
+		confCccpPollPeriod:         time.Second,
+		confCccpFallbackPollPeriod: time.Minute,
+	}
+
+	if agent.cccpPollPeriod() != time.Second {
+		t.Fatalf("Expected the normal poll period without notifications")
+	}
+
+	atomic.StoreInt32(&agent.clusterMapNotifsActive, 1)
+	if agent.cccpPollPeriod() != time.Minute {
+		t.Fatalf("Expected the fallback poll period with notifications")
+	}
+}
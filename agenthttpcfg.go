@@ -34,7 +34,9 @@ func hostnameFromUri(uri string) string {
 	return hostname
 }
 
-func (agent *Agent) httpLooper(firstCfgFn func(*cfgBucket, string, error) bool) {
+// httpLooper streams configs from the management endpoints of the current config.  Until
+// the first config has been received, the seed endpoints are also tried.
+func (agent *Agent) httpLooper(seedEps []string, firstCfgFn func(*cfgBucket, string, error) bool) {
 	waitPeriod := agent.confHttpRetryDelay
 	maxConnPeriod := agent.confHttpRedialPeriod
 
@@ -51,8 +53,13 @@ func (agent *Agent) httpLooper(firstCfgFn func(*cfgBucket, string, error) bool)
 			break
 		}
 
+		mgmtEpList := routingInfo.mgmtEpList
+		if isFirstTry {
+			mgmtEpList = append(append([]string{}, mgmtEpList...), seedEps...)
+		}
+
 		var pickedSrv string
-		for _, srv := range routingInfo.mgmtEpList {
+		for _, srv := range mgmtEpList {
 			if seenNodes[srv] >= iterNum {
 				continue
 			}
@@ -70,6 +77,8 @@ func (agent *Agent) httpLooper(firstCfgFn func(*cfgBucket, string, error) bool)
 			}
 
 			if !iterSawConfig {
+				agent.maybeRebootstrap()
+
 				logDebugf("Looper waiting...")
 				// Wait for a period before trying again if there was a problem...
 				// We also watch for the client being shut down.
@@ -103,6 +112,10 @@ func (agent *Agent) httpLooper(firstCfgFn func(*cfgBucket, string, error) bool)
 			}
 			// HTTP request time!
 			uri := fmt.Sprintf("%s/pools/default/%s/%s", pickedSrv, streamPath, agent.bucket)
+			if agent.bucket == "" {
+				// Without a bucket we stream the cluster config instead.
+				uri = fmt.Sprintf("%s/pools/default/nodeServicesStreaming", pickedSrv)
+			}
 			logDebugf("Requesting config from: %s.", uri)
 
 			req, err := http.NewRequest("GET", uri, nil)
@@ -131,6 +144,10 @@ func (agent *Agent) httpLooper(firstCfgFn func(*cfgBucket, string, error) bool)
 					firstCfgFn(nil, "", ErrAuthError)
 					return -1
 				} else if resp.StatusCode == 404 {
+					if agent.bucket == "" {
+						logDebugf("Failed to connect to host, cluster config not available.")
+						return 0
+					}
 					if is2x {
 						logDebugf("Failed to connect to host, bad bucket.")
 						firstCfgFn(nil, "", ErrAuthError)
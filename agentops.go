@@ -2,6 +2,7 @@ package gocbcore
 
 import (
 	"encoding/json"
+	"sync"
 	"sync/atomic"
 	"time"
 )
@@ -53,7 +54,155 @@ func (mp *multiPendingOp) IncrementCompletedOps() uint32 {
 	return atomic.AddUint32(&mp.completedOps, 1)
 }
 
+// retryingPendingOp is the PendingOp for an operation which is attempted repeatedly,
+// backing off in between, such as an optimistic read-modify-write.  dispatch sends
+// the requests for an attempt and fail completes the operation with an error.
+type retryingPendingOp struct {
+	tracer      *opTracer
+	deadline    time.Time
+	maxAttempts uint32
+	backoff     BackoffCalculator
+	dispatch    func() (PendingOp, error)
+	fail        func(error)
+
+	attempt   uint32
+	lock      sync.Mutex
+	op        PendingOp
+	timer     *time.Timer
+	completed bool
+	cancelled bool
+}
+
+func (op *retryingPendingOp) Cancel() bool {
+	op.lock.Lock()
+	if op.completed {
+		op.lock.Unlock()
+		return false
+	}
+
+	subOp := op.op
+	if subOp == nil {
+		// We are waiting to retry, or the next attempt is already being started,
+		// in which case it is cancelled as soon as it has been dispatched.
+		if op.timer != nil {
+			op.timer.Stop()
+			op.timer = nil
+		}
+		op.completed = true
+		op.cancelled = true
+		op.lock.Unlock()
+		op.tracer.Finish()
+		return true
+	}
+	op.lock.Unlock()
+
+	if subOp == nil || !subOp.Cancel() {
+		return false
+	}
+
+	op.lock.Lock()
+	op.completed = true
+	op.lock.Unlock()
+	op.tracer.Finish()
+	return true
+}
+
+// complete marks the operation as completed, returning true if the caller is
+// responsible for invoking the callback.
+func (op *retryingPendingOp) complete() bool {
+	op.lock.Lock()
+	if op.completed {
+		op.lock.Unlock()
+		return false
+	}
+	op.completed = true
+	op.lock.Unlock()
+
+	op.tracer.Finish()
+	return true
+}
+
+// start dispatches the next attempt.
+func (op *retryingPendingOp) start() error {
+	op.attempt++
+
+	subOp, err := op.dispatch()
+	if err != nil {
+		return err
+	}
+
+	op.recordOp(subOp)
+	return nil
+}
+
+// setOp records the currently outstanding request, failing the operation if
+// the request could not be dispatched.
+func (op *retryingPendingOp) setOp(subOp PendingOp, err error) {
+	if err != nil {
+		op.fail(err)
+		return
+	}
+
+	op.recordOp(subOp)
+}
+
+// recordOp records the currently outstanding request, cancelling it if the
+// operation was cancelled while the request was being dispatched.
+func (op *retryingPendingOp) recordOp(subOp PendingOp) {
+	op.lock.Lock()
+	op.op = subOp
+	cancelled := op.cancelled
+	op.lock.Unlock()
+
+	if cancelled {
+		subOp.Cancel()
+	}
+}
+
+// retry schedules another attempt after backing off, or fails with lastErr if
+// there are no attempts remaining.
+func (op *retryingPendingOp) retry(lastErr error) {
+	if op.attempt >= op.maxAttempts {
+		op.fail(lastErr)
+		return
+	}
+
+	waitDura := jitterBackoff(op.backoff(op.attempt - 1))
+
+	if !op.deadline.IsZero() && time.Now().Add(waitDura).After(op.deadline) {
+		op.fail(ErrTimeout)
+		return
+	}
+
+	op.lock.Lock()
+	if op.completed {
+		op.lock.Unlock()
+		return
+	}
+	op.op = nil
+	op.timer = time.AfterFunc(waitDura, func() {
+		op.lock.Lock()
+		if op.cancelled {
+			op.lock.Unlock()
+			return
+		}
+		op.timer = nil
+		op.lock.Unlock()
+
+		if err := op.start(); err != nil {
+			op.fail(err)
+		}
+	})
+	op.lock.Unlock()
+}
+
 func (agent *Agent) waitAndRetryOperation(req *memdQRequest, waitDura time.Duration) {
+	if !req.Deadline.IsZero() && time.Now().Add(waitDura).After(req.Deadline) {
+		// There is no point in retrying an operation that will have timed out
+		// before the retry occurs, the deadline timer will handle it.
+		return
+	}
+
 	if waitDura == 0 {
 		agent.requeueDirect(req)
 	} else {
@@ -64,7 +213,53 @@ func (agent *Agent) waitAndRetryOperation(req *memdQRequest, waitDura time.Durat
 }
 
 func (agent *Agent) waitAndRetryNmv(req *memdQRequest) {
-	agent.waitAndRetryOperation(req, agent.nmvRetryDelay)
+	agent.retryRequest(req, RetryReasonNotMyVbucket, agent.nmvRetryDelay)
+}
+
+// retryRequest consults the retry strategy for the request and, if it should be
+// retried, schedules the retry after waiting at least minWait.  It returns false
+// if the request is not going to be retried.
+func (agent *Agent) retryRequest(req *memdQRequest, reason RetryReason, minWait time.Duration) bool {
+	waitDura := minWait
+	if !reason.AlwaysRetry() {
+		if reason != RetryReasonKvErrMap && (req.Persistent || req.ReplicaIdx == addressRoutedReplicaIdx) {
+			// These requests are not able to be transparently rerouted.  Error map
+			//  retries are resent to the same place, so they are still allowed.
+			return false
+		}
+
+		strategy := req.RetryStrategy
+		if strategy == nil {
+			strategy = agent.retryStrategy
+		}
+
+		strategyWait, shouldRetry := strategy.RetryAfter(req, reason)
+		if !shouldRetry {
+			return false
+		}
+
+		if strategyWait > waitDura {
+			waitDura = strategyWait
+		}
+	}
+
+	logDebugf("Retrying request OP=0x%x. Opaque=%d due to %s", req.Opcode, req.Opaque, reason)
+
+	// Close off any spans from this attempt, the retry will begin new ones.
+	req.processingLock.Lock()
+	agent.cancelReqTrace(req, nil)
+	req.processingLock.Unlock()
+
+	if reason == RetryReasonKvErrMap {
+		// Only error map retries count towards the error map retry delay.
+		req.retryCount++
+	}
+	req.recordRetryAttempt(reason)
+
+	// The outcome of the retry belongs to wherever it is dispatched to next.
+	req.setCircuitBreaker(nil)
+	agent.waitAndRetryOperation(req, waitDura)
+	return true
 }
 
 func (agent *Agent) handleOpNmv(resp *memdQResponse, req *memdQRequest) {
@@ -82,12 +277,22 @@ func (agent *Agent) handleOpNmv(resp *memdQResponse, req *memdQRequest) {
 		agent.updateConfig(bk)
 	}
 
+	// During a rebalance the fast-forward map most likely already names the new owner
+	// of the vbucket, so there is no need to wait before retrying against it.
+	routingInfo := agent.routingInfo.Get()
+	if routingInfo != nil && routingInfo.vbMapForward != nil && !req.Persistent && req.recordNmv() {
+		logDebugf("Retrying NMV request using the fast-forward vbucket map")
+		agent.retryRequest(req, RetryReasonNotMyVbucket, 0)
+		return
+	}
+
 	// Redirect it!  This may actually come back to this server, but I won't tell
 	//   if you don't ;)
 	agent.waitAndRetryNmv(req)
 }
 
 func (agent *Agent) handleCollectionUnknown(req *memdQRequest) {
+	req.recordRetryAttempt(RetryReasonCollectionUnknown)
 	agent.cidMgr.requeue(req)
 }
 
@@ -164,6 +369,8 @@ func (agent *Agent) makeBasicMemdError(code StatusCode, opaque uint32) error {
 
 func (agent *Agent) handleOpRoutingResp(resp *memdQResponse, req *memdQRequest, err error) (bool, error) {
 	if resp.Magic == resMagic {
+		isLocked := resp.Status == StatusLocked
+
 		// Temporary backwards compatibility handling...
 		if resp.Status == StatusLocked {
 			switch req.Opcode {
@@ -198,13 +405,24 @@ func (agent *Agent) handleOpRoutingResp(resp *memdQResponse, req *memdQRequest,
 						break
 					}
 
-					req.retryCount++
-					agent.waitAndRetryOperation(req, retryWait)
-					return true, nil
+					if agent.retryRequest(req, RetryReasonKvErrMap, retryWait) {
+						return true, nil
+					}
+					break
 				}
 			}
 		}
 
+		if isLocked {
+			if agent.retryRequest(req, RetryReasonLocked, 0) {
+				return true, nil
+			}
+		} else if resp.Status == StatusTmpFail {
+			if agent.retryRequest(req, RetryReasonTmpFail, 0) {
+				return true, nil
+			}
+		}
+
 		if DatatypeFlag(resp.Datatype)&DatatypeFlagJson != 0 {
 			err = agent.makeMemdError(resp.Status, kvErrData, resp.Opaque, resp.Value)
 
@@ -224,17 +442,73 @@ func (agent *Agent) handleOpRoutingResp(resp *memdQResponse, req *memdQRequest,
 func (agent *Agent) dispatchOp(req *memdQRequest) (PendingOp, error) {
 	req.owner = agent
 	req.dispatchTime = time.Now()
+	req.startDeadlineTimer()
+
+	op, err := agent.cidMgr.dispatch(req)
+	if err != nil {
+		if !req.abortDispatch() {
+			// The deadline has already passed and the callback has been
+			// invoked with a timeout, so we must not also return an error.
+			return req, nil
+		}
+
+		return nil, err
+	}
 
-	return agent.cidMgr.dispatch(req)
+	return op, nil
+}
+
+// dispatchOps dispatches a set of requests, batching together those which are
+// able to be sent directly to the same pipeline.  It returns the dispatch error
+// for each request, nil indicating the request will have its callback invoked.
+func (agent *Agent) dispatchOps(reqs []*memdQRequest) []error {
+	errs := make([]error, len(reqs))
+
+	var directReqs []*memdQRequest
+	var directIdxs []int
+	for i, req := range reqs {
+		req.owner = agent
+		req.dispatchTime = time.Now()
+		req.startDeadlineTimer()
+
+		// Requests whose collection id is not yet known need to go through
+		// the collection id manager to be queued until it is resolved.
+		if !agent.cidMgr.resolveDirect(req) {
+			_, errs[i] = agent.cidMgr.dispatch(req)
+			continue
+		}
+
+		directReqs = append(directReqs, req)
+		directIdxs = append(directIdxs, i)
+	}
+
+	for i, err := range agent.dispatchDirectMulti(directReqs) {
+		errs[directIdxs[i]] = err
+	}
+
+	for i, err := range errs {
+		if err != nil && !reqs[i].abortDispatch() {
+			// The deadline was reached first, and the callback was invoked.
+			errs[i] = nil
+		}
+	}
+
+	return errs
 }
 
 func (agent *Agent) dispatchOpToAddress(req *memdQRequest, address string) (PendingOp, error) {
 	req.owner = agent
 	req.dispatchTime = time.Now()
+	req.startDeadlineTimer()
 
 	err := agent.dispatchDirectToAddress(req, address)
 	if err != nil {
-		return req, nil
+		if !req.abortDispatch() {
+			return req, nil
+		}
+
+		return nil, err
 	}
+
 	return req, nil
 }
@@ -0,0 +1,463 @@
+package gocbcore
+
+import (
+	"encoding/binary"
+	"sync/atomic"
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+)
+
+// bulkOp represents a single outstanding bulk operation, made up of one
+// request per item.  The aggregated callback is invoked once every item
+// has been handled, including those which failed to dispatch.
+type bulkOp struct {
+	reqs       []*memdQRequest
+	remaining  int32
+	failItem   func(int, error)
+	onComplete func()
+}
+
+func (op *bulkOp) handledOne() {
+	if atomic.AddInt32(&op.remaining, -1) == 0 {
+		op.onComplete()
+	}
+}
+
+// Cancel cancels each of the outstanding items, which will complete with
+// ErrCancelled.  It returns true if there were outstanding items, all of which
+// were cancelled, and false if every item had already completed.
+func (op *bulkOp) Cancel() bool {
+	cancelled := false
+	for i, req := range op.reqs {
+		if req.Cancel() {
+			op.failItem(i, ErrCancelled)
+			op.handledOne()
+			cancelled = true
+		}
+	}
+	return cancelled
+}
+
+func (agent *Agent) dispatchBulkOp(op *bulkOp) (PendingOp, error) {
+	// We initialize remaining to one more than the number of items so that
+	// the callback cannot be invoked until all of the items were dispatched.
+	op.remaining = int32(len(op.reqs)) + 1
+
+	for i, err := range agent.dispatchOps(op.reqs) {
+		if err != nil {
+			op.failItem(i, err)
+			op.handledOne()
+		}
+	}
+
+	op.handledOne()
+	return op, nil
+}
+
+func bulkMutationToken(resp *memdQResponse, req *memdQRequest) MutationToken {
+	mutToken := MutationToken{}
+	if len(resp.Extras) >= 16 {
+		mutToken.VbId = req.Vbucket
+		mutToken.VbUuid = VbUuid(binary.BigEndian.Uint64(resp.Extras[0:]))
+		mutToken.SeqNo = SeqNo(binary.BigEndian.Uint64(resp.Extras[8:]))
+	}
+	return mutToken
+}
+
+func (agent *Agent) bulkDurabilityFrame(level DurabilityLevel, timeout uint16) (commandMagic, *memdFrameExtras, error) {
+	if level == 0 {
+		return reqMagic, nil, nil
+	}
+
+	if agent.durabilityLevelStatus == durabilityLevelStatusUnsupported {
+		return 0, nil, ErrEnhancedDurabilityUnsupported
+	}
+
+	return altReqMagic, &memdFrameExtras{
+		DurabilityLevel:        level,
+		DurabilityLevelTimeout: timeout,
+	}, nil
+}
+
+// GetMultiOptions encapsulates the parameters for a GetMultiEx operation.
+type GetMultiOptions struct {
+	Keys           [][]byte
+	CollectionName string
+	ScopeName      string
+	TraceContext   opentracing.SpanContext
+	Deadline       time.Time
+	RetryStrategy  RetryStrategy
+	Priority       RequestPriority
+}
+
+// GetMultiItemResult encapsulates the result of a single key within a GetMultiEx operation.
+type GetMultiItemResult struct {
+	Key    []byte
+	Result *GetResult
+	Err    error
+}
+
+// GetMultiResult encapsulates the result of a GetMultiEx operation.
+type GetMultiResult struct {
+	Items []GetMultiItemResult
+}
+
+// GetMultiExCallback is invoked upon completion of a GetMultiEx operation.
+type GetMultiExCallback func(*GetMultiResult, error)
+
+// GetMultiEx retrieves a number of documents, sending the requests for each server
+// as a single batch.  Results are returned in the same order as the keys.
+func (agent *Agent) GetMultiEx(opts GetMultiOptions, cb GetMultiExCallback) (PendingOp, error) {
+	tracer := agent.createOpTrace("GetMultiEx", opts.TraceContext)
+
+	items := make([]GetMultiItemResult, len(opts.Keys))
+	op := &bulkOp{
+		reqs: make([]*memdQRequest, len(opts.Keys)),
+		failItem: func(i int, err error) {
+			items[i].Err = err
+		},
+		onComplete: func() {
+			tracer.Finish()
+			cb(&GetMultiResult{
+				Items: items,
+			}, nil)
+		},
+	}
+
+	for i, key := range opts.Keys {
+		i := i
+		items[i].Key = key
+
+		handler := func(resp *memdQResponse, req *memdQRequest, err error) {
+			if err != nil {
+				items[i].Err = err
+			} else if len(resp.Extras) != 4 {
+				items[i].Err = ErrProtocol
+			} else {
+				items[i].Result = &GetResult{
+					Value:    resp.Value,
+					Flags:    binary.BigEndian.Uint32(resp.Extras[0:]),
+					Cas:      Cas(resp.Cas),
+					Datatype: resp.Datatype,
+					buffer:   resp.takeBuffer(),
+				}
+			}
+			op.handledOne()
+		}
+
+		op.reqs[i] = &memdQRequest{
+			memdPacket: memdPacket{
+				Magic:    reqMagic,
+				Opcode:   cmdGet,
+				Datatype: 0,
+				Cas:      0,
+				Extras:   nil,
+				Key:      key,
+				Value:    nil,
+			},
+			Callback:         handler,
+			RootTraceContext: tracer.RootContext(),
+			CollectionName:   opts.CollectionName,
+			ScopeName:        opts.ScopeName,
+			Deadline:         opts.Deadline,
+			RetryStrategy:    opts.RetryStrategy,
+			Priority:         opts.Priority,
+		}
+	}
+
+	return agent.dispatchBulkOp(op)
+}
+
+// SetMultiItem represents a single document to be stored by a SetMultiEx operation.
+type SetMultiItem struct {
+	Key      []byte
+	Value    []byte
+	Flags    uint32
+	Datatype uint8
+	Expiry   uint32
+}
+
+// SetMultiOptions encapsulates the parameters for a SetMultiEx operation.
+type SetMultiOptions struct {
+	Items                  []SetMultiItem
+	CollectionName         string
+	ScopeName              string
+	TraceContext           opentracing.SpanContext
+	Deadline               time.Time
+	RetryStrategy          RetryStrategy
+	Priority               RequestPriority
+	DurabilityLevel        DurabilityLevel
+	DurabilityLevelTimeout uint16
+}
+
+// StoreMultiItemResult encapsulates the result of a single document within a SetMultiEx operation.
+type StoreMultiItemResult struct {
+	Key    []byte
+	Result *StoreResult
+	Err    error
+}
+
+// StoreMultiResult encapsulates the result of a SetMultiEx operation.
+type StoreMultiResult struct {
+	Items []StoreMultiItemResult
+}
+
+// StoreMultiExCallback is invoked upon completion of a SetMultiEx operation.
+type StoreMultiExCallback func(*StoreMultiResult, error)
+
+// SetMultiEx stores a number of documents, sending the requests for each server
+// as a single batch.  Results are returned in the same order as the items.
+func (agent *Agent) SetMultiEx(opts SetMultiOptions, cb StoreMultiExCallback) (PendingOp, error) {
+	tracer := agent.createOpTrace("SetMultiEx", opts.TraceContext)
+
+	magic, frameExtras, err := agent.bulkDurabilityFrame(opts.DurabilityLevel, opts.DurabilityLevelTimeout)
+	if err != nil {
+		tracer.Finish()
+		return nil, err
+	}
+
+	items := make([]StoreMultiItemResult, len(opts.Items))
+	op := &bulkOp{
+		reqs: make([]*memdQRequest, len(opts.Items)),
+		failItem: func(i int, err error) {
+			items[i].Err = err
+		},
+		onComplete: func() {
+			tracer.Finish()
+			cb(&StoreMultiResult{
+				Items: items,
+			}, nil)
+		},
+	}
+
+	for i, item := range opts.Items {
+		i := i
+		items[i].Key = item.Key
+
+		handler := func(resp *memdQResponse, req *memdQRequest, err error) {
+			if err != nil {
+				items[i].Err = err
+			} else {
+				items[i].Result = &StoreResult{
+					Cas:           Cas(resp.Cas),
+					MutationToken: bulkMutationToken(resp, req),
+				}
+			}
+			op.handledOne()
+		}
+
+		extraBuf := make([]byte, 8)
+		binary.BigEndian.PutUint32(extraBuf[0:], item.Flags)
+		binary.BigEndian.PutUint32(extraBuf[4:], item.Expiry)
+
+		op.reqs[i] = &memdQRequest{
+			memdPacket: memdPacket{
+				Magic:       magic,
+				Opcode:      cmdSet,
+				Datatype:    item.Datatype,
+				Cas:         0,
+				Extras:      extraBuf,
+				Key:         item.Key,
+				Value:       item.Value,
+				FrameExtras: frameExtras,
+			},
+			Callback:         handler,
+			RootTraceContext: tracer.RootContext(),
+			CollectionName:   opts.CollectionName,
+			ScopeName:        opts.ScopeName,
+			Deadline:         opts.Deadline,
+			RetryStrategy:    opts.RetryStrategy,
+			Priority:         opts.Priority,
+		}
+	}
+
+	return agent.dispatchBulkOp(op)
+}
+
+// DeleteMultiItem represents a single document to be removed by a DeleteMultiEx operation.
+type DeleteMultiItem struct {
+	Key []byte
+	Cas Cas
+}
+
+// DeleteMultiOptions encapsulates the parameters for a DeleteMultiEx operation.
+type DeleteMultiOptions struct {
+	Items                  []DeleteMultiItem
+	CollectionName         string
+	ScopeName              string
+	TraceContext           opentracing.SpanContext
+	Deadline               time.Time
+	RetryStrategy          RetryStrategy
+	Priority               RequestPriority
+	DurabilityLevel        DurabilityLevel
+	DurabilityLevelTimeout uint16
+}
+
+// DeleteMultiItemResult encapsulates the result of a single document within a DeleteMultiEx operation.
+type DeleteMultiItemResult struct {
+	Key    []byte
+	Result *DeleteResult
+	Err    error
+}
+
+// DeleteMultiResult encapsulates the result of a DeleteMultiEx operation.
+type DeleteMultiResult struct {
+	Items []DeleteMultiItemResult
+}
+
+// DeleteMultiExCallback is invoked upon completion of a DeleteMultiEx operation.
+type DeleteMultiExCallback func(*DeleteMultiResult, error)
+
+// DeleteMultiEx removes a number of documents, sending the requests for each server
+// as a single batch.  Results are returned in the same order as the items.
+func (agent *Agent) DeleteMultiEx(opts DeleteMultiOptions, cb DeleteMultiExCallback) (PendingOp, error) {
+	tracer := agent.createOpTrace("DeleteMultiEx", opts.TraceContext)
+
+	magic, frameExtras, err := agent.bulkDurabilityFrame(opts.DurabilityLevel, opts.DurabilityLevelTimeout)
+	if err != nil {
+		tracer.Finish()
+		return nil, err
+	}
+
+	items := make([]DeleteMultiItemResult, len(opts.Items))
+	op := &bulkOp{
+		reqs: make([]*memdQRequest, len(opts.Items)),
+		failItem: func(i int, err error) {
+			items[i].Err = err
+		},
+		onComplete: func() {
+			tracer.Finish()
+			cb(&DeleteMultiResult{
+				Items: items,
+			}, nil)
+		},
+	}
+
+	for i, item := range opts.Items {
+		i := i
+		items[i].Key = item.Key
+
+		handler := func(resp *memdQResponse, req *memdQRequest, err error) {
+			if err != nil {
+				items[i].Err = err
+			} else {
+				items[i].Result = &DeleteResult{
+					Cas:           Cas(resp.Cas),
+					MutationToken: bulkMutationToken(resp, req),
+				}
+			}
+			op.handledOne()
+		}
+
+		op.reqs[i] = &memdQRequest{
+			memdPacket: memdPacket{
+				Magic:       magic,
+				Opcode:      cmdDelete,
+				Datatype:    0,
+				Cas:         uint64(item.Cas),
+				Extras:      nil,
+				Key:         item.Key,
+				Value:       nil,
+				FrameExtras: frameExtras,
+			},
+			Callback:         handler,
+			RootTraceContext: tracer.RootContext(),
+			CollectionName:   opts.CollectionName,
+			ScopeName:        opts.ScopeName,
+			Deadline:         opts.Deadline,
+			RetryStrategy:    opts.RetryStrategy,
+			Priority:         opts.Priority,
+		}
+	}
+
+	return agent.dispatchBulkOp(op)
+}
+
+// TouchMultiOptions encapsulates the parameters for a TouchMultiEx operation.
+type TouchMultiOptions struct {
+	Keys           [][]byte
+	Expiry         uint32
+	CollectionName string
+	ScopeName      string
+	TraceContext   opentracing.SpanContext
+	Deadline       time.Time
+	RetryStrategy  RetryStrategy
+	Priority       RequestPriority
+}
+
+// TouchMultiItemResult encapsulates the result of a single key within a TouchMultiEx operation.
+type TouchMultiItemResult struct {
+	Key    []byte
+	Result *TouchResult
+	Err    error
+}
+
+// TouchMultiResult encapsulates the result of a TouchMultiEx operation.
+type TouchMultiResult struct {
+	Items []TouchMultiItemResult
+}
+
+// TouchMultiExCallback is invoked upon completion of a TouchMultiEx operation.
+type TouchMultiExCallback func(*TouchMultiResult, error)
+
+// TouchMultiEx updates the expiry for a number of documents, sending the requests
+// for each server as a single batch.  Results are returned in the same order as the keys.
+func (agent *Agent) TouchMultiEx(opts TouchMultiOptions, cb TouchMultiExCallback) (PendingOp, error) {
+	tracer := agent.createOpTrace("TouchMultiEx", opts.TraceContext)
+
+	items := make([]TouchMultiItemResult, len(opts.Keys))
+	op := &bulkOp{
+		reqs: make([]*memdQRequest, len(opts.Keys)),
+		failItem: func(i int, err error) {
+			items[i].Err = err
+		},
+		onComplete: func() {
+			tracer.Finish()
+			cb(&TouchMultiResult{
+				Items: items,
+			}, nil)
+		},
+	}
+
+	for i, key := range opts.Keys {
+		i := i
+		items[i].Key = key
+
+		handler := func(resp *memdQResponse, req *memdQRequest, err error) {
+			if err != nil {
+				items[i].Err = err
+			} else {
+				items[i].Result = &TouchResult{
+					Cas:           Cas(resp.Cas),
+					MutationToken: bulkMutationToken(resp, req),
+				}
+			}
+			op.handledOne()
+		}
+
+		extraBuf := make([]byte, 4)
+		binary.BigEndian.PutUint32(extraBuf[0:], opts.Expiry)
+
+		op.reqs[i] = &memdQRequest{
+			memdPacket: memdPacket{
+				Magic:    reqMagic,
+				Opcode:   cmdTouch,
+				Datatype: 0,
+				Cas:      0,
+				Extras:   extraBuf,
+				Key:      key,
+				Value:    nil,
+			},
+			Callback:         handler,
+			RootTraceContext: tracer.RootContext(),
+			CollectionName:   opts.CollectionName,
+			ScopeName:        opts.ScopeName,
+			Deadline:         opts.Deadline,
+			RetryStrategy:    opts.RetryStrategy,
+			Priority:         opts.Priority,
+		}
+	}
+
+	return agent.dispatchBulkOp(op)
+}
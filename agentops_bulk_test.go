@@ -0,0 +1,41 @@
+package gocbcore
+
+import (
+	"testing"
+)
+
+func TestBulkOpCancel(t *testing.T) {
+	var failed []int
+	completed := false
+	op := &bulkOp{
+		reqs: []*memdQRequest{{}, {}},
+		failItem: func(idx int, err error) {
+			if err != ErrCancelled {
+				t.Errorf("Expected ErrCancelled but got %v", err)
+			}
+			failed = append(failed, idx)
+		},
+		onComplete: func() {
+			completed = true
+		},
+	}
+	op.remaining = 2
+
+	// The first item has already completed, so only the second is outstanding.
+	op.reqs[0].isCompleted = 1
+	op.remaining--
+
+	if !op.Cancel() {
+		t.Fatalf("Expected cancelling the outstanding item to succeed")
+	}
+	if len(failed) != 1 || failed[0] != 1 {
+		t.Fatalf("Expected only the outstanding item to be cancelled, got %v", failed)
+	}
+	if !completed {
+		t.Fatalf("Expected the operation to complete once every item was handled")
+	}
+
+	if op.Cancel() {
+		t.Fatalf("Expected cancelling a completed operation to fail")
+	}
+}
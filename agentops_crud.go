@@ -3,6 +3,7 @@ package gocbcore
 import (
 	"encoding/binary"
 	"sync"
+	"time"
 
 	"github.com/opentracing/opentracing-go"
 )
@@ -13,6 +14,9 @@ type GetOptions struct {
 	CollectionName string
 	ScopeName      string
 	TraceContext   opentracing.SpanContext
+	Deadline       time.Time
+	RetryStrategy  RetryStrategy
+	Priority       RequestPriority
 }
 
 // GetResult encapsulates the result of a GetEx operation.
@@ -21,6 +25,16 @@ type GetResult struct {
 	Flags    uint32
 	Datatype uint8
 	Cas      Cas
+
+	buffer *memdBuffer
+}
+
+// Release returns the buffer backing Value to the pool when pooled buffers are enabled,
+// otherwise it does nothing.  Value must not be used once the result is released.
+func (result *GetResult) Release() {
+	result.buffer.release()
+	result.buffer = nil
+	result.Value = nil
 }
 
 // GetExCallback is invoked upon completion of a GetEx operation.
@@ -48,6 +62,7 @@ func (agent *Agent) GetEx(opts GetOptions, cb GetExCallback) (PendingOp, error)
 		res.Flags = binary.BigEndian.Uint32(resp.Extras[0:])
 		res.Cas = Cas(resp.Cas)
 		res.Datatype = resp.Datatype
+		res.buffer = resp.takeBuffer()
 
 		tracer.Finish()
 		cb(&res, nil)
@@ -67,6 +82,9 @@ func (agent *Agent) GetEx(opts GetOptions, cb GetExCallback) (PendingOp, error)
 		RootTraceContext: tracer.RootContext(),
 		CollectionName:   opts.CollectionName,
 		ScopeName:        opts.ScopeName,
+		Deadline:         opts.Deadline,
+		RetryStrategy:    opts.RetryStrategy,
+		Priority:         opts.Priority,
 	}
 
 	return agent.dispatchOp(req)
@@ -77,6 +95,9 @@ type GetAndTouchOptions struct {
 	Key                    []byte
 	Expiry                 uint32
 	TraceContext           opentracing.SpanContext
+	Deadline               time.Time
+	RetryStrategy          RetryStrategy
+	Priority               RequestPriority
 	CollectionName         string
 	ScopeName              string
 	DurabilityLevel        DurabilityLevel
@@ -89,6 +110,16 @@ type GetAndTouchResult struct {
 	Flags    uint32
 	Datatype uint8
 	Cas      Cas
+
+	buffer *memdBuffer
+}
+
+// Release returns the buffer backing Value to the pool when pooled buffers are enabled,
+// otherwise it does nothing.  Value must not be used once the result is released.
+func (result *GetAndTouchResult) Release() {
+	result.buffer.release()
+	result.buffer = nil
+	result.Value = nil
 }
 
 // GetAndTouchExCallback is invoked upon completion of a GetAndTouchEx operation.
@@ -119,6 +150,7 @@ func (agent *Agent) GetAndTouchEx(opts GetAndTouchOptions, cb GetAndTouchExCallb
 			Flags:    flags,
 			Cas:      Cas(resp.Cas),
 			Datatype: resp.Datatype,
+			buffer:   resp.takeBuffer(),
 		}, nil)
 	}
 
@@ -152,6 +184,9 @@ func (agent *Agent) GetAndTouchEx(opts GetAndTouchOptions, cb GetAndTouchExCallb
 		RootTraceContext: tracer.RootContext(),
 		CollectionName:   opts.CollectionName,
 		ScopeName:        opts.ScopeName,
+		Deadline:         opts.Deadline,
+		RetryStrategy:    opts.RetryStrategy,
+		Priority:         opts.Priority,
 	}
 
 	return agent.dispatchOp(req)
@@ -162,6 +197,9 @@ type GetAndLockOptions struct {
 	Key            []byte
 	LockTime       uint32
 	TraceContext   opentracing.SpanContext
+	Deadline       time.Time
+	RetryStrategy  RetryStrategy
+	Priority       RequestPriority
 	CollectionName string
 	ScopeName      string
 }
@@ -172,6 +210,16 @@ type GetAndLockResult struct {
 	Flags    uint32
 	Datatype uint8
 	Cas      Cas
+
+	buffer *memdBuffer
+}
+
+// Release returns the buffer backing Value to the pool when pooled buffers are enabled,
+// otherwise it does nothing.  Value must not be used once the result is released.
+func (result *GetAndLockResult) Release() {
+	result.buffer.release()
+	result.buffer = nil
+	result.Value = nil
 }
 
 // GetAndLockExCallback is invoked upon completion of a GetAndLockEx operation.
@@ -202,6 +250,7 @@ func (agent *Agent) GetAndLockEx(opts GetAndLockOptions, cb GetAndLockExCallback
 			Flags:    flags,
 			Cas:      Cas(resp.Cas),
 			Datatype: resp.Datatype,
+			buffer:   resp.takeBuffer(),
 		}, nil)
 	}
 
@@ -222,6 +271,9 @@ func (agent *Agent) GetAndLockEx(opts GetAndLockOptions, cb GetAndLockExCallback
 		RootTraceContext: tracer.RootContext(),
 		CollectionName:   opts.CollectionName,
 		ScopeName:        opts.ScopeName,
+		Deadline:         opts.Deadline,
+		RetryStrategy:    opts.RetryStrategy,
+		Priority:         opts.Priority,
 	}
 
 	return agent.dispatchOp(req)
@@ -232,6 +284,9 @@ type GetReplicaOptions struct {
 	Key            []byte
 	ReplicaIdx     int
 	TraceContext   opentracing.SpanContext
+	Deadline       time.Time
+	RetryStrategy  RetryStrategy
+	Priority       RequestPriority
 	CollectionName string
 	ScopeName      string
 }
@@ -242,6 +297,16 @@ type GetReplicaResult struct {
 	Flags    uint32
 	Datatype uint8
 	Cas      Cas
+
+	buffer *memdBuffer
+}
+
+// Release returns the buffer backing Value to the pool when pooled buffers are enabled,
+// otherwise it does nothing.  Value must not be used once the result is released.
+func (result *GetReplicaResult) Release() {
+	result.buffer.release()
+	result.buffer = nil
+	result.Value = nil
 }
 
 // GetReplicaExCallback is invoked upon completion of a GetReplicaEx operation.
@@ -270,6 +335,7 @@ func (agent *Agent) getOneReplica(tracer *opTracer, opts GetReplicaOptions, cb G
 			Flags:    flags,
 			Cas:      Cas(resp.Cas),
 			Datatype: resp.Datatype,
+			buffer:   resp.takeBuffer(),
 		}, nil)
 	}
 
@@ -288,6 +354,9 @@ func (agent *Agent) getOneReplica(tracer *opTracer, opts GetReplicaOptions, cb G
 		RootTraceContext: tracer.RootContext(),
 		CollectionName:   opts.CollectionName,
 		ScopeName:        opts.ScopeName,
+		Deadline:         opts.Deadline,
+		RetryStrategy:    opts.RetryStrategy,
+		Priority:         opts.Priority,
 	}
 
 	return agent.dispatchOp(req)
@@ -318,6 +387,7 @@ func (agent *Agent) GetReplicaEx(opts GetReplicaOptions, cb GetReplicaExCallback
 
 	var resultLock sync.Mutex
 	var firstResult *GetReplicaResult
+	var timedOut bool
 
 	op := new(multiPendingOp)
 	expected := uint32(numReplicas)
@@ -327,6 +397,10 @@ func (agent *Agent) GetReplicaEx(opts GetReplicaOptions, cb GetReplicaExCallback
 		if expected-completed == 0 {
 			if firstResult == nil {
 				tracer.Finish()
+				if timedOut {
+					cb(nil, ErrTimeout)
+					return
+				}
 				cb(nil, ErrNoReplicas)
 				return
 			}
@@ -340,7 +414,11 @@ func (agent *Agent) GetReplicaEx(opts GetReplicaOptions, cb GetReplicaExCallback
 		resultLock.Lock()
 
 		if err != nil {
+			if err == ErrTimeout {
+				timedOut = true
+			}
 			opHandledLocked()
+			resultLock.Unlock()
 			return
 		}
 
@@ -371,6 +449,9 @@ func (agent *Agent) GetReplicaEx(opts GetReplicaOptions, cb GetReplicaExCallback
 			ReplicaIdx:     repIdx,
 			CollectionName: opts.CollectionName,
 			ScopeName:      opts.ScopeName,
+			Deadline:       opts.Deadline,
+			RetryStrategy:  opts.RetryStrategy,
+			Priority:       opts.Priority,
 		}, handler)
 
 		resultLock.Lock()
@@ -394,6 +475,9 @@ type TouchOptions struct {
 	Cas                    Cas
 	Expiry                 uint32
 	TraceContext           opentracing.SpanContext
+	Deadline               time.Time
+	RetryStrategy          RetryStrategy
+	Priority               RequestPriority
 	CollectionName         string
 	ScopeName              string
 	DurabilityLevel        DurabilityLevel
@@ -468,6 +552,9 @@ func (agent *Agent) TouchEx(opts TouchOptions, cb TouchExCallback) (PendingOp, e
 		RootTraceContext: tracer.RootContext(),
 		CollectionName:   opts.CollectionName,
 		ScopeName:        opts.ScopeName,
+		Deadline:         opts.Deadline,
+		RetryStrategy:    opts.RetryStrategy,
+		Priority:         opts.Priority,
 	}
 
 	return agent.dispatchOp(req)
@@ -478,6 +565,9 @@ type UnlockOptions struct {
 	Key            []byte
 	Cas            Cas
 	TraceContext   opentracing.SpanContext
+	Deadline       time.Time
+	RetryStrategy  RetryStrategy
+	Priority       RequestPriority
 	CollectionName string
 	ScopeName      string
 }
@@ -530,6 +620,9 @@ func (agent *Agent) UnlockEx(opts UnlockOptions, cb UnlockExCallback) (PendingOp
 		RootTraceContext: tracer.RootContext(),
 		CollectionName:   opts.CollectionName,
 		ScopeName:        opts.ScopeName,
+		Deadline:         opts.Deadline,
+		RetryStrategy:    opts.RetryStrategy,
+		Priority:         opts.Priority,
 	}
 
 	return agent.dispatchOp(req)
@@ -542,8 +635,13 @@ type DeleteOptions struct {
 	ScopeName              string
 	Cas                    Cas
 	TraceContext           opentracing.SpanContext
+	Deadline               time.Time
+	RetryStrategy          RetryStrategy
+	Priority               RequestPriority
 	DurabilityLevel        DurabilityLevel
 	DurabilityLevelTimeout uint16
+	PersistTo              uint
+	ReplicateTo            uint
 }
 
 // DeleteResult encapsulates the result of a DeleteEx operation.
@@ -559,6 +657,12 @@ type DeleteExCallback func(*DeleteResult, error)
 func (agent *Agent) DeleteEx(opts DeleteOptions, cb DeleteExCallback) (PendingOp, error) {
 	tracer := agent.createOpTrace("DeleteEx", opts.TraceContext)
 
+	chain, err := newObserveChainOp(opts.PersistTo, opts.ReplicateTo, opts.DurabilityLevel)
+	if err != nil {
+		tracer.Finish()
+		return nil, err
+	}
+
 	handler := func(resp *memdQResponse, req *memdQRequest, err error) {
 		if err != nil {
 			tracer.Finish()
@@ -573,11 +677,26 @@ func (agent *Agent) DeleteEx(opts DeleteOptions, cb DeleteExCallback) (PendingOp
 			mutToken.SeqNo = SeqNo(binary.BigEndian.Uint64(resp.Extras[8:]))
 		}
 
-		tracer.Finish()
-		cb(&DeleteResult{
+		res := &DeleteResult{
 			Cas:           Cas(resp.Cas),
 			MutationToken: mutToken,
-		}, nil)
+		}
+
+		chain.observeMutation(agent, tracer, DurabilityOptions{
+			Key:            opts.Key,
+			CollectionName: opts.CollectionName,
+			ScopeName:      opts.ScopeName,
+			MutationToken:  mutToken,
+			Cas:            res.Cas,
+			IsDelete:       true,
+			PersistTo:      opts.PersistTo,
+			ReplicateTo:    opts.ReplicateTo,
+			Deadline:       opts.Deadline,
+			RetryStrategy:  opts.RetryStrategy,
+			Priority:       opts.Priority,
+		}, func(err error) {
+			cb(res, err)
+		})
 	}
 
 	magic := reqMagic
@@ -607,8 +726,14 @@ func (agent *Agent) DeleteEx(opts DeleteOptions, cb DeleteExCallback) (PendingOp
 		RootTraceContext: tracer.RootContext(),
 		CollectionName:   opts.CollectionName,
 		ScopeName:        opts.ScopeName,
+		Deadline:         opts.Deadline,
+		RetryStrategy:    opts.RetryStrategy,
+		Priority:         opts.Priority,
 	}
 
+	if chain != nil {
+		return chain.dispatched(agent.dispatchOp(req))
+	}
 	return agent.dispatchOp(req)
 }
 
@@ -622,8 +747,13 @@ type storeOptions struct {
 	Cas                    Cas
 	Expiry                 uint32
 	TraceContext           opentracing.SpanContext
+	Deadline               time.Time
+	RetryStrategy          RetryStrategy
+	Priority               RequestPriority
 	DurabilityLevel        DurabilityLevel
 	DurabilityLevelTimeout uint16
+	PersistTo              uint
+	ReplicateTo            uint
 }
 
 // StoreResult encapsulates the result of a AddEx, SetEx or ReplaceEx operation.
@@ -638,6 +768,12 @@ type StoreExCallback func(*StoreResult, error)
 func (agent *Agent) storeEx(opName string, opcode commandCode, opts storeOptions, cb StoreExCallback) (PendingOp, error) {
 	tracer := agent.createOpTrace(opName, opts.TraceContext)
 
+	chain, err := newObserveChainOp(opts.PersistTo, opts.ReplicateTo, opts.DurabilityLevel)
+	if err != nil {
+		tracer.Finish()
+		return nil, err
+	}
+
 	handler := func(resp *memdQResponse, req *memdQRequest, err error) {
 		if err != nil {
 			tracer.Finish()
@@ -652,11 +788,25 @@ func (agent *Agent) storeEx(opName string, opcode commandCode, opts storeOptions
 			mutToken.SeqNo = SeqNo(binary.BigEndian.Uint64(resp.Extras[8:]))
 		}
 
-		tracer.Finish()
-		cb(&StoreResult{
+		res := &StoreResult{
 			Cas:           Cas(resp.Cas),
 			MutationToken: mutToken,
-		}, nil)
+		}
+
+		chain.observeMutation(agent, tracer, DurabilityOptions{
+			Key:            opts.Key,
+			CollectionName: opts.CollectionName,
+			ScopeName:      opts.ScopeName,
+			MutationToken:  mutToken,
+			Cas:            res.Cas,
+			PersistTo:      opts.PersistTo,
+			ReplicateTo:    opts.ReplicateTo,
+			Deadline:       opts.Deadline,
+			RetryStrategy:  opts.RetryStrategy,
+			Priority:       opts.Priority,
+		}, func(err error) {
+			cb(res, err)
+		})
 	}
 
 	magic := reqMagic
@@ -689,8 +839,14 @@ func (agent *Agent) storeEx(opName string, opcode commandCode, opts storeOptions
 		RootTraceContext: tracer.RootContext(),
 		CollectionName:   opts.CollectionName,
 		ScopeName:        opts.ScopeName,
+		Deadline:         opts.Deadline,
+		RetryStrategy:    opts.RetryStrategy,
+		Priority:         opts.Priority,
 	}
 
+	if chain != nil {
+		return chain.dispatched(agent.dispatchOp(req))
+	}
 	return agent.dispatchOp(req)
 }
 
@@ -704,8 +860,13 @@ type AddOptions struct {
 	Datatype               uint8
 	Expiry                 uint32
 	TraceContext           opentracing.SpanContext
+	Deadline               time.Time
+	RetryStrategy          RetryStrategy
+	Priority               RequestPriority
 	DurabilityLevel        DurabilityLevel
 	DurabilityLevelTimeout uint16
+	PersistTo              uint
+	ReplicateTo            uint
 }
 
 // AddEx stores a document as long as it does not already exist.
@@ -720,8 +881,13 @@ func (agent *Agent) AddEx(opts AddOptions, cb StoreExCallback) (PendingOp, error
 		Cas:                    0,
 		Expiry:                 opts.Expiry,
 		TraceContext:           opts.TraceContext,
+		Deadline:               opts.Deadline,
+		RetryStrategy:          opts.RetryStrategy,
+		Priority:               opts.Priority,
 		DurabilityLevel:        opts.DurabilityLevel,
 		DurabilityLevelTimeout: opts.DurabilityLevelTimeout,
+		PersistTo:              opts.PersistTo,
+		ReplicateTo:            opts.ReplicateTo,
 	}, cb)
 }
 
@@ -735,8 +901,13 @@ type SetOptions struct {
 	Datatype               uint8
 	Expiry                 uint32
 	TraceContext           opentracing.SpanContext
+	Deadline               time.Time
+	RetryStrategy          RetryStrategy
+	Priority               RequestPriority
 	DurabilityLevel        DurabilityLevel
 	DurabilityLevelTimeout uint16
+	PersistTo              uint
+	ReplicateTo            uint
 }
 
 // SetEx stores a document.
@@ -751,8 +922,13 @@ func (agent *Agent) SetEx(opts SetOptions, cb StoreExCallback) (PendingOp, error
 		Cas:                    0,
 		Expiry:                 opts.Expiry,
 		TraceContext:           opts.TraceContext,
+		Deadline:               opts.Deadline,
+		RetryStrategy:          opts.RetryStrategy,
+		Priority:               opts.Priority,
 		DurabilityLevel:        opts.DurabilityLevel,
 		DurabilityLevelTimeout: opts.DurabilityLevelTimeout,
+		PersistTo:              opts.PersistTo,
+		ReplicateTo:            opts.ReplicateTo,
 	}, cb)
 }
 
@@ -767,8 +943,13 @@ type ReplaceOptions struct {
 	Cas                    Cas
 	Expiry                 uint32
 	TraceContext           opentracing.SpanContext
+	Deadline               time.Time
+	RetryStrategy          RetryStrategy
+	Priority               RequestPriority
 	DurabilityLevel        DurabilityLevel
 	DurabilityLevelTimeout uint16
+	PersistTo              uint
+	ReplicateTo            uint
 }
 
 // ReplaceEx replaces the value of a Couchbase document with another value.
@@ -783,8 +964,13 @@ func (agent *Agent) ReplaceEx(opts ReplaceOptions, cb StoreExCallback) (PendingO
 		Cas:                    opts.Cas,
 		Expiry:                 opts.Expiry,
 		TraceContext:           opts.TraceContext,
+		Deadline:               opts.Deadline,
+		RetryStrategy:          opts.RetryStrategy,
+		Priority:               opts.Priority,
 		DurabilityLevel:        opts.DurabilityLevel,
 		DurabilityLevelTimeout: opts.DurabilityLevelTimeout,
+		PersistTo:              opts.PersistTo,
+		ReplicateTo:            opts.ReplicateTo,
 	}, cb)
 }
 
@@ -795,9 +981,14 @@ type AdjoinOptions struct {
 	CollectionName         string
 	ScopeName              string
 	TraceContext           opentracing.SpanContext
+	Deadline               time.Time
+	RetryStrategy          RetryStrategy
+	Priority               RequestPriority
 	Cas                    Cas
 	DurabilityLevel        DurabilityLevel
 	DurabilityLevelTimeout uint16
+	PersistTo              uint
+	ReplicateTo            uint
 }
 
 // AdjoinResult encapsulates the result of a AppendEx or PrependEx operation.
@@ -812,6 +1003,12 @@ type AdjoinExCallback func(*AdjoinResult, error)
 func (agent *Agent) adjoinEx(opName string, opcode commandCode, opts AdjoinOptions, cb AdjoinExCallback) (PendingOp, error) {
 	tracer := agent.createOpTrace(opName, opts.TraceContext)
 
+	chain, err := newObserveChainOp(opts.PersistTo, opts.ReplicateTo, opts.DurabilityLevel)
+	if err != nil {
+		tracer.Finish()
+		return nil, err
+	}
+
 	handler := func(resp *memdQResponse, req *memdQRequest, err error) {
 		if err != nil {
 			tracer.Finish()
@@ -826,11 +1023,25 @@ func (agent *Agent) adjoinEx(opName string, opcode commandCode, opts AdjoinOptio
 			mutToken.SeqNo = SeqNo(binary.BigEndian.Uint64(resp.Extras[8:]))
 		}
 
-		tracer.Finish()
-		cb(&AdjoinResult{
+		res := &AdjoinResult{
 			Cas:           Cas(resp.Cas),
 			MutationToken: mutToken,
-		}, nil)
+		}
+
+		chain.observeMutation(agent, tracer, DurabilityOptions{
+			Key:            opts.Key,
+			CollectionName: opts.CollectionName,
+			ScopeName:      opts.ScopeName,
+			MutationToken:  mutToken,
+			Cas:            res.Cas,
+			PersistTo:      opts.PersistTo,
+			ReplicateTo:    opts.ReplicateTo,
+			Deadline:       opts.Deadline,
+			RetryStrategy:  opts.RetryStrategy,
+			Priority:       opts.Priority,
+		}, func(err error) {
+			cb(res, err)
+		})
 	}
 
 	magic := reqMagic
@@ -860,8 +1071,14 @@ func (agent *Agent) adjoinEx(opName string, opcode commandCode, opts AdjoinOptio
 		RootTraceContext: tracer.RootContext(),
 		CollectionName:   opts.CollectionName,
 		ScopeName:        opts.ScopeName,
+		Deadline:         opts.Deadline,
+		RetryStrategy:    opts.RetryStrategy,
+		Priority:         opts.Priority,
 	}
 
+	if chain != nil {
+		return chain.dispatched(agent.dispatchOp(req))
+	}
 	return agent.dispatchOp(req)
 }
 
@@ -882,11 +1099,16 @@ type CounterOptions struct {
 	Initial                uint64
 	Expiry                 uint32
 	TraceContext           opentracing.SpanContext
+	Deadline               time.Time
+	RetryStrategy          RetryStrategy
+	Priority               RequestPriority
 	CollectionName         string
 	ScopeName              string
 	Cas                    Cas
 	DurabilityLevel        DurabilityLevel
 	DurabilityLevelTimeout uint16
+	PersistTo              uint
+	ReplicateTo            uint
 }
 
 // CounterResult encapsulates the result of a IncrementEx or DecrementEx operation.
@@ -902,6 +1124,12 @@ type CounterExCallback func(*CounterResult, error)
 func (agent *Agent) counterEx(opName string, opcode commandCode, opts CounterOptions, cb CounterExCallback) (PendingOp, error) {
 	tracer := agent.createOpTrace(opName, opts.TraceContext)
 
+	chain, err := newObserveChainOp(opts.PersistTo, opts.ReplicateTo, opts.DurabilityLevel)
+	if err != nil {
+		tracer.Finish()
+		return nil, err
+	}
+
 	handler := func(resp *memdQResponse, req *memdQRequest, err error) {
 		if err != nil {
 			tracer.Finish()
@@ -923,12 +1151,26 @@ func (agent *Agent) counterEx(opName string, opcode commandCode, opts CounterOpt
 			mutToken.SeqNo = SeqNo(binary.BigEndian.Uint64(resp.Extras[8:]))
 		}
 
-		tracer.Finish()
-		cb(&CounterResult{
+		res := &CounterResult{
 			Value:         intVal,
 			Cas:           Cas(resp.Cas),
 			MutationToken: mutToken,
-		}, nil)
+		}
+
+		chain.observeMutation(agent, tracer, DurabilityOptions{
+			Key:            opts.Key,
+			CollectionName: opts.CollectionName,
+			ScopeName:      opts.ScopeName,
+			MutationToken:  mutToken,
+			Cas:            res.Cas,
+			PersistTo:      opts.PersistTo,
+			ReplicateTo:    opts.ReplicateTo,
+			Deadline:       opts.Deadline,
+			RetryStrategy:  opts.RetryStrategy,
+			Priority:       opts.Priority,
+		}, func(err error) {
+			cb(res, err)
+		})
 	}
 
 	// You cannot have an expiry when you do not want to create the document.
@@ -973,8 +1215,14 @@ func (agent *Agent) counterEx(opName string, opcode commandCode, opts CounterOpt
 		RootTraceContext: tracer.RootContext(),
 		CollectionName:   opts.CollectionName,
 		ScopeName:        opts.ScopeName,
+		Deadline:         opts.Deadline,
+		RetryStrategy:    opts.RetryStrategy,
+		Priority:         opts.Priority,
 	}
 
+	if chain != nil {
+		return chain.dispatched(agent.dispatchOp(req))
+	}
 	return agent.dispatchOp(req)
 }
 
@@ -990,7 +1238,10 @@ func (agent *Agent) DecrementEx(opts CounterOptions, cb CounterExCallback) (Pend
 
 // GetRandomOptions encapsulates the parameters for a GetRandomEx operation.
 type GetRandomOptions struct {
-	TraceContext opentracing.SpanContext
+	TraceContext  opentracing.SpanContext
+	Deadline      time.Time
+	RetryStrategy RetryStrategy
+	Priority      RequestPriority
 }
 
 // GetRandomResult encapsulates the result of a GetRandomEx operation.
@@ -1045,6 +1296,9 @@ func (agent *Agent) GetRandomEx(opts GetRandomOptions, cb GetRandomExCallback) (
 		},
 		Callback:         handler,
 		RootTraceContext: tracer.RootContext(),
+		Deadline:         opts.Deadline,
+		RetryStrategy:    opts.RetryStrategy,
+		Priority:         opts.Priority,
 	}
 
 	return agent.dispatchOp(req)
@@ -1058,8 +1312,11 @@ type SingleServerStats struct {
 
 // StatsOptions encapsulates the parameters for a StatsEx operation.
 type StatsOptions struct {
-	Key          string
-	TraceContext opentracing.SpanContext
+	Key           string
+	TraceContext  opentracing.SpanContext
+	Deadline      time.Time
+	RetryStrategy RetryStrategy
+	Priority      RequestPriority
 }
 
 // StatsResult encapsulates the result of a StatsEx operation.
@@ -1100,9 +1357,7 @@ func (agent *Agent) StatsEx(opts StatsOptions, cb StatsExCallback) (PendingOp, e
 		}
 	}
 
-	handler := func(resp *memdQResponse, req *memdQRequest, err error) {
-		serverAddress := resp.sourceAddr
-
+	handler := func(serverAddress string, resp *memdQResponse, req *memdQRequest, err error) {
 		statsLock.Lock()
 		defer statsLock.Unlock()
 
@@ -1121,14 +1376,16 @@ func (agent *Agent) StatsEx(opts StatsOptions, cb StatsExCallback) (PendingOp, e
 			// server's stats entry.
 			if curStats.Error == nil {
 				curStats.Error = err
+				stats[serverAddress] = curStats
 			} else {
 				logDebugf("Got additional error for stats: %s: %v", serverAddress, err)
 			}
 
 			// When an error occurs, we need to cancel our persistent op.  However, because
 			// a previous error may already have cancelled this and then raced, we should
-			// ensure only a single completion is counted.
-			if req.Cancel() {
+			// ensure only a single completion is counted.  A timeout has already cancelled
+			// the request for us, and is only ever delivered the one time.
+			if err == ErrTimeout || req.Cancel() {
 				opHandledLocked()
 			}
 
@@ -1165,9 +1422,14 @@ func (agent *Agent) StatsEx(opts StatsOptions, cb StatsExCallback) (PendingOp, e
 				Key:      []byte(opts.Key),
 				Value:    nil,
 			},
-			Persistent:       true,
-			Callback:         handler,
+			Persistent: true,
+			Callback: func(resp *memdQResponse, req *memdQRequest, err error) {
+				handler(serverAddress, resp, req, err)
+			},
 			RootTraceContext: tracer.RootContext(),
+			Deadline:         opts.Deadline,
+			RetryStrategy:    opts.RetryStrategy,
+			Priority:         opts.Priority,
 		}
 
 		curOp, err := agent.dispatchOpToAddress(req, serverAddress)
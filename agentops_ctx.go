@@ -0,0 +1,921 @@
+package gocbcore
+
+import (
+	"context"
+	"time"
+)
+
+// ctxDeadline returns whichever of the context deadline and the explicitly
+// provided deadline will occur first.
+func ctxDeadline(ctx context.Context, deadline time.Time) time.Time {
+	ctxDeadline, ok := ctx.Deadline()
+	if !ok {
+		return deadline
+	}
+
+	if deadline.IsZero() || ctxDeadline.Before(deadline) {
+		return ctxDeadline
+	}
+	return deadline
+}
+
+// ctxErr translates a context error into the equivalent gocbcore error.
+func ctxErr(err error) error {
+	if err == context.DeadlineExceeded {
+		return ErrTimeout
+	}
+	return ErrCancelled
+}
+
+// waitForOp dispatches an operation and blocks until it completes or the
+// context is done, in which case the operation is cancelled.  The dispatch
+// function must invoke the provided callback exactly once.
+func waitForOp(ctx context.Context, dispatch func(func(error)) (PendingOp, error)) error {
+	if err := ctx.Err(); err != nil {
+		return ctxErr(err)
+	}
+
+	waitCh := make(chan error, 1)
+	op, err := dispatch(func(err error) {
+		waitCh <- err
+	})
+	if err != nil {
+		return err
+	}
+
+	select {
+	case err := <-waitCh:
+		return err
+	case <-ctx.Done():
+		op.Cancel()
+
+		// The operation may have completed while we were cancelling it, in
+		// which case we prefer to return the real result.
+		select {
+		case err := <-waitCh:
+			return err
+		default:
+			return ctxErr(ctx.Err())
+		}
+	}
+}
+
+// GetContext retrieves a document, blocking until the operation completes or ctx is done.
+func (agent *Agent) GetContext(ctx context.Context, opts GetOptions) (*GetResult, error) {
+	var res *GetResult
+	opts.Deadline = ctxDeadline(ctx, opts.Deadline)
+	err := waitForOp(ctx, func(cb func(error)) (PendingOp, error) {
+		return agent.GetEx(opts, func(r *GetResult, err error) {
+			res = r
+			cb(err)
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// GetAndTouchContext retrieves a document and updates its expiry, blocking until the operation completes or ctx is done.
+func (agent *Agent) GetAndTouchContext(ctx context.Context, opts GetAndTouchOptions) (*GetAndTouchResult, error) {
+	var res *GetAndTouchResult
+	opts.Deadline = ctxDeadline(ctx, opts.Deadline)
+	err := waitForOp(ctx, func(cb func(error)) (PendingOp, error) {
+		return agent.GetAndTouchEx(opts, func(r *GetAndTouchResult, err error) {
+			res = r
+			cb(err)
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// GetAndLockContext retrieves a document and locks it, blocking until the operation completes or ctx is done.
+func (agent *Agent) GetAndLockContext(ctx context.Context, opts GetAndLockOptions) (*GetAndLockResult, error) {
+	var res *GetAndLockResult
+	opts.Deadline = ctxDeadline(ctx, opts.Deadline)
+	err := waitForOp(ctx, func(cb func(error)) (PendingOp, error) {
+		return agent.GetAndLockEx(opts, func(r *GetAndLockResult, err error) {
+			res = r
+			cb(err)
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// GetReplicaContext retrieves a document from a replica server, blocking until the operation completes or ctx is done.
+func (agent *Agent) GetReplicaContext(ctx context.Context, opts GetReplicaOptions) (*GetReplicaResult, error) {
+	var res *GetReplicaResult
+	opts.Deadline = ctxDeadline(ctx, opts.Deadline)
+	err := waitForOp(ctx, func(cb func(error)) (PendingOp, error) {
+		return agent.GetReplicaEx(opts, func(r *GetReplicaResult, err error) {
+			res = r
+			cb(err)
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// TouchContext updates the expiry for a document, blocking until the operation completes or ctx is done.
+func (agent *Agent) TouchContext(ctx context.Context, opts TouchOptions) (*TouchResult, error) {
+	var res *TouchResult
+	opts.Deadline = ctxDeadline(ctx, opts.Deadline)
+	err := waitForOp(ctx, func(cb func(error)) (PendingOp, error) {
+		return agent.TouchEx(opts, func(r *TouchResult, err error) {
+			res = r
+			cb(err)
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// UnlockContext unlocks a locked document, blocking until the operation completes or ctx is done.
+func (agent *Agent) UnlockContext(ctx context.Context, opts UnlockOptions) (*UnlockResult, error) {
+	var res *UnlockResult
+	opts.Deadline = ctxDeadline(ctx, opts.Deadline)
+	err := waitForOp(ctx, func(cb func(error)) (PendingOp, error) {
+		return agent.UnlockEx(opts, func(r *UnlockResult, err error) {
+			res = r
+			cb(err)
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// DeleteContext removes a document, blocking until the operation completes or ctx is done.
+func (agent *Agent) DeleteContext(ctx context.Context, opts DeleteOptions) (*DeleteResult, error) {
+	var res *DeleteResult
+	opts.Deadline = ctxDeadline(ctx, opts.Deadline)
+	err := waitForOp(ctx, func(cb func(error)) (PendingOp, error) {
+		return agent.DeleteEx(opts, func(r *DeleteResult, err error) {
+			res = r
+			cb(err)
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// AddContext stores a document as long as it does not already exist, blocking until the operation completes or ctx is done.
+func (agent *Agent) AddContext(ctx context.Context, opts AddOptions) (*StoreResult, error) {
+	var res *StoreResult
+	opts.Deadline = ctxDeadline(ctx, opts.Deadline)
+	err := waitForOp(ctx, func(cb func(error)) (PendingOp, error) {
+		return agent.AddEx(opts, func(r *StoreResult, err error) {
+			res = r
+			cb(err)
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// SetContext stores a document, blocking until the operation completes or ctx is done.
+func (agent *Agent) SetContext(ctx context.Context, opts SetOptions) (*StoreResult, error) {
+	var res *StoreResult
+	opts.Deadline = ctxDeadline(ctx, opts.Deadline)
+	err := waitForOp(ctx, func(cb func(error)) (PendingOp, error) {
+		return agent.SetEx(opts, func(r *StoreResult, err error) {
+			res = r
+			cb(err)
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// ReplaceContext replaces the value of a Couchbase document with another value, blocking until the operation completes or ctx is done.
+func (agent *Agent) ReplaceContext(ctx context.Context, opts ReplaceOptions) (*StoreResult, error) {
+	var res *StoreResult
+	opts.Deadline = ctxDeadline(ctx, opts.Deadline)
+	err := waitForOp(ctx, func(cb func(error)) (PendingOp, error) {
+		return agent.ReplaceEx(opts, func(r *StoreResult, err error) {
+			res = r
+			cb(err)
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// AppendContext appends some bytes to a document, blocking until the operation completes or ctx is done.
+func (agent *Agent) AppendContext(ctx context.Context, opts AdjoinOptions) (*AdjoinResult, error) {
+	var res *AdjoinResult
+	opts.Deadline = ctxDeadline(ctx, opts.Deadline)
+	err := waitForOp(ctx, func(cb func(error)) (PendingOp, error) {
+		return agent.AppendEx(opts, func(r *AdjoinResult, err error) {
+			res = r
+			cb(err)
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// PrependContext prepends some bytes to a document, blocking until the operation completes or ctx is done.
+func (agent *Agent) PrependContext(ctx context.Context, opts AdjoinOptions) (*AdjoinResult, error) {
+	var res *AdjoinResult
+	opts.Deadline = ctxDeadline(ctx, opts.Deadline)
+	err := waitForOp(ctx, func(cb func(error)) (PendingOp, error) {
+		return agent.PrependEx(opts, func(r *AdjoinResult, err error) {
+			res = r
+			cb(err)
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// IncrementContext increments the unsigned integer value in a document, blocking until the operation completes or ctx is done.
+func (agent *Agent) IncrementContext(ctx context.Context, opts CounterOptions) (*CounterResult, error) {
+	var res *CounterResult
+	opts.Deadline = ctxDeadline(ctx, opts.Deadline)
+	err := waitForOp(ctx, func(cb func(error)) (PendingOp, error) {
+		return agent.IncrementEx(opts, func(r *CounterResult, err error) {
+			res = r
+			cb(err)
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// DecrementContext decrements the unsigned integer value in a document, blocking until the operation completes or ctx is done.
+func (agent *Agent) DecrementContext(ctx context.Context, opts CounterOptions) (*CounterResult, error) {
+	var res *CounterResult
+	opts.Deadline = ctxDeadline(ctx, opts.Deadline)
+	err := waitForOp(ctx, func(cb func(error)) (PendingOp, error) {
+		return agent.DecrementEx(opts, func(r *CounterResult, err error) {
+			res = r
+			cb(err)
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// GetRandomContext retrieves the key and value of a random document stored within Couchbase Server, blocking until the operation completes or ctx is done.
+func (agent *Agent) GetRandomContext(ctx context.Context, opts GetRandomOptions) (*GetRandomResult, error) {
+	var res *GetRandomResult
+	opts.Deadline = ctxDeadline(ctx, opts.Deadline)
+	err := waitForOp(ctx, func(cb func(error)) (PendingOp, error) {
+		return agent.GetRandomEx(opts, func(r *GetRandomResult, err error) {
+			res = r
+			cb(err)
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// StatsContext retrieves statistics information from the server, blocking until the operation completes or ctx is done.
+func (agent *Agent) StatsContext(ctx context.Context, opts StatsOptions) (*StatsResult, error) {
+	var res *StatsResult
+	opts.Deadline = ctxDeadline(ctx, opts.Deadline)
+	err := waitForOp(ctx, func(cb func(error)) (PendingOp, error) {
+		return agent.StatsEx(opts, func(r *StatsResult, err error) {
+			res = r
+			cb(err)
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// ObserveContext retrieves the current CAS and persistence state for a document, blocking until the operation completes or ctx is done.
+func (agent *Agent) ObserveContext(ctx context.Context, opts ObserveOptions) (*ObserveResult, error) {
+	var res *ObserveResult
+	opts.Deadline = ctxDeadline(ctx, opts.Deadline)
+	err := waitForOp(ctx, func(cb func(error)) (PendingOp, error) {
+		return agent.ObserveEx(opts, func(r *ObserveResult, err error) {
+			res = r
+			cb(err)
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// ObserveVbContext retrieves the persistence state sequence numbers for a particular VBucket, blocking until the operation completes or ctx is done.
+func (agent *Agent) ObserveVbContext(ctx context.Context, opts ObserveVbOptions) (*ObserveVbResult, error) {
+	var res *ObserveVbResult
+	opts.Deadline = ctxDeadline(ctx, opts.Deadline)
+	err := waitForOp(ctx, func(cb func(error)) (PendingOp, error) {
+		return agent.ObserveVbEx(opts, func(r *ObserveVbResult, err error) {
+			res = r
+			cb(err)
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// DurabilityContext waits for a mutation to meet observe based durability requirements, blocking until the operation
+// completes or ctx is done.
+func (agent *Agent) DurabilityContext(ctx context.Context, opts DurabilityOptions) (*DurabilityResult, error) {
+	var res *DurabilityResult
+	opts.Deadline = ctxDeadline(ctx, opts.Deadline)
+	err := waitForOp(ctx, func(cb func(error)) (PendingOp, error) {
+		return agent.DurabilityEx(opts, func(r *DurabilityResult, err error) {
+			res = r
+			cb(err)
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// GetMetaContext retrieves a document along with some internal Couchbase meta-data, blocking until the operation completes or ctx is done.
+func (agent *Agent) GetMetaContext(ctx context.Context, opts GetMetaOptions) (*GetMetaResult, error) {
+	var res *GetMetaResult
+	opts.Deadline = ctxDeadline(ctx, opts.Deadline)
+	err := waitForOp(ctx, func(cb func(error)) (PendingOp, error) {
+		return agent.GetMetaEx(opts, func(r *GetMetaResult, err error) {
+			res = r
+			cb(err)
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// SetMetaContext stores a document along with setting some internal Couchbase meta-data, blocking until the operation completes or ctx is done.
+func (agent *Agent) SetMetaContext(ctx context.Context, opts SetMetaOptions) (*SetMetaResult, error) {
+	var res *SetMetaResult
+	opts.Deadline = ctxDeadline(ctx, opts.Deadline)
+	err := waitForOp(ctx, func(cb func(error)) (PendingOp, error) {
+		return agent.SetMetaEx(opts, func(r *SetMetaResult, err error) {
+			res = r
+			cb(err)
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// DeleteMetaContext deletes a document along with setting some internal Couchbase meta-data, blocking until the operation completes or ctx is done.
+func (agent *Agent) DeleteMetaContext(ctx context.Context, opts DeleteMetaOptions) (*DeleteMetaResult, error) {
+	var res *DeleteMetaResult
+	opts.Deadline = ctxDeadline(ctx, opts.Deadline)
+	err := waitForOp(ctx, func(cb func(error)) (PendingOp, error) {
+		return agent.DeleteMetaEx(opts, func(r *DeleteMetaResult, err error) {
+			res = r
+			cb(err)
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// GetInContext retrieves the value at a particular path within a JSON document, blocking until the operation completes or ctx is done.
+func (agent *Agent) GetInContext(ctx context.Context, opts GetInOptions) (*GetInResult, error) {
+	var res *GetInResult
+	opts.Deadline = ctxDeadline(ctx, opts.Deadline)
+	err := waitForOp(ctx, func(cb func(error)) (PendingOp, error) {
+		return agent.GetInEx(opts, func(r *GetInResult, err error) {
+			res = r
+			cb(err)
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// ExistsInContext returns whether a particular path exists within a document, blocking until the operation completes or ctx is done.
+func (agent *Agent) ExistsInContext(ctx context.Context, opts ExistsInOptions) (*ExistsInResult, error) {
+	var res *ExistsInResult
+	opts.Deadline = ctxDeadline(ctx, opts.Deadline)
+	err := waitForOp(ctx, func(cb func(error)) (PendingOp, error) {
+		return agent.ExistsInEx(opts, func(r *ExistsInResult, err error) {
+			res = r
+			cb(err)
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// SetInContext sets the value at a path within a document, blocking until the operation completes or ctx is done.
+func (agent *Agent) SetInContext(ctx context.Context, opts StoreInOptions) (*StoreInResult, error) {
+	var res *StoreInResult
+	opts.Deadline = ctxDeadline(ctx, opts.Deadline)
+	err := waitForOp(ctx, func(cb func(error)) (PendingOp, error) {
+		return agent.SetInEx(opts, func(r *StoreInResult, err error) {
+			res = r
+			cb(err)
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// AddInContext adds a value at the path within a document, blocking until the operation completes or ctx is done.
+func (agent *Agent) AddInContext(ctx context.Context, opts StoreInOptions) (*StoreInResult, error) {
+	var res *StoreInResult
+	opts.Deadline = ctxDeadline(ctx, opts.Deadline)
+	err := waitForOp(ctx, func(cb func(error)) (PendingOp, error) {
+		return agent.AddInEx(opts, func(r *StoreInResult, err error) {
+			res = r
+			cb(err)
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// ReplaceInContext replaces the value at the path within a document, blocking until the operation completes or ctx is done.
+func (agent *Agent) ReplaceInContext(ctx context.Context, opts StoreInOptions) (*StoreInResult, error) {
+	var res *StoreInResult
+	opts.Deadline = ctxDeadline(ctx, opts.Deadline)
+	err := waitForOp(ctx, func(cb func(error)) (PendingOp, error) {
+		return agent.ReplaceInEx(opts, func(r *StoreInResult, err error) {
+			res = r
+			cb(err)
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// PushFrontInContext prepends a value to the array at the path within a document, blocking until the operation completes or ctx is done.
+func (agent *Agent) PushFrontInContext(ctx context.Context, opts StoreInOptions) (*StoreInResult, error) {
+	var res *StoreInResult
+	opts.Deadline = ctxDeadline(ctx, opts.Deadline)
+	err := waitForOp(ctx, func(cb func(error)) (PendingOp, error) {
+		return agent.PushFrontInEx(opts, func(r *StoreInResult, err error) {
+			res = r
+			cb(err)
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// PushBackInContext appends a value to the array at the path within a document, blocking until the operation completes or ctx is done.
+func (agent *Agent) PushBackInContext(ctx context.Context, opts StoreInOptions) (*StoreInResult, error) {
+	var res *StoreInResult
+	opts.Deadline = ctxDeadline(ctx, opts.Deadline)
+	err := waitForOp(ctx, func(cb func(error)) (PendingOp, error) {
+		return agent.PushBackInEx(opts, func(r *StoreInResult, err error) {
+			res = r
+			cb(err)
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// ArrayInsertInContext inserts a value at the index specified by path within a document, blocking until the operation completes or ctx is done.
+func (agent *Agent) ArrayInsertInContext(ctx context.Context, opts StoreInOptions) (*StoreInResult, error) {
+	var res *StoreInResult
+	opts.Deadline = ctxDeadline(ctx, opts.Deadline)
+	err := waitForOp(ctx, func(cb func(error)) (PendingOp, error) {
+		return agent.ArrayInsertInEx(opts, func(r *StoreInResult, err error) {
+			res = r
+			cb(err)
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// AddUniqueInContext adds a value to an array in a document if it does not already exist, blocking until the operation completes or ctx is done.
+func (agent *Agent) AddUniqueInContext(ctx context.Context, opts StoreInOptions) (*StoreInResult, error) {
+	var res *StoreInResult
+	opts.Deadline = ctxDeadline(ctx, opts.Deadline)
+	err := waitForOp(ctx, func(cb func(error)) (PendingOp, error) {
+		return agent.AddUniqueInEx(opts, func(r *StoreInResult, err error) {
+			res = r
+			cb(err)
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// CounterInContext performs an arithmetic add or subtract on a value at a path in the document, blocking until the operation completes or ctx is done.
+func (agent *Agent) CounterInContext(ctx context.Context, opts CounterInOptions) (*CounterInResult, error) {
+	var res *CounterInResult
+	opts.Deadline = ctxDeadline(ctx, opts.Deadline)
+	err := waitForOp(ctx, func(cb func(error)) (PendingOp, error) {
+		return agent.CounterInEx(opts, func(r *CounterInResult, err error) {
+			res = r
+			cb(err)
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// DeleteInContext removes the value at a path within the document, blocking until the operation completes or ctx is done.
+func (agent *Agent) DeleteInContext(ctx context.Context, opts DeleteInOptions) (*DeleteInResult, error) {
+	var res *DeleteInResult
+	opts.Deadline = ctxDeadline(ctx, opts.Deadline)
+	err := waitForOp(ctx, func(cb func(error)) (PendingOp, error) {
+		return agent.DeleteInEx(opts, func(r *DeleteInResult, err error) {
+			res = r
+			cb(err)
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// LookupInContext performs a multiple-lookup sub-document operation on a document, blocking until the operation completes or ctx is done.
+func (agent *Agent) LookupInContext(ctx context.Context, opts LookupInOptions) (*LookupInResult, error) {
+	var res *LookupInResult
+	opts.Deadline = ctxDeadline(ctx, opts.Deadline)
+	err := waitForOp(ctx, func(cb func(error)) (PendingOp, error) {
+		return agent.LookupInEx(opts, func(r *LookupInResult, err error) {
+			res = r
+			cb(err)
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// MutateInContext performs a multiple-mutation sub-document operation on a document, blocking until the operation completes or ctx is done.
+func (agent *Agent) MutateInContext(ctx context.Context, opts MutateInOptions) (*MutateInResult, error) {
+	var res *MutateInResult
+	opts.Deadline = ctxDeadline(ctx, opts.Deadline)
+	err := waitForOp(ctx, func(cb func(error)) (PendingOp, error) {
+		return agent.MutateInEx(opts, func(r *MutateInResult, err error) {
+			res = r
+			cb(err)
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// PingKvContext pings all of the servers we are connected to, blocking until the operation completes or ctx is done.
+func (agent *Agent) PingKvContext(ctx context.Context, opts PingKvOptions) (*PingKvResult, error) {
+	var res *PingKvResult
+	opts.Deadline = ctxDeadline(ctx, opts.Deadline)
+	err := waitForOp(ctx, func(cb func(error)) (PendingOp, error) {
+		return agent.PingKvEx(opts, func(r *PingKvResult, err error) {
+			res = r
+			cb(err)
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// GetMultiContext retrieves a number of documents, blocking until the operation completes or ctx is done.
+func (agent *Agent) GetMultiContext(ctx context.Context, opts GetMultiOptions) (*GetMultiResult, error) {
+	var res *GetMultiResult
+	opts.Deadline = ctxDeadline(ctx, opts.Deadline)
+	err := waitForOp(ctx, func(cb func(error)) (PendingOp, error) {
+		return agent.GetMultiEx(opts, func(r *GetMultiResult, err error) {
+			res = r
+			cb(err)
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// SetMultiContext stores a number of documents, blocking until the operation completes or ctx is done.
+func (agent *Agent) SetMultiContext(ctx context.Context, opts SetMultiOptions) (*StoreMultiResult, error) {
+	var res *StoreMultiResult
+	opts.Deadline = ctxDeadline(ctx, opts.Deadline)
+	err := waitForOp(ctx, func(cb func(error)) (PendingOp, error) {
+		return agent.SetMultiEx(opts, func(r *StoreMultiResult, err error) {
+			res = r
+			cb(err)
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// DeleteMultiContext removes a number of documents, blocking until the operation completes or ctx is done.
+func (agent *Agent) DeleteMultiContext(ctx context.Context, opts DeleteMultiOptions) (*DeleteMultiResult, error) {
+	var res *DeleteMultiResult
+	opts.Deadline = ctxDeadline(ctx, opts.Deadline)
+	err := waitForOp(ctx, func(cb func(error)) (PendingOp, error) {
+		return agent.DeleteMultiEx(opts, func(r *DeleteMultiResult, err error) {
+			res = r
+			cb(err)
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// TouchMultiContext updates the expiry for a number of documents, blocking until the operation completes or ctx is done.
+func (agent *Agent) TouchMultiContext(ctx context.Context, opts TouchMultiOptions) (*TouchMultiResult, error) {
+	var res *TouchMultiResult
+	opts.Deadline = ctxDeadline(ctx, opts.Deadline)
+	err := waitForOp(ctx, func(cb func(error)) (PendingOp, error) {
+		return agent.TouchMultiEx(opts, func(r *TouchMultiResult, err error) {
+			res = r
+			cb(err)
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// GetAnyReplicaContext retrieves a document by racing the active server against all of its replicas, blocking
+// until the operation completes or ctx is done.
+func (agent *Agent) GetAnyReplicaContext(ctx context.Context, opts GetAnyReplicaOptions) (*ReplicaReadResult, error) {
+	var res *ReplicaReadResult
+	opts.Deadline = ctxDeadline(ctx, opts.Deadline)
+	err := waitForOp(ctx, func(cb func(error)) (PendingOp, error) {
+		return agent.GetAnyReplicaEx(opts, func(r *ReplicaReadResult, err error) {
+			res = r
+			cb(err)
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// GetAllReplicasContext retrieves every copy of a document from the active server and all of its replicas,
+// blocking until the operation completes or ctx is done.
+func (agent *Agent) GetAllReplicasContext(ctx context.Context, opts GetAllReplicasOptions) ([]*ReplicaReadResult, error) {
+	var res []*ReplicaReadResult
+	opts.Deadline = ctxDeadline(ctx, opts.Deadline)
+	err := waitForOp(ctx, func(cb func(error)) (PendingOp, error) {
+		return agent.GetAllReplicasEx(opts, func(r *ReplicaReadResult) {
+			res = append(res, r)
+		}, cb)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// GetProjectedContext retrieves only the specified paths of a document, blocking until the operation completes or
+// ctx is done.
+func (agent *Agent) GetProjectedContext(ctx context.Context, opts GetProjectedOptions) (*GetProjectedResult, error) {
+	var res *GetProjectedResult
+	opts.Deadline = ctxDeadline(ctx, opts.Deadline)
+	err := waitForOp(ctx, func(cb func(error)) (PendingOp, error) {
+		return agent.GetProjectedEx(opts, func(r *GetProjectedResult, err error) {
+			res = r
+			cb(err)
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// MutateWithCasContext performs an optimistic read-modify-write of a document, blocking until the operation
+// completes or ctx is done.
+func (agent *Agent) MutateWithCasContext(ctx context.Context, opts MutateWithCasOptions,
+	mutateFn MutateWithCasFunc) (*MutateWithCasResult, error) {
+	var res *MutateWithCasResult
+	opts.Deadline = ctxDeadline(ctx, opts.Deadline)
+	err := waitForOp(ctx, func(cb func(error)) (PendingOp, error) {
+		return agent.MutateWithCasEx(opts, mutateFn, func(r *MutateWithCasResult, err error) {
+			res = r
+			cb(err)
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// LockContext locks a document, returning a LockHandle which is used to mutate the document and release
+// the lock, blocking until the lock is acquired or ctx is done.
+func (agent *Agent) LockContext(ctx context.Context, opts LockOptions) (*LockHandle, error) {
+	var res *LockHandle
+	opts.Deadline = ctxDeadline(ctx, opts.Deadline)
+	err := waitForOp(ctx, func(cb func(error)) (PendingOp, error) {
+		return agent.LockEx(opts, func(r *LockHandle, err error) {
+			res = r
+			cb(err)
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// GetTypedContext retrieves a document and decodes it using a Transcoder, blocking until the operation completes
+// or ctx is done.
+func (agent *Agent) GetTypedContext(ctx context.Context, opts GetTypedOptions) (*GetTypedResult, error) {
+	var res *GetTypedResult
+	opts.Deadline = ctxDeadline(ctx, opts.Deadline)
+	err := waitForOp(ctx, func(cb func(error)) (PendingOp, error) {
+		return agent.GetTypedEx(opts, func(r *GetTypedResult, err error) {
+			res = r
+			cb(err)
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// SetTypedContext encodes a value using a Transcoder and stores it as a document, blocking until the operation
+// completes or ctx is done.
+func (agent *Agent) SetTypedContext(ctx context.Context, opts SetTypedOptions) (*StoreResult, error) {
+	var res *StoreResult
+	opts.Deadline = ctxDeadline(ctx, opts.Deadline)
+	err := waitForOp(ctx, func(cb func(error)) (PendingOp, error) {
+		return agent.SetTypedEx(opts, func(r *StoreResult, err error) {
+			res = r
+			cb(err)
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// GetCollectionIDContext fetches the collection id and manifest id that the collection belongs to,
+// blocking until the operation completes or ctx is done.
+func (agent *Agent) GetCollectionIDContext(ctx context.Context, scopeName string, collectionName string,
+	opts GetCollectionIDOptions) (manifestIDOut uint64, collectionIDOut uint32, errOut error) {
+	opts.Deadline = ctxDeadline(ctx, opts.Deadline)
+	err := waitForOp(ctx, func(cb func(error)) (PendingOp, error) {
+		return agent.GetCollectionID(scopeName, collectionName, opts, func(manifestID uint64, collectionID uint32, err error) {
+			manifestIDOut = manifestID
+			collectionIDOut = collectionID
+			cb(err)
+		})
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	return
+}
+
+// GetCollectionManifestContext fetches the current server manifest, blocking until the
+// operation completes or ctx is done.
+func (agent *Agent) GetCollectionManifestContext(ctx context.Context) ([]byte, error) {
+	var res []byte
+	err := waitForOp(ctx, func(cb func(error)) (PendingOp, error) {
+		return agent.getCollectionManifest(ctxDeadline(ctx, time.Time{}), func(manifest []byte, err error) {
+			res = manifest
+			cb(err)
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// CloseStreamContext shuts down an open stream for the specified VBucket, blocking until
+// the operation completes or ctx is done.
+func (agent *Agent) CloseStreamContext(ctx context.Context, vbId uint16) error {
+	return waitForOp(ctx, func(cb func(error)) (PendingOp, error) {
+		return agent.closeStream(vbId, ctxDeadline(ctx, time.Time{}), cb)
+	})
+}
+
+// CloseStreamWithIdContext shuts down an open stream for the specified VBucket for the specified
+// stream, blocking until the operation completes or ctx is done.
+func (agent *Agent) CloseStreamWithIdContext(ctx context.Context, vbId uint16, streamId uint16) error {
+	return waitForOp(ctx, func(cb func(error)) (PendingOp, error) {
+		return agent.closeStreamWithId(vbId, streamId, ctxDeadline(ctx, time.Time{}), cb)
+	})
+}
+
+// GetFailoverLogContext retrieves the fail-over log for a particular VBucket, blocking until
+// the operation completes or ctx is done.
+func (agent *Agent) GetFailoverLogContext(ctx context.Context, vbId uint16) ([]FailoverEntry, error) {
+	var res []FailoverEntry
+	err := waitForOp(ctx, func(cb func(error)) (PendingOp, error) {
+		return agent.getFailoverLog(vbId, ctxDeadline(ctx, time.Time{}), func(entries []FailoverEntry, err error) {
+			res = entries
+			cb(err)
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// GetVbucketSeqnosContext returns the last checkpoint for each VBucket on a server, blocking
+// until the operation completes or ctx is done.
+func (agent *Agent) GetVbucketSeqnosContext(ctx context.Context, serverIdx int, state VbucketState) ([]VbSeqNoEntry, error) {
+	var res []VbSeqNoEntry
+	err := waitForOp(ctx, func(cb func(error)) (PendingOp, error) {
+		return agent.getVbucketSeqnos(serverIdx, state, ctxDeadline(ctx, time.Time{}), func(vbs []VbSeqNoEntry, err error) {
+			res = vbs
+			cb(err)
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// GetVbucketSeqnosWithCollectionIdContext returns the last checkpoint for each VBucket on a server
+// for a particular collection, blocking until the operation completes or ctx is done.
+func (agent *Agent) GetVbucketSeqnosWithCollectionIdContext(ctx context.Context, serverIdx int, state VbucketState,
+	collectionId uint32) ([]VbSeqNoEntry, error) {
+	var res []VbSeqNoEntry
+	err := waitForOp(ctx, func(cb func(error)) (PendingOp, error) {
+		return agent.getVbucketSeqnosWithCollectionId(serverIdx, state, collectionId, ctxDeadline(ctx, time.Time{}),
+			func(vbs []VbSeqNoEntry, err error) {
+				res = vbs
+				cb(err)
+			})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
@@ -0,0 +1,81 @@
+package gocbcore
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+type testPendingOp struct {
+	cancelled bool
+}
+
+func (op *testPendingOp) Cancel() bool {
+	op.cancelled = true
+	return true
+}
+
+func TestCtxDeadline(t *testing.T) {
+	now := time.Now()
+
+	if !ctxDeadline(context.Background(), time.Time{}).IsZero() {
+		t.Fatalf("Expected no deadline")
+	}
+	if ctxDeadline(context.Background(), now) != now {
+		t.Fatalf("Expected the explicit deadline to be used")
+	}
+
+	ctx, cancel := context.WithDeadline(context.Background(), now.Add(time.Second))
+	defer cancel()
+
+	if ctxDeadline(ctx, time.Time{}) != now.Add(time.Second) {
+		t.Fatalf("Expected the context deadline to be used")
+	}
+	if ctxDeadline(ctx, now) != now {
+		t.Fatalf("Expected the earlier explicit deadline to be used")
+	}
+	if ctxDeadline(ctx, now.Add(time.Minute)) != now.Add(time.Second) {
+		t.Fatalf("Expected the earlier context deadline to be used")
+	}
+}
+
+func TestWaitForOpCompletes(t *testing.T) {
+	op := &testPendingOp{}
+	err := waitForOp(context.Background(), func(cb func(error)) (PendingOp, error) {
+		go cb(ErrKeyNotFound)
+		return op, nil
+	})
+	if err != ErrKeyNotFound {
+		t.Fatalf("Expected the operation error to be returned but got %v", err)
+	}
+	if op.cancelled {
+		t.Fatalf("The operation should not have been cancelled")
+	}
+}
+
+func TestWaitForOpContextDone(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	op := &testPendingOp{}
+	err := waitForOp(ctx, func(cb func(error)) (PendingOp, error) {
+		return op, nil
+	})
+	if err != ErrTimeout {
+		t.Fatalf("Expected a timeout error but got %v", err)
+	}
+	if !op.cancelled {
+		t.Fatalf("The operation should have been cancelled")
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+
+	err = waitForOp(ctx, func(cb func(error)) (PendingOp, error) {
+		t.Fatalf("The operation should not have been dispatched")
+		return nil, nil
+	})
+	if err != ErrCancelled {
+		t.Fatalf("Expected a cancelled error but got %v", err)
+	}
+}
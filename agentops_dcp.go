@@ -4,6 +4,7 @@ import (
 	"encoding/binary"
 	"encoding/json"
 	"fmt"
+	"time"
 )
 
 const (
@@ -271,6 +272,10 @@ func (agent *Agent) OpenStream(vbId uint16, flags DcpStreamAddFlag, vbUuid VbUui
 
 // CloseStreamWithId shuts down an open stream for the specified VBucket for the specified stream.
 func (agent *Agent) CloseStreamWithId(vbId uint16, streamId uint16, cb CloseStreamCallback) (PendingOp, error) {
+	return agent.closeStreamWithId(vbId, streamId, time.Time{}, cb)
+}
+
+func (agent *Agent) closeStreamWithId(vbId uint16, streamId uint16, deadline time.Time, cb CloseStreamCallback) (PendingOp, error) {
 	handler := func(_ *memdQResponse, _ *memdQRequest, err error) {
 		cb(err)
 	}
@@ -293,6 +298,7 @@ func (agent *Agent) CloseStreamWithId(vbId uint16, streamId uint16, cb CloseStre
 			FrameExtras: frameExtras,
 		},
 		Callback:   handler,
+		Deadline:   deadline,
 		ReplicaIdx: 0,
 		Persistent: false,
 	}
@@ -301,6 +307,10 @@ func (agent *Agent) CloseStreamWithId(vbId uint16, streamId uint16, cb CloseStre
 
 // CloseStream shuts down an open stream for the specified VBucket.
 func (agent *Agent) CloseStream(vbId uint16, cb CloseStreamCallback) (PendingOp, error) {
+	return agent.closeStream(vbId, time.Time{}, cb)
+}
+
+func (agent *Agent) closeStream(vbId uint16, deadline time.Time, cb CloseStreamCallback) (PendingOp, error) {
 	handler := func(_ *memdQResponse, _ *memdQRequest, err error) {
 		cb(err)
 	}
@@ -317,6 +327,7 @@ func (agent *Agent) CloseStream(vbId uint16, cb CloseStreamCallback) (PendingOp,
 			Vbucket:  vbId,
 		},
 		Callback:   handler,
+		Deadline:   deadline,
 		ReplicaIdx: 0,
 		Persistent: false,
 	}
@@ -326,6 +337,10 @@ func (agent *Agent) CloseStream(vbId uint16, cb CloseStreamCallback) (PendingOp,
 // GetFailoverLog retrieves the fail-over log for a particular VBucket.  This is used
 // to resume an interrupted stream after a node fail-over has occurred.
 func (agent *Agent) GetFailoverLog(vbId uint16, cb GetFailoverLogCallback) (PendingOp, error) {
+	return agent.getFailoverLog(vbId, time.Time{}, cb)
+}
+
+func (agent *Agent) getFailoverLog(vbId uint16, deadline time.Time, cb GetFailoverLogCallback) (PendingOp, error) {
 	handler := func(resp *memdQResponse, _ *memdQRequest, err error) {
 		if err != nil {
 			cb(nil, err)
@@ -355,6 +370,7 @@ func (agent *Agent) GetFailoverLog(vbId uint16, cb GetFailoverLogCallback) (Pend
 			Vbucket:  vbId,
 		},
 		Callback:   handler,
+		Deadline:   deadline,
 		ReplicaIdx: 0,
 		Persistent: false,
 	}
@@ -364,6 +380,10 @@ func (agent *Agent) GetFailoverLog(vbId uint16, cb GetFailoverLogCallback) (Pend
 // GetVbucketSeqnosWithCollectionId returns the last checkpoint for a particular VBucket for a particular collection. This is useful
 // for starting a DCP stream from wherever the server currently is.
 func (agent *Agent) GetVbucketSeqnosWithCollectionId(serverIdx int, state VbucketState, collectionId uint32, cb GetVBucketSeqnosCallback) (PendingOp, error) {
+	return agent.getVbucketSeqnosWithCollectionId(serverIdx, state, collectionId, time.Time{}, cb)
+}
+
+func (agent *Agent) getVbucketSeqnosWithCollectionId(serverIdx int, state VbucketState, collectionId uint32, deadline time.Time, cb GetVBucketSeqnosCallback) (PendingOp, error) {
 	handler := func(resp *memdQResponse, _ *memdQRequest, err error) {
 		if err != nil {
 			cb(nil, err)
@@ -399,6 +419,7 @@ func (agent *Agent) GetVbucketSeqnosWithCollectionId(serverIdx int, state Vbucke
 			Vbucket:  0,
 		},
 		Callback:   handler,
+		Deadline:   deadline,
 		ReplicaIdx: -serverIdx,
 		Persistent: false,
 	}
@@ -409,6 +430,10 @@ func (agent *Agent) GetVbucketSeqnosWithCollectionId(serverIdx int, state Vbucke
 // GetVbucketSeqnos returns the last checkpoint for a particular VBucket.  This is useful
 // for starting a DCP stream from wherever the server currently is.
 func (agent *Agent) GetVbucketSeqnos(serverIdx int, state VbucketState, cb GetVBucketSeqnosCallback) (PendingOp, error) {
+	return agent.getVbucketSeqnos(serverIdx, state, time.Time{}, cb)
+}
+
+func (agent *Agent) getVbucketSeqnos(serverIdx int, state VbucketState, deadline time.Time, cb GetVBucketSeqnosCallback) (PendingOp, error) {
 	handler := func(resp *memdQResponse, _ *memdQRequest, err error) {
 		if err != nil {
 			cb(nil, err)
@@ -443,6 +468,7 @@ func (agent *Agent) GetVbucketSeqnos(serverIdx int, state VbucketState, cb GetVB
 			Vbucket:  0,
 		},
 		Callback:   handler,
+		Deadline:   deadline,
 		ReplicaIdx: -serverIdx,
 		Persistent: false,
 	}
@@ -2,6 +2,8 @@ package gocbcore
 
 import (
 	"encoding/binary"
+	"sync"
+	"time"
 
 	"github.com/opentracing/opentracing-go"
 )
@@ -11,6 +13,9 @@ type ObserveOptions struct {
 	Key            []byte
 	ReplicaIdx     int
 	TraceContext   opentracing.SpanContext
+	Deadline       time.Time
+	RetryStrategy  RetryStrategy
+	Priority       RequestPriority
 	CollectionName string
 	ScopeName      string
 }
@@ -86,6 +91,9 @@ func (agent *Agent) ObserveEx(opts ObserveOptions, cb ObserveExCallback) (Pendin
 		RootTraceContext: tracer.RootContext(),
 		CollectionName:   opts.CollectionName,
 		ScopeName:        opts.ScopeName,
+		Deadline:         opts.Deadline,
+		RetryStrategy:    opts.RetryStrategy,
+		Priority:         opts.Priority,
 	}
 
 	return agent.dispatchOp(req)
@@ -93,10 +101,13 @@ func (agent *Agent) ObserveEx(opts ObserveOptions, cb ObserveExCallback) (Pendin
 
 // ObserveVbOptions encapsulates the parameters for a ObserveVbEx operation.
 type ObserveVbOptions struct {
-	VbId         uint16
-	VbUuid       VbUuid
-	ReplicaIdx   int
-	TraceContext opentracing.SpanContext
+	VbId          uint16
+	VbUuid        VbUuid
+	ReplicaIdx    int
+	TraceContext  opentracing.SpanContext
+	Deadline      time.Time
+	RetryStrategy RetryStrategy
+	Priority      RequestPriority
 }
 
 // ObserveVbResult encapsulates the result of a ObserveVbEx operation.
@@ -208,6 +219,422 @@ func (agent *Agent) ObserveVbEx(opts ObserveVbOptions, cb ObserveVbExCallback) (
 		ReplicaIdx:       opts.ReplicaIdx,
 		Callback:         handler,
 		RootTraceContext: tracer.RootContext(),
+		Deadline:         opts.Deadline,
+		RetryStrategy:    opts.RetryStrategy,
+		Priority:         opts.Priority,
 	}
 	return agent.dispatchOp(req)
 }
+
+const (
+	defaultDurabilityPollInterval = 10 * time.Millisecond
+
+	// defaultDurabilityTimeout is how long DurabilityEx polls for when no Deadline is
+	// specified.
+	defaultDurabilityTimeout = 10 * time.Second
+)
+
+// DurabilityOptions encapsulates the parameters for a DurabilityEx operation.
+// If the MutationToken is populated, the sequence numbers of the vbucket are
+// observed, otherwise the Cas of the document is observed.
+type DurabilityOptions struct {
+	Key            []byte
+	CollectionName string
+	ScopeName      string
+	MutationToken  MutationToken
+	Cas            Cas
+	IsDelete       bool
+	PersistTo      uint
+	ReplicateTo    uint
+	PollInterval   time.Duration
+	TraceContext   opentracing.SpanContext
+	Deadline       time.Time
+	RetryStrategy  RetryStrategy
+	Priority       RequestPriority
+}
+
+// DurabilityResult encapsulates the result of a DurabilityEx operation.
+type DurabilityResult struct {
+	NumPersisted  int
+	NumReplicated int
+}
+
+// DurabilityExCallback is invoked upon completion of a DurabilityEx operation.
+type DurabilityExCallback func(*DurabilityResult, error)
+
+type durabilityOp struct {
+	agent     *Agent
+	opts      DurabilityOptions
+	tracer    *opTracer
+	cb        DurabilityExCallback
+	useSeqNo  bool
+	lock      sync.Mutex
+	subOps    []PendingOp
+	timer     *time.Timer
+	completed bool
+
+	// observeErr is the last error returned by an observe request which polling
+	// could not recover from, it is reported in place of ErrTimeout.
+	observeErr error
+}
+
+func (op *durabilityOp) Cancel() bool {
+	return op.complete(nil, ErrCancelled, false)
+}
+
+// complete marks the operation as completed, cancelling any outstanding work, and
+// optionally invokes the callback.  It returns false if already completed.
+func (op *durabilityOp) complete(res *DurabilityResult, err error, invokeCb bool) bool {
+	op.lock.Lock()
+	if op.completed {
+		op.lock.Unlock()
+		return false
+	}
+	op.completed = true
+	if op.timer != nil {
+		op.timer.Stop()
+		op.timer = nil
+	}
+	subOps := op.subOps
+	op.subOps = nil
+	op.lock.Unlock()
+
+	for _, subOp := range subOps {
+		subOp.Cancel()
+	}
+
+	op.tracer.Finish()
+	if invokeCb {
+		op.cb(res, err)
+	}
+	return true
+}
+
+// recordObserveErr remembers an error returned by an observe request.  Errors which are
+// expected to go away by polling again are ignored.
+func (op *durabilityOp) recordObserveErr(err error) {
+	switch err {
+	case nil, ErrTimeout, ErrCancelled, ErrTmpFail, ErrBusy, ErrNotMyVBucket:
+		return
+	}
+
+	op.lock.Lock()
+	op.observeErr = err
+	op.lock.Unlock()
+}
+
+// timeoutErr returns the error to fail the operation with once its deadline is hit.
+func (op *durabilityOp) timeoutErr() error {
+	op.lock.Lock()
+	defer op.lock.Unlock()
+
+	if op.observeErr != nil {
+		return op.observeErr
+	}
+	return ErrTimeout
+}
+
+func (op *durabilityOp) poll() {
+	op.lock.Lock()
+	if op.completed {
+		op.lock.Unlock()
+		return
+	}
+	op.timer = nil
+	op.subOps = nil
+	op.lock.Unlock()
+
+	numReplicas := op.agent.NumReplicas()
+
+	var roundLock sync.Mutex
+	remaining := numReplicas + 1
+	numPersisted := 0
+	numReplicated := 0
+	var roundErr error
+
+	handled := func(persisted, replicated bool, err error) {
+		roundLock.Lock()
+		if persisted {
+			numPersisted++
+		}
+		if replicated {
+			numReplicated++
+		}
+		if err != nil && roundErr == nil {
+			roundErr = err
+		}
+		remaining--
+		done := remaining == 0
+		roundLock.Unlock()
+
+		if done {
+			op.roundCompleted(numPersisted, numReplicated, roundErr)
+		}
+	}
+
+	for replicaIdx := 0; replicaIdx <= numReplicas; replicaIdx++ {
+		subOp, err := op.observeOne(replicaIdx, handled)
+		if err != nil {
+			if err == ErrShutdown {
+				handled(false, false, err)
+			} else {
+				op.recordObserveErr(err)
+				handled(false, false, nil)
+			}
+			continue
+		}
+
+		op.lock.Lock()
+		op.subOps = append(op.subOps, subOp)
+		op.lock.Unlock()
+	}
+}
+
+func (op *durabilityOp) observeOne(replicaIdx int, handled func(bool, bool, error)) (PendingOp, error) {
+	if op.useSeqNo {
+		token := op.opts.MutationToken
+		return op.agent.ObserveVbEx(ObserveVbOptions{
+			VbId:          token.VbId,
+			VbUuid:        token.VbUuid,
+			ReplicaIdx:    replicaIdx,
+			Deadline:      op.opts.Deadline,
+			RetryStrategy: op.opts.RetryStrategy,
+			Priority:      op.opts.Priority,
+		}, func(res *ObserveVbResult, err error) {
+			if err != nil {
+				op.recordObserveErr(err)
+				handled(false, false, nil)
+				return
+			}
+
+			if res.DidFailover {
+				handled(false, false, ErrDurabilityFailover)
+				return
+			}
+
+			persisted := res.PersistSeqNo >= token.SeqNo
+			replicated := replicaIdx > 0 && res.CurrentSeqNo >= token.SeqNo
+			handled(persisted, replicated, nil)
+		})
+	}
+
+	return op.agent.ObserveEx(ObserveOptions{
+		Key:            op.opts.Key,
+		ReplicaIdx:     replicaIdx,
+		CollectionName: op.opts.CollectionName,
+		ScopeName:      op.opts.ScopeName,
+		TraceContext:   op.tracer.RootContext(),
+		Deadline:       op.opts.Deadline,
+		RetryStrategy:  op.opts.RetryStrategy,
+		Priority:       op.opts.Priority,
+	}, func(res *ObserveResult, err error) {
+		if err != nil {
+			op.recordObserveErr(err)
+			handled(false, false, nil)
+			return
+		}
+
+		present, persisted := observedDurability(op.opts, res)
+		handled(persisted, present && replicaIdx > 0, nil)
+	})
+}
+
+// observedDurability returns whether an observed copy of a key reflects the mutation,
+// and whether the mutation has been written to disk on that copy.
+func observedDurability(opts DurabilityOptions, res *ObserveResult) (bool, bool) {
+	if opts.IsDelete {
+		// A key which is deleted has had its deletion replicated, but only a key
+		//  which is not found has had its deletion written to disk.
+		present := res.KeyState == KeyStateNotFound || res.KeyState == KeyStateDeleted
+		return present, res.KeyState == KeyStateNotFound
+	}
+
+	present := res.Cas == opts.Cas &&
+		(res.KeyState == KeyStateNotPersisted || res.KeyState == KeyStatePersisted)
+	return present, present && res.KeyState == KeyStatePersisted
+}
+
+func (op *durabilityOp) roundCompleted(numPersisted, numReplicated int, err error) {
+	if err != nil {
+		op.complete(nil, err, true)
+		return
+	}
+
+	if numPersisted >= int(op.opts.PersistTo) && numReplicated >= int(op.opts.ReplicateTo) {
+		op.complete(&DurabilityResult{
+			NumPersisted:  numPersisted,
+			NumReplicated: numReplicated,
+		}, nil, true)
+		return
+	}
+
+	waitDura := op.opts.PollInterval
+	if waitDura <= 0 {
+		waitDura = defaultDurabilityPollInterval
+	}
+
+	untilDeadline := time.Until(op.opts.Deadline)
+	if untilDeadline <= 0 {
+		op.complete(nil, op.timeoutErr(), true)
+		return
+	}
+
+	if untilDeadline < waitDura {
+		op.lock.Lock()
+		if !op.completed {
+			op.timer = time.AfterFunc(untilDeadline, func() {
+				op.complete(nil, op.timeoutErr(), true)
+			})
+		}
+		op.lock.Unlock()
+		return
+	}
+
+	op.lock.Lock()
+	if !op.completed {
+		op.timer = time.AfterFunc(waitDura, op.poll)
+	}
+	op.lock.Unlock()
+}
+
+// DurabilityEx waits for a mutation to be persisted to and replicated to the requested number
+// of servers by polling them with observe requests.  This is used with servers which do not
+// support enhanced durability.  Polling stops at the Deadline, or after 10 seconds if none
+// is given, failing with the last error returned by the observe requests or ErrTimeout.
+func (agent *Agent) DurabilityEx(opts DurabilityOptions, cb DurabilityExCallback) (PendingOp, error) {
+	tracer := agent.createOpTrace("DurabilityEx", opts.TraceContext)
+
+	if agent.bucketType() != bktTypeCouchbase {
+		tracer.Finish()
+		return nil, ErrNotSupported
+	}
+
+	if opts.PersistTo == 0 && opts.ReplicateTo == 0 {
+		tracer.Finish()
+		return nil, ErrInvalidArgs
+	}
+
+	numReplicas := agent.NumReplicas()
+	if int(opts.PersistTo) > numReplicas+1 || int(opts.ReplicateTo) > numReplicas {
+		tracer.Finish()
+		return nil, ErrDurabilityImpossible
+	}
+
+	useSeqNo := opts.MutationToken.VbUuid != 0
+	if !useSeqNo && opts.Cas == 0 && !opts.IsDelete {
+		tracer.Finish()
+		return nil, ErrInvalidArgs
+	}
+
+	if opts.Deadline.IsZero() {
+		opts.Deadline = time.Now().Add(defaultDurabilityTimeout)
+	}
+
+	op := &durabilityOp{
+		agent:    agent,
+		opts:     opts,
+		tracer:   tracer,
+		cb:       cb,
+		useSeqNo: useSeqNo,
+	}
+	op.poll()
+
+	return op, nil
+}
+
+// observeChainOp is the PendingOp returned by a mutation which has PersistTo or
+// ReplicateTo requirements, covering both the mutation and the durability polling.  If
+// the requirements are not met, the mutation result is still returned with the error.
+type observeChainOp struct {
+	lock      sync.Mutex
+	op        PendingOp
+	cancelled bool
+}
+
+func newObserveChainOp(persistTo, replicateTo uint, level DurabilityLevel) (*observeChainOp, error) {
+	if persistTo == 0 && replicateTo == 0 {
+		return nil, nil
+	}
+
+	if level > 0 {
+		// Observe based durability cannot be combined with enhanced durability.
+		return nil, ErrInvalidArgs
+	}
+
+	return &observeChainOp{}, nil
+}
+
+func (chain *observeChainOp) Cancel() bool {
+	chain.lock.Lock()
+	chain.cancelled = true
+	op := chain.op
+	chain.lock.Unlock()
+
+	if op == nil {
+		return false
+	}
+	return op.Cancel()
+}
+
+// dispatched records the mutation operation of the chain, returning the chain
+// itself as the PendingOp for the mutation.
+func (chain *observeChainOp) dispatched(op PendingOp, err error) (PendingOp, error) {
+	if err != nil {
+		return nil, err
+	}
+
+	chain.lock.Lock()
+	if chain.op == nil {
+		chain.op = op
+	}
+	chain.lock.Unlock()
+
+	return chain, nil
+}
+
+// observeMutation waits for a successful mutation to meet the durability requirements of
+// the chain, if it has any, before finishing the trace of the mutation and invoking cb.
+// The mutation has already been applied when this is called, so callers should return
+// the mutation result alongside any durability error.
+func (chain *observeChainOp) observeMutation(agent *Agent, tracer *opTracer, opts DurabilityOptions, cb func(error)) {
+	if chain == nil {
+		tracer.Finish()
+		cb(nil)
+		return
+	}
+
+	opts.TraceContext = tracer.RootContext()
+	chain.observe(agent, opts, func(err error) {
+		tracer.Finish()
+		cb(err)
+	})
+}
+
+// observe starts the durability polling once the mutation has succeeded.
+func (chain *observeChainOp) observe(agent *Agent, opts DurabilityOptions, cb func(error)) {
+	chain.lock.Lock()
+	cancelled := chain.cancelled
+	chain.lock.Unlock()
+
+	if cancelled {
+		cb(ErrCancelled)
+		return
+	}
+
+	op, err := agent.DurabilityEx(opts, func(_ *DurabilityResult, err error) {
+		cb(err)
+	})
+	if err != nil {
+		cb(err)
+		return
+	}
+
+	chain.lock.Lock()
+	chain.op = op
+	cancelled = chain.cancelled
+	chain.lock.Unlock()
+
+	if cancelled && op.Cancel() {
+		cb(ErrCancelled)
+	}
+}
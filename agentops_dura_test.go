@@ -0,0 +1,97 @@
+package gocbcore
+
+import (
+	"testing"
+)
+
+func TestNewObserveChainOp(t *testing.T) {
+	chain, err := newObserveChainOp(0, 0, 0)
+	if err != nil || chain != nil {
+		t.Fatalf("Expected no chain when there are no durability requirements")
+	}
+
+	chain, err = newObserveChainOp(1, 0, 0)
+	if err != nil || chain == nil {
+		t.Fatalf("Expected a chain when there are durability requirements")
+	}
+
+	_, err = newObserveChainOp(1, 1, Majority)
+	if err != ErrInvalidArgs {
+		t.Fatalf("Expected observe durability with a durability level to be rejected but got %v", err)
+	}
+}
+
+func TestObserveMutationWithoutChain(t *testing.T) {
+	var chain *observeChainOp
+	agent := &Agent{noRootTraceSpans: true}
+	tracer := agent.createOpTrace("SetEx", nil)
+
+	called := false
+	chain.observeMutation(agent, tracer, DurabilityOptions{}, func(err error) {
+		called = true
+		if err != nil {
+			t.Fatalf("Expected no error without durability requirements but got %v", err)
+		}
+	})
+	if !called {
+		t.Fatalf("Expected the callback to be invoked immediately")
+	}
+}
+
+func TestObserveChainOpCancel(t *testing.T) {
+	chain, _ := newObserveChainOp(1, 0, 0)
+
+	mutationOp := &testPendingOp{}
+	op, err := chain.dispatched(mutationOp, nil)
+	if err != nil {
+		t.Fatalf("Failed to record dispatched op: %v", err)
+	}
+
+	if !op.Cancel() {
+		t.Fatalf("Expected cancellation to succeed")
+	}
+	if !mutationOp.cancelled {
+		t.Fatalf("Expected the mutation op to be cancelled")
+	}
+
+	// Once cancelled, the durability phase should not be started.
+	var cbErr error
+	chain.observe(nil, DurabilityOptions{}, func(err error) {
+		cbErr = err
+	})
+	if cbErr != ErrCancelled {
+		t.Fatalf("Expected cancelled error but got %v", cbErr)
+	}
+}
+
+func TestObservedDurabilityOfDelete(t *testing.T) {
+	opts := DurabilityOptions{IsDelete: true}
+
+	present, persisted := observedDurability(opts, &ObserveResult{KeyState: KeyStateDeleted})
+	if !present || persisted {
+		t.Fatalf("Expected a deleted key to be replicated but not persisted")
+	}
+
+	present, persisted = observedDurability(opts, &ObserveResult{KeyState: KeyStateNotFound})
+	if !present || !persisted {
+		t.Fatalf("Expected a key which is not found to be persisted")
+	}
+
+	present, _ = observedDurability(opts, &ObserveResult{KeyState: KeyStatePersisted})
+	if present {
+		t.Fatalf("Expected a key which still exists not to reflect the delete")
+	}
+}
+
+func TestDurabilityOpTimeoutError(t *testing.T) {
+	op := &durabilityOp{}
+	op.recordObserveErr(ErrTmpFail)
+	if err := op.timeoutErr(); err != ErrTimeout {
+		t.Fatalf("Expected errors which polling recovers from to be ignored, got %v", err)
+	}
+
+	op.recordObserveErr(ErrAccessError)
+	if err := op.timeoutErr(); err != ErrAccessError {
+		t.Fatalf("Expected the observe error to be reported at the deadline, got %v", err)
+	}
+}
@@ -2,6 +2,7 @@ package gocbcore
 
 import (
 	"encoding/binary"
+	"time"
 
 	"github.com/opentracing/opentracing-go"
 )
@@ -10,6 +11,9 @@ import (
 type GetMetaOptions struct {
 	Key            []byte
 	TraceContext   opentracing.SpanContext
+	Deadline       time.Time
+	RetryStrategy  RetryStrategy
+	Priority       RequestPriority
 	CollectionName string
 	ScopeName      string
 }
@@ -80,6 +84,9 @@ func (agent *Agent) GetMetaEx(opts GetMetaOptions, cb GetMetaExCallback) (Pendin
 		RootTraceContext: tracer.RootContext(),
 		CollectionName:   opts.CollectionName,
 		ScopeName:        opts.ScopeName,
+		Deadline:         opts.Deadline,
+		RetryStrategy:    opts.RetryStrategy,
+		Priority:         opts.Priority,
 	}
 
 	return agent.dispatchOp(req)
@@ -97,6 +104,9 @@ type SetMetaOptions struct {
 	Cas            Cas
 	RevNo          uint64
 	TraceContext   opentracing.SpanContext
+	Deadline       time.Time
+	RetryStrategy  RetryStrategy
+	Priority       RequestPriority
 	CollectionName string
 	ScopeName      string
 }
@@ -157,6 +167,9 @@ func (agent *Agent) SetMetaEx(opts SetMetaOptions, cb SetMetaExCallback) (Pendin
 		RootTraceContext: tracer.RootContext(),
 		CollectionName:   opts.CollectionName,
 		ScopeName:        opts.ScopeName,
+		Deadline:         opts.Deadline,
+		RetryStrategy:    opts.RetryStrategy,
+		Priority:         opts.Priority,
 	}
 
 	return agent.dispatchOp(req)
@@ -174,6 +187,9 @@ type DeleteMetaOptions struct {
 	Cas            Cas
 	RevNo          uint64
 	TraceContext   opentracing.SpanContext
+	Deadline       time.Time
+	RetryStrategy  RetryStrategy
+	Priority       RequestPriority
 	CollectionName string
 	ScopeName      string
 }
@@ -234,6 +250,9 @@ func (agent *Agent) DeleteMetaEx(opts DeleteMetaOptions, cb DeleteMetaExCallback
 		RootTraceContext: tracer.RootContext(),
 		CollectionName:   opts.CollectionName,
 		ScopeName:        opts.ScopeName,
+		Deadline:         opts.Deadline,
+		RetryStrategy:    opts.RetryStrategy,
+		Priority:         opts.Priority,
 	}
 
 	return agent.dispatchOp(req)
@@ -0,0 +1,468 @@
+package gocbcore
+
+import (
+	"sync"
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+)
+
+const defaultLockMaxAttempts = 20
+
+// LockOptions encapsulates the parameters for a LockEx operation.
+type LockOptions struct {
+	Key            []byte
+	CollectionName string
+	ScopeName      string
+	LockTime       uint32
+	TraceContext   opentracing.SpanContext
+	Deadline       time.Time
+	RetryStrategy  RetryStrategy
+	Priority       RequestPriority
+
+	// MaxAttempts is the maximum number of times acquiring the lock is attempted while
+	// the document is locked by someone else.  If zero, a default of 20 attempts is used.
+	MaxAttempts uint32
+
+	// Backoff determines the time to wait between attempts, with jitter being
+	// applied.  If nil, an exponential backoff between 10ms and 500ms is used.
+	Backoff BackoffCalculator
+}
+
+// LockExCallback is invoked upon completion of a LockEx operation.
+type LockExCallback func(*LockHandle, error)
+
+// LockHandle represents a lock held on a document, acquired using LockEx.  The lock is
+// released by calling ReleaseEx, or by mutating the document through the handle.  Any
+// handles which are still held when the Agent is closed are released automatically.
+type LockHandle struct {
+	agent          *Agent
+	key            []byte
+	collectionName string
+	scopeName      string
+
+	lock     sync.Mutex
+	cas      Cas
+	value    []byte
+	flags    uint32
+	datatype uint8
+	released bool
+}
+
+// Key returns the key of the locked document.
+func (handle *LockHandle) Key() []byte {
+	return handle.key
+}
+
+// Cas returns the CAS of the locked document, which is required to mutate it.
+func (handle *LockHandle) Cas() Cas {
+	handle.lock.Lock()
+	defer handle.lock.Unlock()
+	return handle.cas
+}
+
+// Value returns the value of the document at the time it was locked.
+func (handle *LockHandle) Value() []byte {
+	handle.lock.Lock()
+	defer handle.lock.Unlock()
+	return handle.value
+}
+
+// Flags returns the flags of the document at the time it was locked.
+func (handle *LockHandle) Flags() uint32 {
+	handle.lock.Lock()
+	defer handle.lock.Unlock()
+	return handle.flags
+}
+
+// Datatype returns the datatype of the document at the time it was locked.
+func (handle *LockHandle) Datatype() uint8 {
+	handle.lock.Lock()
+	defer handle.lock.Unlock()
+	return handle.datatype
+}
+
+// IsReleased returns whether the lock has been released.
+func (handle *LockHandle) IsReleased() bool {
+	handle.lock.Lock()
+	defer handle.lock.Unlock()
+	return handle.released
+}
+
+func (handle *LockHandle) update(res *GetAndLockResult) {
+	handle.lock.Lock()
+	handle.cas = res.Cas
+	handle.value = res.Value
+	handle.flags = res.Flags
+	handle.datatype = res.Datatype
+	handle.lock.Unlock()
+}
+
+// lockedCas returns the CAS to use for an operation against the lock, failing
+// if the lock has already been released.
+func (handle *LockHandle) lockedCas() (Cas, error) {
+	handle.lock.Lock()
+	defer handle.lock.Unlock()
+
+	if handle.released {
+		return 0, ErrLockReleased
+	}
+	return handle.cas, nil
+}
+
+// markReleased records that the lock is no longer held, returning false if
+// it had already been released.
+func (handle *LockHandle) markReleased() bool {
+	handle.lock.Lock()
+	if handle.released {
+		handle.lock.Unlock()
+		return false
+	}
+	handle.released = true
+	handle.lock.Unlock()
+
+	handle.agent.untrackLockHandle(handle)
+	return true
+}
+
+// LockReleaseOptions encapsulates the parameters for a LockHandle ReleaseEx operation.
+type LockReleaseOptions struct {
+	TraceContext  opentracing.SpanContext
+	Deadline      time.Time
+	RetryStrategy RetryStrategy
+	Priority      RequestPriority
+}
+
+// ReleaseEx unlocks the document.  The handle is considered released once the unlock has
+// been dispatched, as the lock will be released by the server once its lock time expires
+// regardless of whether the unlock succeeds.
+func (handle *LockHandle) ReleaseEx(opts LockReleaseOptions, cb UnlockExCallback) (PendingOp, error) {
+	cas, err := handle.lockedCas()
+	if err != nil {
+		return nil, err
+	}
+
+	op, err := handle.agent.UnlockEx(UnlockOptions{
+		Key:            handle.key,
+		Cas:            cas,
+		CollectionName: handle.collectionName,
+		ScopeName:      handle.scopeName,
+		TraceContext:   opts.TraceContext,
+		Deadline:       opts.Deadline,
+		RetryStrategy:  opts.RetryStrategy,
+		Priority:       opts.Priority,
+	}, cb)
+	if err != nil {
+		return nil, err
+	}
+
+	handle.markReleased()
+	return op, nil
+}
+
+// LockExtendOptions encapsulates the parameters for a LockHandle ExtendEx operation.
+type LockExtendOptions struct {
+	LockTime      uint32
+	TraceContext  opentracing.SpanContext
+	Deadline      time.Time
+	RetryStrategy RetryStrategy
+	Priority      RequestPriority
+}
+
+// ExtendEx extends the lock by unlocking the document and immediately locking it again
+// for the new lock time.  This is not atomic, if another client acquires the lock in
+// between then the handle is released and the locked error is returned.
+func (handle *LockHandle) ExtendEx(opts LockExtendOptions, cb GetAndLockExCallback) (PendingOp, error) {
+	cas, err := handle.lockedCas()
+	if err != nil {
+		return nil, err
+	}
+
+	tracer := handle.agent.createOpTrace("ExtendEx", opts.TraceContext)
+
+	op := &sequencedPendingOp{}
+	relock := func() error {
+		subOp, err := handle.agent.GetAndLockEx(GetAndLockOptions{
+			Key:            handle.key,
+			LockTime:       opts.LockTime,
+			CollectionName: handle.collectionName,
+			ScopeName:      handle.scopeName,
+			TraceContext:   tracer.RootContext(),
+			Deadline:       opts.Deadline,
+			RetryStrategy:  opts.RetryStrategy,
+			Priority:       opts.Priority,
+		}, func(res *GetAndLockResult, err error) {
+			if !op.complete() {
+				return
+			}
+			tracer.Finish()
+
+			if err != nil {
+				handle.markReleased()
+				cb(nil, err)
+				return
+			}
+
+			handle.update(res)
+			cb(res, nil)
+		})
+		if err != nil {
+			return err
+		}
+
+		op.addOp(subOp)
+		return nil
+	}
+
+	subOp, err := handle.agent.UnlockEx(UnlockOptions{
+		Key:            handle.key,
+		Cas:            cas,
+		CollectionName: handle.collectionName,
+		ScopeName:      handle.scopeName,
+		TraceContext:   tracer.RootContext(),
+		Deadline:       opts.Deadline,
+		RetryStrategy:  opts.RetryStrategy,
+		Priority:       opts.Priority,
+	}, func(res *UnlockResult, err error) {
+		if err != nil {
+			if op.complete() {
+				tracer.Finish()
+				cb(nil, err)
+			}
+			return
+		}
+
+		// The document is no longer locked by us, so the operation can no
+		// longer be cancelled without losing the lock.
+		if !op.markApplied() {
+			return
+		}
+
+		err = relock()
+		if err != nil {
+			if op.complete() {
+				tracer.Finish()
+				handle.markReleased()
+				cb(nil, err)
+			}
+		}
+	})
+	if err != nil {
+		tracer.Finish()
+		return nil, err
+	}
+
+	op.addOp(subOp)
+	return op, nil
+}
+
+// ReplaceEx replaces the value of the locked document, which also releases the lock.
+// The Key, CollectionName, ScopeName and Cas options are set from the handle.
+func (handle *LockHandle) ReplaceEx(opts ReplaceOptions, cb StoreExCallback) (PendingOp, error) {
+	cas, err := handle.lockedCas()
+	if err != nil {
+		return nil, err
+	}
+
+	opts.Key = handle.key
+	opts.CollectionName = handle.collectionName
+	opts.ScopeName = handle.scopeName
+	opts.Cas = cas
+	return handle.agent.ReplaceEx(opts, func(res *StoreResult, err error) {
+		if err == nil {
+			handle.markReleased()
+		}
+		cb(res, err)
+	})
+}
+
+// MutateInEx performs sub-document mutations against the locked document, which also
+// releases the lock.  The Key, CollectionName, ScopeName and Cas options are set from
+// the handle.
+func (handle *LockHandle) MutateInEx(opts MutateInOptions, cb MutateInExCallback) (PendingOp, error) {
+	cas, err := handle.lockedCas()
+	if err != nil {
+		return nil, err
+	}
+
+	opts.Key = handle.key
+	opts.CollectionName = handle.collectionName
+	opts.ScopeName = handle.scopeName
+	opts.Cas = cas
+	return handle.agent.MutateInEx(opts, func(res *MutateInResult, err error) {
+		if err == nil {
+			handle.markReleased()
+		}
+		cb(res, err)
+	})
+}
+
+// DeleteEx removes the locked document, which also releases the lock.  The Key,
+// CollectionName, ScopeName and Cas options are set from the handle.
+func (handle *LockHandle) DeleteEx(opts DeleteOptions, cb DeleteExCallback) (PendingOp, error) {
+	cas, err := handle.lockedCas()
+	if err != nil {
+		return nil, err
+	}
+
+	opts.Key = handle.key
+	opts.CollectionName = handle.collectionName
+	opts.ScopeName = handle.scopeName
+	opts.Cas = cas
+	return handle.agent.DeleteEx(opts, func(res *DeleteResult, err error) {
+		if err == nil {
+			handle.markReleased()
+		}
+		cb(res, err)
+	})
+}
+
+func (agent *Agent) trackLockHandle(handle *LockHandle) {
+	agent.lockHandlesLock.Lock()
+	if agent.lockHandles == nil {
+		agent.lockHandles = make(map[*LockHandle]struct{})
+	}
+	agent.lockHandles[handle] = struct{}{}
+	agent.lockHandlesLock.Unlock()
+}
+
+func (agent *Agent) untrackLockHandle(handle *LockHandle) {
+	agent.lockHandlesLock.Lock()
+	delete(agent.lockHandles, handle)
+	agent.lockHandlesLock.Unlock()
+}
+
+// releaseLockHandles releases all of the locks which are still held.  It only waits for
+// the unlocks to complete, for up to lockReleaseOnCloseTimeout, if a timeout is set.
+func (agent *Agent) releaseLockHandles() {
+	agent.lockHandlesLock.Lock()
+	var handles []*LockHandle
+	for handle := range agent.lockHandles {
+		handles = append(handles, handle)
+	}
+	agent.lockHandlesLock.Unlock()
+
+	if len(handles) == 0 {
+		return
+	}
+
+	waitForRelease := agent.lockReleaseOnCloseTimeout > 0
+
+	var deadline time.Time
+	if waitForRelease {
+		deadline = time.Now().Add(agent.lockReleaseOnCloseTimeout)
+	}
+
+	var wg sync.WaitGroup
+	for _, handle := range handles {
+		wg.Add(1)
+		_, err := handle.ReleaseEx(LockReleaseOptions{
+			Deadline: deadline,
+		}, func(res *UnlockResult, err error) {
+			if err != nil {
+				logDebugf("Failed to release lock during close: %v", err)
+			}
+			wg.Done()
+		})
+		if err != nil {
+			handle.markReleased()
+			wg.Done()
+		}
+	}
+
+	if waitForRelease {
+		wg.Wait()
+	}
+}
+
+type lockOp struct {
+	*retryingPendingOp
+	agent *Agent
+	opts  LockOptions
+	cb    LockExCallback
+}
+
+func newLockOp(agent *Agent, opts LockOptions, cb LockExCallback) *lockOp {
+	op := &lockOp{
+		agent: agent,
+		opts:  opts,
+		cb:    cb,
+	}
+	op.retryingPendingOp = &retryingPendingOp{
+		tracer:      agent.createOpTrace("LockEx", opts.TraceContext),
+		deadline:    opts.Deadline,
+		maxAttempts: opts.MaxAttempts,
+		backoff:     opts.Backoff,
+		dispatch:    op.run,
+		fail: func(err error) {
+			op.finish(nil, err)
+		},
+	}
+	return op
+}
+
+func (op *lockOp) finish(handle *LockHandle, err error) {
+	if op.complete() {
+		op.cb(handle, err)
+	}
+}
+
+// isLockedError returns whether an error indicates that a document is locked.  Older
+// servers report a locked document as a temporary failure.
+func isLockedError(err error) bool {
+	return IsErrorStatus(err, StatusLocked) || IsErrorStatus(err, StatusTmpFail)
+}
+
+func (op *lockOp) run() (PendingOp, error) {
+	return op.agent.GetAndLockEx(GetAndLockOptions{
+		Key:            op.opts.Key,
+		LockTime:       op.opts.LockTime,
+		CollectionName: op.opts.CollectionName,
+		ScopeName:      op.opts.ScopeName,
+		TraceContext:   op.tracer.RootContext(),
+		Deadline:       op.opts.Deadline,
+		RetryStrategy:  op.opts.RetryStrategy,
+		Priority:       op.opts.Priority,
+	}, func(res *GetAndLockResult, err error) {
+		if err != nil {
+			if isLockedError(err) {
+				op.retry(err)
+				return
+			}
+
+			op.finish(nil, err)
+			return
+		}
+
+		handle := &LockHandle{
+			agent:          op.agent,
+			key:            op.opts.Key,
+			collectionName: op.opts.CollectionName,
+			scopeName:      op.opts.ScopeName,
+		}
+		handle.update(res)
+		op.agent.trackLockHandle(handle)
+
+		op.finish(handle, nil)
+	})
+}
+
+// LockEx locks a document, returning a LockHandle which is used to mutate the document
+// and release the lock.  If the document is already locked then acquiring the lock is
+// retried after backing off.
+func (agent *Agent) LockEx(opts LockOptions, cb LockExCallback) (PendingOp, error) {
+	if opts.MaxAttempts == 0 {
+		opts.MaxAttempts = defaultLockMaxAttempts
+	}
+	if opts.Backoff == nil {
+		opts.Backoff = ExponentialBackoff(10*time.Millisecond, 500*time.Millisecond, 2)
+	}
+
+	op := newLockOp(agent, opts, cb)
+	if err := op.start(); err != nil {
+		op.tracer.Finish()
+		return nil, err
+	}
+
+	return op, nil
+}
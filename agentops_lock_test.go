@@ -0,0 +1,153 @@
+package gocbcore
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLockHandleRelease(t *testing.T) {
+	agent := &Agent{}
+	handle := &LockHandle{
+		agent: agent,
+		cas:   Cas(10),
+	}
+	agent.trackLockHandle(handle)
+
+	cas, err := handle.lockedCas()
+	if err != nil || cas != Cas(10) {
+		t.Fatalf("Expected the lock cas but got %d, %v", cas, err)
+	}
+
+	if !handle.markReleased() {
+		t.Fatalf("Expected the first release to succeed")
+	}
+	if handle.markReleased() {
+		t.Fatalf("Expected a second release to fail")
+	}
+	if len(agent.lockHandles) != 0 {
+		t.Fatalf("Expected the handle to no longer be tracked")
+	}
+
+	_, err = handle.lockedCas()
+	if err != ErrLockReleased {
+		t.Fatalf("Expected ErrLockReleased but got %v", err)
+	}
+
+	_, err = handle.ReleaseEx(LockReleaseOptions{}, func(res *UnlockResult, err error) {
+		t.Errorf("Callback should not be invoked for a released lock")
+	})
+	if err != ErrLockReleased {
+		t.Fatalf("Expected ErrLockReleased but got %v", err)
+	}
+}
+
+func TestLockRetryExhausted(t *testing.T) {
+	var cbErr error
+	op := newLockOp(&Agent{noRootTraceSpans: true}, LockOptions{
+		MaxAttempts: 3,
+		Backoff:     ExponentialBackoff(1*time.Millisecond, 10*time.Millisecond, 2),
+	}, func(handle *LockHandle, err error) {
+		cbErr = err
+	})
+	op.attempt = 3
+
+	lockedErr := ErrTmpFail
+	if !isLockedError(lockedErr) {
+		t.Fatalf("Expected a temporary failure to be treated as locked")
+	}
+
+	op.retry(lockedErr)
+	if cbErr != lockedErr {
+		t.Fatalf("Expected the last error once attempts are exhausted but got %v", cbErr)
+	}
+}
+
+func TestLockCancelDuringBackoff(t *testing.T) {
+	op := newLockOp(&Agent{noRootTraceSpans: true}, LockOptions{
+		MaxAttempts: 5,
+		Backoff: func(retryAttempts uint32) time.Duration {
+			return 1 * time.Second
+		},
+	}, func(handle *LockHandle, err error) {
+		t.Errorf("Callback should not be invoked after cancellation")
+	})
+	op.attempt = 1
+
+	op.retry(ErrTmpFail)
+	if !op.Cancel() {
+		t.Fatalf("Expected cancellation during backoff to succeed")
+	}
+	if op.Cancel() {
+		t.Fatalf("Expected a second cancellation to fail")
+	}
+}
+
+func TestLockCancelWhileStartingAttempt(t *testing.T) {
+	op := newLockOp(&Agent{noRootTraceSpans: true}, LockOptions{
+		MaxAttempts: 5,
+	}, func(handle *LockHandle, err error) {
+		t.Errorf("Callback should not be invoked after cancellation")
+	})
+
+	// The cancellation arrives after the backoff has elapsed, but before the next
+	// attempt has been dispatched.
+	subOp := &testPendingOp{}
+	op.dispatch = func() (PendingOp, error) {
+		if !op.Cancel() {
+			t.Errorf("Expected cancellation while starting an attempt to succeed")
+		}
+		return subOp, nil
+	}
+
+	if err := op.start(); err != nil {
+		t.Fatalf("Failed to start attempt: %v", err)
+	}
+	if !subOp.cancelled {
+		t.Fatalf("Expected the attempt to be cancelled once it was dispatched")
+	}
+	if op.Cancel() {
+		t.Fatalf("Expected a second cancellation to fail")
+	}
+}
+
+func TestReleaseLockHandlesWithoutTimeout(t *testing.T) {
+	cfg := getConfig(t, "testdata/bucket_config_with_external_addresses.json")
+
+	// A pool size of zero stops the pipelines from dialing the servers in the config,
+	// so the unlock is queued but never sent.
+	agent := &Agent{
+		bucket:           "default",
+		numVbuckets:      cfg.vbMap.NumVbuckets(),
+		networkType:      "default",
+		kvPoolSize:       0,
+		maxQueueSize:     10,
+		noRootTraceSpans: true,
+	}
+	agent.cidMgr = newCollectionIdManager(agent, agent.maxQueueSize)
+	agent.routingInfo.Update(nil, &routeData{
+		revId: -1,
+	})
+	agent.applyConfig(cfg)
+
+	handle := &LockHandle{
+		agent: agent,
+		key:   []byte("key"),
+		cas:   Cas(10),
+	}
+	agent.trackLockHandle(handle)
+
+	done := make(chan struct{})
+	go func() {
+		agent.releaseLockHandles()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("Expected releasing the locks not to wait for the unlocks")
+	}
+	if !handle.IsReleased() {
+		t.Fatalf("Expected the handle to be released")
+	}
+}
@@ -0,0 +1,339 @@
+package gocbcore
+
+import (
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+)
+
+const defaultMutateWithCasMaxAttempts = 10
+
+// MutateWithCasState is the current state of a document, passed to a MutateWithCasFunc.
+type MutateWithCasState struct {
+	// Exists indicates whether the document currently exists.  It will only be
+	// false when InsertIfMissing is set.
+	Exists bool
+	Cas    Cas
+
+	// Value, Flags and Datatype are populated in full document mode.
+	Value    []byte
+	Flags    uint32
+	Datatype uint8
+
+	// Ops contains the results of the LookupOps in sub-document mode.
+	Ops []SubDocResult
+
+	// Attempt is the number of the current attempt, starting at 1.
+	Attempt uint32
+}
+
+// MutateWithCasChange is the change to apply to a document, returned by a MutateWithCasFunc.
+type MutateWithCasChange struct {
+	// Value, Flags and Datatype are used in full document mode, or when inserting
+	// a document which does not exist.
+	Value    []byte
+	Flags    uint32
+	Datatype uint8
+
+	// Ops contains the mutations to apply in sub-document mode.
+	Ops []SubDocOp
+}
+
+// MutateWithCasFunc is invoked with the current state of a document and returns the change
+// to apply to it.  It may be invoked multiple times if the document is concurrently modified.
+// Returning a nil change leaves the document untouched, and returning an error aborts the
+// operation with that error.
+type MutateWithCasFunc func(state *MutateWithCasState) (*MutateWithCasChange, error)
+
+// MutateWithCasOptions encapsulates the parameters for a MutateWithCasEx operation.
+type MutateWithCasOptions struct {
+	Key                    []byte
+	CollectionName         string
+	ScopeName              string
+	Expiry                 uint32
+	TraceContext           opentracing.SpanContext
+	Deadline               time.Time
+	RetryStrategy          RetryStrategy
+	Priority               RequestPriority
+	DurabilityLevel        DurabilityLevel
+	DurabilityLevelTimeout uint16
+	PersistTo              uint
+	ReplicateTo            uint
+
+	// LookupOps enables sub-document mode, where these lookups are used to read the
+	// document and the mutation is applied using MutateInEx.
+	LookupOps []SubDocOp
+
+	// InsertIfMissing causes the document to be inserted, using AddEx, if it does
+	// not exist.  Otherwise a missing document fails the operation.
+	InsertIfMissing bool
+
+	// MaxAttempts is the maximum number of times the document is read and mutated.
+	// If zero, a default of 10 attempts is used.
+	MaxAttempts uint32
+
+	// Backoff determines the time to wait between attempts, with jitter being
+	// applied.  If nil, an exponential backoff between 1ms and 100ms is used.
+	Backoff BackoffCalculator
+}
+
+// MutateWithCasResult encapsulates the result of a MutateWithCasEx operation.
+type MutateWithCasResult struct {
+	Cas           Cas
+	MutationToken MutationToken
+	Attempts      uint32
+	Inserted      bool
+}
+
+// MutateWithCasExCallback is invoked upon completion of a MutateWithCasEx operation.
+type MutateWithCasExCallback func(*MutateWithCasResult, error)
+
+type mutateWithCasOp struct {
+	*retryingPendingOp
+	agent    *Agent
+	opts     MutateWithCasOptions
+	mutateFn MutateWithCasFunc
+	cb       MutateWithCasExCallback
+}
+
+func newMutateWithCasOp(agent *Agent, opts MutateWithCasOptions, mutateFn MutateWithCasFunc,
+	cb MutateWithCasExCallback) *mutateWithCasOp {
+	op := &mutateWithCasOp{
+		agent:    agent,
+		opts:     opts,
+		mutateFn: mutateFn,
+		cb:       cb,
+	}
+	op.retryingPendingOp = &retryingPendingOp{
+		tracer:      agent.createOpTrace("MutateWithCasEx", opts.TraceContext),
+		deadline:    opts.Deadline,
+		maxAttempts: opts.MaxAttempts,
+		backoff:     opts.Backoff,
+		dispatch:    op.run,
+		fail: func(err error) {
+			op.finish(nil, err)
+		},
+	}
+	return op
+}
+
+func (op *mutateWithCasOp) finish(res *MutateWithCasResult, err error) {
+	if op.complete() {
+		op.cb(res, err)
+	}
+}
+
+func (op *mutateWithCasOp) run() (PendingOp, error) {
+	var subOp PendingOp
+	var err error
+	if len(op.opts.LookupOps) > 0 {
+		subOp, err = op.agent.LookupInEx(LookupInOptions{
+			Key:            op.opts.Key,
+			Ops:            op.opts.LookupOps,
+			CollectionName: op.opts.CollectionName,
+			ScopeName:      op.opts.ScopeName,
+			TraceContext:   op.tracer.RootContext(),
+			Deadline:       op.opts.Deadline,
+			RetryStrategy:  op.opts.RetryStrategy,
+			Priority:       op.opts.Priority,
+		}, func(res *LookupInResult, err error) {
+			if res == nil {
+				op.handleMissing(err)
+				return
+			}
+
+			op.apply(&MutateWithCasState{
+				Exists:  true,
+				Cas:     res.Cas,
+				Ops:     res.Ops,
+				Attempt: op.attempt,
+			})
+		})
+	} else {
+		subOp, err = op.agent.GetEx(GetOptions{
+			Key:            op.opts.Key,
+			CollectionName: op.opts.CollectionName,
+			ScopeName:      op.opts.ScopeName,
+			TraceContext:   op.tracer.RootContext(),
+			Deadline:       op.opts.Deadline,
+			RetryStrategy:  op.opts.RetryStrategy,
+			Priority:       op.opts.Priority,
+		}, func(res *GetResult, err error) {
+			if err != nil {
+				op.handleMissing(err)
+				return
+			}
+
+			op.apply(&MutateWithCasState{
+				Exists:   true,
+				Cas:      res.Cas,
+				Value:    res.Value,
+				Flags:    res.Flags,
+				Datatype: res.Datatype,
+				Attempt:  op.attempt,
+			})
+		})
+	}
+	return subOp, err
+}
+
+func (op *mutateWithCasOp) handleMissing(err error) {
+	if !IsErrorStatus(err, StatusKeyNotFound) || !op.opts.InsertIfMissing {
+		op.finish(nil, err)
+		return
+	}
+
+	op.apply(&MutateWithCasState{
+		Exists:  false,
+		Attempt: op.attempt,
+	})
+}
+
+func (op *mutateWithCasOp) apply(state *MutateWithCasState) {
+	change, err := op.mutateFn(state)
+	if err != nil {
+		op.finish(nil, err)
+		return
+	}
+
+	if change == nil {
+		op.finish(&MutateWithCasResult{
+			Cas:      state.Cas,
+			Attempts: op.attempt,
+		}, nil)
+		return
+	}
+
+	// A result accompanies the error if the mutation was applied but did not meet its
+	// durability requirements.  Only errors without a result are handled here, as an
+	// applied mutation must not be retried.
+	handleErr := func(err error) {
+		// The document was changed, created or removed by someone else since
+		// we read it, so we need to try again.
+		if IsErrorStatus(err, StatusKeyExists) || IsErrorStatus(err, StatusKeyNotFound) {
+			op.retry(err)
+			return
+		}
+
+		op.finish(nil, err)
+	}
+
+	if !state.Exists {
+		op.setOp(op.agent.AddEx(AddOptions{
+			Key:                    op.opts.Key,
+			CollectionName:         op.opts.CollectionName,
+			ScopeName:              op.opts.ScopeName,
+			Value:                  change.Value,
+			Flags:                  change.Flags,
+			Datatype:               change.Datatype,
+			Expiry:                 op.opts.Expiry,
+			TraceContext:           op.tracer.RootContext(),
+			Deadline:               op.opts.Deadline,
+			RetryStrategy:          op.opts.RetryStrategy,
+			Priority:               op.opts.Priority,
+			DurabilityLevel:        op.opts.DurabilityLevel,
+			DurabilityLevelTimeout: op.opts.DurabilityLevelTimeout,
+			PersistTo:              op.opts.PersistTo,
+			ReplicateTo:            op.opts.ReplicateTo,
+		}, func(res *StoreResult, err error) {
+			if res == nil {
+				handleErr(err)
+				return
+			}
+
+			op.finish(&MutateWithCasResult{
+				Cas:           res.Cas,
+				MutationToken: res.MutationToken,
+				Attempts:      op.attempt,
+				Inserted:      true,
+			}, err)
+		}))
+		return
+	}
+
+	if len(op.opts.LookupOps) > 0 {
+		op.setOp(op.agent.MutateInEx(MutateInOptions{
+			Key:                    op.opts.Key,
+			Cas:                    state.Cas,
+			Expiry:                 op.opts.Expiry,
+			Ops:                    change.Ops,
+			CollectionName:         op.opts.CollectionName,
+			ScopeName:              op.opts.ScopeName,
+			DurabilityLevel:        op.opts.DurabilityLevel,
+			DurabilityLevelTimeout: op.opts.DurabilityLevelTimeout,
+			PersistTo:              op.opts.PersistTo,
+			ReplicateTo:            op.opts.ReplicateTo,
+			TraceContext:           op.tracer.RootContext(),
+			Deadline:               op.opts.Deadline,
+			RetryStrategy:          op.opts.RetryStrategy,
+			Priority:               op.opts.Priority,
+		}, func(res *MutateInResult, err error) {
+			if res == nil {
+				handleErr(err)
+				return
+			}
+
+			op.finish(&MutateWithCasResult{
+				Cas:           res.Cas,
+				MutationToken: res.MutationToken,
+				Attempts:      op.attempt,
+			}, err)
+		}))
+		return
+	}
+
+	op.setOp(op.agent.ReplaceEx(ReplaceOptions{
+		Key:                    op.opts.Key,
+		CollectionName:         op.opts.CollectionName,
+		ScopeName:              op.opts.ScopeName,
+		Value:                  change.Value,
+		Flags:                  change.Flags,
+		Datatype:               change.Datatype,
+		Cas:                    state.Cas,
+		Expiry:                 op.opts.Expiry,
+		TraceContext:           op.tracer.RootContext(),
+		Deadline:               op.opts.Deadline,
+		RetryStrategy:          op.opts.RetryStrategy,
+		Priority:               op.opts.Priority,
+		DurabilityLevel:        op.opts.DurabilityLevel,
+		DurabilityLevelTimeout: op.opts.DurabilityLevelTimeout,
+		PersistTo:              op.opts.PersistTo,
+		ReplicateTo:            op.opts.ReplicateTo,
+	}, func(res *StoreResult, err error) {
+		if res == nil {
+			handleErr(err)
+			return
+		}
+
+		op.finish(&MutateWithCasResult{
+			Cas:           res.Cas,
+			MutationToken: res.MutationToken,
+			Attempts:      op.attempt,
+		}, err)
+	}))
+}
+
+// MutateWithCasEx performs an optimistic read-modify-write of a document.  The document is
+// read, passed to mutateFn, and the returned change is written using the CAS of the read.
+// If the document was concurrently modified, the whole process is retried after backing off.
+func (agent *Agent) MutateWithCasEx(opts MutateWithCasOptions, mutateFn MutateWithCasFunc,
+	cb MutateWithCasExCallback) (PendingOp, error) {
+	if mutateFn == nil {
+		return nil, ErrInvalidArgs
+	}
+
+	if opts.MaxAttempts == 0 {
+		opts.MaxAttempts = defaultMutateWithCasMaxAttempts
+	}
+	if opts.Backoff == nil {
+		opts.Backoff = ExponentialBackoff(1*time.Millisecond, 100*time.Millisecond, 2)
+	}
+
+	op := newMutateWithCasOp(agent, opts, mutateFn, cb)
+	if err := op.start(); err != nil {
+		op.tracer.Finish()
+		return nil, err
+	}
+
+	return op, nil
+}
@@ -0,0 +1,65 @@
+package gocbcore
+
+import (
+	"testing"
+	"time"
+)
+
+func newTestMutateWithCasOp(opts MutateWithCasOptions, cb MutateWithCasExCallback) *mutateWithCasOp {
+	if opts.Backoff == nil {
+		opts.Backoff = ExponentialBackoff(1*time.Millisecond, 10*time.Millisecond, 2)
+	}
+
+	return newMutateWithCasOp(&Agent{noRootTraceSpans: true}, opts, nil, cb)
+}
+
+func TestMutateWithCasRetryExhausted(t *testing.T) {
+	var cbErr error
+	op := newTestMutateWithCasOp(MutateWithCasOptions{
+		MaxAttempts: 2,
+	}, func(res *MutateWithCasResult, err error) {
+		cbErr = err
+	})
+	op.attempt = 2
+
+	op.retry(ErrKeyExists)
+	if cbErr != ErrKeyExists {
+		t.Fatalf("Expected the last error once attempts are exhausted but got %v", cbErr)
+	}
+}
+
+func TestMutateWithCasRetryDeadline(t *testing.T) {
+	var cbErr error
+	op := newTestMutateWithCasOp(MutateWithCasOptions{
+		MaxAttempts: 5,
+		Deadline:    time.Now().Add(-1 * time.Millisecond),
+	}, func(res *MutateWithCasResult, err error) {
+		cbErr = err
+	})
+	op.attempt = 1
+
+	op.retry(ErrKeyExists)
+	if cbErr != ErrTimeout {
+		t.Fatalf("Expected a timeout when the deadline would be exceeded but got %v", cbErr)
+	}
+}
+
+func TestMutateWithCasCancelDuringBackoff(t *testing.T) {
+	op := newTestMutateWithCasOp(MutateWithCasOptions{
+		MaxAttempts: 5,
+		Backoff: func(retryAttempts uint32) time.Duration {
+			return 1 * time.Second
+		},
+	}, func(res *MutateWithCasResult, err error) {
+		t.Errorf("Callback should not be invoked after cancellation")
+	})
+	op.attempt = 1
+
+	op.retry(ErrKeyExists)
+	if !op.Cancel() {
+		t.Fatalf("Expected cancellation during backoff to succeed")
+	}
+	if op.Cancel() {
+		t.Fatalf("Expected a second cancellation to fail")
+	}
+}
@@ -0,0 +1,437 @@
+package gocbcore
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+)
+
+// GetProjectedOptions encapsulates the parameters for a GetProjectedEx operation.
+type GetProjectedOptions struct {
+	Key            []byte
+	Paths          []string
+	WithExpiry     bool
+	CollectionName string
+	ScopeName      string
+	TraceContext   opentracing.SpanContext
+	Deadline       time.Time
+	RetryStrategy  RetryStrategy
+	Priority       RequestPriority
+}
+
+// GetProjectedResult encapsulates the result of a GetProjectedEx operation.
+type GetProjectedResult struct {
+	Value  []byte
+	Cas    Cas
+	Expiry uint32
+}
+
+// GetProjectedExCallback is invoked upon completion of a GetProjectedEx operation.
+type GetProjectedExCallback func(*GetProjectedResult, error)
+
+type projectionPathPart struct {
+	name    string
+	index   int
+	isIndex bool
+}
+
+// parseProjectionPath splits a sub-document path such as `a.b[2].c` into its
+// individual parts.  Field names may be escaped using backticks.
+func parseProjectionPath(path string) ([]projectionPathPart, error) {
+	var parts []projectionPathPart
+
+	i := 0
+	for i < len(path) {
+		switch path[i] {
+		case '.':
+			if len(parts) == 0 || i+1 >= len(path) {
+				return nil, ErrInvalidArgs
+			}
+			i++
+		case '[':
+			end := bytes.IndexByte([]byte(path[i:]), ']')
+			if len(parts) == 0 || end < 0 {
+				return nil, ErrInvalidArgs
+			}
+			index, err := strconv.Atoi(path[i+1 : i+end])
+			if err != nil {
+				return nil, ErrInvalidArgs
+			}
+			parts = append(parts, projectionPathPart{index: index, isIndex: true})
+			i += end + 1
+			continue
+		}
+
+		if i < len(path) && path[i] == '`' {
+			var name []byte
+			i++
+			for {
+				if i >= len(path) {
+					return nil, ErrInvalidArgs
+				}
+				if path[i] == '`' {
+					if i+1 < len(path) && path[i+1] == '`' {
+						name = append(name, '`')
+						i += 2
+						continue
+					}
+					i++
+					break
+				}
+				name = append(name, path[i])
+				i++
+			}
+			parts = append(parts, projectionPathPart{name: string(name)})
+			continue
+		}
+
+		start := i
+		for i < len(path) && path[i] != '.' && path[i] != '[' {
+			i++
+		}
+		if start == i {
+			return nil, ErrInvalidArgs
+		}
+		parts = append(parts, projectionPathPart{name: path[start:i]})
+	}
+
+	if len(parts) == 0 {
+		return nil, ErrInvalidArgs
+	}
+
+	return parts, nil
+}
+
+// projectionNode is used to rebuild a JSON document from a number of projected
+// paths, maintaining the order in which the paths were requested.
+type projectionNode struct {
+	value   json.RawMessage
+	isArray bool
+	keys    []string
+	fields  map[string]*projectionNode
+	indexes []int
+	elems   map[int]*projectionNode
+}
+
+func (node *projectionNode) child(part projectionPathPart) *projectionNode {
+	if part.isIndex {
+		node.isArray = true
+		if node.elems == nil {
+			node.elems = make(map[int]*projectionNode)
+		}
+		child, ok := node.elems[part.index]
+		if !ok {
+			child = &projectionNode{}
+			node.elems[part.index] = child
+			node.indexes = append(node.indexes, part.index)
+		}
+		return child
+	}
+
+	if node.fields == nil {
+		node.fields = make(map[string]*projectionNode)
+	}
+	child, ok := node.fields[part.name]
+	if !ok {
+		child = &projectionNode{}
+		node.fields[part.name] = child
+		node.keys = append(node.keys, part.name)
+	}
+	return child
+}
+
+func (node *projectionNode) set(parts []projectionPathPart, value json.RawMessage) {
+	for _, part := range parts {
+		node = node.child(part)
+	}
+	node.value = value
+}
+
+func (node *projectionNode) encode(buf *bytes.Buffer) error {
+	if node.value != nil {
+		buf.Write(node.value)
+		return nil
+	}
+
+	if node.isArray {
+		buf.WriteByte('[')
+		for i, index := range node.indexes {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := node.elems[index].encode(buf); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+		return nil
+	}
+
+	buf.WriteByte('{')
+	for i, key := range node.keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		keyBytes, err := json.Marshal(key)
+		if err != nil {
+			return err
+		}
+		buf.Write(keyBytes)
+		buf.WriteByte(':')
+		if err := node.fields[key].encode(buf); err != nil {
+			return err
+		}
+	}
+	buf.WriteByte('}')
+	return nil
+}
+
+// findProjectionPath locates the value at a path within a decoded document.
+func findProjectionPath(doc interface{}, parts []projectionPathPart) (interface{}, bool) {
+	for _, part := range parts {
+		if part.isIndex {
+			arr, ok := doc.([]interface{})
+			if !ok {
+				return nil, false
+			}
+			index := part.index
+			if index < 0 {
+				index += len(arr)
+			}
+			if index < 0 || index >= len(arr) {
+				return nil, false
+			}
+			doc = arr[index]
+			continue
+		}
+
+		obj, ok := doc.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		doc, ok = obj[part.name]
+		if !ok {
+			return nil, false
+		}
+	}
+
+	return doc, true
+}
+
+// projectDocument builds a document containing only the requested paths of a
+// full JSON document.  Paths which do not exist are omitted.
+func projectDocument(value []byte, paths [][]projectionPathPart) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(value))
+	decoder.UseNumber()
+
+	var doc interface{}
+	if err := decoder.Decode(&doc); err != nil {
+		return nil, err
+	}
+
+	root := &projectionNode{}
+	for _, parts := range paths {
+		found, ok := findProjectionPath(doc, parts)
+		if !ok {
+			continue
+		}
+
+		foundBytes, err := json.Marshal(found)
+		if err != nil {
+			return nil, err
+		}
+		root.set(parts, foundBytes)
+	}
+
+	var buf bytes.Buffer
+	if err := root.encode(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// GetProjectedEx retrieves only the specified paths of a document, reassembling them into
+// a JSON document containing just those paths.  If more paths are requested than can be
+// performed in a single sub-document lookup, the full document is fetched and projected
+// on the client instead.
+func (agent *Agent) GetProjectedEx(opts GetProjectedOptions, cb GetProjectedExCallback) (PendingOp, error) {
+	paths := make([][]projectionPathPart, len(opts.Paths))
+	for i, path := range opts.Paths {
+		parts, err := parseProjectionPath(path)
+		if err != nil {
+			return nil, err
+		}
+		paths[i] = parts
+	}
+
+	numOps := len(opts.Paths)
+	if opts.WithExpiry {
+		numOps++
+	}
+
+	if len(opts.Paths) > 0 && numOps <= maxSubDocOps {
+		return agent.getProjectedLookup(opts, paths, cb)
+	}
+
+	return agent.getProjectedFull(opts, paths, cb)
+}
+
+func (agent *Agent) getProjectedLookup(opts GetProjectedOptions, paths [][]projectionPathPart,
+	cb GetProjectedExCallback) (PendingOp, error) {
+	var ops []SubDocOp
+	if opts.WithExpiry {
+		ops = append(ops, SubDocOp{
+			Op:    SubDocOpGet,
+			Flags: SubdocFlagXattrPath,
+			Path:  "$document.exptime",
+		})
+	}
+	for _, path := range opts.Paths {
+		ops = append(ops, SubDocOp{
+			Op:   SubDocOpGet,
+			Path: path,
+		})
+	}
+
+	return agent.LookupInEx(LookupInOptions{
+		Key:            opts.Key,
+		Ops:            ops,
+		CollectionName: opts.CollectionName,
+		ScopeName:      opts.ScopeName,
+		TraceContext:   opts.TraceContext,
+		Deadline:       opts.Deadline,
+		RetryStrategy:  opts.RetryStrategy,
+		Priority:       opts.Priority,
+	}, func(res *LookupInResult, err error) {
+		if res == nil {
+			cb(nil, err)
+			return
+		}
+
+		result := &GetProjectedResult{
+			Cas: res.Cas,
+		}
+
+		results := res.Ops
+		if opts.WithExpiry {
+			if results[0].Err != nil {
+				cb(nil, results[0].Err)
+				return
+			}
+
+			expiry, err := strconv.ParseUint(string(results[0].Value), 10, 32)
+			if err != nil {
+				cb(nil, ErrProtocol)
+				return
+			}
+			result.Expiry = uint32(expiry)
+			results = results[1:]
+		}
+
+		root := &projectionNode{}
+		for i, opRes := range results {
+			if opRes.Err != nil {
+				if IsErrorStatus(opRes.Err, StatusSubDocPathNotFound) {
+					continue
+				}
+				cb(nil, opRes.Err)
+				return
+			}
+
+			root.set(paths[i], opRes.Value)
+		}
+
+		var buf bytes.Buffer
+		if err := root.encode(&buf); err != nil {
+			cb(nil, err)
+			return
+		}
+		result.Value = buf.Bytes()
+
+		cb(result, nil)
+	})
+}
+
+func (agent *Agent) getProjectedFull(opts GetProjectedOptions, paths [][]projectionPathPart,
+	cb GetProjectedExCallback) (PendingOp, error) {
+	project := func(value []byte, cas Cas, expiry uint32) {
+		if len(paths) > 0 {
+			var err error
+			value, err = projectDocument(value, paths)
+			if err != nil {
+				cb(nil, err)
+				return
+			}
+		}
+
+		cb(&GetProjectedResult{
+			Value:  value,
+			Cas:    cas,
+			Expiry: expiry,
+		}, nil)
+	}
+
+	if !opts.WithExpiry {
+		return agent.GetEx(GetOptions{
+			Key:            opts.Key,
+			CollectionName: opts.CollectionName,
+			ScopeName:      opts.ScopeName,
+			TraceContext:   opts.TraceContext,
+			Deadline:       opts.Deadline,
+			RetryStrategy:  opts.RetryStrategy,
+			Priority:       opts.Priority,
+		}, func(res *GetResult, err error) {
+			if err != nil {
+				cb(nil, err)
+				return
+			}
+
+			project(res.Value, res.Cas, 0)
+		})
+	}
+
+	// The expiry is not returned by a normal get, so we fetch it along with
+	// the full document using a lookup instead.
+	return agent.LookupInEx(LookupInOptions{
+		Key: opts.Key,
+		Ops: []SubDocOp{
+			{
+				Op:    SubDocOpGet,
+				Flags: SubdocFlagXattrPath,
+				Path:  "$document.exptime",
+			},
+			{
+				Op: SubDocOpGetDoc,
+			},
+		},
+		CollectionName: opts.CollectionName,
+		ScopeName:      opts.ScopeName,
+		TraceContext:   opts.TraceContext,
+		Deadline:       opts.Deadline,
+		RetryStrategy:  opts.RetryStrategy,
+		Priority:       opts.Priority,
+	}, func(res *LookupInResult, err error) {
+		if res == nil {
+			cb(nil, err)
+			return
+		}
+
+		for _, opRes := range res.Ops {
+			if opRes.Err != nil {
+				cb(nil, opRes.Err)
+				return
+			}
+		}
+
+		expiry, err := strconv.ParseUint(string(res.Ops[0].Value), 10, 32)
+		if err != nil {
+			cb(nil, ErrProtocol)
+			return
+		}
+
+		project(res.Ops[1].Value, res.Cas, uint32(expiry))
+	})
+}
@@ -0,0 +1,56 @@
+package gocbcore
+
+import (
+	"testing"
+)
+
+func TestParseProjectionPath(t *testing.T) {
+	parts, err := parseProjectionPath("a.b[2].`c.d`")
+	if err != nil {
+		t.Fatalf("Failed to parse path: %v", err)
+	}
+
+	expected := []projectionPathPart{
+		{name: "a"},
+		{name: "b"},
+		{index: 2, isIndex: true},
+		{name: "c.d"},
+	}
+	if len(parts) != len(expected) {
+		t.Fatalf("Expected %d parts but got %d", len(expected), len(parts))
+	}
+	for i, part := range parts {
+		if part != expected[i] {
+			t.Fatalf("Part %d was %+v but expected %+v", i, part, expected[i])
+		}
+	}
+
+	for _, badPath := range []string{"", ".a", "a.", "[1]", "a[x]", "a[1", "`a"} {
+		if _, err := parseProjectionPath(badPath); err != ErrInvalidArgs {
+			t.Fatalf("Expected path %q to be rejected", badPath)
+		}
+	}
+}
+
+func TestProjectDocument(t *testing.T) {
+	doc := []byte(`{"name":"frank","age":42,"address":{"city":"london","zip":"n1"},"tags":["a","b","c"],"big":12345678901234567890}`)
+
+	var paths [][]projectionPathPart
+	for _, path := range []string{"address.city", "name", "tags[1]", "missing", "big"} {
+		parts, err := parseProjectionPath(path)
+		if err != nil {
+			t.Fatalf("Failed to parse path: %v", err)
+		}
+		paths = append(paths, parts)
+	}
+
+	value, err := projectDocument(doc, paths)
+	if err != nil {
+		t.Fatalf("Failed to project document: %v", err)
+	}
+
+	expected := `{"address":{"city":"london"},"name":"frank","tags":["b"],"big":12345678901234567890}`
+	if string(value) != expected {
+		t.Fatalf("Expected projected document %s but got %s", expected, value)
+	}
+}
@@ -0,0 +1,375 @@
+package gocbcore
+
+import (
+	"encoding/binary"
+	"sync"
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+)
+
+// ReplicaReadResult encapsulates the result of reading a single copy of a
+// document from either the active server or one of its replicas.
+type ReplicaReadResult struct {
+	Value     []byte
+	Flags     uint32
+	Datatype  uint8
+	Cas       Cas
+	IsReplica bool
+	ServerIdx int
+
+	buffer *memdBuffer
+}
+
+// Release returns the buffer backing Value to the pool when pooled buffers are enabled,
+// otherwise it does nothing.  Value must not be used once the result is released.
+func (result *ReplicaReadResult) Release() {
+	result.buffer.release()
+	result.buffer = nil
+	result.Value = nil
+}
+
+// ReplicaReadMode specifies which copies of a document a replica read uses, based on
+// the server group configured with AgentConfig.PreferredServerGroup.
+type ReplicaReadMode int
+
+const (
+	// ReplicaReadModeAll reads from the active server and all of its replicas.
+	ReplicaReadModeAll = ReplicaReadMode(0)
+
+	// ReplicaReadModePreferServerGroup reads from the copies stored in the preferred
+	// server group, only reading the other copies if none of those could be read.
+	ReplicaReadModePreferServerGroup = ReplicaReadMode(1)
+
+	// ReplicaReadModeServerGroupOnly reads only from the copies stored in the preferred
+	// server group.
+	ReplicaReadModeServerGroupOnly = ReplicaReadMode(2)
+)
+
+// GetAnyReplicaOptions encapsulates the parameters for a GetAnyReplicaEx operation.
+type GetAnyReplicaOptions struct {
+	Key            []byte
+	CollectionName string
+	ScopeName      string
+	TraceContext   opentracing.SpanContext
+	Deadline       time.Time
+	RetryStrategy  RetryStrategy
+	Priority       RequestPriority
+	ReadMode       ReplicaReadMode
+}
+
+// GetAnyReplicaExCallback is invoked upon completion of a GetAnyReplicaEx operation.
+type GetAnyReplicaExCallback func(*ReplicaReadResult, error)
+
+// GetAllReplicasOptions encapsulates the parameters for a GetAllReplicasEx operation.
+type GetAllReplicasOptions struct {
+	Key            []byte
+	CollectionName string
+	ScopeName      string
+	TraceContext   opentracing.SpanContext
+	Deadline       time.Time
+	RetryStrategy  RetryStrategy
+	Priority       RequestPriority
+	ReadMode       ReplicaReadMode
+}
+
+// GetAllReplicasStreamCallback is invoked for each copy of a document which is
+// successfully read during a GetAllReplicasEx operation, as soon as it arrives.
+type GetAllReplicasStreamCallback func(*ReplicaReadResult)
+
+// GetAllReplicasExCallback is invoked once every copy of the document has either
+// been read or failed during a GetAllReplicasEx operation.  An error is only
+// returned if no copy of the document could be read.
+type GetAllReplicasExCallback func(error)
+
+type replicaReadOptions struct {
+	Key            []byte
+	CollectionName string
+	ScopeName      string
+	Deadline       time.Time
+	RetryStrategy  RetryStrategy
+	Priority       RequestPriority
+}
+
+// getCopy reads a single copy of a document, using a normal get for the active
+// server (replicaIdx 0) and a replica get otherwise.
+func (agent *Agent) getCopy(tracer *opTracer, opts replicaReadOptions, replicaIdx int, cb GetAnyReplicaExCallback) (PendingOp, error) {
+	opcode := cmdGetReplica
+	if replicaIdx == 0 {
+		opcode = cmdGet
+	}
+
+	handler := func(resp *memdQResponse, req *memdQRequest, err error) {
+		if err != nil {
+			cb(nil, err)
+			return
+		}
+
+		if len(resp.Extras) != 4 {
+			cb(nil, ErrProtocol)
+			return
+		}
+
+		cb(&ReplicaReadResult{
+			Value:     resp.Value,
+			Flags:     binary.BigEndian.Uint32(resp.Extras[0:]),
+			Datatype:  resp.Datatype,
+			Cas:       Cas(resp.Cas),
+			IsReplica: replicaIdx > 0,
+			ServerIdx: req.serverIdx,
+			buffer:    resp.takeBuffer(),
+		}, nil)
+	}
+
+	req := &memdQRequest{
+		memdPacket: memdPacket{
+			Magic:    reqMagic,
+			Opcode:   opcode,
+			Datatype: 0,
+			Cas:      0,
+			Extras:   nil,
+			Key:      opts.Key,
+			Value:    nil,
+		},
+		Callback:         handler,
+		ReplicaIdx:       replicaIdx,
+		RootTraceContext: tracer.RootContext(),
+		CollectionName:   opts.CollectionName,
+		ScopeName:        opts.ScopeName,
+		Deadline:         opts.Deadline,
+		RetryStrategy:    opts.RetryStrategy,
+		Priority:         opts.Priority,
+	}
+
+	return agent.dispatchOp(req)
+}
+
+// getAllCopies reads the copies of a document held by each of replicaIdxs, where
+// index 0 is the active server.  If firstOnly is set, the remaining reads are
+// cancelled as soon as one succeeds and only that result is passed to resultCb.
+// completeCb is invoked once all the reads are handled.
+func (agent *Agent) getAllCopies(tracer *opTracer, opts replicaReadOptions, replicaIdxs []int, firstOnly bool,
+	resultCb func(*ReplicaReadResult), completeCb func(error)) (PendingOp, error) {
+	var resultLock sync.Mutex
+	var numResults int
+	var activeErr error
+	var timedOut bool
+
+	op := new(multiPendingOp)
+	expected := uint32(len(replicaIdxs))
+
+	opHandledLocked := func() {
+		completed := op.IncrementCompletedOps()
+		if expected-completed != 0 {
+			return
+		}
+
+		if numResults > 0 {
+			completeCb(nil)
+		} else if activeErr != nil && activeErr != ErrTimeout {
+			// The active server is authoritative, so if it told us something
+			// like the document not existing we pass that on.
+			completeCb(activeErr)
+		} else if timedOut {
+			completeCb(ErrTimeout)
+		} else {
+			completeCb(ErrNoReplicas)
+		}
+	}
+
+	for _, repIdx := range replicaIdxs {
+		repIdx := repIdx
+
+		handler := func(res *ReplicaReadResult, err error) {
+			resultLock.Lock()
+			defer resultLock.Unlock()
+
+			if err != nil {
+				if err == ErrTimeout {
+					timedOut = true
+				}
+				if repIdx == 0 {
+					activeErr = err
+				}
+				opHandledLocked()
+				return
+			}
+
+			if firstOnly && numResults > 0 {
+				opHandledLocked()
+				return
+			}
+
+			numResults++
+			resultCb(res)
+			opHandledLocked()
+
+			if firstOnly {
+				// Try to cancel every other operation so we can return as soon
+				// as possible to the user (and close any open tracing spans)
+				for _, subOp := range op.ops {
+					if subOp.Cancel() {
+						opHandledLocked()
+					}
+				}
+			}
+		}
+
+		subOp, err := agent.getCopy(tracer, opts, repIdx, handler)
+
+		resultLock.Lock()
+
+		if err != nil {
+			if repIdx == 0 {
+				activeErr = err
+			}
+			opHandledLocked()
+			resultLock.Unlock()
+			continue
+		}
+
+		op.ops = append(op.ops, subOp)
+		resultLock.Unlock()
+	}
+
+	return op, nil
+}
+
+// replicaIdxsByServerGroup splits the copies of the document stored under key into
+// those held by servers in serverGroup and those held elsewhere.  Index 0 is the
+// active server.  Every copy is considered to be in the group if serverGroup is empty.
+func (agent *Agent) replicaIdxsByServerGroup(key []byte, serverGroup string) ([]int, []int) {
+	routingInfo := agent.routingInfo.Get()
+	if routingInfo == nil || routingInfo.vbMap == nil {
+		return []int{0}, nil
+	}
+
+	var groupList []string
+	if routingInfo.source != nil {
+		groupList = routingInfo.source.kvGroupList
+	}
+
+	var groupIdxs, otherIdxs []int
+	for repIdx := 0; repIdx <= routingInfo.vbMap.NumReplicas(); repIdx++ {
+		srvIdx, err := routingInfo.vbMap.NodeByKey(key, uint32(repIdx))
+		if serverGroup == "" || (err == nil && srvIdx >= 0 && srvIdx < len(groupList) && groupList[srvIdx] == serverGroup) {
+			groupIdxs = append(groupIdxs, repIdx)
+		} else {
+			otherIdxs = append(otherIdxs, repIdx)
+		}
+	}
+
+	return groupIdxs, otherIdxs
+}
+
+// readCopies reads the copies of a document selected by mode.  When the copies in the
+// preferred server group are read first, the remaining copies are only read if none
+// of those could be read, the deadline has not passed and the active server did not
+// report an authoritative error.
+func (agent *Agent) readCopies(tracer *opTracer, opts replicaReadOptions, mode ReplicaReadMode, firstOnly bool,
+	resultCb func(*ReplicaReadResult), completeCb func(error)) (PendingOp, error) {
+	var groupIdxs, otherIdxs []int
+	switch mode {
+	case ReplicaReadModePreferServerGroup:
+		groupIdxs, otherIdxs = agent.replicaIdxsByServerGroup(opts.Key, agent.preferredServerGroup)
+	case ReplicaReadModeServerGroupOnly:
+		if agent.preferredServerGroup == "" {
+			tracer.Finish()
+			return nil, ErrNoServerGroupReplicas
+		}
+		groupIdxs, _ = agent.replicaIdxsByServerGroup(opts.Key, agent.preferredServerGroup)
+	default:
+		groupIdxs, _ = agent.replicaIdxsByServerGroup(opts.Key, "")
+	}
+
+	if len(groupIdxs) == 0 {
+		if len(otherIdxs) == 0 {
+			tracer.Finish()
+			return nil, ErrNoServerGroupReplicas
+		}
+
+		groupIdxs, otherIdxs = otherIdxs, nil
+	}
+
+	if len(otherIdxs) == 0 {
+		return agent.getAllCopies(tracer, opts, groupIdxs, firstOnly, resultCb, func(err error) {
+			tracer.Finish()
+			completeCb(err)
+		})
+	}
+
+	op := new(sequencedPendingOp)
+
+	finish := func(err error) {
+		tracer.Finish()
+		if op.complete() {
+			completeCb(err)
+		}
+	}
+
+	subOp, err := agent.getAllCopies(tracer, opts, groupIdxs, firstOnly, resultCb, func(err error) {
+		if err != ErrNoReplicas {
+			finish(err)
+			return
+		}
+
+		logDebugf("No copies could be read from the preferred server group, reading other copies")
+		fallbackOp, err := agent.getAllCopies(tracer, opts, otherIdxs, firstOnly, resultCb, finish)
+		if err != nil {
+			finish(err)
+			return
+		}
+		op.addOp(fallbackOp)
+	})
+	if err != nil {
+		tracer.Finish()
+		return nil, err
+	}
+	op.addOp(subOp)
+
+	return op, nil
+}
+
+// GetAnyReplicaEx retrieves a document by racing the active server against
+// its replicas, returning whichever copy arrives first.  The copies which are
+// read are selected by the ReadMode of the options.
+func (agent *Agent) GetAnyReplicaEx(opts GetAnyReplicaOptions, cb GetAnyReplicaExCallback) (PendingOp, error) {
+	tracer := agent.createOpTrace("GetAnyReplicaEx", opts.TraceContext)
+
+	var firstResult *ReplicaReadResult
+	return agent.readCopies(tracer, replicaReadOptions{
+		Key:            opts.Key,
+		CollectionName: opts.CollectionName,
+		ScopeName:      opts.ScopeName,
+		Deadline:       opts.Deadline,
+		RetryStrategy:  opts.RetryStrategy,
+		Priority:       opts.Priority,
+	}, opts.ReadMode, true, func(res *ReplicaReadResult) {
+		firstResult = res
+	}, func(err error) {
+		if err != nil {
+			cb(nil, err)
+			return
+		}
+
+		cb(firstResult, nil)
+	})
+}
+
+// GetAllReplicasEx retrieves every copy of a document from the active server
+// and its replicas, as selected by the ReadMode of the options.  Each copy is
+// passed to streamCb as it arrives, and cb is invoked once all of the copies
+// have been handled.
+func (agent *Agent) GetAllReplicasEx(opts GetAllReplicasOptions, streamCb GetAllReplicasStreamCallback,
+	cb GetAllReplicasExCallback) (PendingOp, error) {
+	tracer := agent.createOpTrace("GetAllReplicasEx", opts.TraceContext)
+
+	return agent.readCopies(tracer, replicaReadOptions{
+		Key:            opts.Key,
+		CollectionName: opts.CollectionName,
+		ScopeName:      opts.ScopeName,
+		Deadline:       opts.Deadline,
+		RetryStrategy:  opts.RetryStrategy,
+		Priority:       opts.Priority,
+	}, opts.ReadMode, false, streamCb, cb)
+}
@@ -2,6 +2,7 @@ package gocbcore
 
 import (
 	"encoding/binary"
+	"time"
 
 	"github.com/opentracing/opentracing-go"
 )
@@ -20,6 +21,9 @@ type GetInOptions struct {
 	CollectionName string
 	ScopeName      string
 	TraceContext   opentracing.SpanContext
+	Deadline       time.Time
+	RetryStrategy  RetryStrategy
+	Priority       RequestPriority
 }
 
 // GetInResult encapsulates the result of a GetInEx operation.
@@ -69,6 +73,9 @@ func (agent *Agent) GetInEx(opts GetInOptions, cb GetInExCallback) (PendingOp, e
 		RootTraceContext: tracer.RootContext(),
 		CollectionName:   opts.CollectionName,
 		ScopeName:        opts.ScopeName,
+		Deadline:         opts.Deadline,
+		RetryStrategy:    opts.RetryStrategy,
+		Priority:         opts.Priority,
 	}
 
 	return agent.dispatchOp(req)
@@ -82,6 +89,9 @@ type ExistsInOptions struct {
 	CollectionName string
 	ScopeName      string
 	TraceContext   opentracing.SpanContext
+	Deadline       time.Time
+	RetryStrategy  RetryStrategy
+	Priority       RequestPriority
 }
 
 // ExistsInResult encapsulates the result of a ExistsInEx operation.
@@ -129,6 +139,9 @@ func (agent *Agent) ExistsInEx(opts ExistsInOptions, cb ExistsInExCallback) (Pen
 		RootTraceContext: tracer.RootContext(),
 		CollectionName:   opts.CollectionName,
 		ScopeName:        opts.ScopeName,
+		Deadline:         opts.Deadline,
+		RetryStrategy:    opts.RetryStrategy,
+		Priority:         opts.Priority,
 	}
 
 	return agent.dispatchOp(req)
@@ -146,8 +159,13 @@ type StoreInOptions struct {
 	CollectionName         string
 	ScopeName              string
 	TraceContext           opentracing.SpanContext
+	Deadline               time.Time
+	RetryStrategy          RetryStrategy
+	Priority               RequestPriority
 	DurabilityLevel        DurabilityLevel
 	DurabilityLevelTimeout uint16
+	PersistTo              uint
+	ReplicateTo            uint
 }
 
 // StoreInResult encapsulates the result of a SetInEx, AddInEx, ReplaceInEx,
@@ -165,6 +183,12 @@ type StoreInExCallback func(*StoreInResult, error)
 func (agent *Agent) storeInEx(opName string, opcode commandCode, opts StoreInOptions, cb StoreInExCallback) (PendingOp, error) {
 	tracer := agent.createOpTrace(opName, nil)
 
+	chain, err := newObserveChainOp(opts.PersistTo, opts.ReplicateTo, opts.DurabilityLevel)
+	if err != nil {
+		tracer.Finish()
+		return nil, err
+	}
+
 	handler := func(resp *memdQResponse, req *memdQRequest, err error) {
 		if err != nil {
 			tracer.Finish()
@@ -179,11 +203,25 @@ func (agent *Agent) storeInEx(opName string, opcode commandCode, opts StoreInOpt
 			mutToken.SeqNo = SeqNo(binary.BigEndian.Uint64(resp.Extras[8:]))
 		}
 
-		tracer.Finish()
-		cb(&StoreInResult{
+		res := &StoreInResult{
 			Cas:           Cas(resp.Cas),
 			MutationToken: mutToken,
-		}, nil)
+		}
+
+		chain.observeMutation(agent, tracer, DurabilityOptions{
+			Key:            opts.Key,
+			CollectionName: opts.CollectionName,
+			ScopeName:      opts.ScopeName,
+			MutationToken:  mutToken,
+			Cas:            res.Cas,
+			PersistTo:      opts.PersistTo,
+			ReplicateTo:    opts.ReplicateTo,
+			Deadline:       opts.Deadline,
+			RetryStrategy:  opts.RetryStrategy,
+			Priority:       opts.Priority,
+		}, func(err error) {
+			cb(res, err)
+		})
 	}
 
 	magic := reqMagic
@@ -228,8 +266,14 @@ func (agent *Agent) storeInEx(opName string, opcode commandCode, opts StoreInOpt
 		RootTraceContext: tracer.RootContext(),
 		CollectionName:   opts.CollectionName,
 		ScopeName:        opts.ScopeName,
+		Deadline:         opts.Deadline,
+		RetryStrategy:    opts.RetryStrategy,
+		Priority:         opts.Priority,
 	}
 
+	if chain != nil {
+		return chain.dispatched(agent.dispatchOp(req))
+	}
 	return agent.dispatchOp(req)
 }
 
@@ -289,6 +333,12 @@ type CounterInExCallback func(*CounterInResult, error)
 func (agent *Agent) CounterInEx(opts CounterInOptions, cb CounterInExCallback) (PendingOp, error) {
 	tracer := agent.createOpTrace("CounterInEx", nil)
 
+	chain, err := newObserveChainOp(opts.PersistTo, opts.ReplicateTo, opts.DurabilityLevel)
+	if err != nil {
+		tracer.Finish()
+		return nil, err
+	}
+
 	handler := func(resp *memdQResponse, req *memdQRequest, err error) {
 		if err != nil {
 			tracer.Finish()
@@ -303,12 +353,26 @@ func (agent *Agent) CounterInEx(opts CounterInOptions, cb CounterInExCallback) (
 			mutToken.SeqNo = SeqNo(binary.BigEndian.Uint64(resp.Extras[8:]))
 		}
 
-		tracer.Finish()
-		cb(&CounterInResult{
+		res := &CounterInResult{
 			Value:         resp.Value,
 			Cas:           Cas(resp.Cas),
 			MutationToken: mutToken,
-		}, nil)
+		}
+
+		chain.observeMutation(agent, tracer, DurabilityOptions{
+			Key:            opts.Key,
+			CollectionName: opts.CollectionName,
+			ScopeName:      opts.ScopeName,
+			MutationToken:  mutToken,
+			Cas:            res.Cas,
+			PersistTo:      opts.PersistTo,
+			ReplicateTo:    opts.ReplicateTo,
+			Deadline:       opts.Deadline,
+			RetryStrategy:  opts.RetryStrategy,
+			Priority:       opts.Priority,
+		}, func(err error) {
+			cb(res, err)
+		})
 	}
 
 	pathBytes := []byte(opts.Path)
@@ -353,8 +417,14 @@ func (agent *Agent) CounterInEx(opts CounterInOptions, cb CounterInExCallback) (
 		RootTraceContext: tracer.RootContext(),
 		CollectionName:   opts.CollectionName,
 		ScopeName:        opts.ScopeName,
+		Deadline:         opts.Deadline,
+		RetryStrategy:    opts.RetryStrategy,
+		Priority:         opts.Priority,
 	}
 
+	if chain != nil {
+		return chain.dispatched(agent.dispatchOp(req))
+	}
 	return agent.dispatchOp(req)
 }
 
@@ -369,7 +439,12 @@ type DeleteInOptions struct {
 	ScopeName              string
 	DurabilityLevel        DurabilityLevel
 	DurabilityLevelTimeout uint16
+	PersistTo              uint
+	ReplicateTo            uint
 	TraceContext           opentracing.SpanContext
+	Deadline               time.Time
+	RetryStrategy          RetryStrategy
+	Priority               RequestPriority
 }
 
 // DeleteInResult encapsulates the result of a DeleteInEx operation.
@@ -385,6 +460,12 @@ type DeleteInExCallback func(*DeleteInResult, error)
 func (agent *Agent) DeleteInEx(opts DeleteInOptions, cb DeleteInExCallback) (PendingOp, error) {
 	tracer := agent.createOpTrace("DeleteInEx", nil)
 
+	chain, err := newObserveChainOp(opts.PersistTo, opts.ReplicateTo, opts.DurabilityLevel)
+	if err != nil {
+		tracer.Finish()
+		return nil, err
+	}
+
 	handler := func(resp *memdQResponse, req *memdQRequest, err error) {
 		if err != nil {
 			tracer.Finish()
@@ -399,11 +480,25 @@ func (agent *Agent) DeleteInEx(opts DeleteInOptions, cb DeleteInExCallback) (Pen
 			mutToken.SeqNo = SeqNo(binary.BigEndian.Uint64(resp.Extras[8:]))
 		}
 
-		tracer.Finish()
-		cb(&DeleteInResult{
+		res := &DeleteInResult{
 			Cas:           Cas(resp.Cas),
 			MutationToken: mutToken,
-		}, nil)
+		}
+
+		chain.observeMutation(agent, tracer, DurabilityOptions{
+			Key:            opts.Key,
+			CollectionName: opts.CollectionName,
+			ScopeName:      opts.ScopeName,
+			MutationToken:  mutToken,
+			Cas:            res.Cas,
+			PersistTo:      opts.PersistTo,
+			ReplicateTo:    opts.ReplicateTo,
+			Deadline:       opts.Deadline,
+			RetryStrategy:  opts.RetryStrategy,
+			Priority:       opts.Priority,
+		}, func(err error) {
+			cb(res, err)
+		})
 	}
 
 	pathBytes := []byte(opts.Path)
@@ -444,11 +539,21 @@ func (agent *Agent) DeleteInEx(opts DeleteInOptions, cb DeleteInExCallback) (Pen
 		RootTraceContext: tracer.RootContext(),
 		CollectionName:   opts.CollectionName,
 		ScopeName:        opts.ScopeName,
+		Deadline:         opts.Deadline,
+		RetryStrategy:    opts.RetryStrategy,
+		Priority:         opts.Priority,
 	}
 
+	if chain != nil {
+		return chain.dispatched(agent.dispatchOp(req))
+	}
 	return agent.dispatchOp(req)
 }
 
+// maxSubDocOps is the maximum number of operations the server permits in a single
+// multi-lookup or multi-mutation request.
+const maxSubDocOps = 16
+
 // SubDocOp defines a per-operation structure to be passed to MutateIn
 // or LookupIn for performing many sub-document operations.
 type SubDocOp struct {
@@ -458,6 +563,20 @@ type SubDocOp struct {
 	Value []byte
 }
 
+func isLookupInOp(op SubDocOpType) bool {
+	return op == SubDocOpGet || op == SubDocOpExists ||
+		op == SubDocOpGetDoc || op == SubDocOpGetCount
+}
+
+func isMutateInOp(op SubDocOpType) bool {
+	return op == SubDocOpDictAdd || op == SubDocOpDictSet ||
+		op == SubDocOpDelete || op == SubDocOpReplace ||
+		op == SubDocOpArrayPushLast || op == SubDocOpArrayPushFirst ||
+		op == SubDocOpArrayInsert || op == SubDocOpArrayAddUnique ||
+		op == SubDocOpCounter || op == SubDocOpSetDoc ||
+		op == SubDocOpAddDoc || op == SubDocOpDeleteDoc
+}
+
 // LookupInOptions encapsulates the parameters for a LookupInEx operation.
 type LookupInOptions struct {
 	Key            []byte
@@ -466,6 +585,9 @@ type LookupInOptions struct {
 	CollectionName string
 	ScopeName      string
 	TraceContext   opentracing.SpanContext
+	Deadline       time.Time
+	RetryStrategy  RetryStrategy
+	Priority       RequestPriority
 }
 
 // LookupInResult encapsulates the result of a LookupInEx operation.
@@ -477,8 +599,18 @@ type LookupInResult struct {
 // LookupInExCallback is invoked upon completion of a LookupInEx operation.
 type LookupInExCallback func(*LookupInResult, error)
 
-// LookupInEx performs a multiple-lookup sub-document operation on a document.
+// LookupInEx performs a multiple-lookup sub-document operation on a document.  If more
+// operations are specified than the server supports in a single request, the lookup is
+// split into multiple requests and the results are merged in their original order.
 func (agent *Agent) LookupInEx(opts LookupInOptions, cb LookupInExCallback) (PendingOp, error) {
+	if len(opts.Ops) > maxSubDocOps {
+		return agent.lookupInSplit(opts, cb)
+	}
+
+	return agent.lookupInSingle(opts, cb)
+}
+
+func (agent *Agent) lookupInSingle(opts LookupInOptions, cb LookupInExCallback) (PendingOp, error) {
 	tracer := agent.createOpTrace("LookupInEx", opts.TraceContext)
 
 	results := make([]SubDocResult, len(opts.Ops))
@@ -534,8 +666,7 @@ func (agent *Agent) LookupInEx(opts LookupInOptions, cb LookupInExCallback) (Pen
 
 	valueIter := 0
 	for i, op := range opts.Ops {
-		if op.Op != SubDocOpGet && op.Op != SubDocOpExists &&
-			op.Op != SubDocOpGetDoc && op.Op != SubDocOpGetCount {
+		if !isLookupInOp(op.Op) {
 			return nil, ErrInvalidArgs
 		}
 		if op.Value != nil {
@@ -571,6 +702,9 @@ func (agent *Agent) LookupInEx(opts LookupInOptions, cb LookupInExCallback) (Pen
 		RootTraceContext: tracer.RootContext(),
 		CollectionName:   opts.CollectionName,
 		ScopeName:        opts.ScopeName,
+		Deadline:         opts.Deadline,
+		RetryStrategy:    opts.RetryStrategy,
+		Priority:         opts.Priority,
 	}
 
 	return agent.dispatchOp(req)
@@ -587,7 +721,18 @@ type MutateInOptions struct {
 	ScopeName              string
 	DurabilityLevel        DurabilityLevel
 	DurabilityLevelTimeout uint16
+	PersistTo              uint
+	ReplicateTo            uint
 	TraceContext           opentracing.SpanContext
+	Deadline               time.Time
+	RetryStrategy          RetryStrategy
+	Priority               RequestPriority
+
+	// AllowSplit permits the operations to be split across multiple requests when
+	// there are more than the server supports, which means that they are no longer
+	// applied atomically.  Each request is CAS-chained to the previous one, and a
+	// SubDocPartialMutateError is returned if a later request fails.
+	AllowSplit bool
 }
 
 // MutateInResult encapsulates the result of a MutateInEx operation.
@@ -600,10 +745,26 @@ type MutateInResult struct {
 // MutateInExCallback is invoked upon completion of a MutateInEx operation.
 type MutateInExCallback func(*MutateInResult, error)
 
-// MutateInEx performs a multiple-mutation sub-document operation on a document.
+// MutateInEx performs a multiple-mutation sub-document operation on a document.  If
+// AllowSplit is set and more operations are specified than the server supports in a
+// single request, the mutation is split into multiple CAS-chained requests.
 func (agent *Agent) MutateInEx(opts MutateInOptions, cb MutateInExCallback) (PendingOp, error) {
+	if opts.AllowSplit && len(opts.Ops) > maxSubDocOps {
+		return agent.mutateInSplit(opts, cb)
+	}
+
+	return agent.mutateInSingle(opts, cb)
+}
+
+func (agent *Agent) mutateInSingle(opts MutateInOptions, cb MutateInExCallback) (PendingOp, error) {
 	tracer := agent.createOpTrace("MutateInEx", opts.TraceContext)
 
+	chain, err := newObserveChainOp(opts.PersistTo, opts.ReplicateTo, opts.DurabilityLevel)
+	if err != nil {
+		tracer.Finish()
+		return nil, err
+	}
+
 	results := make([]SubDocResult, len(opts.Ops))
 
 	handler := func(resp *memdQResponse, req *memdQRequest, err error) {
@@ -654,12 +815,26 @@ func (agent *Agent) MutateInEx(opts MutateInOptions, cb MutateInExCallback) (Pen
 			mutToken.SeqNo = SeqNo(binary.BigEndian.Uint64(resp.Extras[8:]))
 		}
 
-		tracer.Finish()
-		cb(&MutateInResult{
+		res := &MutateInResult{
 			Cas:           Cas(resp.Cas),
 			MutationToken: mutToken,
 			Ops:           results,
-		}, nil)
+		}
+
+		chain.observeMutation(agent, tracer, DurabilityOptions{
+			Key:            opts.Key,
+			CollectionName: opts.CollectionName,
+			ScopeName:      opts.ScopeName,
+			MutationToken:  mutToken,
+			Cas:            res.Cas,
+			PersistTo:      opts.PersistTo,
+			ReplicateTo:    opts.ReplicateTo,
+			Deadline:       opts.Deadline,
+			RetryStrategy:  opts.RetryStrategy,
+			Priority:       opts.Priority,
+		}, func(err error) {
+			cb(res, err)
+		})
 	}
 
 	magic := reqMagic
@@ -688,12 +863,7 @@ func (agent *Agent) MutateInEx(opts MutateInOptions, cb MutateInExCallback) (Pen
 
 	valueIter := 0
 	for i, op := range opts.Ops {
-		if op.Op != SubDocOpDictAdd && op.Op != SubDocOpDictSet &&
-			op.Op != SubDocOpDelete && op.Op != SubDocOpReplace &&
-			op.Op != SubDocOpArrayPushLast && op.Op != SubDocOpArrayPushFirst &&
-			op.Op != SubDocOpArrayInsert && op.Op != SubDocOpArrayAddUnique &&
-			op.Op != SubDocOpCounter && op.Op != SubDocOpSetDoc &&
-			op.Op != SubDocOpAddDoc && op.Op != SubDocOpDeleteDoc {
+		if !isMutateInOp(op.Op) {
 			return nil, ErrInvalidArgs
 		}
 
@@ -735,7 +905,13 @@ func (agent *Agent) MutateInEx(opts MutateInOptions, cb MutateInExCallback) (Pen
 		RootTraceContext: tracer.RootContext(),
 		CollectionName:   opts.CollectionName,
 		ScopeName:        opts.ScopeName,
+		Deadline:         opts.Deadline,
+		RetryStrategy:    opts.RetryStrategy,
+		Priority:         opts.Priority,
 	}
 
+	if chain != nil {
+		return chain.dispatched(agent.dispatchOp(req))
+	}
 	return agent.dispatchOp(req)
 }
@@ -0,0 +1,300 @@
+package gocbcore
+
+import (
+	"sync"
+)
+
+// sequencedPendingOp is the PendingOp for an operation which is made up of a
+// sequence of requests, such as a split sub-document operation.
+type sequencedPendingOp struct {
+	lock          sync.Mutex
+	ops           []PendingOp
+	completed     bool
+	uncancellable bool
+}
+
+func (op *sequencedPendingOp) Cancel() bool {
+	op.lock.Lock()
+	if op.completed || op.uncancellable {
+		op.lock.Unlock()
+		return false
+	}
+	op.completed = true
+	ops := op.ops
+	op.ops = nil
+	op.lock.Unlock()
+
+	for _, subOp := range ops {
+		subOp.Cancel()
+	}
+
+	return true
+}
+
+// addOp records a dispatched request against the operation.
+func (op *sequencedPendingOp) addOp(subOp PendingOp) {
+	op.lock.Lock()
+	if op.completed {
+		op.lock.Unlock()
+		subOp.Cancel()
+		return
+	}
+	op.ops = append(op.ops, subOp)
+	op.lock.Unlock()
+}
+
+// complete marks the operation as completed, returning true if the caller is
+// responsible for invoking the callback.  Any outstanding requests are cancelled.
+func (op *sequencedPendingOp) complete() bool {
+	op.lock.Lock()
+	if op.completed {
+		op.lock.Unlock()
+		return false
+	}
+	op.completed = true
+	ops := op.ops
+	op.ops = nil
+	op.lock.Unlock()
+
+	for _, subOp := range ops {
+		subOp.Cancel()
+	}
+
+	return true
+}
+
+// markApplied prevents the operation from being cancelled once some of its
+// mutations have been applied, returning false if it was already completed.
+func (op *sequencedPendingOp) markApplied() bool {
+	op.lock.Lock()
+	defer op.lock.Unlock()
+
+	op.uncancellable = true
+	return !op.completed
+}
+
+func subDocChunkEnd(start, numOps int) int {
+	end := start + maxSubDocOps
+	if end > numOps {
+		end = numOps
+	}
+	return end
+}
+
+// subDocChunkFlags returns the document flags for the request which starts at start.
+// The document is known to exist after the first request, so later requests must
+// neither create nor replace it.
+func subDocChunkFlags(flags SubdocDocFlag, start int) SubdocDocFlag {
+	if start > 0 {
+		flags &^= SubdocDocFlagMkDoc | SubdocDocFlagReplaceDoc | SubdocDocFlagCreateAsDeleted
+	}
+	return flags
+}
+
+func (agent *Agent) lookupInSplit(opts LookupInOptions, cb LookupInExCallback) (PendingOp, error) {
+	for _, op := range opts.Ops {
+		if !isLookupInOp(op.Op) || op.Value != nil {
+			return nil, ErrInvalidArgs
+		}
+	}
+
+	tracer := agent.createOpTrace("LookupInEx", opts.TraceContext)
+
+	op := &sequencedPendingOp{}
+	results := make([]SubDocResult, len(opts.Ops))
+	numChunks := (len(opts.Ops) + maxSubDocOps - 1) / maxSubDocOps
+	chunkErrs := make([]error, numChunks)
+
+	var resultLock sync.Mutex
+	remaining := numChunks
+	var cas Cas
+	casMismatch := false
+
+	for chunkIdx := 0; chunkIdx < numChunks; chunkIdx++ {
+		chunkIdx := chunkIdx
+		start := chunkIdx * maxSubDocOps
+		end := subDocChunkEnd(start, len(opts.Ops))
+
+		subOp, err := agent.lookupInSingle(LookupInOptions{
+			Key:            opts.Key,
+			Flags:          opts.Flags,
+			Ops:            opts.Ops[start:end],
+			CollectionName: opts.CollectionName,
+			ScopeName:      opts.ScopeName,
+			TraceContext:   tracer.RootContext(),
+			Deadline:       opts.Deadline,
+			RetryStrategy:  opts.RetryStrategy,
+			Priority:       opts.Priority,
+		}, func(res *LookupInResult, err error) {
+			if res == nil {
+				if op.complete() {
+					tracer.Finish()
+					cb(nil, err)
+				}
+				return
+			}
+
+			resultLock.Lock()
+			copy(results[start:end], res.Ops)
+			chunkErrs[chunkIdx] = err
+			if remaining == numChunks {
+				cas = res.Cas
+			} else if res.Cas != cas {
+				casMismatch = true
+			}
+			remaining--
+			done := remaining == 0
+			resultLock.Unlock()
+
+			if !done || !op.complete() {
+				return
+			}
+
+			tracer.Finish()
+			if casMismatch {
+				cb(nil, ErrSubDocSplitCasMismatch)
+				return
+			}
+
+			var firstErr error
+			for _, chunkErr := range chunkErrs {
+				if chunkErr != nil {
+					firstErr = chunkErr
+					break
+				}
+			}
+
+			cb(&LookupInResult{
+				Cas: cas,
+				Ops: results,
+			}, firstErr)
+		})
+		if err != nil {
+			if !op.Cancel() {
+				// One of the requests already failed and invoked the callback.
+				return op, nil
+			}
+			tracer.Finish()
+			return nil, err
+		}
+
+		op.addOp(subOp)
+	}
+
+	return op, nil
+}
+
+func (agent *Agent) mutateInSplit(opts MutateInOptions, cb MutateInExCallback) (PendingOp, error) {
+	for _, op := range opts.Ops {
+		if !isMutateInOp(op.Op) {
+			return nil, ErrInvalidArgs
+		}
+	}
+
+	if _, err := newObserveChainOp(opts.PersistTo, opts.ReplicateTo, opts.DurabilityLevel); err != nil {
+		return nil, err
+	}
+
+	tracer := agent.createOpTrace("MutateInEx", opts.TraceContext)
+
+	op := &sequencedPendingOp{}
+	results := make([]SubDocResult, len(opts.Ops))
+
+	var dispatchChunk func(start int, cas Cas, token MutationToken) error
+	dispatchChunk = func(start int, cas Cas, token MutationToken) error {
+		end := subDocChunkEnd(start, len(opts.Ops))
+		isLast := end == len(opts.Ops)
+
+		chunkOpts := MutateInOptions{
+			Key:            opts.Key,
+			Flags:          subDocChunkFlags(opts.Flags, start),
+			Cas:            cas,
+			Expiry:         opts.Expiry,
+			Ops:            opts.Ops[start:end],
+			CollectionName: opts.CollectionName,
+			ScopeName:      opts.ScopeName,
+			TraceContext:   tracer.RootContext(),
+			Deadline:       opts.Deadline,
+			RetryStrategy:  opts.RetryStrategy,
+			Priority:       opts.Priority,
+		}
+		if isLast {
+			// Waiting for durability of the final mutation also covers the
+			// earlier ones, as they were applied to the same vbucket first.
+			chunkOpts.DurabilityLevel = opts.DurabilityLevel
+			chunkOpts.DurabilityLevelTimeout = opts.DurabilityLevelTimeout
+			chunkOpts.PersistTo = opts.PersistTo
+			chunkOpts.ReplicateTo = opts.ReplicateTo
+		}
+
+		subOp, err := agent.mutateInSingle(chunkOpts, func(res *MutateInResult, err error) {
+			// A result accompanies the error if the final mutation was applied but
+			// did not meet its durability requirements.
+			if err != nil && res == nil {
+				if mutErr, ok := err.(SubDocMutateError); ok {
+					mutErr.OpIndex += start
+					err = mutErr
+				}
+				if start > 0 {
+					err = SubDocPartialMutateError{
+						Err:           err,
+						AppliedOps:    start,
+						Cas:           cas,
+						MutationToken: token,
+					}
+				}
+
+				if op.complete() {
+					tracer.Finish()
+					cb(nil, err)
+				}
+				return
+			}
+
+			copy(results[start:end], res.Ops)
+
+			if isLast {
+				if op.complete() {
+					tracer.Finish()
+					cb(&MutateInResult{
+						Cas:           res.Cas,
+						MutationToken: res.MutationToken,
+						Ops:           results,
+					}, err)
+				}
+				return
+			}
+
+			if !op.markApplied() {
+				return
+			}
+
+			err = dispatchChunk(end, res.Cas, res.MutationToken)
+			if err != nil {
+				if op.complete() {
+					tracer.Finish()
+					cb(nil, SubDocPartialMutateError{
+						Err:           err,
+						AppliedOps:    end,
+						Cas:           res.Cas,
+						MutationToken: res.MutationToken,
+					})
+				}
+			}
+		})
+		if err != nil {
+			return err
+		}
+
+		op.addOp(subOp)
+		return nil
+	}
+
+	err := dispatchChunk(0, opts.Cas, MutationToken{})
+	if err != nil {
+		tracer.Finish()
+		return nil, err
+	}
+
+	return op, nil
+}
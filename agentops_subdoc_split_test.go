@@ -0,0 +1,62 @@
+package gocbcore
+
+import (
+	"testing"
+)
+
+func TestSequencedPendingOpCancel(t *testing.T) {
+	op := &sequencedPendingOp{}
+	subOp := &testPendingOp{}
+	op.addOp(subOp)
+
+	if !op.Cancel() {
+		t.Fatalf("Expected cancellation to succeed")
+	}
+	if !subOp.cancelled {
+		t.Fatalf("Expected outstanding requests to be cancelled")
+	}
+	if op.complete() {
+		t.Fatalf("A cancelled operation should not be completable")
+	}
+
+	lateOp := &testPendingOp{}
+	op.addOp(lateOp)
+	if !lateOp.cancelled {
+		t.Fatalf("Expected requests added after cancellation to be cancelled")
+	}
+}
+
+func TestSequencedPendingOpApplied(t *testing.T) {
+	op := &sequencedPendingOp{}
+
+	if !op.markApplied() {
+		t.Fatalf("Expected operation to still be in progress")
+	}
+	if op.Cancel() {
+		t.Fatalf("An operation should not be cancellable once mutations are applied")
+	}
+	if !op.complete() {
+		t.Fatalf("Expected operation to be completable")
+	}
+}
+
+func TestSubDocChunkEnd(t *testing.T) {
+	if end := subDocChunkEnd(0, 40); end != maxSubDocOps {
+		t.Fatalf("Unexpected chunk end %d", end)
+	}
+	if end := subDocChunkEnd(32, 40); end != 40 {
+		t.Fatalf("Unexpected chunk end %d", end)
+	}
+}
+
+func TestSubDocChunkFlags(t *testing.T) {
+	if flags := subDocChunkFlags(SubdocDocFlagAddDoc, 0); flags != SubdocDocFlagAddDoc {
+		t.Fatalf("Expected the first request to keep its flags, got %d", flags)
+	}
+	if flags := subDocChunkFlags(SubdocDocFlagAddDoc, maxSubDocOps); flags != SubdocDocFlagNone {
+		t.Fatalf("Expected later requests not to add the document, got %d", flags)
+	}
+	if flags := subDocChunkFlags(SubdocDocFlagMkDoc|SubdocDocFlagAccessDeleted, maxSubDocOps); flags != SubdocDocFlagAccessDeleted {
+		t.Fatalf("Expected later requests to only drop the creation flags, got %d", flags)
+	}
+}
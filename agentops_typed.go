@@ -0,0 +1,121 @@
+package gocbcore
+
+import (
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+)
+
+// GetTypedOptions encapsulates the parameters for a GetTypedEx operation.
+type GetTypedOptions struct {
+	Key            []byte
+	CollectionName string
+	ScopeName      string
+	TraceContext   opentracing.SpanContext
+	Deadline       time.Time
+	RetryStrategy  RetryStrategy
+	Priority       RequestPriority
+
+	// Transcoder is used to decode the document, if nil the agent transcoder is used.
+	Transcoder Transcoder
+
+	// ValuePtr is a pointer to the value that the document will be decoded into.
+	ValuePtr interface{}
+}
+
+// GetTypedResult encapsulates the result of a GetTypedEx operation.  The document
+// value itself is decoded into the ValuePtr specified in the options.
+type GetTypedResult struct {
+	Flags    uint32
+	Datatype uint8
+	Cas      Cas
+}
+
+// GetTypedExCallback is invoked upon completion of a GetTypedEx operation.
+type GetTypedExCallback func(*GetTypedResult, error)
+
+// GetTypedEx retrieves a document and decodes it using a Transcoder.
+func (agent *Agent) GetTypedEx(opts GetTypedOptions, cb GetTypedExCallback) (PendingOp, error) {
+	transcoder := opts.Transcoder
+	if transcoder == nil {
+		transcoder = agent.transcoder
+	}
+
+	return agent.GetEx(GetOptions{
+		Key:            opts.Key,
+		CollectionName: opts.CollectionName,
+		ScopeName:      opts.ScopeName,
+		TraceContext:   opts.TraceContext,
+		Deadline:       opts.Deadline,
+		RetryStrategy:  opts.RetryStrategy,
+		Priority:       opts.Priority,
+	}, func(res *GetResult, err error) {
+		if err != nil {
+			cb(nil, err)
+			return
+		}
+
+		err = transcoder.Decode(res.Value, res.Flags, res.Datatype, opts.ValuePtr)
+		if err != nil {
+			cb(nil, err)
+			return
+		}
+
+		cb(&GetTypedResult{
+			Flags:    res.Flags,
+			Datatype: res.Datatype,
+			Cas:      res.Cas,
+		}, nil)
+	})
+}
+
+// SetTypedOptions encapsulates the parameters for a SetTypedEx operation.
+type SetTypedOptions struct {
+	Key                    []byte
+	CollectionName         string
+	ScopeName              string
+	Value                  interface{}
+	Expiry                 uint32
+	TraceContext           opentracing.SpanContext
+	Deadline               time.Time
+	RetryStrategy          RetryStrategy
+	Priority               RequestPriority
+	DurabilityLevel        DurabilityLevel
+	DurabilityLevelTimeout uint16
+	PersistTo              uint
+	ReplicateTo            uint
+
+	// Transcoder is used to encode the value, if nil the agent transcoder is used.
+	Transcoder Transcoder
+}
+
+// SetTypedEx encodes a value using a Transcoder and stores it as a document.
+func (agent *Agent) SetTypedEx(opts SetTypedOptions, cb StoreExCallback) (PendingOp, error) {
+	transcoder := opts.Transcoder
+	if transcoder == nil {
+		transcoder = agent.transcoder
+	}
+
+	bytes, flags, datatype, err := transcoder.Encode(opts.Value)
+	if err != nil {
+		return nil, err
+	}
+
+	return agent.SetEx(SetOptions{
+		Key:                    opts.Key,
+		CollectionName:         opts.CollectionName,
+		ScopeName:              opts.ScopeName,
+		Value:                  bytes,
+		Flags:                  flags,
+		Datatype:               datatype,
+		Expiry:                 opts.Expiry,
+		TraceContext:           opts.TraceContext,
+		Deadline:               opts.Deadline,
+		RetryStrategy:          opts.RetryStrategy,
+		Priority:               opts.Priority,
+		DurabilityLevel:        opts.DurabilityLevel,
+		DurabilityLevelTimeout: opts.DurabilityLevelTimeout,
+		PersistTo:              opts.PersistTo,
+		ReplicateTo:            opts.ReplicateTo,
+	}, cb)
+}
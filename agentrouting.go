@@ -34,16 +34,19 @@ func (agent *Agent) dialMemdClient(address string) (*memdClient, error) {
 
 	deadline := time.Now().Add(agent.serverConnectTimeout)
 
-	memdConn, err := dialMemdConn(address, tlsConfig, deadline)
+	memdConn, err := dialMemdConn(address, tlsConfig, deadline, agent.closeNotify)
 	if err != nil {
 		logDebugf("Failed to connect. %v", err)
 		return nil, err
 	}
 
+	memdConn.EnablePooledBuffers(agent.usePooledBuffers)
+
 	client := newMemdClient(agent, memdConn)
 
 	sclient := syncClient{
-		client: client,
+		client:      client,
+		closeNotify: agent.closeNotify,
 	}
 
 	logDebugf("Fetching cluster client data")
@@ -81,6 +84,13 @@ func (agent *Agent) dialMemdClient(address string) (*memdClient, error) {
 		features = append(features, FeatureCollections)
 	}
 
+	// Cluster map notifications are sent by the server as server-initiated
+	// requests, which are only permitted in duplex mode.
+	if agent.useClusterMapNotifs {
+		features = append(features, FeatureDuplex)
+		features = append(features, FeatureClusterMapNotif)
+	}
+
 	// These flags are informational so don't actually enable anything
 	// but the enhanced durability flag tells us if the server supports
 	// the feature
@@ -222,29 +232,42 @@ func (agent *Agent) applyConfig(cfg *routeConfig) {
 	defer agent.configLock.Unlock()
 
 	newRouting := &routeData{
-		revId:      cfg.revId,
-		uuid:       cfg.uuid,
-		capiEpList: cfg.capiEpList,
-		mgmtEpList: cfg.mgmtEpList,
-		n1qlEpList: cfg.n1qlEpList,
-		ftsEpList:  cfg.ftsEpList,
-		cbasEpList: cfg.cbasEpList,
-		vbMap:      cfg.vbMap,
-		ketamaMap:  cfg.ketamaMap,
-		bktType:    cfg.bktType,
-		source:     cfg,
+		revId:        cfg.revId,
+		uuid:         cfg.uuid,
+		capiEpList:   cfg.capiEpList,
+		mgmtEpList:   cfg.mgmtEpList,
+		n1qlEpList:   cfg.n1qlEpList,
+		ftsEpList:    cfg.ftsEpList,
+		cbasEpList:   cfg.cbasEpList,
+		vbMap:        cfg.vbMap,
+		vbMapForward: cfg.vbMapForward,
+		ketamaMap:    cfg.ketamaMap,
+		bktType:      cfg.bktType,
+		source:       cfg,
 	}
 
 	kvPoolSize := agent.kvPoolSize
 	maxQueueSize := agent.maxQueueSize
 	newRouting.clientMux = newMemdClientMux(cfg.kvServerList, kvPoolSize, maxQueueSize, agent.slowDialMemdClient)
+	for _, pipeline := range newRouting.clientMux.pipelines {
+		pipeline.breaker = agent.kvCircuitBreaker(pipeline.address)
+	}
 
 	oldRouting := agent.routingInfo.Get()
 	if oldRouting == nil {
 		return
 	}
 
-	if newRouting.revId == 0 {
+	if cfg.forceApply || (oldRouting.source != nil && oldRouting.source.fromCache && !cfg.fromCache) {
+		// A cached config may be stale or belong to an earlier incarnation of the bucket,
+		//  so the first config received from the cluster always replaces it.  The same
+		//  applies to the current config once contact with the cluster has been lost.
+		if newRouting.uuid != oldRouting.uuid {
+			logDebugf("Replacing cached configuration for a different bucket UUID")
+		} else {
+			logDebugf("Replacing cached configuration")
+		}
+	} else if newRouting.revId == 0 {
 		logDebugf("Unversioned configuration data, ")
 	} else if newRouting.revId == oldRouting.revId {
 		logDebugf("Ignoring configuration with identical revision number")
@@ -263,6 +286,11 @@ func (agent *Agent) applyConfig(cfg *routeConfig) {
 	logDebugf("Switching routing data (update)...")
 	logDebugf("New Routing Data:\n%s", newRouting.DebugString())
 
+	// Building the event walks every vbucket, so skip it when nobody is listening.
+	if agent.hasTopologyListeners() {
+		agent.queueTopologyEvent(newTopologyEvent(oldRouting.source, cfg))
+	}
+
 	if oldRouting.clientMux == nil {
 		// This is a new agent so there is no existing muxer.  We can
 		// simply start the new muxer.
@@ -298,6 +326,8 @@ func (agent *Agent) updateConfig(bk *cfgBucket) {
 
 		agent.applyConfig(oldRouting.source)
 	} else {
+		agent.markConfigReceived()
+
 		// Normalize the cfgBucket to a routeConfig and apply it.
 		routeCfg := buildRouteConfig(bk, agent.IsSecure(), agent.networkType, false)
 		if !routeCfg.IsValid() {
@@ -311,7 +341,28 @@ func (agent *Agent) updateConfig(bk *cfgBucket) {
 		}
 
 		agent.applyConfig(routeCfg)
+
+		// Configs may arrive on a client's read loop, so the cache is written separately.
+		if agent.configCache != nil {
+			go agent.storeCachedConfig(bk)
+		}
+	}
+}
+
+// selectVbucketMap picks the vbucket map to route a request with.  The fast-forward map
+// is used while a rebalance is in progress if the agent is configured to prefer it, or
+// once the request has been rejected by the owner in the current map.
+func (agent *Agent) selectVbucketMap(routingInfo *routeData, req *memdQRequest) (*vbucketMap, VbucketMapType) {
+	// Persistent requests, such as DCP streams, must go to the current owner.
+	if routingInfo.vbMapForward == nil || req.Persistent {
+		return routingInfo.vbMap, VbucketMapCurrent
+	}
+
+	if req.shouldUseForwardMap(agent.useForwardVbMap) {
+		return routingInfo.vbMapForward, VbucketMapForward
 	}
+
+	return routingInfo.vbMap, VbucketMapCurrent
 }
 
 func (agent *Agent) routeRequest(req *memdQRequest) (*memdPipeline, error) {
@@ -334,10 +385,12 @@ func (agent *Agent) routeRequest(req *memdQRequest) (*memdPipeline, error) {
 				req.Vbucket = routingInfo.vbMap.VbucketByKey(req.Key)
 			}
 
-			srvIdx, err = routingInfo.vbMap.NodeByVbucket(req.Vbucket, uint32(repId))
+			vbMap, mapType := agent.selectVbucketMap(routingInfo, req)
+			srvIdx, err = vbMap.NodeByVbucket(req.Vbucket, uint32(repId))
 			if err != nil {
 				return nil, err
 			}
+			req.setVbucketMap(mapType)
 		} else if routingInfo.bktType == bktTypeMemcached {
 			if repId > 0 {
 				// Error. Memcached buckets don't understand replicas!
@@ -353,9 +406,13 @@ func (agent *Agent) routeRequest(req *memdQRequest) (*memdPipeline, error) {
 			if err != nil {
 				return nil, err
 			}
+		} else if routingInfo.bktType == bktTypeNone {
+			// Requests can only be routed to specific servers without a bucket.
+			return nil, ErrNoBucket
 		}
 	}
 
+	req.serverIdx = srvIdx
 	return routingInfo.clientMux.GetPipeline(srvIdx), nil
 }
 
@@ -383,6 +440,71 @@ func (agent *Agent) dispatchDirect(req *memdQRequest) error {
 	return nil
 }
 
+// dispatchDirectMulti routes a set of requests and sends each group destined for
+// the same pipeline as a single batch.  It returns the dispatch error (if any)
+// for each of the requests.
+func (agent *Agent) dispatchDirectMulti(reqs []*memdQRequest) []error {
+	errs := make([]error, len(reqs))
+
+	pending := make([]int, len(reqs))
+	for i, req := range reqs {
+		agent.startCmdTrace(req)
+		pending[i] = i
+	}
+
+	for len(pending) > 0 {
+		var pipelines []*memdPipeline
+		groups := make(map[*memdPipeline][]int)
+		for _, reqIdx := range pending {
+			pipeline, err := agent.routeRequest(reqs[reqIdx])
+			if err != nil {
+				errs[reqIdx] = err
+				continue
+			}
+
+			if _, ok := groups[pipeline]; !ok {
+				pipelines = append(pipelines, pipeline)
+			}
+			groups[pipeline] = append(groups[pipeline], reqIdx)
+		}
+
+		var retryIdxs []int
+		for _, pipeline := range pipelines {
+			reqIdxs := groups[pipeline]
+
+			batch := make([]*memdQRequest, len(reqIdxs))
+			for i, reqIdx := range reqIdxs {
+				batch[i] = reqs[reqIdx]
+			}
+
+			numHandled, err := pipeline.SendRequests(batch)
+			if err == nil {
+				continue
+			}
+
+			for _, reqIdx := range reqIdxs[numHandled:] {
+				if err == errPipelineClosed {
+					// The pipeline was swapped out underneath us, so we need to
+					// route these requests again against the new config.
+					retryIdxs = append(retryIdxs, reqIdx)
+				} else if err == errPipelineFull {
+					errs[reqIdx] = ErrOverload
+				} else {
+					errs[reqIdx] = err
+				}
+			}
+		}
+
+		pending = retryIdxs
+	}
+
+	return errs
+}
+
+// This is the replica index assigned to requests which are dispatched to a specific
+// server address rather than being routed by the vbucket map.
+const addressRoutedReplicaIdx = -999999999
+
 func (agent *Agent) dispatchDirectToAddress(req *memdQRequest, address string) error {
 	agent.startCmdTrace(req)
 
@@ -391,7 +513,7 @@ func (agent *Agent) dispatchDirectToAddress(req *memdQRequest, address string) e
 	if req.ReplicaIdx != 0 {
 		return ErrInvalidReplica
 	}
-	req.ReplicaIdx = -999999999
+	req.ReplicaIdx = addressRoutedReplicaIdx
 
 	for {
 		routingInfo := agent.routingInfo.Get()
@@ -426,7 +548,58 @@ func (agent *Agent) dispatchDirectToAddress(req *memdQRequest, address string) e
 	return nil
 }
 
+// kvCircuitBreaker returns the circuit breaker of the pipeline for a server, or nil if
+// circuit breakers are disabled.
+func (agent *Agent) kvCircuitBreaker(address string) *circuitBreaker {
+	return agent.circuitBreakers.Get(MemdService, address, func(done func(error)) {
+		agent.sendKvCanary(address, done)
+	})
+}
+
+// sendKvCanary sends a NOOP to a server whose circuit breaker is half-open.  The canary
+// bypasses the breaker, which would otherwise reject it.
+func (agent *Agent) sendKvCanary(address string, done func(error)) {
+	req := &memdQRequest{
+		memdPacket: memdPacket{
+			Magic:  reqMagic,
+			Opcode: cmdNoop,
+		},
+		Callback: func(resp *memdQResponse, req *memdQRequest, err error) {
+			done(err)
+		},
+		ReplicaIdx:    addressRoutedReplicaIdx,
+		RetryStrategy: NewFailFastRetryStrategy(),
+		Priority:      RequestPriorityCritical,
+		Deadline:      time.Now().Add(agent.circuitBreakers.config.CanaryTimeout),
+		owner:         agent,
+	}
+
+	var foundPipeline *memdPipeline
+	if routingInfo := agent.routingInfo.Get(); routingInfo != nil {
+		for _, pipeline := range routingInfo.clientMux.pipelines {
+			if pipeline.Address() == address {
+				foundPipeline = pipeline
+				break
+			}
+		}
+	}
+	if foundPipeline == nil {
+		done(ErrInvalidServer)
+		return
+	}
+
+	req.startDeadlineTimer()
+	if err := foundPipeline.RequeueRequest(req); err != nil {
+		req.tryCallback(nil, err)
+	}
+}
+
 func (agent *Agent) requeueDirect(req *memdQRequest) {
+	if req.isCancelled() {
+		// The request was cancelled or timed out while waiting to be retried.
+		return
+	}
+
 	agent.startCmdTrace(req)
 
 	handleError := func(err error) {
@@ -0,0 +1,71 @@
+package gocbcore
+
+import (
+	"sync"
+)
+
+const (
+	// The smallest and largest buffers held by the buffer pool are 2^9 and 2^20 bytes,
+	//  larger buffers are allocated normally and left to the garbage collector.
+	memdBufferMinClassShift = 9
+	memdBufferMaxClassShift = 20
+)
+
+var globalMemdBufferPools [memdBufferMaxClassShift - memdBufferMinClassShift + 1]sync.Pool
+
+// memdBuffer is a buffer from the global pool which backs a response packet when pooled
+// buffers are enabled.  A response may be backed by several buffers, for example once
+// its value has been decompressed, which are chained together through next.
+type memdBuffer struct {
+	data  []byte
+	class int
+	next  *memdBuffer
+}
+
+// acquireMemdBuffer returns a buffer whose data holds at least size bytes.
+func acquireMemdBuffer(size int) *memdBuffer {
+	class := 0
+	for (1 << uint(memdBufferMinClassShift+class)) < size {
+		class++
+	}
+
+	if class >= len(globalMemdBufferPools) {
+		return &memdBuffer{
+			data:  make([]byte, size),
+			class: -1,
+		}
+	}
+
+	buf, isBuf := globalMemdBufferPools[class].Get().(*memdBuffer)
+	if buf == nil || !isBuf {
+		return &memdBuffer{
+			data:  make([]byte, 1<<uint(memdBufferMinClassShift+class)),
+			class: class,
+		}
+	}
+
+	return buf
+}
+
+// release returns the buffer, and any buffers chained to it, to the pool.  Nothing may
+// reference the buffers once they have been released.
+func (buf *memdBuffer) release() {
+	for buf != nil {
+		next := buf.next
+		buf.next = nil
+		if buf.class >= 0 {
+			globalMemdBufferPools[buf.class].Put(buf)
+		}
+		buf = next
+	}
+}
+
+// isPooledResponseOp returns whether successful responses to an operation are read into
+// pooled buffers.  These are the operations whose results can be released.
+func isPooledResponseOp(command commandCode) bool {
+	switch command {
+	case cmdGet, cmdGetReplica, cmdGAT, cmdGetLocked:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,159 @@
+package gocbcore
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/golang/snappy"
+)
+
+func TestAcquireMemdBuffer(t *testing.T) {
+	buf := acquireMemdBuffer(1)
+	if len(buf.data) != 512 || buf.class != 0 {
+		t.Fatalf("Expected the smallest class for a tiny buffer, got %d bytes", len(buf.data))
+	}
+	buf.release()
+
+	buf = acquireMemdBuffer(5000)
+	if len(buf.data) != 8192 {
+		t.Fatalf("Expected the buffer to be rounded up to 8192 bytes, got %d", len(buf.data))
+	}
+	buf.release()
+
+	buf = acquireMemdBuffer(2 << 20)
+	if len(buf.data) != 2<<20 || buf.class != -1 {
+		t.Fatalf("Expected a large buffer to be allocated outside of the pool")
+	}
+	buf.release()
+
+	// Releasing the buffers of a result which was never pooled does nothing.
+	res := &GetResult{Value: []byte("value")}
+	res.Release()
+	if res.Value != nil {
+		t.Fatalf("Expected the value to be cleared on release")
+	}
+}
+
+func TestPooledResponseBuffers(t *testing.T) {
+	clientConn, serverConn := newTestPipeMemdConns()
+	clientConn.EnablePooledBuffers(true)
+
+	value := bytes.Repeat([]byte("value"), 100)
+	go func() {
+		packets := []*memdPacket{
+			{Magic: resMagic, Opcode: cmdGet, Extras: make([]byte, 4), Value: value},
+			{Magic: resMagic, Opcode: cmdGet, Status: StatusKeyNotFound},
+			{Magic: resMagic, Opcode: cmdSet},
+		}
+		for _, packet := range packets {
+			if err := serverConn.WritePacket(packet); err != nil {
+				return
+			}
+		}
+	}()
+
+	var resp memdPacket
+	if err := clientConn.ReadPacket(&resp); err != nil {
+		t.Fatalf("Failed to read packet: %v", err)
+	}
+	if resp.buffer == nil || !bytes.Equal(resp.Value, value) {
+		t.Fatalf("Expected a successful get to be read into a pooled buffer")
+	}
+	resp.takeBuffer().release()
+
+	for i := 0; i < 2; i++ {
+		if err := clientConn.ReadPacket(&resp); err != nil {
+			t.Fatalf("Failed to read packet: %v", err)
+		}
+		if resp.buffer != nil {
+			t.Fatalf("Expected response %d not to be read into a pooled buffer", i)
+		}
+	}
+}
+
+func TestPooledResponseBufferReleasedOnReadError(t *testing.T) {
+	clientConn, serverConn := newTestPipeMemdConns()
+	clientConn.EnablePooledBuffers(true)
+
+	go func() {
+		// Send the header of a successful get, but close the connection before its body.
+		header := make([]byte, 24)
+		header[0] = byte(resMagic)
+		header[1] = byte(cmdGet)
+		binary.BigEndian.PutUint32(header[8:], 100)
+		if _, err := serverConn.conn.Write(header); err != nil {
+			return
+		}
+		serverConn.Close()
+	}()
+
+	var resp memdPacket
+	if err := clientConn.ReadPacket(&resp); err == nil {
+		t.Fatalf("Expected reading a truncated packet to fail")
+	}
+	if resp.buffer != nil {
+		t.Fatalf("Expected the pooled buffer to be released when the read fails")
+	}
+}
+
+func TestPooledResponseDecompression(t *testing.T) {
+	clientConn, serverConn := newTestPipeMemdConns()
+	clientConn.EnablePooledBuffers(true)
+	client := newMemdClient(&Agent{clientId: "test"}, clientConn)
+	defer client.Close()
+
+	value := bytes.Repeat([]byte("value"), 1000)
+	go func() {
+		var packet memdPacket
+		if err := serverConn.ReadPacket(&packet); err != nil {
+			return
+		}
+
+		_ = serverConn.WritePacket(&memdPacket{
+			Magic:    resMagic,
+			Opcode:   cmdGet,
+			Datatype: uint8(DatatypeFlagCompressed),
+			Opaque:   packet.Opaque,
+			Extras:   make([]byte, 4),
+			Value:    snappy.Encode(nil, value),
+		})
+	}()
+
+	resultCh := make(chan *GetResult, 1)
+	err := client.SendRequest(&memdQRequest{
+		memdPacket: memdPacket{
+			Magic:  reqMagic,
+			Opcode: cmdGet,
+			Key:    []byte("key"),
+		},
+		Callback: func(resp *memdQResponse, req *memdQRequest, err error) {
+			if err != nil {
+				resultCh <- nil
+				return
+			}
+			resultCh <- &GetResult{
+				Value:  resp.Value,
+				buffer: resp.takeBuffer(),
+			}
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to send request: %v", err)
+	}
+
+	select {
+	case res := <-resultCh:
+		if res == nil || !bytes.Equal(res.Value, value) {
+			t.Fatalf("Expected the decompressed value")
+		}
+		// The result holds both the decompressed value and the original packet.
+		if res.buffer == nil || res.buffer.next == nil {
+			t.Fatalf("Expected the result to hold the pooled buffers of the response")
+		}
+		res.Release()
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timed out waiting for response")
+	}
+}
@@ -0,0 +1,302 @@
+package gocbcore
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// CircuitBreakerState is the state of a circuit breaker.
+type CircuitBreakerState int
+
+const (
+	// CircuitBreakerStateClosed indicates that requests are allowed through the breaker.
+	CircuitBreakerStateClosed = CircuitBreakerState(0)
+
+	// CircuitBreakerStateHalfOpen indicates that a canary request is probing whether the
+	// endpoint has recovered.  Other requests are rejected until it completes.
+	CircuitBreakerStateHalfOpen = CircuitBreakerState(1)
+
+	// CircuitBreakerStateOpen indicates that requests are rejected by the breaker.
+	CircuitBreakerStateOpen = CircuitBreakerState(2)
+)
+
+// String returns the name of the state.
+func (state CircuitBreakerState) String() string {
+	switch state {
+	case CircuitBreakerStateClosed:
+		return "closed"
+	case CircuitBreakerStateHalfOpen:
+		return "half-open"
+	case CircuitBreakerStateOpen:
+		return "open"
+	}
+	return "unknown"
+}
+
+// CircuitBreakerCallback is used to decide whether a completed request succeeded as far
+// as the circuit breaker is concerned.
+type CircuitBreakerCallback func(error) bool
+
+// CircuitBreakerConfig specifies how the circuit breakers of the memcached pipelines and
+// HTTP endpoints behave.  A breaker opens once at least VolumeThreshold requests have
+// completed within the RollingWindow, and at least ErrorThresholdPercentage of them
+// failed.  After the SleepWindow a canary request is sent, and the breaker closes again
+// if it succeeds within the CanaryTimeout.
+type CircuitBreakerConfig struct {
+	Disabled                 bool
+	VolumeThreshold          int64
+	ErrorThresholdPercentage float64
+	SleepWindow              time.Duration
+	RollingWindow            time.Duration
+	CanaryTimeout            time.Duration
+
+	// CompletionCallback overrides which requests are counted as failures.  By default
+	// only timeouts and network errors are.
+	CompletionCallback CircuitBreakerCallback
+}
+
+// CircuitBreakerInfo describes the state of a single circuit breaker.
+type CircuitBreakerInfo struct {
+	Endpoint string
+	Service  ServiceType
+	State    CircuitBreakerState
+}
+
+func defaultCircuitBreakerConfig() CircuitBreakerConfig {
+	return CircuitBreakerConfig{
+		VolumeThreshold:          20,
+		ErrorThresholdPercentage: 50,
+		SleepWindow:              5 * time.Second,
+		RollingWindow:            1 * time.Minute,
+		CanaryTimeout:            5 * time.Second,
+	}
+}
+
+// mergeCircuitBreakerConfig fills in any unset values of config with the defaults.
+func mergeCircuitBreakerConfig(config CircuitBreakerConfig) CircuitBreakerConfig {
+	merged := defaultCircuitBreakerConfig()
+	merged.Disabled = config.Disabled
+	merged.CompletionCallback = config.CompletionCallback
+	if config.VolumeThreshold > 0 {
+		merged.VolumeThreshold = config.VolumeThreshold
+	}
+	if config.ErrorThresholdPercentage > 0 {
+		merged.ErrorThresholdPercentage = config.ErrorThresholdPercentage
+	}
+	if config.SleepWindow > 0 {
+		merged.SleepWindow = config.SleepWindow
+	}
+	if config.RollingWindow > 0 {
+		merged.RollingWindow = config.RollingWindow
+	}
+	if config.CanaryTimeout > 0 {
+		merged.CanaryTimeout = config.CanaryTimeout
+	}
+	return merged
+}
+
+func circuitBreakerDefaultCompletion(err error) bool {
+	return err == nil || (err != ErrTimeout && err != ErrNetwork)
+}
+
+type circuitBreaker struct {
+	lock   sync.Mutex
+	config CircuitBreakerConfig
+	canary func(func(error))
+
+	state       CircuitBreakerState
+	windowStart time.Time
+	total       int64
+	failed      int64
+	openedAt    time.Time
+}
+
+func newCircuitBreaker(config CircuitBreakerConfig, canary func(func(error))) *circuitBreaker {
+	return &circuitBreaker{
+		config:      config,
+		canary:      canary,
+		windowStart: time.Now(),
+	}
+}
+
+// State returns the current state of the breaker.
+func (breaker *circuitBreaker) State() CircuitBreakerState {
+	breaker.lock.Lock()
+	defer breaker.lock.Unlock()
+	return breaker.state
+}
+
+// AllowsRequest returns whether a request may be sent through the breaker.  Once an open
+// breaker has slept for long enough this sends the canary request.
+func (breaker *circuitBreaker) AllowsRequest() bool {
+	breaker.lock.Lock()
+
+	switch breaker.state {
+	case CircuitBreakerStateClosed:
+		breaker.lock.Unlock()
+		return true
+	case CircuitBreakerStateOpen:
+		if time.Since(breaker.openedAt) < breaker.config.SleepWindow {
+			breaker.lock.Unlock()
+			return false
+		}
+	default:
+		breaker.lock.Unlock()
+		return false
+	}
+
+	breaker.state = CircuitBreakerStateHalfOpen
+	breaker.lock.Unlock()
+
+	breaker.canary(breaker.handleCanaryResult)
+	return false
+}
+
+func (breaker *circuitBreaker) handleCanaryResult(err error) {
+	breaker.lock.Lock()
+	defer breaker.lock.Unlock()
+
+	if breaker.state != CircuitBreakerStateHalfOpen {
+		return
+	}
+
+	if breaker.isSuccess(err) {
+		breaker.state = CircuitBreakerStateClosed
+		breaker.resetLocked()
+		return
+	}
+
+	breaker.state = CircuitBreakerStateOpen
+	breaker.openedAt = time.Now()
+}
+
+func (breaker *circuitBreaker) isSuccess(err error) bool {
+	if breaker.config.CompletionCallback != nil {
+		return breaker.config.CompletionCallback(err)
+	}
+	return circuitBreakerDefaultCompletion(err)
+}
+
+func (breaker *circuitBreaker) resetLocked() {
+	breaker.windowStart = time.Now()
+	breaker.total = 0
+	breaker.failed = 0
+}
+
+// MarkCompleted records the outcome of a request which was sent through the breaker,
+// opening it if too many requests have failed.
+func (breaker *circuitBreaker) MarkCompleted(err error) {
+	if err == ErrCancelled || err == ErrShutdown {
+		return
+	}
+
+	success := breaker.isSuccess(err)
+
+	breaker.lock.Lock()
+	defer breaker.lock.Unlock()
+
+	if breaker.state != CircuitBreakerStateClosed {
+		return
+	}
+
+	if time.Since(breaker.windowStart) > breaker.config.RollingWindow {
+		breaker.resetLocked()
+	}
+
+	breaker.total++
+	if !success {
+		breaker.failed++
+	}
+
+	if breaker.total < breaker.config.VolumeThreshold {
+		return
+	}
+
+	if float64(breaker.failed)*100/float64(breaker.total) >= breaker.config.ErrorThresholdPercentage {
+		breaker.state = CircuitBreakerStateOpen
+		breaker.openedAt = time.Now()
+	}
+}
+
+type circuitBreakerKey struct {
+	service  ServiceType
+	endpoint string
+}
+
+// circuitBreakerSet holds the circuit breaker of each endpoint.  The breakers are kept
+// independently of the routing config so that they survive config changes.
+type circuitBreakerSet struct {
+	lock     sync.Mutex
+	config   CircuitBreakerConfig
+	breakers map[circuitBreakerKey]*circuitBreaker
+}
+
+func newCircuitBreakerSet(config CircuitBreakerConfig) *circuitBreakerSet {
+	return &circuitBreakerSet{
+		config:   mergeCircuitBreakerConfig(config),
+		breakers: make(map[circuitBreakerKey]*circuitBreaker),
+	}
+}
+
+// Get returns the breaker for an endpoint, creating it with the canary function if it
+// does not exist yet.  It returns nil if circuit breakers are disabled.
+func (set *circuitBreakerSet) Get(service ServiceType, endpoint string, canary func(func(error))) *circuitBreaker {
+	if set == nil || set.config.Disabled {
+		return nil
+	}
+
+	set.lock.Lock()
+	defer set.lock.Unlock()
+
+	key := circuitBreakerKey{
+		service:  service,
+		endpoint: endpoint,
+	}
+	breaker, ok := set.breakers[key]
+	if !ok {
+		breaker = newCircuitBreaker(set.config, canary)
+		set.breakers[key] = breaker
+	}
+
+	return breaker
+}
+
+// Info returns the state of every breaker, ordered by service and endpoint.
+func (set *circuitBreakerSet) Info() []CircuitBreakerInfo {
+	if set == nil {
+		return nil
+	}
+
+	set.lock.Lock()
+	var infos []CircuitBreakerInfo
+	for key, breaker := range set.breakers {
+		infos = append(infos, CircuitBreakerInfo{
+			Endpoint: key.endpoint,
+			Service:  key.service,
+		})
+		infos[len(infos)-1].State = breaker.State()
+	}
+	set.lock.Unlock()
+
+	sort.Sort(circuitBreakerInfoSorter(infos))
+
+	return infos
+}
+
+type circuitBreakerInfoSorter []CircuitBreakerInfo
+
+func (list circuitBreakerInfoSorter) Len() int {
+	return len(list)
+}
+
+func (list circuitBreakerInfoSorter) Less(i, j int) bool {
+	if list[i].Service != list[j].Service {
+		return list[i].Service < list[j].Service
+	}
+	return list[i].Endpoint < list[j].Endpoint
+}
+
+func (list circuitBreakerInfoSorter) Swap(i, j int) {
+	list[i], list[j] = list[j], list[i]
+}
@@ -0,0 +1,189 @@
+package gocbcore
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreakerOpensAndRecovers(t *testing.T) {
+	var canaryDone func(error)
+	breaker := newCircuitBreaker(mergeCircuitBreakerConfig(CircuitBreakerConfig{
+		VolumeThreshold:          4,
+		ErrorThresholdPercentage: 50,
+		SleepWindow:              20 * time.Millisecond,
+	}), func(done func(error)) {
+		canaryDone = done
+	})
+
+	breaker.MarkCompleted(nil)
+	breaker.MarkCompleted(ErrTimeout)
+	breaker.MarkCompleted(ErrKeyNotFound)
+	if breaker.State() != CircuitBreakerStateClosed || !breaker.AllowsRequest() {
+		t.Fatalf("Expected the breaker to stay closed below the volume threshold")
+	}
+
+	breaker.MarkCompleted(ErrNetwork)
+	if breaker.State() != CircuitBreakerStateOpen || breaker.AllowsRequest() {
+		t.Fatalf("Expected the breaker to open once half of the requests failed")
+	}
+	if canaryDone != nil {
+		t.Fatalf("Expected no canary to be sent during the sleep window")
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	if breaker.AllowsRequest() || canaryDone == nil {
+		t.Fatalf("Expected a canary to be sent after the sleep window")
+	}
+	if breaker.State() != CircuitBreakerStateHalfOpen {
+		t.Fatalf("Expected the breaker to be half-open while the canary is in flight")
+	}
+
+	canaryDone(ErrTimeout)
+	canaryDone = nil
+	if breaker.State() != CircuitBreakerStateOpen {
+		t.Fatalf("Expected a failed canary to open the breaker again")
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	breaker.AllowsRequest()
+	canaryDone(nil)
+	if breaker.State() != CircuitBreakerStateClosed || !breaker.AllowsRequest() {
+		t.Fatalf("Expected a successful canary to close the breaker")
+	}
+}
+
+func TestCircuitBreakerPipelineFailsFast(t *testing.T) {
+	breakers := newCircuitBreakerSet(CircuitBreakerConfig{
+		VolumeThreshold: 1,
+		SleepWindow:     time.Minute,
+	})
+	pipeline := newPipeline("127.0.0.1:11210", 0, 10, nil)
+	pipeline.breaker = breakers.Get(MemdService, pipeline.address, func(done func(error)) {})
+
+	req := &memdQRequest{
+		memdPacket: memdPacket{
+			Magic:  reqMagic,
+			Opcode: cmdGet,
+		},
+		Callback: func(resp *memdQResponse, req *memdQRequest, err error) {},
+	}
+	if err := pipeline.SendRequest(req); err != nil {
+		t.Fatalf("Failed to queue request: %v", err)
+	}
+	pipeline.queue.Remove(req)
+	req.markReachedConn()
+	req.tryCallback(nil, ErrTimeout)
+
+	err := pipeline.SendRequest(&memdQRequest{
+		memdPacket: memdPacket{
+			Magic:  reqMagic,
+			Opcode: cmdGet,
+		},
+	})
+	if err != ErrCircuitBreakerOpen {
+		t.Fatalf("Expected the request to fail fast, got %v", err)
+	}
+
+	infos := breakers.Info()
+	if len(infos) != 1 || infos[0].Endpoint != "127.0.0.1:11210" || infos[0].State != CircuitBreakerStateOpen {
+		t.Fatalf("Unexpected circuit breaker info %+v", infos)
+	}
+}
+
+func TestCircuitBreakerOnlyCountsSentRequests(t *testing.T) {
+	breakers := newCircuitBreakerSet(CircuitBreakerConfig{
+		VolumeThreshold: 1,
+		SleepWindow:     time.Minute,
+	})
+	pipeline := newPipeline("127.0.0.1:11210", 0, 10, nil)
+	pipeline.breaker = breakers.Get(MemdService, pipeline.address, func(done func(error)) {})
+	otherPipeline := newPipeline("127.0.0.1:11211", 0, 10, nil)
+
+	newReq := func() *memdQRequest {
+		return &memdQRequest{
+			memdPacket: memdPacket{
+				Magic:  reqMagic,
+				Opcode: cmdGet,
+			},
+			Callback: func(resp *memdQResponse, req *memdQRequest, err error) {},
+		}
+	}
+
+	// A request which times out while it is still queued says nothing about the server.
+	req := newReq()
+	if err := pipeline.SendRequest(req); err != nil {
+		t.Fatalf("Failed to queue request: %v", err)
+	}
+	pipeline.queue.Remove(req)
+	req.tryCallback(nil, ErrTimeout)
+	if pipeline.breaker.State() != CircuitBreakerStateClosed {
+		t.Fatalf("Expected a request which was never sent not to be counted")
+	}
+
+	// A request which was sent and then requeued elsewhere belongs to the new pipeline.
+	req = newReq()
+	if err := pipeline.SendRequest(req); err != nil {
+		t.Fatalf("Failed to queue request: %v", err)
+	}
+	pipeline.queue.Remove(req)
+	req.markReachedConn()
+	if err := otherPipeline.RequeueRequest(req); err != nil {
+		t.Fatalf("Failed to requeue request: %v", err)
+	}
+	otherPipeline.queue.Remove(req)
+	req.markReachedConn()
+	req.tryCallback(nil, ErrTimeout)
+	if pipeline.breaker.State() != CircuitBreakerStateClosed {
+		t.Fatalf("Expected a requeued request not to be counted against its first pipeline")
+	}
+}
+
+func TestCircuitBreakerHttpSkipsOpenEndpoints(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	agent := &Agent{
+		httpCli: &http.Client{},
+		circuitBreakers: newCircuitBreakerSet(CircuitBreakerConfig{
+			VolumeThreshold: 1,
+			SleepWindow:     time.Minute,
+		}),
+	}
+	agent.routingInfo.Update(nil, &routeData{
+		revId:      -1,
+		n1qlEpList: []string{"http://127.0.0.1:1", server.URL},
+	})
+
+	agent.httpCircuitBreaker(N1qlService, "http://127.0.0.1:1").MarkCompleted(ErrNetwork)
+
+	for i := 0; i < 10; i++ {
+		resp, err := agent.DoHttpRequest(&HttpRequest{
+			Service:  N1qlService,
+			Method:   "GET",
+			Path:     "/query/service",
+			Username: "user",
+			Password: "pass",
+		})
+		if err != nil {
+			t.Fatalf("Failed to perform request: %v", err)
+		}
+		if resp.Endpoint != server.URL {
+			t.Fatalf("Expected the request to avoid the open endpoint, got %s", resp.Endpoint)
+		}
+		_ = resp.Body.Close()
+	}
+
+	_, err := agent.DoHttpRequest(&HttpRequest{
+		Service:  N1qlService,
+		Method:   "GET",
+		Endpoint: "http://127.0.0.1:1",
+		Path:     "/query/service",
+		Username: "user",
+		Password: "pass",
+	})
+	if err != ErrCircuitBreakerOpen {
+		t.Fatalf("Expected a request to the open endpoint to fail fast, got %v", err)
+	}
+}
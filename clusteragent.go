@@ -0,0 +1,148 @@
+package gocbcore
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+)
+
+// ClusterAgent is an agent which is not bound to a bucket.  It bootstraps against the
+// cluster config, rather than the config of a bucket, and keeps the endpoints of the
+// cluster services up to date so that HTTP requests, pings and diagnostics can be
+// performed before any bucket is opened.
+type ClusterAgent struct {
+	agent  *Agent
+	config AgentConfig
+}
+
+// CreateClusterAgent creates an agent for performing cluster level operations.  The
+// BucketName of the config is ignored.  Servers which do not support fetching the
+// cluster config over CCCP are bootstrapped against using HTTP.
+func CreateClusterAgent(configIn *AgentConfig) (*ClusterAgent, error) {
+	config := *configIn
+	config.BucketName = ""
+
+	agent, err := CreateAgent(&config)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ClusterAgent{
+		agent:  agent,
+		config: *configIn,
+	}, nil
+}
+
+// Close shuts down the agent, disconnecting from all servers.  Agents opened with
+// OpenBucket are not affected and must be closed separately.
+func (agent *ClusterAgent) Close() error {
+	return agent.agent.Close()
+}
+
+// ClientId returns the unique id for this agent
+func (agent *ClusterAgent) ClientId() string {
+	return agent.agent.ClientId()
+}
+
+// HttpClient returns a pre-configured HTTP Client for communicating with
+// Couchbase Server.  You must still specify authentication information
+// for any dispatched requests.
+func (agent *ClusterAgent) HttpClient() *http.Client {
+	return agent.agent.HttpClient()
+}
+
+// MgmtEps returns all the available endpoints for performing
+// management queries.
+func (agent *ClusterAgent) MgmtEps() []string {
+	return agent.agent.MgmtEps()
+}
+
+// N1qlEps returns all the available endpoints for performing
+// N1QL queries.
+func (agent *ClusterAgent) N1qlEps() []string {
+	return agent.agent.N1qlEps()
+}
+
+// FtsEps returns all the available endpoints for performing
+// FTS queries.
+func (agent *ClusterAgent) FtsEps() []string {
+	return agent.agent.FtsEps()
+}
+
+// CbasEps returns all the available endpoints for performing
+// CBAS queries.
+func (agent *ClusterAgent) CbasEps() []string {
+	return agent.agent.CbasEps()
+}
+
+// Topology returns a snapshot of the cluster config currently in use by the agent.
+func (agent *ClusterAgent) Topology() *ClusterTopology {
+	return agent.agent.Topology()
+}
+
+// DoHttpRequest will perform an HTTP request against one of the HTTP
+// services which are available within the SDK.
+func (agent *ClusterAgent) DoHttpRequest(req *HttpRequest) (*HttpResponse, error) {
+	return agent.agent.DoHttpRequest(req)
+}
+
+// PingKvEx pings all of the servers we are connected to and returns
+// a report regarding the pings that were performed.
+func (agent *ClusterAgent) PingKvEx(opts PingKvOptions, cb PingKvExCallback) (PendingOp, error) {
+	return agent.agent.PingKvEx(opts, cb)
+}
+
+// PingKvContext pings all of the servers we are connected to, blocking until the operation completes or ctx is done.
+func (agent *ClusterAgent) PingKvContext(ctx context.Context, opts PingKvOptions) (*PingKvResult, error) {
+	return agent.agent.PingKvContext(ctx, opts)
+}
+
+// Diagnostics returns diagnostics information about the client.
+// Mainly containing a list of open connections and their current
+// states.
+func (agent *ClusterAgent) Diagnostics() (*DiagnosticInfo, error) {
+	return agent.agent.Diagnostics()
+}
+
+// seedAddrs returns the memcached and http addresses of the nodes in the current
+// cluster config.
+func (agent *ClusterAgent) seedAddrs() ([]string, []string) {
+	routingInfo := agent.agent.routingInfo.Get()
+	if routingInfo == nil || routingInfo.source == nil {
+		return nil, nil
+	}
+
+	var memdAddrs []string
+	memdAddrs = append(memdAddrs, routingInfo.source.kvServerList...)
+
+	var httpAddrs []string
+	for _, ep := range routingInfo.source.mgmtEpList {
+		epInfo, err := url.Parse(ep)
+		if err != nil {
+			logDebugf("Failed to parse management endpoint %s (%s)", ep, err)
+			continue
+		}
+		httpAddrs = append(httpAddrs, epInfo.Host)
+	}
+
+	return memdAddrs, httpAddrs
+}
+
+// OpenBucket creates an agent for the named bucket, using the config the cluster agent
+// was created with.  The bucket agent bootstraps against the nodes in the current
+// cluster config using the network type selected by the cluster agent, falling back to
+// the configured addresses if there is no cluster config.  If a custom AuthHandler was
+// configured it is responsible for selecting the bucket.
+func (agent *ClusterAgent) OpenBucket(bucketName string) (*Agent, error) {
+	config := agent.config
+	config.BucketName = bucketName
+
+	memdAddrs, httpAddrs := agent.seedAddrs()
+	if len(memdAddrs) > 0 || len(httpAddrs) > 0 {
+		config.MemdAddrs = memdAddrs
+		config.HttpAddrs = httpAddrs
+		config.NetworkType = agent.agent.networkType
+	}
+
+	return CreateAgent(&config)
+}
@@ -0,0 +1,94 @@
+package gocbcore
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestClusterConfig(t *testing.T) {
+	cfg := getConfig(t, "testdata/cluster_config.json")
+	if cfg.bktType != bktTypeNone || !cfg.IsValid() {
+		t.Fatalf("Expected a valid cluster config, got type %d", cfg.bktType)
+	}
+	if len(cfg.kvServerList) != 3 || cfg.kvServerList[1] != "172.17.0.3:11210" {
+		t.Fatalf("Unexpected kv servers %v", cfg.kvServerList)
+	}
+	if len(cfg.mgmtEpList) != 3 || len(cfg.n1qlEpList) != 3 || len(cfg.ftsEpList) != 3 || len(cfg.cbasEpList) != 1 {
+		t.Fatalf("Unexpected service endpoints %+v", cfg)
+	}
+	if cfg.vbMap != nil || cfg.ketamaMap != nil {
+		t.Fatalf("Expected no key distribution for a cluster config")
+	}
+
+	// A pool size of zero stops the pipelines from dialing the servers in the config.
+	agent := &ClusterAgent{
+		agent: &Agent{
+			networkType: "default",
+			kvPoolSize:  0,
+		},
+	}
+	agent.agent.routingInfo.Update(nil, &routeData{
+		revId: -1,
+	})
+	agent.agent.applyConfig(cfg)
+
+	if agent.Topology().BucketType != TopologyBucketTypeNone {
+		t.Fatalf("Expected the topology to describe no bucket")
+	}
+	if len(agent.CbasEps()) != 1 || agent.CbasEps()[0] != "http://172.17.0.4:8095" {
+		t.Fatalf("Unexpected analytics endpoints %v", agent.CbasEps())
+	}
+
+	_, err := agent.agent.routeRequest(&memdQRequest{
+		memdPacket: memdPacket{
+			Key: []byte("key"),
+		},
+	})
+	if err != ErrNoBucket {
+		t.Fatalf("Expected keyed requests to fail without a bucket, got %v", err)
+	}
+
+	memdAddrs, httpAddrs := agent.seedAddrs()
+	if len(memdAddrs) != 3 || memdAddrs[2] != "172.17.0.4:11210" {
+		t.Fatalf("Unexpected bucket seed memcached addresses %v", memdAddrs)
+	}
+	if len(httpAddrs) != 3 || httpAddrs[0] != "172.17.0.2:8091" {
+		t.Fatalf("Unexpected bucket seed http addresses %v", httpAddrs)
+	}
+}
+
+func TestClusterConfigFromHttp(t *testing.T) {
+	clusterCfg, err := ioutil.ReadFile("testdata/cluster_config.json")
+	if err != nil {
+		t.Fatalf("Failed to read config: %v", err)
+	}
+
+	var requestedPath string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestedPath = r.URL.Path
+		_, err := w.Write(clusterCfg)
+		if err != nil {
+			t.Errorf("Failed to write config: %v", err)
+		}
+	}))
+	defer server.Close()
+
+	agent := &Agent{
+		auth:    &PasswordAuthProvider{Username: "user", Password: "pass"},
+		httpCli: &http.Client{},
+	}
+
+	bk, err := agent.fetchHttpSeedConfig(strings.TrimPrefix(server.URL, "http://"))
+	if err != nil {
+		t.Fatalf("Failed to fetch cluster config: %v", err)
+	}
+	if requestedPath != "/pools/default/nodeServices" {
+		t.Fatalf("Unexpected config request path %s", requestedPath)
+	}
+	if bk.Rev != 1080 || len(bk.NodesExt) != 3 {
+		t.Fatalf("Unexpected cluster config %+v", bk)
+	}
+}
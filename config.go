@@ -39,6 +39,7 @@ type cfgNode struct {
 	Uptime               int                `json:"uptime,string"`
 	Version              string             `json:"version"`
 	ThisNode             bool               `json:"thisNode,omitempty"`
+	ServerGroup          string             `json:"serverGroup,omitempty"`
 }
 
 type cfgNodeServices struct {
@@ -64,6 +65,7 @@ type cfgNodeExt struct {
 	Services     cfgNodeServices              `json:"services"`
 	Hostname     string                       `json:"hostname"`
 	AltAddresses map[string]cfgNodeAltAddress `json:"alternateAddresses"`
+	ServerGroup  string                       `json:"serverGroup,omitempty"`
 }
 
 // A Pool of nodes and buckets.
@@ -80,6 +82,10 @@ type cfgVBucketServerMap struct {
 	NumReplicas   int      `json:"numReplicas"`
 	ServerList    []string `json:"serverList"`
 	VBucketMap    [][]int  `json:"vBucketMap"`
+
+	// VBucketMapForward is the map the vbuckets will have once an in-progress
+	// rebalance has completed.
+	VBucketMapForward [][]int `json:"vBucketMapForward,omitempty"`
 }
 
 // Bucket is the primary entry point for most data operations.
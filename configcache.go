@@ -0,0 +1,248 @@
+package gocbcore
+
+import (
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ConfigCache is a store used to persist the last known good cluster configuration
+// for a bucket, allowing an agent to begin routing operations before it has fetched
+// a configuration from the cluster.
+type ConfigCache interface {
+	// Load returns the data stored for the key, or nil if there is none.
+	Load(key string) ([]byte, error)
+
+	// Store replaces the data stored for the key.
+	Store(key string, data []byte) error
+}
+
+// FileConfigCache is a ConfigCache which stores each configuration as a file within
+// a directory.
+type FileConfigCache struct {
+	dir string
+}
+
+// NewFileConfigCache creates a ConfigCache which stores configurations within dir,
+// creating the directory if required.
+func NewFileConfigCache(dir string) *FileConfigCache {
+	return &FileConfigCache{
+		dir: dir,
+	}
+}
+
+func (cache *FileConfigCache) path(key string) string {
+	return filepath.Join(cache.dir, fmt.Sprintf("%x.json", sha1.Sum([]byte(key))))
+}
+
+// Load returns the data stored for the key, or nil if there is none.
+func (cache *FileConfigCache) Load(key string) ([]byte, error) {
+	data, err := ioutil.ReadFile(cache.path(key))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+// Store replaces the data stored for the key.  The data is written to a temporary
+// file which is then renamed so that readers never observe a partial write.
+func (cache *FileConfigCache) Store(key string, data []byte) error {
+	if err := os.MkdirAll(cache.dir, 0700); err != nil {
+		return err
+	}
+
+	tmpFile, err := ioutil.TempFile(cache.dir, "config-")
+	if err != nil {
+		return err
+	}
+
+	_, err = tmpFile.Write(data)
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpFile.Name(), cache.path(key))
+	}
+	if err != nil {
+		if removeErr := os.Remove(tmpFile.Name()); removeErr != nil {
+			logDebugf("Failed to remove temporary config cache file (%s)", removeErr)
+		}
+		return err
+	}
+
+	return nil
+}
+
+// cachedConfig is the form in which configurations are stored in a ConfigCache.
+type cachedConfig struct {
+	NetworkType string     `json:"networkType"`
+	Config      *cfgBucket `json:"config"`
+}
+
+// makeConfigCacheKey builds the key identifying a bucket on a particular cluster, where
+// the cluster is identified by the addresses used to bootstrap against it.
+func makeConfigCacheKey(bucketName string, memdAddrs, httpAddrs []string) string {
+	var addrs []string
+	for _, addr := range memdAddrs {
+		addrs = append(addrs, "couchbase://"+addr)
+	}
+	for _, addr := range httpAddrs {
+		addrs = append(addrs, "http://"+addr)
+	}
+	sort.Strings(addrs)
+
+	return strings.Join(addrs, ",") + "/" + bucketName
+}
+
+// loadCachedConfig fetches the cached configuration for the agent's bucket and builds
+// the routing configuration from it.  It returns nil if there is no usable entry.
+func (agent *Agent) loadCachedConfig() *routeConfig {
+	data, err := agent.configCache.Load(agent.configCacheKey)
+	if err != nil {
+		logDebugf("Failed to load cached config (%s)", err)
+		return nil
+	} else if data == nil {
+		logDebugf("No cached config available")
+		return nil
+	}
+
+	var cached cachedConfig
+	if err := json.Unmarshal(data, &cached); err != nil || cached.Config == nil {
+		logDebugf("Failed to parse cached config (%v)", err)
+		return nil
+	}
+
+	bk := cached.Config
+	if bk.Name != agent.bucket {
+		logDebugf("Ignoring cached config for bucket %s", bk.Name)
+		return nil
+	}
+
+	networkType := agent.networkType
+	if networkType == "" || networkType == "auto" {
+		networkType = cached.NetworkType
+	}
+	if networkType == "" {
+		networkType = "default"
+	}
+
+	routeCfg := buildRouteConfig(bk, agent.IsSecure(), networkType, true)
+	if !routeCfg.IsValid() {
+		logDebugf("Ignoring invalid cached config")
+		return nil
+	}
+	routeCfg.fromCache = true
+	agent.networkType = networkType
+
+	if agent.useCollections && !bk.supports("collections") {
+		logDebugf("Disabling collections as unsupported")
+		agent.useCollections = false
+	}
+
+	if bk.supports("syncreplication") {
+		agent.durabilityLevelStatus = durabilityLevelStatusSupported
+	} else {
+		agent.durabilityLevelStatus = durabilityLevelStatusUnsupported
+	}
+
+	return routeCfg
+}
+
+// storeCachedConfig saves a configuration to the config cache, unless a newer revision
+// of the same bucket has already been saved by this agent.  The entry loaded at startup
+// is not considered, as it may be stale and must always be replaced.
+func (agent *Agent) storeCachedConfig(bk *cfgBucket) {
+	if agent.configCache == nil {
+		return
+	}
+
+	agent.configCacheLock.Lock()
+	defer agent.configCacheLock.Unlock()
+
+	if bk.UUID == agent.configCacheUUID && bk.Rev <= agent.configCacheRev {
+		return
+	}
+
+	data, err := json.Marshal(cachedConfig{
+		NetworkType: agent.networkType,
+		Config:      bk,
+	})
+	if err != nil {
+		logDebugf("Failed to encode config for caching (%s)", err)
+		return
+	}
+
+	if err := agent.configCache.Store(agent.configCacheKey, data); err != nil {
+		logDebugf("Failed to store cached config (%s)", err)
+		return
+	}
+
+	agent.configCacheRev = bk.Rev
+	agent.configCacheUUID = bk.UUID
+}
+
+// bootstrapFromCache applies the cached configuration, if there is one, and then
+// connects to the cluster in the background.  It returns false if no cached
+// configuration could be used, in which case the agent must connect normally.
+func (agent *Agent) bootstrapFromCache(memdAddrs, httpAddrs []string, deadline time.Time) bool {
+	routeCfg := agent.loadCachedConfig()
+	if routeCfg == nil {
+		return false
+	}
+
+	logDebugf("Bootstrapping from cached config with revision %d", routeCfg.revId)
+
+	agent.bootstrappedFromCache = true
+	if routeCfg.vbMap != nil {
+		agent.numVbuckets = routeCfg.vbMap.NumVbuckets()
+	}
+
+	agent.routingInfo.Update(nil, &routeData{
+		revId: -1,
+	})
+	agent.applyConfig(routeCfg)
+
+	// Nothing fetches configs until connect succeeds, so we keep trying until it does.
+	connectTimeout := deadline.Sub(time.Now())
+	agent.bootstrapDoneSig = make(chan struct{})
+	go func() {
+		defer close(agent.bootstrapDoneSig)
+
+		for {
+			err := agent.connect(memdAddrs, httpAddrs, deadline)
+			if err == nil || err == ErrShutdown {
+				return
+			}
+			if err == ErrAuthError {
+				logErrorf("Failed to fetch config from the cluster, continuing with cached config (%s)", err)
+				return
+			}
+
+			logWarnf("Failed to fetch config from the cluster, retrying with cached config (%s)", err)
+
+			// Only one HTTP looper may run at a time, it exits once it has failed to
+			// fetch a first config.
+			if agent.httpLooperDoneSig != nil {
+				<-agent.httpLooperDoneSig
+			}
+
+			select {
+			case <-time.After(agent.confHttpRetryDelay):
+			case <-agent.closeNotify:
+				return
+			}
+			deadline = time.Now().Add(connectTimeout)
+		}
+	}()
+
+	return true
+}
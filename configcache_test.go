@@ -0,0 +1,278 @@
+package gocbcore
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+type testConfigCache struct {
+	lock    sync.Mutex
+	entries map[string][]byte
+}
+
+func (cache *testConfigCache) Load(key string) ([]byte, error) {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+	return cache.entries[key], nil
+}
+
+func (cache *testConfigCache) Store(key string, data []byte) error {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+	cache.entries[key] = data
+	return nil
+}
+
+func TestFileConfigCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gocbcore-configcache")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	cache := NewFileConfigCache(dir + "/nested")
+
+	data, err := cache.Load("key")
+	if err != nil || data != nil {
+		t.Fatalf("Expected a miss for an empty cache, got %v, %v", data, err)
+	}
+
+	if err := cache.Store("key", []byte("first")); err != nil {
+		t.Fatalf("Failed to store: %v", err)
+	}
+	if err := cache.Store("key", []byte("second")); err != nil {
+		t.Fatalf("Failed to store: %v", err)
+	}
+	if err := cache.Store("other", []byte("third")); err != nil {
+		t.Fatalf("Failed to store: %v", err)
+	}
+
+	data, err = cache.Load("key")
+	if err != nil || !bytes.Equal(data, []byte("second")) {
+		t.Fatalf("Unexpected cached data %s, %v", data, err)
+	}
+
+	files, err := ioutil.ReadDir(dir + "/nested")
+	if err != nil {
+		t.Fatalf("Failed to list cache dir: %v", err)
+	}
+	if len(files) != 2 {
+		t.Fatalf("Expected only the two cache files, found %d", len(files))
+	}
+}
+
+func TestConfigCacheKey(t *testing.T) {
+	keyA := makeConfigCacheKey("default", []string{"a:11210", "b:11210"}, nil)
+	keyB := makeConfigCacheKey("default", []string{"b:11210", "a:11210"}, nil)
+	if keyA != keyB {
+		t.Fatalf("Expected the key to be independent of address order")
+	}
+
+	if keyA == makeConfigCacheKey("other", []string{"a:11210", "b:11210"}, nil) {
+		t.Fatalf("Expected different buckets to have different keys")
+	}
+	if keyA == makeConfigCacheKey("default", nil, []string{"a:11210", "b:11210"}) {
+		t.Fatalf("Expected memcached and http addresses to have different keys")
+	}
+}
+
+func TestCachedConfigBootstrap(t *testing.T) {
+	filename := "testdata/bucket_config_with_external_addresses.json"
+	cachedBk := loadConfigFromFile(t, filename)
+	cachedBk.Rev = 2000
+
+	cache := &testConfigCache{
+		entries: make(map[string][]byte),
+	}
+	key := makeConfigCacheKey("default", []string{"172.17.0.2:11210"}, nil)
+	data, err := json.Marshal(cachedConfig{
+		NetworkType: "default",
+		Config:      cachedBk,
+	})
+	if err != nil {
+		t.Fatalf("Failed to encode cached config: %v", err)
+	}
+	cache.entries[key] = data
+
+	// A pool size of zero stops the pipelines from dialing the servers in the config.
+	agent := &Agent{
+		bucket:         "default",
+		kvPoolSize:     0,
+		configCache:    cache,
+		configCacheKey: key,
+	}
+
+	routeCfg := agent.loadCachedConfig()
+	if routeCfg == nil || !routeCfg.fromCache || routeCfg.revId != 2000 {
+		t.Fatalf("Expected the cached config to be used, got %+v", routeCfg)
+	}
+	if agent.networkType != "default" {
+		t.Fatalf("Expected the cached network type to be used, got %s", agent.networkType)
+	}
+
+	agent.numVbuckets = routeCfg.vbMap.NumVbuckets()
+	agent.routingInfo.Update(nil, &routeData{
+		revId: -1,
+	})
+	agent.applyConfig(routeCfg)
+	if agent.Topology().RevID != 2000 {
+		t.Fatalf("Expected the cached config to be applied")
+	}
+
+	// The config from the cluster replaces the cached one even with an older revision.
+	freshBk := loadConfigFromFile(t, filename)
+	agent.updateConfig(freshBk)
+	if agent.Topology().RevID != freshBk.Rev {
+		t.Fatalf("Expected the fresh config to replace the cached config, got revision %d", agent.Topology().RevID)
+	}
+
+	// Later configs are subject to the usual revision checks.
+	olderBk := loadConfigFromFile(t, filename)
+	olderBk.Rev = freshBk.Rev - 1
+	agent.applyConfig(buildRouteConfig(olderBk, false, "default", false))
+	if agent.Topology().RevID != freshBk.Rev {
+		t.Fatalf("Expected an older config not to replace a fresh config")
+	}
+
+	// The fresh config has a different revision to the cached one so must be stored,
+	// whereas storing it again or storing an older revision must not.
+	agent.storeCachedConfig(freshBk)
+	agent.storeCachedConfig(freshBk)
+	agent.storeCachedConfig(olderBk)
+
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+	var stored cachedConfig
+	if err := json.Unmarshal(cache.entries[key], &stored); err != nil {
+		t.Fatalf("Failed to decode stored config: %v", err)
+	}
+	if stored.Config.Rev != freshBk.Rev || stored.Config.SourceHostname != freshBk.SourceHostname {
+		t.Fatalf("Unexpected stored config revision %d", stored.Config.Rev)
+	}
+}
+
+func TestCachedConfigWrongBucket(t *testing.T) {
+	cachedBk := loadConfigFromFile(t, "testdata/bucket_config_with_external_addresses.json")
+	data, err := json.Marshal(cachedConfig{
+		NetworkType: "external",
+		Config:      cachedBk,
+	})
+	if err != nil {
+		t.Fatalf("Failed to encode cached config: %v", err)
+	}
+
+	agent := &Agent{
+		bucket: "other",
+		configCache: &testConfigCache{
+			entries: map[string][]byte{"key": data},
+		},
+		configCacheKey: "key",
+	}
+
+	if agent.loadCachedConfig() != nil {
+		t.Fatalf("Expected a cached config for another bucket to be ignored")
+	}
+	if agent.networkType != "" {
+		t.Fatalf("Expected the network type to be left unresolved")
+	}
+}
+
+func TestCachedConfigHttpLooperTriesSeeds(t *testing.T) {
+	cfgBytes := loadConfigBytesWithRev(t, "testdata/bucket_config_with_external_addresses.json", 5)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, err := w.Write(cfgBytes)
+		if err != nil {
+			t.Errorf("Failed to write config: %v", err)
+		}
+	}))
+	defer server.Close()
+
+	agent := &Agent{
+		bucket:               "default",
+		auth:                 &PasswordAuthProvider{Username: "user", Password: "pass"},
+		httpCli:              &http.Client{},
+		closeNotify:          make(chan struct{}),
+		confHttpRedialPeriod: time.Minute,
+		httpLooperDoneSig:    make(chan struct{}),
+	}
+	// The endpoints from the cached config are no longer reachable.
+	agent.routingInfo.Update(nil, &routeData{
+		revId:      -1,
+		mgmtEpList: []string{"http://127.0.0.1:1"},
+	})
+
+	srcCh := make(chan string, 1)
+	go agent.httpLooper([]string{server.URL}, func(cfg *cfgBucket, srcServer string, err error) bool {
+		if err != nil {
+			srcCh <- ""
+			return true
+		}
+		srcCh <- srcServer
+		return true
+	})
+
+	select {
+	case srcServer := <-srcCh:
+		if srcServer != server.URL {
+			t.Fatalf("Expected the config to be fetched from the seed endpoint, got %q", srcServer)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timed out waiting for a config")
+	}
+
+	close(agent.closeNotify)
+	agent.routingInfo.Clear()
+	<-agent.httpLooperDoneSig
+}
+
+type testBlackholeSender struct{}
+
+func (sender *testBlackholeSender) SupportsFeature(feature HelloFeature) bool {
+	return false
+}
+
+func (sender *testBlackholeSender) Address() string {
+	return "blackhole:11210"
+}
+
+func (sender *testBlackholeSender) SendRequest(req *memdQRequest) error {
+	return nil
+}
+
+func TestCachedConfigBootstrapStopsOnClose(t *testing.T) {
+	closeNotify := make(chan struct{})
+	close(closeNotify)
+
+	agent := &Agent{
+		closeNotify:          closeNotify,
+		serverConnectTimeout: 10 * time.Second,
+	}
+
+	// The agent is already closed, so no server should be tried.
+	err := agent.connect([]string{"blackhole:11210"}, nil, time.Now().Add(10*time.Second))
+	if err != ErrShutdown {
+		t.Fatalf("Expected ErrShutdown but got %v", err)
+	}
+
+	// Requests on connections which are still being set up are abandoned.
+	syncCli := syncClient{
+		client:      &testBlackholeSender{},
+		closeNotify: closeNotify,
+	}
+	start := time.Now()
+	_, err = syncCli.ExecCccpRequest(time.Now().Add(10 * time.Second))
+	if err != ErrShutdown {
+		t.Fatalf("Expected ErrShutdown but got %v", err)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Fatalf("Expected the request to be abandoned without waiting for its deadline")
+	}
+}
@@ -12,6 +12,15 @@ const (
 
 	altReqMagic = commandMagic(0x08)
 	altResMagic = commandMagic(0x18)
+
+	// Server-initiated requests and the responses to them, used in duplex mode.
+	srvReqMagic = commandMagic(0x82)
+	srvResMagic = commandMagic(0x83)
+)
+
+// Server-initiated commands, which share opcode values with client commands.
+const (
+	srvCmdClustermapChangeNotification = commandCode(0x01)
 )
 
 type frameExtraType uint16
@@ -367,6 +376,7 @@ const (
 	bktTypeInvalid   bucketType = 0
 	bktTypeCouchbase            = iota
 	bktTypeMemcached            = iota
+	bktTypeNone                 = iota
 )
 
 // VbucketState represents the state of a particular vbucket on a particular server.
@@ -422,7 +432,8 @@ const (
 	// KeyStateNotFound indicates that the key is not found in memory or on disk.
 	KeyStateNotFound = KeyState(0x80)
 
-	// KeyStateDeleted indicates that the key has been written to disk as deleted.
+	// KeyStateDeleted indicates that the key has been deleted in memory, but the deletion
+	// has not yet been written to disk.
 	KeyStateDeleted = KeyState(0x81)
 )
 
@@ -560,9 +571,21 @@ const (
 	// SubdocDocFlagReplaceDoc indices that this operation should be a replace rather than upsert.
 	SubdocDocFlagReplaceDoc = SubdocDocFlag(0x02)
 
+	// SubdocDocFlagAddDoc indicates that the document should be created, failing if it already exists.
+	// This shares its value with SubdocDocFlagReplaceDoc, which is how the server interprets the flag.
+	SubdocDocFlagAddDoc = SubdocDocFlag(0x02)
+
 	// SubdocDocFlagAccessDeleted indicates that you wish to receive soft-deleted documents.
 	// Internal: This should never be used and is not supported.
 	SubdocDocFlagAccessDeleted = SubdocDocFlag(0x04)
+
+	// SubdocDocFlagCreateAsDeleted indicates that a document created by the operation should be
+	// created as a soft-deleted document.  It must be combined with SubdocDocFlagAccessDeleted.
+	SubdocDocFlagCreateAsDeleted = SubdocDocFlag(0x08)
+
+	// SubdocDocFlagReviveDocument indicates that a soft-deleted document should be brought back
+	// to life by the operation.  It must be combined with SubdocDocFlagAccessDeleted.
+	SubdocDocFlagReviveDocument = SubdocDocFlag(0x10)
 )
 
 // ServiceType specifies a particular Couchbase service type.
@@ -47,6 +47,20 @@ func (e SubDocMutateError) Error() string {
 	return fmt.Sprintf("subdocument mutation %d failed (%s)", e.OpIndex, e.Err.Error())
 }
 
+// SubDocPartialMutateError occurs when a split sub-document mutation fails after some
+// of its operations have already been applied to the document.
+type SubDocPartialMutateError struct {
+	Err           error
+	AppliedOps    int
+	Cas           Cas
+	MutationToken MutationToken
+}
+
+func (e SubDocPartialMutateError) Error() string {
+	return fmt.Sprintf("subdocument mutation partially applied, %d operations were applied before failure (%s)",
+		e.AppliedOps, e.Err.Error())
+}
+
 type timeoutError struct {
 }
 
@@ -289,6 +303,14 @@ var (
 	// ErrNoReplicas occurs when no replicas respond in time
 	ErrNoReplicas = errors.New("no replicas responded in time")
 
+	// ErrCircuitBreakerOpen occurs when a request is rejected because the circuit breaker
+	// of the server or endpoint it was sent to is open.
+	ErrCircuitBreakerOpen = errors.New("circuit breaker is open")
+
+	// ErrNoServerGroupReplicas occurs when a read is limited to the preferred server group,
+	// but no copy of the document is stored in that group.
+	ErrNoServerGroupReplicas = errors.New("no copies of the document are in the preferred server group")
+
 	// ErrNoServer occurs when no server is available to service a keys vbucket.
 	ErrNoServer = errors.New("no server available for this vbucket")
 
@@ -342,6 +364,40 @@ var (
 	// being used does not support it.
 	ErrEnhancedDurabilityUnsupported = errors.New("Enhanced durability is not supported by this server version.")
 
+	// ErrUnsupportedTranscoderFormat occurs when a transcoder is asked to decode a document whose flags indicate a
+	// format that the transcoder does not support.
+	ErrUnsupportedTranscoderFormat = errors.New("The document format is not supported by this transcoder.")
+
+	// ErrInvalidTranscoderValue occurs when a transcoder is asked to encode, or decode into, a Go type that it does not
+	// support.
+	ErrInvalidTranscoderValue = errors.New("The value type is not supported by this transcoder.")
+
+	// ErrDurabilityFailover occurs when a failover of the vbucket is detected while waiting for observe based
+	// durability requirements to be met, in which case the mutation may have been lost.
+	ErrDurabilityFailover = errors.New("A failover was detected while waiting for durability requirements.")
+
+	// ErrSubDocSplitCasMismatch occurs when a document is modified while a split sub-document lookup is being
+	// performed, meaning the results do not represent a consistent view of the document.
+	ErrSubDocSplitCasMismatch = errors.New("The document was modified while a split sub-document lookup was in progress.")
+
+	// ErrLockReleased occurs when a LockHandle is used after its lock has been released.
+	ErrLockReleased = errors.New("The lock has already been released.")
+
+	// ErrTransactionWriteConflict occurs when a transaction attempts to modify a document which has
+	// been modified by another transaction which has not yet completed.
+	ErrTransactionWriteConflict = errors.New("The document is being modified by another transaction.")
+
+	// ErrTransactionDocumentModified occurs when a document is modified outside of a transaction
+	// after it was read or staged by the transaction.
+	ErrTransactionDocumentModified = errors.New("The document was modified outside of the transaction.")
+
+	// ErrTransactionExpired occurs when a transaction does not complete before its expiration time.
+	ErrTransactionExpired = errors.New("The transaction expired before it could be completed.")
+
+	// ErrTransactionNotActive occurs when an operation is performed on a transaction which has
+	// already been completed, or which has another operation in progress.
+	ErrTransactionNotActive = errors.New("The transaction is not active or has an operation in progress.")
+
 	// ErrShutdown occurs when operations are performed on a previously closed Agent.
 	ErrShutdown = &shutdownError{}
 
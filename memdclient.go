@@ -116,6 +116,7 @@ func (client *memdClient) takeRequestOwnership(req *memdQRequest) bool {
 		return false
 	}
 
+	req.markReachedConn()
 	client.opList.Add(req)
 	return true
 }
@@ -137,7 +138,23 @@ func (client *memdClient) CancelRequest(req *memdQRequest) bool {
 	return removed
 }
 
+// SendRequest writes a request to the server immediately.
 func (client *memdClient) SendRequest(req *memdQRequest) error {
+	return client.sendRequest(req, true)
+}
+
+// QueueRequest adds a request to the write buffer of the connection, it is not
+// guaranteed to be sent until Flush is called.
+func (client *memdClient) QueueRequest(req *memdQRequest) error {
+	return client.sendRequest(req, false)
+}
+
+// Flush writes any queued requests to the server.
+func (client *memdClient) Flush() error {
+	return client.conn.Flush()
+}
+
+func (client *memdClient) sendRequest(req *memdQRequest, flush bool) error {
 	addSuccess := client.takeRequestOwnership(req)
 	if !addSuccess {
 		return ErrCancelled
@@ -162,7 +179,12 @@ func (client *memdClient) SendRequest(req *memdQRequest) error {
 
 	client.parent.startNetTrace(req)
 
-	err := client.conn.WritePacket(packet)
+	var err error
+	if flush {
+		err = client.conn.WritePacket(packet)
+	} else {
+		err = client.conn.QueuePacket(packet)
+	}
 	if err != nil {
 		logDebugf("memdClient write failure: %v", err)
 		client.CancelRequest(req)
@@ -172,7 +194,52 @@ func (client *memdClient) SendRequest(req *memdQRequest) error {
 	return nil
 }
 
+// sendKeepAlive sends a NOOP to the server, returning whether it was answered within
+// the timeout.  The NOOP is written separately as a dead connection may block writes.
+func (client *memdClient) sendKeepAlive(timeout time.Duration) bool {
+	respCh := make(chan error, 1)
+	req := &memdQRequest{
+		memdPacket: memdPacket{
+			Magic:  reqMagic,
+			Opcode: cmdNoop,
+		},
+		Callback: func(resp *memdQResponse, req *memdQRequest, err error) {
+			respCh <- err
+		},
+		Priority: RequestPriorityCritical,
+	}
+
+	go func() {
+		if err := client.SendRequest(req); err != nil {
+			req.tryCallback(nil, err)
+		}
+	}()
+
+	timer := AcquireTimer(timeout)
+	select {
+	case err := <-respCh:
+		ReleaseTimer(timer, false)
+		return err == nil
+	case <-timer.C:
+		ReleaseTimer(timer, true)
+	}
+
+	if atomic.SwapUint32(&req.isCompleted, 1) != 0 {
+		// The response arrived just as we timed out.
+		return <-respCh == nil
+	}
+
+	client.CancelRequest(req)
+	return false
+}
+
 func (client *memdClient) resolveRequest(resp *memdQResponse) {
+	// Any pooled buffer which was not taken over by the request's result is no longer
+	//  referenced once the request has been handled.
+	defer func() {
+		resp.takeBuffer().release()
+	}()
+
 	opIndex := resp.Opaque
 
 	client.lock.Lock()
@@ -201,7 +268,13 @@ func (client *memdClient) resolveRequest(resp *memdQResponse) {
 
 	isCompressed := (resp.Datatype & uint8(DatatypeFlagCompressed)) != 0
 	if isCompressed && !client.parent.disableDecompression {
-		newValue, err := snappy.Decode(nil, resp.Value)
+		var newValue []byte
+		var err error
+		if resp.buffer != nil {
+			newValue, err = client.decodePooledValue(resp)
+		} else {
+			newValue, err = snappy.Decode(nil, resp.Value)
+		}
 		if err != nil {
 			req.processingLock.Unlock()
 			logDebugf("Failed to decompress value from the server for key `%s`.", req.Key)
@@ -247,6 +320,37 @@ func (client *memdClient) resolveRequest(resp *memdQResponse) {
 	req.tryCallback(resp, err)
 }
 
+// decodePooledValue decompresses the value of a response into a pooled buffer, which is
+// chained to the buffer already backing the response.
+func (client *memdClient) decodePooledValue(resp *memdQResponse) ([]byte, error) {
+	decodedLen, err := snappy.DecodedLen(resp.Value)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := acquireMemdBuffer(decodedLen)
+	newValue, err := snappy.Decode(buf.data[:decodedLen], resp.Value)
+	if err != nil {
+		buf.release()
+		return nil, err
+	}
+
+	buf.next = resp.buffer
+	resp.buffer = buf
+	return newValue, nil
+}
+
+func (client *memdClient) handleServerRequest(req *memdQResponse) {
+	switch req.Opcode {
+	case srvCmdClustermapChangeNotification:
+		if client.parent != nil {
+			client.parent.handleClusterMapNotification(req)
+		}
+	default:
+		logDebugf("Ignoring unsupported server request. OP=0x%x", req.Opcode)
+	}
+}
+
 func (client *memdClient) run() {
 	dcpBufferQ := make(chan *memdQResponse)
 	dcpKillSwitch := make(chan bool)
@@ -289,6 +393,13 @@ func (client *memdClient) run() {
 
 			atomic.StoreInt64(&client.lastActivity, time.Now().UnixNano())
 
+			// Server-initiated requests are only sent in duplex mode and do not
+			// correspond to any request of ours.
+			if resp.memdPacket.Magic == srvReqMagic {
+				client.handleServerRequest(resp)
+				continue
+			}
+
 			// We handle DCP no-op's directly here so we can reply immediately.
 			if resp.memdPacket.Opcode == cmdDcpNoop {
 				err := client.conn.WritePacket(&memdPacket{
@@ -344,6 +455,10 @@ func (client *memdClient) run() {
 				logWarnf("Encountered an unowned request in a client opMap")
 			}
 
+			if req.owner != nil && req.owner.retryRequest(req, RetryReasonSocketClosedWhileInFlight, 0) {
+				return
+			}
+
 			req.tryCallback(nil, ErrNetwork)
 		})
 
@@ -42,6 +42,17 @@ func (mux *memdClientMux) GetPipeline(index int) *memdPipeline {
 	return mux.pipelines[index]
 }
 
+// HasConnectedClients returns whether any pipeline has a client which is currently
+// connected to its server.
+func (mux *memdClientMux) HasConnectedClients() bool {
+	for _, pipeline := range mux.pipelines {
+		if pipeline.HasConnectedClients() {
+			return true
+		}
+	}
+	return false
+}
+
 func (mux *memdClientMux) Start() {
 	// Initialize new pipelines
 	for _, pipeline := range mux.pipelines {
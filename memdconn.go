@@ -7,9 +7,20 @@ import (
 	"io"
 	"math"
 	"net"
+	"sync"
 	"time"
 )
 
+const (
+	// memdReadBufferSize is the size of the read buffer of a connection, which allows
+	// the headers of many small responses to be parsed from a single read.
+	memdReadBufferSize = 64 * 1024
+
+	// memdWriteBufferSize is the size of the write buffer of a connection.  Queued
+	// packets are written to the socket once this fills up, or when they are flushed.
+	memdWriteBufferSize = 64 * 1024
+)
+
 type memdFrameExtras struct {
 	HasSrvDuration         bool
 	SrvDuration            time.Duration
@@ -33,31 +44,50 @@ type memdPacket struct {
 	CollectionID uint32
 
 	FrameExtras *memdFrameExtras
+
+	// buffer is the pooled buffer the packet was read into, if any.
+	buffer *memdBuffer
+}
+
+// takeBuffer hands the pooled buffer backing the packet over to the caller, which
+// becomes responsible for releasing it.
+func (packet *memdPacket) takeBuffer() *memdBuffer {
+	buf := packet.buffer
+	packet.buffer = nil
+	return buf
 }
 
 type memdConn interface {
 	LocalAddr() string
 	RemoteAddr() string
 	WritePacket(*memdPacket) error
+	QueuePacket(*memdPacket) error
+	Flush() error
 	ReadPacket(*memdPacket) error
 	Close() error
 	EnableFramingExtras(bool)
 	EnableCollections(bool)
+	EnablePooledBuffers(bool)
 }
 
 type memdTcpConn struct {
 	conn             io.ReadWriteCloser
 	reader           *bufio.Reader
 	headerBuf        []byte
+	writeLock        sync.Mutex
+	writer           *bufio.Writer
+	writeHeaderBuf   []byte
 	localAddr        string
 	remoteAddr       string
 	useFramingExtras bool
 	useCollections   bool
+	usePooledBuffers bool
 }
 
-func dialMemdConn(address string, tlsConfig *tls.Config, deadline time.Time) (memdConn, error) {
+func dialMemdConn(address string, tlsConfig *tls.Config, deadline time.Time, cancelSig <-chan struct{}) (memdConn, error) {
 	d := net.Dialer{
 		Deadline: deadline,
+		Cancel:   cancelSig,
 	}
 
 	baseConn, err := d.Dial("tcp", address)
@@ -88,13 +118,20 @@ func dialMemdConn(address string, tlsConfig *tls.Config, deadline time.Time) (me
 		conn = tlsConn
 	}
 
+	return newMemdTcpConn(conn, baseConn.LocalAddr().String(), address), nil
+}
+
+func newMemdTcpConn(conn io.ReadWriteCloser, localAddr, remoteAddr string) *memdTcpConn {
 	return &memdTcpConn{
-		conn:       conn,
-		reader:     bufio.NewReader(conn),
-		headerBuf:  make([]byte, 24),
-		localAddr:  baseConn.LocalAddr().String(),
-		remoteAddr: address,
-	}, nil
+		conn:      conn,
+		reader:    bufio.NewReaderSize(conn, memdReadBufferSize),
+		headerBuf: make([]byte, 24),
+		writer:    bufio.NewWriterSize(conn, memdWriteBufferSize),
+		// The header is followed by at most 7 bytes of framing extras.
+		writeHeaderBuf: make([]byte, 24+7),
+		localAddr:      localAddr,
+		remoteAddr:     remoteAddr,
+	}
 }
 
 func (s *memdTcpConn) LocalAddr() string {
@@ -109,7 +146,38 @@ func (s *memdTcpConn) Close() error {
 	return s.conn.Close()
 }
 
+// WritePacket writes a packet, along with any packets queued before it, to the socket.
 func (s *memdTcpConn) WritePacket(req *memdPacket) error {
+	s.writeLock.Lock()
+	defer s.writeLock.Unlock()
+
+	err := s.queuePacketLocked(req)
+	if err != nil {
+		return err
+	}
+
+	return s.writer.Flush()
+}
+
+// QueuePacket adds a packet to the write buffer without flushing it, so that many small
+// packets can be written to the socket at once.  The packet is only guaranteed to have
+// been written once Flush has been called.
+func (s *memdTcpConn) QueuePacket(req *memdPacket) error {
+	s.writeLock.Lock()
+	defer s.writeLock.Unlock()
+
+	return s.queuePacketLocked(req)
+}
+
+// Flush writes any queued packets to the socket.
+func (s *memdTcpConn) Flush() error {
+	s.writeLock.Lock()
+	defer s.writeLock.Unlock()
+
+	return s.writer.Flush()
+}
+
+func (s *memdTcpConn) queuePacketLocked(req *memdPacket) error {
 	encodedKey := req.Key
 	if s.useCollections {
 		if supported, ok := cidSupportedOps[req.Opcode]; ok && supported {
@@ -154,11 +222,10 @@ func (s *memdTcpConn) WritePacket(req *memdPacket) error {
 		}
 	}
 
-	// Go appears to do some clever things in regards to writing data
-	//   to the kernel for network dispatch.  Having a write buffer
-	//   per-server that is re-used actually hinders performance...
-	// For now, we will simply create a new buffer and let it be GC'd.
-	buffer := make([]byte, 24+keyLen+extLen+valLen+frameLen)
+	// The header and framing extras are encoded into a scratch buffer, the rest of the
+	//  packet is copied straight into the write buffer (or written directly to the
+	//  socket if it is larger than the buffer) without being assembled first.
+	buffer := s.writeHeaderBuf[:24+frameLen]
 
 	buffer[0] = uint8(req.Magic)
 	buffer[1] = uint8(req.Opcode)
@@ -175,7 +242,7 @@ func (s *memdTcpConn) WritePacket(req *memdPacket) error {
 	} else {
 		binary.BigEndian.PutUint16(buffer[6:], uint16(req.Status))
 	}
-	binary.BigEndian.PutUint32(buffer[8:], uint32(len(buffer)-24))
+	binary.BigEndian.PutUint32(buffer[8:], uint32(frameLen+extLen+keyLen+valLen))
 	binary.BigEndian.PutUint32(buffer[12:], req.Opaque)
 	binary.BigEndian.PutUint64(buffer[16:], req.Cas)
 
@@ -204,11 +271,17 @@ func (s *memdTcpConn) WritePacket(req *memdPacket) error {
 			}
 		}
 	}
-	copy(buffer[extrasStart:], req.Extras)
-	copy(buffer[extrasStart+extLen:], encodedKey)
-	copy(buffer[extrasStart+extLen+keyLen:], req.Value)
 
-	_, err := s.conn.Write(buffer)
+	if _, err := s.writer.Write(buffer); err != nil {
+		return err
+	}
+	if _, err := s.writer.Write(req.Extras); err != nil {
+		return err
+	}
+	if _, err := s.writer.Write(encodedKey); err != nil {
+		return err
+	}
+	_, err := s.writer.Write(req.Value)
 	return err
 }
 
@@ -237,9 +310,20 @@ func (s *memdTcpConn) ReadPacket(resp *memdPacket) error {
 
 	bodyLen := int(binary.BigEndian.Uint32(s.headerBuf[8:]))
 
-	bodyBuf := make([]byte, bodyLen)
+	var bodyBuf []byte
+	resp.buffer = nil
+	if s.usePooledBuffers && s.isPooledResponse() {
+		resp.buffer = acquireMemdBuffer(bodyLen)
+		bodyBuf = resp.buffer.data[:bodyLen]
+	} else {
+		bodyBuf = make([]byte, bodyLen)
+	}
 	err = s.readFullBuffer(bodyBuf)
 	if err != nil {
+		if resp.buffer != nil {
+			resp.buffer.release()
+			resp.buffer = nil
+		}
 		return err
 	}
 
@@ -305,7 +389,8 @@ func (s *memdTcpConn) ReadPacket(resp *memdPacket) error {
 
 	resp.Extras = bodyBuf[frameExtrasLen : frameExtrasLen+extLen]
 	var collectionIdLen int
-	if s.useCollections {
+	// Server-initiated requests never encode a collection id in the key.
+	if s.useCollections && resp.Magic != srvReqMagic {
 		var n uint8
 		// Some operations do not encode the cid in the key
 		switch resp.Opcode {
@@ -329,6 +414,25 @@ func (s *memdTcpConn) EnableCollections(use bool) {
 	s.useCollections = use
 }
 
+// EnablePooledBuffers makes successful responses to get operations be read into pooled
+// buffers, which must be released once they are no longer referenced.
+func (s *memdTcpConn) EnablePooledBuffers(use bool) {
+	s.usePooledBuffers = use
+}
+
+// isPooledResponse returns whether the packet whose header was just read should be read
+// into a pooled buffer.
+func (s *memdTcpConn) isPooledResponse() bool {
+	magic := commandMagic(s.headerBuf[0])
+	if magic != resMagic && magic != altResMagic {
+		return false
+	}
+	if StatusCode(binary.BigEndian.Uint16(s.headerBuf[6:])) != StatusSuccess {
+		return false
+	}
+	return isPooledResponseOp(commandCode(s.headerBuf[1]))
+}
+
 var cidSupportedOps = map[commandCode]bool{
 	cmdGet:                  true,
 	cmdSet:                  true,
@@ -15,6 +15,41 @@ var (
 	errAlreadyQueued = errors.New("request was already queued somewhere else")
 )
 
+// RequestPriority specifies which lane of the operation queue a request is placed into.
+// Requests in higher priority lanes are sent first, although requests in lower priority
+// lanes are still sent periodically so that they are not starved.
+type RequestPriority int
+
+const (
+	// RequestPriorityNormal is the priority of requests which do not specify one.
+	RequestPriorityNormal = RequestPriority(0)
+
+	// RequestPriorityCritical is the priority for latency sensitive requests.  It is also
+	// used for config polling and keepalives.
+	RequestPriorityCritical = RequestPriority(1)
+
+	// RequestPriorityBackground is the priority for bulk work, such as batch imports,
+	// which should not delay other requests.
+	RequestPriorityBackground = RequestPriority(2)
+)
+
+const memdOpQueueNumLanes = 3
+
+// lane returns the index of the queue lane for the priority, from highest to lowest.
+func (priority RequestPriority) lane() int {
+	switch priority {
+	case RequestPriorityCritical:
+		return 0
+	case RequestPriorityBackground:
+		return 2
+	}
+	return 1
+}
+
+// memdOpQueueMaxSkips is how many requests from higher lanes may be sent while a lane
+// has requests waiting before one of its requests is sent instead.
+var memdOpQueueMaxSkips = [memdOpQueueNumLanes]int{0, 8, 32}
+
 type memdOpConsumer struct {
 	parent   *memdOpQueue
 	isClosed bool
@@ -28,31 +63,84 @@ func (c *memdOpConsumer) Pop() *memdQRequest {
 	return c.parent.pop(c)
 }
 
+// TryPop returns the next request without waiting for one, or nil if the queue is
+// empty.
+func (c *memdOpConsumer) TryPop() *memdQRequest {
+	return c.parent.tryPop(c)
+}
+
 func (c *memdOpConsumer) Close() {
 	c.parent.closeConsumer(c)
 }
 
 type memdOpQueue struct {
-	lock   sync.Mutex
-	signal *sync.Cond
-	items  *list.List
-	isOpen bool
+	lock    sync.Mutex
+	signal  *sync.Cond
+	lanes   [memdOpQueueNumLanes]*list.List
+	skipped [memdOpQueueNumLanes]int
+	isOpen  bool
 }
 
 func newMemdOpQueue() *memdOpQueue {
 	q := memdOpQueue{
 		isOpen: true,
-		items:  list.New(),
+	}
+	for i := range q.lanes {
+		q.lanes[i] = list.New()
 	}
 	q.signal = sync.NewCond(&q.lock)
 	return &q
 }
 
+// numItemsLocked returns the number of requests across all lanes.
+func (q *memdOpQueue) numItemsLocked() int {
+	numItems := 0
+	for _, lane := range q.lanes {
+		numItems += lane.Len()
+	}
+	return numItems
+}
+
+// nextLaneLocked picks the lane to pop the next request from, or -1 if the queue is
+// empty.  This is the highest priority lane with requests waiting, unless a lower lane
+// has been passed over too many times.
+func (q *memdOpQueue) nextLaneLocked() int {
+	chosen := -1
+	for i, lane := range q.lanes {
+		if lane.Len() > 0 {
+			chosen = i
+			break
+		}
+	}
+	if chosen < 0 {
+		return -1
+	}
+
+	for i := len(q.lanes) - 1; i > chosen; i-- {
+		if q.lanes[i].Len() > 0 && q.skipped[i] >= memdOpQueueMaxSkips[i] {
+			chosen = i
+			break
+		}
+	}
+
+	for i := chosen + 1; i < len(q.lanes); i++ {
+		if q.lanes[i].Len() > 0 {
+			q.skipped[i]++
+		}
+	}
+	q.skipped[chosen] = 0
+
+	return chosen
+}
+
 func (q *memdOpQueue) debugString() string {
 	var outStr string
 	q.lock.Lock()
 
-	outStr += fmt.Sprintf("Num Items: %d\n", q.items.Len())
+	outStr += fmt.Sprintf("Num Items: %d\n", q.numItemsLocked())
+	for i, lane := range q.lanes {
+		outStr += fmt.Sprintf("Lane %d Items: %d\n", i, lane.Len())
+	}
 
 	if q.isOpen {
 		outStr += fmt.Sprintf("Is Open: true")
@@ -72,9 +160,10 @@ func (q *memdOpQueue) Remove(req *memdQRequest) bool {
 		return false
 	}
 
-	for e := q.items.Front(); e != nil; e = e.Next() {
+	lane := q.lanes[req.Priority.lane()]
+	for e := lane.Front(); e != nil; e = e.Next() {
 		if e.Value.(*memdQRequest) == req {
-			q.items.Remove(e)
+			lane.Remove(e)
 			break
 		}
 	}
@@ -91,7 +180,7 @@ func (q *memdOpQueue) Push(req *memdQRequest, maxItems int) error {
 		return errOpQueueClosed
 	}
 
-	if maxItems > 0 && q.items.Len() >= maxItems {
+	if maxItems > 0 && q.numItemsLocked() >= maxItems {
 		q.lock.Unlock()
 		return errOpQueueFull
 	}
@@ -109,13 +198,57 @@ func (q *memdOpQueue) Push(req *memdQRequest, maxItems int) error {
 		return errAlreadyQueued
 	}
 
-	q.items.PushBack(req)
+	q.lanes[req.Priority.lane()].PushBack(req)
 	q.lock.Unlock()
 
 	q.signal.Broadcast()
 	return nil
 }
 
+// PushMany queues a batch of requests while only acquiring the queue lock and
+// waking consumers once.  It returns the number of requests which were handled
+// before an error occurred, requests after that point have not been queued.
+func (q *memdOpQueue) PushMany(reqs []*memdQRequest, maxItems int) (int, error) {
+	q.lock.Lock()
+	if !q.isOpen {
+		q.lock.Unlock()
+		return 0, errOpQueueClosed
+	}
+
+	numHandled := 0
+	numItems := q.numItemsLocked()
+	var err error
+	for _, req := range reqs {
+		if maxItems > 0 && numItems >= maxItems {
+			err = errOpQueueFull
+			break
+		}
+
+		if !atomic.CompareAndSwapPointer(&req.queuedWith, nil, unsafe.Pointer(q)) {
+			err = errAlreadyQueued
+			break
+		}
+
+		if req.isCancelled() {
+			// This request has already been completed (most likely by its deadline
+			// expiring), so we simply skip over it rather than failing the batch.
+			atomic.CompareAndSwapPointer(&req.queuedWith, unsafe.Pointer(q), nil)
+			numHandled++
+			continue
+		}
+
+		q.lanes[req.Priority.lane()].PushBack(req)
+		numItems++
+		numHandled++
+	}
+	q.lock.Unlock()
+
+	if numHandled > 0 {
+		q.signal.Broadcast()
+	}
+	return numHandled, err
+}
+
 func (q *memdOpQueue) Consumer() *memdOpConsumer {
 	return &memdOpConsumer{
 		parent:   q,
@@ -134,7 +267,12 @@ func (q *memdOpQueue) closeConsumer(c *memdOpConsumer) {
 func (q *memdOpQueue) pop(c *memdOpConsumer) *memdQRequest {
 	q.lock.Lock()
 
-	for q.isOpen && !c.isClosed && q.items.Len() == 0 {
+	laneIdx := -1
+	for q.isOpen && !c.isClosed {
+		laneIdx = q.nextLaneLocked()
+		if laneIdx >= 0 {
+			break
+		}
 		q.signal.Wait()
 	}
 
@@ -143,8 +281,9 @@ func (q *memdOpQueue) pop(c *memdOpConsumer) *memdQRequest {
 		return nil
 	}
 
-	e := q.items.Front()
-	q.items.Remove(e)
+	lane := q.lanes[laneIdx]
+	e := lane.Front()
+	lane.Remove(e)
 
 	req, ok := e.Value.(*memdQRequest)
 	if !ok {
@@ -159,6 +298,29 @@ func (q *memdOpQueue) pop(c *memdOpConsumer) *memdQRequest {
 	return req
 }
 
+func (q *memdOpQueue) tryPop(c *memdOpConsumer) *memdQRequest {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if !q.isOpen || c.isClosed {
+		return nil
+	}
+
+	laneIdx := q.nextLaneLocked()
+	if laneIdx < 0 {
+		return nil
+	}
+
+	lane := q.lanes[laneIdx]
+	e := lane.Front()
+	lane.Remove(e)
+
+	req := e.Value.(*memdQRequest)
+	atomic.CompareAndSwapPointer(&req.queuedWith, unsafe.Pointer(q), nil)
+
+	return req
+}
+
 type drainCallback func(*memdQRequest)
 
 func (q *memdOpQueue) Drain(cb drainCallback) {
@@ -170,16 +332,18 @@ func (q *memdOpQueue) Drain(cb drainCallback) {
 		return
 	}
 
-	for e := q.items.Front(); e != nil; e = e.Next() {
-		req, ok := e.Value.(*memdQRequest)
-		if !ok {
-			logErrorf("Encountered incorrect type in memdOpQueue")
-			continue
-		}
+	for _, lane := range q.lanes {
+		for e := lane.Front(); e != nil; e = e.Next() {
+			req, ok := e.Value.(*memdQRequest)
+			if !ok {
+				logErrorf("Encountered incorrect type in memdOpQueue")
+				continue
+			}
 
-		atomic.CompareAndSwapPointer(&req.queuedWith, unsafe.Pointer(q), nil)
+			atomic.CompareAndSwapPointer(&req.queuedWith, unsafe.Pointer(q), nil)
 
-		cb(req)
+			cb(req)
+		}
 	}
 
 	q.lock.Unlock()
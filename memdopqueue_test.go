@@ -0,0 +1,81 @@
+package gocbcore
+
+import "testing"
+
+func TestOpQueuePushMany(t *testing.T) {
+	q := newMemdOpQueue()
+
+	reqs := []*memdQRequest{
+		{memdPacket: memdPacket{}},
+		{memdPacket: memdPacket{}},
+		{memdPacket: memdPacket{}},
+	}
+
+	numHandled, err := q.PushMany(reqs, 2)
+	if err != errOpQueueFull {
+		t.Fatalf("Expected the queue to be full but got %v", err)
+	}
+	if numHandled != 2 {
+		t.Fatalf("Expected 2 requests to be handled but got %d", numHandled)
+	}
+
+	consumer := q.Consumer()
+	if consumer.Pop() != reqs[0] || consumer.Pop() != reqs[1] {
+		t.Fatalf("Requests were not queued in order")
+	}
+
+	// A request which has already completed should be skipped.
+	reqs[2].isCompleted = 1
+	numHandled, err = q.PushMany(reqs[2:], 0)
+	if err != nil || numHandled != 1 {
+		t.Fatalf("Expected the completed request to be skipped: %d, %v", numHandled, err)
+	}
+	if q.Remove(reqs[2]) {
+		t.Fatalf("The completed request should not have been queued")
+	}
+
+	q.Close()
+	if _, err := q.PushMany(reqs, 0); err != errOpQueueClosed {
+		t.Fatalf("Expected the queue to be closed but got %v", err)
+	}
+}
+
+func TestOpQueuePriorityLanes(t *testing.T) {
+	q := newMemdOpQueue()
+
+	push := func(priority RequestPriority, count int) []*memdQRequest {
+		var reqs []*memdQRequest
+		for i := 0; i < count; i++ {
+			req := &memdQRequest{Priority: priority}
+			if err := q.Push(req, 0); err != nil {
+				t.Fatalf("Failed to push request: %v", err)
+			}
+			reqs = append(reqs, req)
+		}
+		return reqs
+	}
+
+	background := push(RequestPriorityBackground, 1)
+	normal := push(RequestPriorityNormal, 3)
+	critical := push(RequestPriorityCritical, 20)
+
+	if !q.Remove(normal[2]) {
+		t.Fatalf("Failed to remove a queued request")
+	}
+
+	// Critical requests go first, but a normal request is sent after every 8 of them
+	// so that the lower lanes are not starved.
+	expected := append([]*memdQRequest{}, critical[:8]...)
+	expected = append(expected, normal[0])
+	expected = append(expected, critical[8:16]...)
+	expected = append(expected, normal[1])
+	expected = append(expected, critical[16:]...)
+	expected = append(expected, background[0])
+
+	consumer := q.Consumer()
+	for i, req := range expected {
+		if consumer.Pop() != req {
+			t.Fatalf("Request %d was popped out of order", i)
+		}
+	}
+}
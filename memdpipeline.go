@@ -21,6 +21,10 @@ type memdPipeline struct {
 	maxClients  int
 	clients     []*memdPipelineClient
 	clientsLock sync.Mutex
+
+	// breaker is the circuit breaker for the server, or nil if circuit breakers are
+	// disabled.  It is set before the pipeline is used and never changed.
+	breaker *circuitBreaker
 }
 
 func newPipeline(address string, maxClients, maxItems int, getClientFn memdGetClientFn) *memdPipeline {
@@ -71,6 +75,25 @@ func (pipeline *memdPipeline) StartClients() {
 	}
 }
 
+// HasConnectedClients returns whether any of the pipeline's clients are currently
+// connected to the server.
+func (pipeline *memdPipeline) HasConnectedClients() bool {
+	pipeline.clientsLock.Lock()
+	defer pipeline.clientsLock.Unlock()
+
+	for _, pipecli := range pipeline.clients {
+		pipecli.lock.Lock()
+		connected := pipecli.client != nil
+		pipecli.lock.Unlock()
+
+		if connected {
+			return true
+		}
+	}
+
+	return false
+}
+
 func (pipeline *memdPipeline) sendRequest(req *memdQRequest, maxItems int) error {
 	err := pipeline.queue.Push(req, maxItems)
 	if err == errOpQueueClosed {
@@ -85,13 +108,45 @@ func (pipeline *memdPipeline) sendRequest(req *memdQRequest, maxItems int) error
 }
 
 func (pipeline *memdPipeline) RequeueRequest(req *memdQRequest) error {
+	req.setCircuitBreaker(pipeline.breaker)
 	return pipeline.sendRequest(req, 0)
 }
 
+// SendRequest queues a new request to this pipeline.  Unlike requeued requests, new
+// requests are rejected while the circuit breaker of the pipeline is open.
 func (pipeline *memdPipeline) SendRequest(req *memdQRequest) error {
+	if pipeline.breaker != nil {
+		if !pipeline.breaker.AllowsRequest() {
+			return ErrCircuitBreakerOpen
+		}
+	}
+
+	req.setCircuitBreaker(pipeline.breaker)
 	return pipeline.sendRequest(req, pipeline.maxItems)
 }
 
+// SendRequests queues a batch of requests to this pipeline, returning the number
+// of requests which were handled before any error occurred.
+func (pipeline *memdPipeline) SendRequests(reqs []*memdQRequest) (int, error) {
+	if pipeline.breaker != nil {
+		if !pipeline.breaker.AllowsRequest() {
+			return 0, ErrCircuitBreakerOpen
+		}
+	}
+	for _, req := range reqs {
+		req.setCircuitBreaker(pipeline.breaker)
+	}
+
+	numHandled, err := pipeline.queue.PushMany(reqs, pipeline.maxItems)
+	if err == errOpQueueClosed {
+		return numHandled, errPipelineClosed
+	} else if err == errOpQueueFull {
+		return numHandled, errPipelineFull
+	}
+
+	return numHandled, err
+}
+
 // Performs a takeover of another pipeline.  Note that this does not
 //  take over the requests queued in the old pipeline, and those must
 //  be drained and processed separately.
@@ -3,6 +3,8 @@ package gocbcore
 import (
 	"io"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type memdPipelineClient struct {
@@ -51,6 +53,10 @@ func (pipecli *memdPipelineClient) ioLoop(client *memdClient) {
 	pipecli.client = client
 	pipecli.lock.Unlock()
 
+	if client.parent != nil && client.parent.kvKeepAliveInterval > 0 {
+		go pipecli.keepAliveLoop(client, client.parent.kvKeepAliveInterval, client.parent.kvKeepAliveMaxMissed)
+	}
+
 	killSig := make(chan struct{})
 
 	// This goroutine is responsible for monitoring the client and handling
@@ -138,7 +144,27 @@ func (pipecli *memdPipelineClient) ioLoop(client *memdClient) {
 			continue
 		}
 
-		err := client.SendRequest(req)
+		// Any other requests which are already waiting are coalesced into the same
+		//  write, which is flushed once the queue is empty.  The write buffer is also
+		//  written out whenever it fills up.
+		err := client.QueueRequest(req)
+		for err == nil {
+			nextReq := localConsumer.TryPop()
+			if nextReq == nil {
+				break
+			}
+
+			req = nextReq
+			err = client.QueueRequest(req)
+		}
+		if err == nil {
+			err = client.Flush()
+			if err != nil {
+				// The queued requests are all in the client's op list, closing the client
+				//  fails or retries them.
+				req = nil
+			}
+		}
 		if err != nil {
 			logDebugf("Pipeline client `%s/%p` encountered a socket write error: %v", pipecli.address, pipecli, err)
 
@@ -154,8 +180,13 @@ func (pipecli *memdPipelineClient) ioLoop(client *memdClient) {
 				}
 			}
 
-			// We need to alert the caller that there was a network error
-			req.Callback(nil, req, ErrNetwork)
+			// If the request never made it into the client's op list, it cannot
+			// have been sent so it is safe to be retried elsewhere.  Otherwise we
+			// need to alert the caller that there was a network error.
+			if req != nil && (atomic.LoadPointer(&req.waitingIn) != nil || req.owner == nil ||
+				!req.owner.retryRequest(req, RetryReasonSocketNotAvailable, 0)) {
+				req.tryCallback(nil, ErrNetwork)
+			}
 
 			// Stop looping
 			break
@@ -170,6 +201,45 @@ func (pipecli *memdPipelineClient) ioLoop(client *memdClient) {
 	logDebugf("Pipeline client `%s/%p` received client shutdown notification", pipecli.address, pipecli)
 }
 
+// keepAliveLoop sends a NOOP over the client whenever nothing has been received from
+// the server for the keepalive interval.  Once maxMissed NOOPs in a row have gone
+// unanswered the client is closed, which fails or retries its in-flight requests and
+// causes Run to dial a new connection.
+func (pipecli *memdPipelineClient) keepAliveLoop(client *memdClient, interval time.Duration, maxMissed int) {
+	numMissed := 0
+	for {
+		select {
+		case <-time.After(interval):
+		case <-client.CloseNotify():
+			return
+		}
+
+		lastActivity := time.Unix(0, atomic.LoadInt64(&client.lastActivity))
+		if time.Since(lastActivity) < interval {
+			numMissed = 0
+			continue
+		}
+
+		if client.sendKeepAlive(interval) {
+			numMissed = 0
+			continue
+		}
+
+		numMissed++
+		logDebugf("Pipeline client `%s/%p` missed keepalive %d of %d", pipecli.address, pipecli, numMissed, maxMissed)
+		if numMissed < maxMissed {
+			continue
+		}
+
+		logWarnf("Pipeline client `%s/%p` connection is unresponsive, reconnecting", pipecli.address, pipecli)
+		err := client.Close()
+		if err != nil {
+			logErrorf("Pipeline client `%s/%p` failed to shut down unresponsive client socket (%s)", pipecli.address, pipecli, err)
+		}
+		return
+	}
+}
+
 func (pipecli *memdPipelineClient) Run() {
 	for {
 		logDebugf("Pipeline Client `%s/%p` preparing for new client loop", pipecli.address, pipecli)
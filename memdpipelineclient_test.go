@@ -0,0 +1,266 @@
+package gocbcore
+
+import (
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestKeepAliveDetectsDeadConnection(t *testing.T) {
+	clientConn, serverConn := newTestPipeMemdConns()
+	client := newMemdClient(&Agent{clientId: "test"}, clientConn)
+
+	// The server answers keepalives, but nothing else, until it stops responding.
+	var answering int32 = 1
+	go func() {
+		for {
+			var packet memdPacket
+			if err := serverConn.ReadPacket(&packet); err != nil {
+				return
+			}
+			if packet.Opcode != cmdNoop || atomic.LoadInt32(&answering) == 0 {
+				continue
+			}
+
+			err := serverConn.WritePacket(&memdPacket{
+				Magic:  resMagic,
+				Opcode: cmdNoop,
+				Opaque: packet.Opaque,
+			})
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	inFlightErr := make(chan error, 1)
+	err := client.SendRequest(&memdQRequest{
+		memdPacket: memdPacket{
+			Magic:  reqMagic,
+			Opcode: cmdGet,
+			Key:    []byte("key"),
+		},
+		Callback: func(resp *memdQResponse, req *memdQRequest, err error) {
+			inFlightErr <- err
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to send request: %v", err)
+	}
+
+	pipecli := &memdPipelineClient{
+		address: "127.0.0.1:11210",
+	}
+	loopDone := make(chan struct{})
+	go func() {
+		pipecli.keepAliveLoop(client, 20*time.Millisecond, 2)
+		close(loopDone)
+	}()
+
+	time.Sleep(200 * time.Millisecond)
+	select {
+	case <-client.CloseNotify():
+		t.Fatalf("Expected a connection answering keepalives to stay open")
+	default:
+	}
+
+	atomic.StoreInt32(&answering, 0)
+
+	select {
+	case <-client.CloseNotify():
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Expected an unresponsive connection to be closed")
+	}
+	<-loopDone
+
+	select {
+	case err := <-inFlightErr:
+		if err != ErrNetwork {
+			t.Fatalf("Expected the in-flight request to fail with a network error, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Expected the in-flight request to be failed")
+	}
+}
+
+// startFakeMemdServer starts an in-process memcached server which answers every request
+// with an empty success response.  The returned function stops the server.
+func startFakeMemdServer(tb testing.TB) (string, func()) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		tb.Fatalf("Failed to listen: %v", err)
+	}
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			go func() {
+				memdConn := newMemdTcpConn(conn, conn.LocalAddr().String(), conn.RemoteAddr().String())
+				defer memdConn.Close()
+
+				for {
+					var packet memdPacket
+					if err := memdConn.ReadPacket(&packet); err != nil {
+						return
+					}
+
+					err := memdConn.QueuePacket(&memdPacket{
+						Magic:  resMagic,
+						Opcode: packet.Opcode,
+						Opaque: packet.Opaque,
+					})
+					// Responses are batched up for as long as there are more requests
+					// waiting to be read, like the server does.
+					if err == nil && memdConn.reader.Buffered() == 0 {
+						err = memdConn.Flush()
+					}
+					if err != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+
+	return listener.Addr().String(), func() {
+		_ = listener.Close()
+	}
+}
+
+func TestPipelineClientCoalescedRequests(t *testing.T) {
+	address, stop := startFakeMemdServer(t)
+	defer stop()
+
+	pipeline := newTestFakeServerPipeline(address)
+	defer pipeline.Close()
+
+	// Queue up enough requests that the I/O loop writes them in batches.
+	errCh := make(chan error, 1000)
+	for i := 0; i < cap(errCh); i++ {
+		err := pipeline.SendRequest(&memdQRequest{
+			memdPacket: memdPacket{
+				Magic:  reqMagic,
+				Opcode: cmdGet,
+				Key:    []byte("key"),
+			},
+			Callback: func(resp *memdQResponse, req *memdQRequest, err error) {
+				errCh <- err
+			},
+		})
+		if err != nil {
+			t.Fatalf("Failed to queue request: %v", err)
+		}
+	}
+
+	for i := 0; i < cap(errCh); i++ {
+		select {
+		case err := <-errCh:
+			if err != nil {
+				t.Fatalf("Request failed: %v", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out after %d responses", i)
+		}
+	}
+}
+
+func newTestFakeServerPipeline(address string) *memdPipeline {
+	agent := &Agent{clientId: "bench"}
+	pipeline := newPipeline(address, 1, 0, func() (*memdClient, error) {
+		conn, err := net.Dial("tcp", address)
+		if err != nil {
+			return nil, err
+		}
+		return newMemdClient(agent, newMemdTcpConn(conn, conn.LocalAddr().String(), address)), nil
+	})
+	pipeline.StartClients()
+	return pipeline
+}
+
+func BenchmarkPipelineClientSmallGets(b *testing.B) {
+	address, stop := startFakeMemdServer(b)
+	defer stop()
+
+	pipeline := newTestFakeServerPipeline(address)
+	defer pipeline.Close()
+
+	b.SetParallelism(16)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		errCh := make(chan error, 1)
+		for pb.Next() {
+			err := pipeline.SendRequest(&memdQRequest{
+				memdPacket: memdPacket{
+					Magic:  reqMagic,
+					Opcode: cmdGet,
+					Key:    []byte("key"),
+				},
+				Callback: func(resp *memdQResponse, req *memdQRequest, err error) {
+					errCh <- err
+				},
+			})
+			if err != nil {
+				b.Fatalf("Failed to queue request: %v", err)
+			}
+			if err := <-errCh; err != nil {
+				b.Fatalf("Request failed: %v", err)
+			}
+		}
+	})
+}
+
+func benchmarkMemdConnWrites(b *testing.B, batchSize int) {
+	address, stop := startFakeMemdServer(b)
+	defer stop()
+
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		b.Fatalf("Failed to dial: %v", err)
+	}
+	memdConn := newMemdTcpConn(conn, conn.LocalAddr().String(), address)
+	defer memdConn.Close()
+
+	// The responses must be read so that the server does not stall.
+	go func() {
+		var packet memdPacket
+		for memdConn.ReadPacket(&packet) == nil {
+		}
+	}()
+
+	packet := &memdPacket{
+		Magic:  reqMagic,
+		Opcode: cmdSet,
+		Key:    []byte("key"),
+		Extras: make([]byte, 8),
+		Value:  make([]byte, 64),
+	}
+
+	b.SetBytes(int64(24 + len(packet.Extras) + len(packet.Key) + len(packet.Value)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := memdConn.QueuePacket(packet); err != nil {
+			b.Fatalf("Failed to write packet: %v", err)
+		}
+		if (i+1)%batchSize == 0 {
+			if err := memdConn.Flush(); err != nil {
+				b.Fatalf("Failed to flush packets: %v", err)
+			}
+		}
+	}
+	if err := memdConn.Flush(); err != nil {
+		b.Fatalf("Failed to flush packets: %v", err)
+	}
+}
+
+func BenchmarkMemdConnWriteUnbatched(b *testing.B) {
+	benchmarkMemdConnWrites(b, 1)
+}
+
+func BenchmarkMemdConnWriteBatched(b *testing.B) {
+	benchmarkMemdConnWrites(b, 64)
+}
@@ -1,6 +1,7 @@
 package gocbcore
 
 import (
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -52,6 +53,15 @@ type memdQRequest struct {
 	//  whenever the request is cancelled
 	waitingIn unsafe.Pointer
 
+	// This stores a pointer to the circuit breaker of the pipeline the
+	//  request was last dispatched to, which is told the outcome of the
+	//  request once it completes if the request reached a connection.
+	circuitBreaker unsafe.Pointer
+
+	// This is set once the request has been handed to a connection of
+	//  the pipeline it was dispatched to, and cleared when it is retried.
+	reachedConn uint32
+
 	// This keeps track of whether the request has been 'completed'
 	//  which is synonymous with the callback having been invoked.
 	//  This is an integer to allow us to atomically control it.
@@ -66,6 +76,38 @@ type memdQRequest struct {
 	// algorithms.
 	retryCount uint32
 
+	// This stores the reason for each retry of the request, for
+	// any reason, so that they can be logged and traced.
+	retryReasons []RetryReason
+	retryLock    sync.Mutex
+
+	// This tracks which vbucket map was used to route the latest attempt
+	// of the request, and which map its retries should be routed with
+	// following a not-my-vbucket response.
+	vbMapType        VbucketMapType
+	preferForwardMap bool
+	forwardMapFailed bool
+
+	// This stores the index of the server the latest attempt of the
+	// request was routed to.
+	serverIdx int
+
+	// RetryStrategy overrides the agent retry strategy for this request.
+	RetryStrategy RetryStrategy
+
+	// Priority selects the lane of the operation queue the request is placed into.
+	Priority RequestPriority
+
+	// Deadline is the point in time at which this request will be
+	// failed with ErrTimeout if it has not yet completed.  A zero
+	// value indicates that the request has no deadline.
+	Deadline time.Time
+
+	// This is signalled when the request completes before its deadline
+	// so that the goroutine watching the deadline timer can exit and
+	// return the timer to the pool.
+	deadlineDoneCh chan struct{}
+
 	RootTraceContext opentracing.SpanContext
 	cmdTraceSpan     opentracing.Span
 	netTraceSpan     opentracing.Span
@@ -82,7 +124,130 @@ func (req *memdQRequest) cloneNew() *memdQRequest {
 		Persistent:       req.Persistent,
 		owner:            req.owner,
 		RootTraceContext: req.RootTraceContext,
+		Deadline:         req.Deadline,
+		RetryStrategy:    req.RetryStrategy,
+		Priority:         req.Priority,
+	}
+}
+
+// RetryAttempts returns the number of times that this request has been retried.
+func (req *memdQRequest) RetryAttempts() uint32 {
+	req.retryLock.Lock()
+	defer req.retryLock.Unlock()
+	return uint32(len(req.retryReasons))
+}
+
+// RetryReasons returns the reasons for each of the retries of this request.
+func (req *memdQRequest) RetryReasons() []RetryReason {
+	req.retryLock.Lock()
+	defer req.retryLock.Unlock()
+	return append([]RetryReason(nil), req.retryReasons...)
+}
+
+// Idempotent returns whether this request can be safely applied multiple times.
+func (req *memdQRequest) Idempotent() bool {
+	return isIdempotentOpcode(req.Opcode)
+}
+
+// VbucketMap returns which vbucket map was used to route the latest attempt of this request.
+func (req *memdQRequest) VbucketMap() VbucketMapType {
+	req.retryLock.Lock()
+	defer req.retryLock.Unlock()
+	return req.vbMapType
+}
+
+func (req *memdQRequest) setVbucketMap(mapType VbucketMapType) {
+	req.retryLock.Lock()
+	req.vbMapType = mapType
+	req.retryLock.Unlock()
+}
+
+func (req *memdQRequest) shouldUseForwardMap(agentPrefersForward bool) bool {
+	req.retryLock.Lock()
+	defer req.retryLock.Unlock()
+	return !req.forwardMapFailed && (agentPrefersForward || req.preferForwardMap)
+}
+
+// recordNmv records a not-my-vbucket response against the map the request was routed
+// with.  It returns true if the request should now be routed using the fast-forward
+// map, or false if it should continue to use the current map.
+func (req *memdQRequest) recordNmv() bool {
+	req.retryLock.Lock()
+	defer req.retryLock.Unlock()
+
+	if req.vbMapType == VbucketMapForward {
+		req.forwardMapFailed = true
+		req.preferForwardMap = false
+		return false
+	}
+
+	req.preferForwardMap = !req.forwardMapFailed
+	return req.preferForwardMap
+}
+
+func (req *memdQRequest) recordRetryAttempt(reason RetryReason) {
+	req.retryLock.Lock()
+	req.retryReasons = append(req.retryReasons, reason)
+	req.retryLock.Unlock()
+}
+
+// logTimeout logs the retries of a request which has reached its deadline.  The
+// request itself is always failed with ErrTimeout.
+func (req *memdQRequest) logTimeout() {
+	reasons := req.RetryReasons()
+	if len(reasons) == 0 {
+		logDebugf("Request OP=0x%x. Opaque=%d timed out", req.Opcode, req.Opaque)
+		return
 	}
+
+	var reasonStrs []string
+	for _, reason := range reasons {
+		reasonStrs = append(reasonStrs, reason.String())
+	}
+	logDebugf("Request OP=0x%x. Opaque=%d timed out after %d retries (%s)",
+		req.Opcode, req.Opaque, len(reasons), strings.Join(reasonStrs, ", "))
+}
+
+// startDeadlineTimer arms the deadline for this request (if it has one).
+// It must be invoked before the request is dispatched.
+func (req *memdQRequest) startDeadlineTimer() {
+	if req.Deadline.IsZero() {
+		return
+	}
+
+	tmr := AcquireTimer(req.Deadline.Sub(time.Now()))
+	doneCh := make(chan struct{})
+	req.deadlineDoneCh = doneCh
+
+	go func() {
+		select {
+		case <-tmr.C:
+			ReleaseTimer(tmr, true)
+			req.logTimeout()
+			req.cancelWithCallback(ErrTimeout)
+		case <-doneCh:
+			ReleaseTimer(tmr, false)
+		}
+	}()
+}
+
+// stopDeadlineTimer releases the deadline timer.  This must only be invoked
+// by whoever successfully marked the request as completed.
+func (req *memdQRequest) stopDeadlineTimer() {
+	if req.deadlineDoneCh != nil {
+		close(req.deadlineDoneCh)
+	}
+}
+
+// abortDispatch is used when a request fails to be dispatched.  It returns
+// false if the deadline has already fired and the callback was invoked.
+func (req *memdQRequest) abortDispatch() bool {
+	if atomic.SwapUint32(&req.isCompleted, 1) != 0 {
+		return false
+	}
+
+	req.stopDeadlineTimer()
+	return true
 }
 
 func (req *memdQRequest) tryCallback(resp *memdQResponse, err error) bool {
@@ -93,6 +258,8 @@ func (req *memdQRequest) tryCallback(resp *memdQResponse, err error) bool {
 		}
 	} else {
 		if atomic.SwapUint32(&req.isCompleted, 1) == 0 {
+			req.stopDeadlineTimer()
+			req.markCircuitBreaker(err)
 			req.Callback(resp, req, err)
 			return true
 		}
@@ -101,11 +268,38 @@ func (req *memdQRequest) tryCallback(resp *memdQResponse, err error) bool {
 	return false
 }
 
+// setCircuitBreaker records the circuit breaker of the pipeline the request is dispatched
+// to, which may be nil.  The request has not yet reached a connection of the pipeline.
+func (req *memdQRequest) setCircuitBreaker(breaker *circuitBreaker) {
+	atomic.StoreUint32(&req.reachedConn, 0)
+	atomic.StorePointer(&req.circuitBreaker, unsafe.Pointer(breaker))
+}
+
+// markReachedConn records that the request has been handed to a connection, so that
+// its outcome reflects the health of the server.
+func (req *memdQRequest) markReachedConn() {
+	atomic.StoreUint32(&req.reachedConn, 1)
+}
+
+// markCircuitBreaker tells the circuit breaker of the pipeline the request was dispatched
+// to the outcome of the request.  Requests which never reached a connection, for example
+// because they timed out while queued, are not counted.
+func (req *memdQRequest) markCircuitBreaker(err error) {
+	if atomic.LoadUint32(&req.reachedConn) == 0 {
+		return
+	}
+
+	breaker := (*circuitBreaker)(atomic.LoadPointer(&req.circuitBreaker))
+	if breaker != nil {
+		breaker.MarkCompleted(err)
+	}
+}
+
 func (req *memdQRequest) isCancelled() bool {
 	return atomic.LoadUint32(&req.isCompleted) != 0
 }
 
-func (req *memdQRequest) Cancel() bool {
+func (req *memdQRequest) internalCancel(err error) bool {
 	req.processingLock.Lock()
 
 	if atomic.SwapUint32(&req.isCompleted, 1) != 0 {
@@ -124,7 +318,27 @@ func (req *memdQRequest) Cancel() bool {
 		waitingIn.CancelRequest(req)
 	}
 
-	req.owner.cancelReqTrace(req, ErrCancelled)
+	req.owner.cancelReqTrace(req, err)
 	req.processingLock.Unlock()
 	return true
 }
+
+// cancelWithCallback cancels the request and invokes its callback with the
+// provided error.  This is used when the request has reached its deadline.
+func (req *memdQRequest) cancelWithCallback(err error) {
+	if !req.internalCancel(err) {
+		return
+	}
+
+	req.markCircuitBreaker(err)
+	req.Callback(nil, req, err)
+}
+
+func (req *memdQRequest) Cancel() bool {
+	if !req.internalCancel(ErrCancelled) {
+		return false
+	}
+
+	req.stopDeadlineTimer()
+	return true
+}
@@ -0,0 +1,66 @@
+package gocbcore
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRequestDeadlineQueued(t *testing.T) {
+	q := newMemdOpQueue()
+	errCh := make(chan error, 2)
+
+	req := &memdQRequest{
+		memdPacket: memdPacket{},
+		Callback: func(resp *memdQResponse, req *memdQRequest, err error) {
+			errCh <- err
+		},
+		Deadline: time.Now().Add(50 * time.Millisecond),
+		owner:    &Agent{},
+	}
+
+	if err := q.Push(req, 0); err != nil {
+		t.Fatalf("Failed to queue request: %v", err)
+	}
+	req.startDeadlineTimer()
+
+	select {
+	case err := <-errCh:
+		if err != ErrTimeout {
+			t.Fatalf("Expected timeout error but got %v", err)
+		}
+	case <-time.After(1 * time.Second):
+		t.Fatalf("Request deadline was never reached")
+	}
+
+	if q.Remove(req) {
+		t.Fatalf("The request should have been removed from the queue")
+	}
+
+	if req.tryCallback(nil, nil) {
+		t.Fatalf("The request should not be completable after timing out")
+	}
+}
+
+func TestRequestDeadlineCompleted(t *testing.T) {
+	req := &memdQRequest{
+		memdPacket: memdPacket{},
+		Callback: func(resp *memdQResponse, req *memdQRequest, err error) {
+			if err != nil {
+				t.Errorf("Expected no error but got %v", err)
+			}
+		},
+		Deadline: time.Now().Add(20 * time.Millisecond),
+		owner:    &Agent{},
+	}
+	req.startDeadlineTimer()
+
+	if !req.tryCallback(nil, nil) {
+		t.Fatalf("The request should have completed")
+	}
+
+	time.Sleep(50 * time.Millisecond)
+
+	if req.Cancel() {
+		t.Fatalf("The request should not be cancellable after completing")
+	}
+}
@@ -0,0 +1,213 @@
+package gocbcore
+
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
+// RetryReason represents the reason that an operation may be retried.
+type RetryReason uint8
+
+const (
+	// RetryReasonUnknown indicates that the operation was retried for an unknown reason.
+	RetryReasonUnknown = RetryReason(0)
+
+	// RetryReasonNotMyVbucket indicates that the operation was sent to the wrong server.
+	RetryReasonNotMyVbucket = RetryReason(1)
+
+	// RetryReasonTmpFail indicates that the server was temporarily unable to handle the operation.
+	RetryReasonTmpFail = RetryReason(2)
+
+	// RetryReasonLocked indicates that the document targeted by the operation was locked.
+	RetryReasonLocked = RetryReason(3)
+
+	// RetryReasonCollectionUnknown indicates that the collection id used by the operation was outdated.
+	RetryReasonCollectionUnknown = RetryReason(4)
+
+	// RetryReasonSocketNotAvailable indicates that the operation could not be written to the network.
+	RetryReasonSocketNotAvailable = RetryReason(5)
+
+	// RetryReasonSocketClosedWhileInFlight indicates that the connection was closed after the
+	// operation was written but before a response was received.
+	RetryReasonSocketClosedWhileInFlight = RetryReason(6)
+
+	// RetryReasonKvErrMap indicates that the server error map requested the operation be retried.
+	RetryReasonKvErrMap = RetryReason(7)
+)
+
+// String returns a human readable name for the retry reason.
+func (reason RetryReason) String() string {
+	switch reason {
+	case RetryReasonNotMyVbucket:
+		return "NotMyVbucket"
+	case RetryReasonTmpFail:
+		return "TmpFail"
+	case RetryReasonLocked:
+		return "Locked"
+	case RetryReasonCollectionUnknown:
+		return "CollectionUnknown"
+	case RetryReasonSocketNotAvailable:
+		return "SocketNotAvailable"
+	case RetryReasonSocketClosedWhileInFlight:
+		return "SocketClosedWhileInFlight"
+	case RetryReasonKvErrMap:
+		return "KvErrMap"
+	}
+
+	return "Unknown"
+}
+
+// AllowsNonIdempotentRetry indicates whether an operation which is not idempotent
+// may safely be retried for this reason.  This is only the case when we know that
+// the server did not apply the operation.
+func (reason RetryReason) AllowsNonIdempotentRetry() bool {
+	return reason != RetryReasonUnknown && reason != RetryReasonSocketClosedWhileInFlight
+}
+
+// AlwaysRetry indicates whether operations are always retried for this reason,
+// regardless of the retry strategy in use.  These reasons are resolved by the
+// client itself updating its view of the cluster.
+func (reason RetryReason) AlwaysRetry() bool {
+	return reason == RetryReasonNotMyVbucket || reason == RetryReasonCollectionUnknown
+}
+
+// RetryRequest is the view of an operation provided to a RetryStrategy.
+type RetryRequest interface {
+	RetryAttempts() uint32
+	RetryReasons() []RetryReason
+	Idempotent() bool
+	VbucketMap() VbucketMapType
+}
+
+// RetryStrategy determines whether an operation should be retried, and how long
+// to wait before doing so.
+type RetryStrategy interface {
+	RetryAfter(req RetryRequest, reason RetryReason) (time.Duration, bool)
+}
+
+// BackoffCalculator returns the time to wait before performing the given retry attempt.
+type BackoffCalculator func(retryAttempts uint32) time.Duration
+
+// ExponentialBackoff returns a BackoffCalculator which starts at min and grows by
+// backoffFactor for each attempt, up to a maximum of max.
+func ExponentialBackoff(min, max time.Duration, backoffFactor float64) BackoffCalculator {
+	if backoffFactor <= 0 {
+		backoffFactor = 2
+	}
+
+	return func(retryAttempts uint32) time.Duration {
+		backoff := float64(min) * math.Pow(backoffFactor, float64(retryAttempts))
+		if backoff > float64(max) || math.IsInf(backoff, 1) {
+			return max
+		}
+		if backoff < float64(min) {
+			return min
+		}
+		return time.Duration(backoff)
+	}
+}
+
+// jitterBackoff randomises a backoff duration to between half and all of its
+// value, so that competing clients do not retry in lockstep.
+func jitterBackoff(waitDura time.Duration) time.Duration {
+	if waitDura <= 0 {
+		return waitDura
+	}
+	return waitDura/2 + time.Duration(rand.Int63n(int64(waitDura/2)+1))
+}
+
+// BestEffortRetryStrategy retries operations whenever it is safe to do so, until
+// they either succeed or reach their deadline.  It should only be used with
+// operations which have a deadline set.
+type BestEffortRetryStrategy struct {
+	backoff BackoffCalculator
+}
+
+// NewBestEffortRetryStrategy returns a new BestEffortRetryStrategy which will use
+// the supplied calculator to determine the backoff.  If nil is supplied, an
+// exponential backoff between 1ms and 500ms is used.
+func NewBestEffortRetryStrategy(calculator BackoffCalculator) *BestEffortRetryStrategy {
+	if calculator == nil {
+		calculator = ExponentialBackoff(1*time.Millisecond, 500*time.Millisecond, 2)
+	}
+
+	return &BestEffortRetryStrategy{
+		backoff: calculator,
+	}
+}
+
+// RetryAfter calculates whether, and after how long, the operation should be retried.
+func (rs *BestEffortRetryStrategy) RetryAfter(req RetryRequest, reason RetryReason) (time.Duration, bool) {
+	if !req.Idempotent() && !reason.AllowsNonIdempotentRetry() {
+		return 0, false
+	}
+
+	return rs.backoff(req.RetryAttempts()), true
+}
+
+// FailFastRetryStrategy never retries an operation, other than for reasons which
+// are always retried.
+type FailFastRetryStrategy struct {
+}
+
+// NewFailFastRetryStrategy returns a new FailFastRetryStrategy.
+func NewFailFastRetryStrategy() *FailFastRetryStrategy {
+	return &FailFastRetryStrategy{}
+}
+
+// RetryAfter calculates whether, and after how long, the operation should be retried.
+func (rs *FailFastRetryStrategy) RetryAfter(req RetryRequest, reason RetryReason) (time.Duration, bool) {
+	return 0, false
+}
+
+// ExponentialBackoffRetryStrategy retries operations up to a maximum number of
+// times, waiting exponentially longer between each attempt.
+type ExponentialBackoffRetryStrategy struct {
+	maxRetries uint32
+	backoff    BackoffCalculator
+}
+
+// NewExponentialBackoffRetryStrategy returns a new ExponentialBackoffRetryStrategy
+// which waits between min and max, growing by backoffFactor, for up to maxRetries retries.
+func NewExponentialBackoffRetryStrategy(min, max time.Duration, backoffFactor float64, maxRetries uint32) *ExponentialBackoffRetryStrategy {
+	return &ExponentialBackoffRetryStrategy{
+		maxRetries: maxRetries,
+		backoff:    ExponentialBackoff(min, max, backoffFactor),
+	}
+}
+
+// RetryAfter calculates whether, and after how long, the operation should be retried.
+func (rs *ExponentialBackoffRetryStrategy) RetryAfter(req RetryRequest, reason RetryReason) (time.Duration, bool) {
+	if !req.Idempotent() && !reason.AllowsNonIdempotentRetry() {
+		return 0, false
+	}
+
+	if req.RetryAttempts() >= rs.maxRetries {
+		return 0, false
+	}
+
+	return rs.backoff(req.RetryAttempts()), true
+}
+
+// errMapRetryStrategy is used when no retry strategy has been specified.  It only
+// retries operations which the server error map has indicated should be retried,
+// with the delay being determined by the error map.
+type errMapRetryStrategy struct {
+}
+
+func (rs *errMapRetryStrategy) RetryAfter(req RetryRequest, reason RetryReason) (time.Duration, bool) {
+	return 0, reason == RetryReasonKvErrMap
+}
+
+func isIdempotentOpcode(opcode commandCode) bool {
+	switch opcode {
+	case cmdGet, cmdGetReplica, cmdGetMeta, cmdGetRandom, cmdNoop, cmdStat,
+		cmdObserve, cmdObserveSeqNo, cmdSubDocGet, cmdSubDocExists, cmdSubDocGetCount,
+		cmdSubDocMultiLookup, cmdGetClusterConfig, cmdGetErrorMap, cmdGetAllVBSeqnos,
+		cmdDcpGetFailoverLog, cmdCollectionsGetID, cmdCollectionsGetManifest:
+		return true
+	}
+
+	return false
+}
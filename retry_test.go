@@ -0,0 +1,138 @@
+package gocbcore
+
+import (
+	"testing"
+	"time"
+
+	"github.com/opentracing/opentracing-go/mocktracer"
+)
+
+func TestExponentialBackoff(t *testing.T) {
+	calc := ExponentialBackoff(1*time.Millisecond, 10*time.Millisecond, 2)
+
+	expected := []time.Duration{
+		1 * time.Millisecond,
+		2 * time.Millisecond,
+		4 * time.Millisecond,
+		8 * time.Millisecond,
+		10 * time.Millisecond,
+		10 * time.Millisecond,
+	}
+	for i, exp := range expected {
+		if backoff := calc(uint32(i)); backoff != exp {
+			t.Fatalf("Expected backoff of %v for attempt %d but got %v", exp, i, backoff)
+		}
+	}
+
+	if backoff := calc(10000); backoff != 10*time.Millisecond {
+		t.Fatalf("Expected backoff to be capped at max but got %v", backoff)
+	}
+}
+
+func TestBestEffortRetryStrategy(t *testing.T) {
+	rs := NewBestEffortRetryStrategy(nil)
+
+	getReq := &memdQRequest{memdPacket: memdPacket{Opcode: cmdGet}}
+	setReq := &memdQRequest{memdPacket: memdPacket{Opcode: cmdSet}}
+
+	if _, ok := rs.RetryAfter(getReq, RetryReasonSocketClosedWhileInFlight); !ok {
+		t.Fatalf("Idempotent operations should be retried")
+	}
+	if _, ok := rs.RetryAfter(setReq, RetryReasonSocketClosedWhileInFlight); ok {
+		t.Fatalf("Non-idempotent operations should not be retried after being sent")
+	}
+	if _, ok := rs.RetryAfter(setReq, RetryReasonTmpFail); !ok {
+		t.Fatalf("Non-idempotent operations should be retried when the server did not apply them")
+	}
+}
+
+func TestFailFastRetryStrategy(t *testing.T) {
+	rs := NewFailFastRetryStrategy()
+
+	getReq := &memdQRequest{memdPacket: memdPacket{Opcode: cmdGet}}
+	if _, ok := rs.RetryAfter(getReq, RetryReasonTmpFail); ok {
+		t.Fatalf("Fail fast strategy should never retry")
+	}
+}
+
+func TestExponentialBackoffRetryStrategy(t *testing.T) {
+	rs := NewExponentialBackoffRetryStrategy(1*time.Millisecond, 5*time.Millisecond, 2, 2)
+
+	req := &memdQRequest{memdPacket: memdPacket{Opcode: cmdGet}}
+	for i := 0; i < 2; i++ {
+		wait, ok := rs.RetryAfter(req, RetryReasonLocked)
+		if !ok {
+			t.Fatalf("Expected attempt %d to be retried", i)
+		}
+		if wait <= 0 {
+			t.Fatalf("Expected a positive backoff for attempt %d", i)
+		}
+		req.recordRetryAttempt(RetryReasonLocked)
+	}
+
+	if _, ok := rs.RetryAfter(req, RetryReasonLocked); ok {
+		t.Fatalf("Expected retries to stop once max retries was reached")
+	}
+}
+
+func TestRetriedRequestTimeout(t *testing.T) {
+	errCh := make(chan error, 1)
+	req := &memdQRequest{
+		memdPacket: memdPacket{Opcode: cmdGet},
+		Callback: func(resp *memdQResponse, req *memdQRequest, err error) {
+			errCh <- err
+		},
+		Deadline: time.Now().Add(10 * time.Millisecond),
+	}
+
+	req.recordRetryAttempt(RetryReasonTmpFail)
+	req.recordRetryAttempt(RetryReasonNotMyVbucket)
+
+	if req.RetryAttempts() != 2 {
+		t.Fatalf("Expected 2 retry attempts but got %d", req.RetryAttempts())
+	}
+	reasons := req.RetryReasons()
+	if len(reasons) != 2 || reasons[0] != RetryReasonTmpFail || reasons[1] != RetryReasonNotMyVbucket {
+		t.Fatalf("Unexpected retry reasons %v", reasons)
+	}
+	if req.retryCount != 0 {
+		t.Fatalf("Expected only error map retries to count towards the error map retry delay")
+	}
+
+	// A retried request still times out with the ErrTimeout sentinel.
+	req.startDeadlineTimer()
+	select {
+	case err := <-errCh:
+		if err != ErrTimeout {
+			t.Fatalf("Expected ErrTimeout but got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timed out waiting for the deadline")
+	}
+}
+
+func TestRetriedRequestTimeoutTrace(t *testing.T) {
+	tracer := mocktracer.New()
+	agent := &Agent{tracer: tracer}
+	req := &memdQRequest{
+		memdPacket:       memdPacket{Opcode: cmdGet},
+		RootTraceContext: tracer.StartSpan("GetEx").Context(),
+	}
+
+	req.recordRetryAttempt(RetryReasonTmpFail)
+	req.recordRetryAttempt(RetryReasonLocked)
+
+	agent.startCmdTrace(req)
+	agent.cancelReqTrace(req, ErrTimeout)
+
+	spans := tracer.FinishedSpans()
+	if len(spans) != 1 {
+		t.Fatalf("Expected 1 finished span but got %d", len(spans))
+	}
+	if retries := spans[0].Tag("retry"); retries != 2 {
+		t.Fatalf("Expected the span to record 2 retries but got %v", retries)
+	}
+	if reasons := spans[0].Tag("retry_reasons"); reasons != "TmpFail,Locked" {
+		t.Fatalf("Expected the span to record every retry reason but got %v", reasons)
+	}
+}
@@ -2,21 +2,40 @@ package gocbcore
 
 import (
 	"fmt"
+	"net"
+	"strconv"
 	"strings"
 )
 
+// routeNode describes a single node of the cluster and the services it runs.  Nodes
+// are identified by the address of their management service.
+type routeNode struct {
+	address      string
+	services     []ServiceType
+	hostname     string
+	ports        cfgNodeServices
+	altAddresses map[string]cfgNodeAltAddress
+	serverGroup  string
+}
+
 type routeConfig struct {
 	revId        int64
 	uuid         string
 	bktType      bucketType
 	kvServerList []string
+	kvGroupList  []string
 	capiEpList   []string
 	mgmtEpList   []string
 	n1qlEpList   []string
 	ftsEpList    []string
 	cbasEpList   []string
 	vbMap        *vbucketMap
+	vbMapForward *vbucketMap
 	ketamaMap    *ketamaContinuum
+	nodes        []routeNode
+	capabilities []string
+	fromCache    bool
+	forceApply   bool
 }
 
 func (config *routeConfig) IsValid() bool {
@@ -28,6 +47,8 @@ func (config *routeConfig) IsValid() bool {
 		return config.vbMap != nil && config.vbMap.IsValid()
 	case bktTypeMemcached:
 		return config.ketamaMap != nil && config.ketamaMap.IsValid()
+	case bktTypeNone:
+		return true
 	default:
 		return false
 	}
@@ -35,11 +56,13 @@ func (config *routeConfig) IsValid() bool {
 
 func buildRouteConfig(bk *cfgBucket, useSsl bool, networkType string, firstConnect bool) *routeConfig {
 	var kvServerList []string
+	var kvGroupList []string
 	var capiEpList []string
 	var mgmtEpList []string
 	var n1qlEpList []string
 	var ftsEpList []string
 	var cbasEpList []string
+	var nodes []routeNode
 	var bktType bucketType
 
 	switch bk.NodeLocator {
@@ -48,6 +71,11 @@ func buildRouteConfig(bk *cfgBucket, useSsl bool, networkType string, firstConne
 	case "vbucket":
 		bktType = bktTypeCouchbase
 	default:
+		if bk.NodeLocator == "" && bk.Name == "" {
+			// Cluster configs describe the nodes of the cluster but no bucket.
+			bktType = bktTypeNone
+			break
+		}
 		logDebugf("Invalid nodeLocator %s", bk.NodeLocator)
 		bktType = bktTypeInvalid
 	}
@@ -82,53 +110,83 @@ func buildRouteConfig(bk *cfgBucket, useSsl bool, networkType string, firstConne
 				}
 			}
 
+			rn := routeNode{
+				hostname:     node.Hostname,
+				ports:        node.Services,
+				altAddresses: node.AltAddresses,
+				serverGroup:  node.ServerGroup,
+			}
+			if rn.hostname == "" {
+				rn.hostname = bk.SourceHostname
+			} else if strings.Contains(rn.hostname, ":") {
+				rn.hostname = "[" + rn.hostname + "]"
+			}
+
 			if !useSsl {
+				rn.address = fmt.Sprintf("%s:%d", hostname, ports.Mgmt)
 				if ports.Kv > 0 {
-					if i >= lenNodes {
+					if i >= lenNodes && bktType != bktTypeNone {
 						logDebugf("KV node present in nodesext but not in nodes for %s:%d", hostname, ports.Kv)
 					} else {
 						kvServerList = append(kvServerList, fmt.Sprintf("%s:%d", hostname, ports.Kv))
+						kvGroupList = append(kvGroupList, node.ServerGroup)
+						rn.services = append(rn.services, MemdService)
 					}
 				}
 				if ports.Capi > 0 {
 					capiEpList = append(capiEpList, fmt.Sprintf("http://%s:%d/%s", hostname, ports.Capi, bk.Name))
+					rn.services = append(rn.services, CapiService)
 				}
 				if ports.Mgmt > 0 {
 					mgmtEpList = append(mgmtEpList, fmt.Sprintf("http://%s:%d", hostname, ports.Mgmt))
+					rn.services = append(rn.services, MgmtService)
 				}
 				if ports.N1ql > 0 {
 					n1qlEpList = append(n1qlEpList, fmt.Sprintf("http://%s:%d", hostname, ports.N1ql))
+					rn.services = append(rn.services, N1qlService)
 				}
 				if ports.Fts > 0 {
 					ftsEpList = append(ftsEpList, fmt.Sprintf("http://%s:%d", hostname, ports.Fts))
+					rn.services = append(rn.services, FtsService)
 				}
 				if ports.Cbas > 0 {
 					cbasEpList = append(cbasEpList, fmt.Sprintf("http://%s:%d", hostname, ports.Cbas))
+					rn.services = append(rn.services, CbasService)
 				}
 			} else {
+				rn.address = fmt.Sprintf("%s:%d", hostname, ports.MgmtSsl)
 				if ports.KvSsl > 0 {
-					if i >= lenNodes {
+					if i >= lenNodes && bktType != bktTypeNone {
 						logDebugf("KV node present in nodesext but not in nodes for %s:%d", hostname, ports.KvSsl)
 					} else {
 						kvServerList = append(kvServerList, fmt.Sprintf("%s:%d", hostname, ports.KvSsl))
+						kvGroupList = append(kvGroupList, node.ServerGroup)
+						rn.services = append(rn.services, MemdService)
 					}
 				}
 				if ports.CapiSsl > 0 {
 					capiEpList = append(capiEpList, fmt.Sprintf("https://%s:%d/%s", hostname, ports.CapiSsl, bk.Name))
+					rn.services = append(rn.services, CapiService)
 				}
 				if ports.MgmtSsl > 0 {
 					mgmtEpList = append(mgmtEpList, fmt.Sprintf("https://%s:%d", hostname, ports.MgmtSsl))
+					rn.services = append(rn.services, MgmtService)
 				}
 				if ports.N1qlSsl > 0 {
 					n1qlEpList = append(n1qlEpList, fmt.Sprintf("https://%s:%d", hostname, ports.N1qlSsl))
+					rn.services = append(rn.services, N1qlService)
 				}
 				if ports.FtsSsl > 0 {
 					ftsEpList = append(ftsEpList, fmt.Sprintf("https://%s:%d", hostname, ports.FtsSsl))
+					rn.services = append(rn.services, FtsService)
 				}
 				if ports.CbasSsl > 0 {
 					cbasEpList = append(cbasEpList, fmt.Sprintf("https://%s:%d", hostname, ports.CbasSsl))
+					rn.services = append(rn.services, CbasService)
 				}
 			}
+
+			nodes = append(nodes, rn)
 		}
 	} else {
 		if useSsl {
@@ -136,19 +194,45 @@ func buildRouteConfig(bk *cfgBucket, useSsl bool, networkType string, firstConne
 			return &routeConfig{}
 		}
 
+		// The server list is not ordered by node, so the server groups are matched to
+		// it by the data address of each node.
+		kvGroups := make(map[string]string)
+
 		if bktType == bktTypeCouchbase {
 			kvServerList = bk.VBucketServerMap.ServerList
 		}
 
 		for _, node := range bk.Nodes {
+			rn := routeNode{
+				address:     node.Hostname,
+				serverGroup: node.ServerGroup,
+			}
+			if host, err := hostFromHostPort(node.Hostname); err == nil {
+				rn.hostname = host
+			}
+			if _, port, err := net.SplitHostPort(node.Hostname); err == nil {
+				if mgmtPort, err := strconv.ParseUint(port, 10, 16); err == nil {
+					rn.ports.Mgmt = uint16(mgmtPort)
+				}
+			}
+			rn.ports.Kv = uint16(node.Ports["direct"])
+			if rn.hostname != "" {
+				kvGroups[fmt.Sprintf("%s:%d", rn.hostname, rn.ports.Kv)] = node.ServerGroup
+			}
+
+			if bktType == bktTypeCouchbase {
+				rn.services = append(rn.services, MemdService)
+			}
 			if node.CouchAPIBase != "" {
 				// Slice off the UUID as Go's HTTP client cannot handle being passed URL-Encoded path values.
 				capiEp := strings.SplitN(node.CouchAPIBase, "%2B", 2)[0]
 
 				capiEpList = append(capiEpList, capiEp)
+				rn.services = append(rn.services, CapiService)
 			}
 			if node.Hostname != "" {
 				mgmtEpList = append(mgmtEpList, fmt.Sprintf("http://%s", node.Hostname))
+				rn.services = append(rn.services, MgmtService)
 			}
 
 			if bktType == bktTypeMemcached {
@@ -161,7 +245,14 @@ func buildRouteConfig(bk *cfgBucket, useSsl bool, networkType string, firstConne
 
 				curKvHost := fmt.Sprintf("%s:%d", host, node.Ports["direct"])
 				kvServerList = append(kvServerList, curKvHost)
+				rn.services = append(rn.services, MemdService)
 			}
+
+			nodes = append(nodes, rn)
+		}
+
+		for _, kvServer := range kvServerList {
+			kvGroupList = append(kvGroupList, kvGroups[kvServer])
 		}
 	}
 
@@ -169,18 +260,27 @@ func buildRouteConfig(bk *cfgBucket, useSsl bool, networkType string, firstConne
 		revId:        bk.Rev,
 		uuid:         bk.UUID,
 		kvServerList: kvServerList,
+		kvGroupList:  kvGroupList,
 		capiEpList:   capiEpList,
 		mgmtEpList:   mgmtEpList,
 		n1qlEpList:   n1qlEpList,
 		ftsEpList:    ftsEpList,
 		cbasEpList:   cbasEpList,
 		bktType:      bktType,
+		nodes:        nodes,
+		capabilities: bk.Capabilities,
 	}
 
 	if bktType == bktTypeCouchbase {
 		vbMap := bk.VBucketServerMap.VBucketMap
 		numReplicas := bk.VBucketServerMap.NumReplicas
 		rc.vbMap = newVbucketMap(vbMap, numReplicas)
+
+		// The fast-forward map is only present while a rebalance is in progress.
+		vbMapForward := bk.VBucketServerMap.VBucketMapForward
+		if len(vbMapForward) == len(vbMap) && len(vbMapForward) > 0 {
+			rc.vbMapForward = newVbucketMap(vbMapForward, numReplicas)
+		}
 	} else if bktType == bktTypeMemcached {
 		rc.ketamaMap = newKetamaContinuum(kvServerList)
 	}
@@ -4,6 +4,8 @@ import (
 	"encoding/json"
 	"io/ioutil"
 	"testing"
+
+	"github.com/opentracing/opentracing-go"
 )
 
 func loadConfigFromFile(t *testing.T, filename string) (cfg *cfgBucket) {
@@ -93,3 +95,102 @@ func TestNodeExtConfig(t *testing.T) {
 		t.Fatalf("Expected 3 kv nodes, got %d", len(cfg.kvServerList))
 	}
 }
+
+func TestForwardVbucketMapConfig(t *testing.T) {
+	cfgBk := loadConfigFromFile(t, "testdata/bucket_config_with_external_addresses.json")
+
+	cfg := buildRouteConfig(cfgBk, false, "default", false)
+	if cfg.vbMapForward != nil {
+		t.Fatalf("Expected no fast-forward map outside of a rebalance")
+	}
+
+	numVbuckets := len(cfgBk.VBucketServerMap.VBucketMap)
+	cfgBk.VBucketServerMap.VBucketMapForward = make([][]int, numVbuckets)
+	for vbID := range cfgBk.VBucketServerMap.VBucketMapForward {
+		cfgBk.VBucketServerMap.VBucketMapForward[vbID] = []int{1, 0}
+	}
+
+	cfg = buildRouteConfig(cfgBk, false, "default", false)
+	if cfg.vbMapForward == nil || cfg.vbMapForward.NumVbuckets() != numVbuckets {
+		t.Fatalf("Expected the fast-forward map to be parsed")
+	}
+
+	topology := newClusterTopology(cfg)
+	if len(topology.VbucketMapForward) != numVbuckets || topology.VbucketMapForward[0][0] != 1 {
+		t.Fatalf("Expected the fast-forward map in the topology")
+	}
+
+	// A fast-forward map which does not match the current map is ignored.
+	cfgBk.VBucketServerMap.VBucketMapForward = cfgBk.VBucketServerMap.VBucketMapForward[:1]
+	cfg = buildRouteConfig(cfgBk, false, "default", false)
+	if cfg.vbMapForward != nil {
+		t.Fatalf("Expected a mismatched fast-forward map to be ignored")
+	}
+}
+
+func TestServerGroupConfig(t *testing.T) {
+	cfgBk := loadConfigFromFile(t, "testdata/bucket_config_with_external_addresses.json")
+	groups := []string{"group_a", "group_b", "group_a"}
+	for i := range cfgBk.NodesExt {
+		cfgBk.NodesExt[i].ServerGroup = groups[i]
+	}
+	for i := range cfgBk.Nodes {
+		cfgBk.Nodes[i].ServerGroup = groups[i]
+	}
+
+	cfg := buildRouteConfig(cfgBk, false, "default", false)
+	if len(cfg.kvGroupList) != len(groups) || cfg.kvGroupList[1] != "group_b" {
+		t.Fatalf("Unexpected server groups %v", cfg.kvGroupList)
+	}
+	if newClusterTopology(cfg).Nodes[1].ServerGroup != "group_b" {
+		t.Fatalf("Expected the server group in the topology")
+	}
+
+	// Without nodesExt the groups are matched to the server list by address.
+	cfgBk.NodesExt = nil
+	cfgBk.VBucketServerMap.ServerList = []string{"172.17.0.3:11210", "172.17.0.2:11210", "172.17.0.4:11210"}
+	cfg = buildRouteConfig(cfgBk, false, "default", false)
+	if len(cfg.kvGroupList) != 3 || cfg.kvGroupList[0] != "group_b" || cfg.kvGroupList[1] != "group_a" {
+		t.Fatalf("Unexpected server groups without nodesExt %v", cfg.kvGroupList)
+	}
+
+	// Every vbucket is active on the first server and replicated to the second.
+	entries := make([][]int, cfg.vbMap.NumVbuckets())
+	for vbID := range entries {
+		entries[vbID] = []int{0, 1}
+	}
+	agent := &Agent{
+		tracer: opentracing.NoopTracer{},
+	}
+	agent.routingInfo.Update(nil, &routeData{
+		revId:  cfg.revId,
+		vbMap:  newVbucketMap(entries, 1),
+		source: cfg,
+	})
+
+	groupIdxs, otherIdxs := agent.replicaIdxsByServerGroup([]byte("key"), "group_a")
+	if len(groupIdxs) != 1 || groupIdxs[0] != 1 || len(otherIdxs) != 1 || otherIdxs[0] != 0 {
+		t.Fatalf("Unexpected copies for group_a %v, %v", groupIdxs, otherIdxs)
+	}
+
+	groupIdxs, otherIdxs = agent.replicaIdxsByServerGroup([]byte("key"), "group_c")
+	if len(groupIdxs) != 0 || len(otherIdxs) != 2 {
+		t.Fatalf("Unexpected copies for group_c %v, %v", groupIdxs, otherIdxs)
+	}
+
+	groupIdxs, otherIdxs = agent.replicaIdxsByServerGroup([]byte("key"), "")
+	if len(groupIdxs) != 2 || len(otherIdxs) != 0 {
+		t.Fatalf("Expected every copy without a server group %v, %v", groupIdxs, otherIdxs)
+	}
+
+	agent.preferredServerGroup = "group_c"
+	_, err := agent.GetAnyReplicaEx(GetAnyReplicaOptions{
+		Key:      []byte("key"),
+		ReadMode: ReplicaReadModeServerGroupOnly,
+	}, func(*ReplicaReadResult, error) {
+		t.Errorf("Expected the callback not to be invoked")
+	})
+	if err != ErrNoServerGroupReplicas {
+		t.Fatalf("Expected a group-only read without copies in the group to fail, got %v", err)
+	}
+}
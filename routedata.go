@@ -11,9 +11,10 @@ type routeData struct {
 	uuid    string
 	bktType bucketType
 
-	ketamaMap *ketamaContinuum
-	vbMap     *vbucketMap
-	clientMux *memdClientMux
+	ketamaMap    *ketamaContinuum
+	vbMap        *vbucketMap
+	vbMapForward *vbucketMap
+	clientMux    *memdClientMux
 
 	capiEpList []string
 	mgmtEpList []string
@@ -29,6 +30,10 @@ func (rd *routeData) DebugString() string {
 
 	outStr += fmt.Sprintf("Revision ID: %d\n", rd.revId)
 
+	if rd.vbMapForward != nil {
+		outStr += "Fast-Forward Vbucket Map: present\n"
+	}
+
 	outStr += "Client Multiplexer:"
 	outStr += reindentLog("  ", rd.clientMux.debugString()) + "\n"
 
@@ -10,3 +10,75 @@ func TestRouteDataPtr(t *testing.T) {
 		t.Errorf("Route Data should start with nil")
 	}
 }
+
+func TestForwardVbucketMapRouting(t *testing.T) {
+	agent := &Agent{}
+	agent.routingInfo.Update(nil, &routeData{
+		revId:        1,
+		bktType:      bktTypeCouchbase,
+		vbMap:        newVbucketMap([][]int{{0}, {0}}, 0),
+		vbMapForward: newVbucketMap([][]int{{1}, {1}}, 0),
+		clientMux:    newMemdClientMux([]string{"a:11210", "b:11210"}, 0, 0, nil),
+	})
+
+	routeAddress := func(req *memdQRequest) string {
+		pipeline, err := agent.routeRequest(req)
+		if err != nil {
+			t.Fatalf("Failed to route request: %v", err)
+		}
+		return pipeline.Address()
+	}
+
+	req := &memdQRequest{
+		memdPacket: memdPacket{
+			Key: []byte("key"),
+		},
+	}
+	if routeAddress(req) != "a:11210" || req.VbucketMap() != VbucketMapCurrent {
+		t.Fatalf("Expected the current map to be used initially")
+	}
+
+	// After being rejected by the current owner, the future owner is used.
+	if !req.recordNmv() {
+		t.Fatalf("Expected the fast-forward map to be used after an NMV")
+	}
+	if routeAddress(req) != "b:11210" || req.VbucketMap() != VbucketMapForward {
+		t.Fatalf("Expected the fast-forward map to be used after an NMV")
+	}
+	if req.serverIdx != 1 {
+		t.Fatalf("Expected the index of the server the request was routed to, got %d", req.serverIdx)
+	}
+
+	// After being rejected by the future owner too, the request sticks to the current map.
+	if req.recordNmv() {
+		t.Fatalf("Expected the current map to be used after an NMV from the future owner")
+	}
+	if routeAddress(req) != "a:11210" || req.VbucketMap() != VbucketMapCurrent {
+		t.Fatalf("Expected the current map to be used after an NMV from the future owner")
+	}
+	if req.recordNmv() {
+		t.Fatalf("Expected the fast-forward map not to be used again")
+	}
+
+	// Requests can be routed with the fast-forward map up front.
+	agent.useForwardVbMap = true
+	preemptiveReq := &memdQRequest{
+		memdPacket: memdPacket{
+			Key: []byte("key"),
+		},
+	}
+	if routeAddress(preemptiveReq) != "b:11210" || preemptiveReq.VbucketMap() != VbucketMapForward {
+		t.Fatalf("Expected the fast-forward map to be used preemptively")
+	}
+
+	// Persistent requests always go to the current owner.
+	persistentReq := &memdQRequest{
+		memdPacket: memdPacket{
+			Vbucket: 1,
+		},
+		Persistent: true,
+	}
+	if routeAddress(persistentReq) != "a:11210" || persistentReq.VbucketMap() != VbucketMapCurrent {
+		t.Fatalf("Expected persistent requests to use the current map")
+	}
+}
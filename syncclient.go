@@ -31,6 +31,9 @@ func (wrap *memdPipelineSenderWrap) SendRequest(req *memdQRequest) error {
 
 type syncClient struct {
 	client memdSenderClient
+
+	// Requests are abandoned with ErrShutdown once this is closed.
+	closeNotify <-chan struct{}
 }
 
 func (client *syncClient) SupportsFeature(feature HelloFeature) bool {
@@ -53,6 +56,9 @@ func (client *syncClient) doRequest(req *memdPacket, deadline time.Time) (respOu
 			errOut = err
 			signal <- true
 		},
+		// These requests are used for bootstrapping and config polling, which should
+		// not wait behind user requests.
+		Priority: RequestPriorityCritical,
 	}
 
 	err := client.client.SendRequest(&qreq)
@@ -72,6 +78,15 @@ func (client *syncClient) doRequest(req *memdPacket, deadline time.Time) (respOu
 			return
 		}
 		return nil, ErrTimeout
+	case <-client.closeNotify:
+		// The agent is shutting down, so there is no point waiting any longer
+		// for a connection which is still being set up.
+		ReleaseTimer(timeoutTmr, false)
+		if !qreq.Cancel() {
+			<-signal
+			return
+		}
+		return nil, ErrShutdown
 	}
 }
 
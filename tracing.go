@@ -5,6 +5,7 @@ import (
 	"fmt"
 
 	"sort"
+	"strings"
 	"time"
 
 	"github.com/opentracing/opentracing-go"
@@ -60,11 +61,16 @@ func (agent *Agent) startCmdTrace(req *memdQRequest) {
 		return
 	}
 
-	req.processingLock.Lock()
-	req.cmdTraceSpan = agent.tracer.StartSpan(
-		getCommandName(req.memdPacket.Opcode),
+	tags := []opentracing.StartSpanOption{
 		opentracing.ChildOf(req.RootTraceContext),
-		opentracing.Tag{Key: "retry", Value: req.retryCount})
+		opentracing.Tag{Key: "retry", Value: req.RetryAttempts()},
+	}
+	if reasons := req.RetryReasons(); len(reasons) > 0 {
+		tags = append(tags, opentracing.Tag{Key: "retry_reason", Value: reasons[len(reasons)-1].String()})
+	}
+
+	req.processingLock.Lock()
+	req.cmdTraceSpan = agent.tracer.StartSpan(getCommandName(req.memdPacket.Opcode), tags...)
 	req.processingLock.Unlock()
 }
 
@@ -127,11 +133,24 @@ func (agent *Agent) stopNetTrace(req *memdQRequest, resp *memdQResponse, client
 
 func (agent *Agent) cancelReqTrace(req *memdQRequest, err error) {
 	if req.cmdTraceSpan != nil {
+		if err == ErrTimeout {
+			// Record every retry on the span of a request which timed out, rather
+			//  than only the reason for the latest retry.
+			var reasons []string
+			for _, reason := range req.RetryReasons() {
+				reasons = append(reasons, reason.String())
+			}
+			req.cmdTraceSpan.SetTag("retry", len(reasons))
+			req.cmdTraceSpan.SetTag("retry_reasons", strings.Join(reasons, ","))
+		}
+
 		if req.netTraceSpan != nil {
 			req.netTraceSpan.Finish()
+			req.netTraceSpan = nil
 		}
 
 		req.cmdTraceSpan.Finish()
+		req.cmdTraceSpan = nil
 	}
 }
 
@@ -0,0 +1,1035 @@
+package gocbcore
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultTransactionExpiration = 15 * time.Second
+	defaultTransactionKvTimeout  = 2500 * time.Millisecond
+	defaultTransactionNumATRs    = 1024
+
+	// transactionXattrPath is the xattr in which mutations are staged on documents.
+	transactionXattrPath = "txn"
+
+	// transactionATRAttemptsPath is the xattr holding the entries of an ATR document.
+	transactionATRAttemptsPath = "attempts"
+
+	mutationCasMacro = "${Mutation.CAS}"
+)
+
+// The states of an attempt stored in its ATR entry.  Entries are removed once the
+// attempt has been completed or rolled back.
+const (
+	transactionATRStatePending   = "PENDING"
+	transactionATRStateCommitted = "COMMITTED"
+	transactionATRStateAborted   = "ABORTED"
+)
+
+// The types of a mutation staged on a document.
+const (
+	transactionOpInsert  = "insert"
+	transactionOpReplace = "replace"
+	transactionOpRemove  = "remove"
+)
+
+// errTransactionStateChanged occurs when an ATR entry is not in the expected
+// state, meaning that another actor has committed or rolled back the attempt.
+var errTransactionStateChanged = errors.New("the transaction state was changed by another actor")
+
+// errTransactionIncomplete occurs when an attempt has reached its final state but not all
+// of its documents could be unstaged, leaving it to the cleanup of lost transactions.
+var errTransactionIncomplete = errors.New("the transaction documents could not all be unstaged")
+
+// transactionsKV is the subset of the Agent used by transactions, allowing them
+// to be run against a stand-in server.
+type transactionsKV interface {
+	LookupInEx(opts LookupInOptions, cb LookupInExCallback) (PendingOp, error)
+	MutateInEx(opts MutateInOptions, cb MutateInExCallback) (PendingOp, error)
+	KeyToVbucket(key []byte) uint16
+}
+
+// TransactionsConfig specifies the configuration options for transactions.
+type TransactionsConfig struct {
+	// DurabilityLevel is the durability level used for all of the mutations performed
+	// by transactions.
+	DurabilityLevel DurabilityLevel
+
+	// ExpirationTime is the time after which a transaction is considered to be lost
+	// and may be cleaned up.  If zero, a default of 15 seconds is used.
+	ExpirationTime time.Duration
+
+	// KeyValueTimeout is the timeout applied to each individual operation.  If zero,
+	// a default of 2.5 seconds is used.
+	KeyValueTimeout time.Duration
+
+	// NumATRs is the number of active transaction record documents which transactions
+	// are spread across, by the vbucket of their first mutation.  If zero, a default
+	// of 1024 is used.
+	NumATRs int
+
+	// ATRCollectionName and ATRScopeName specify the collection holding the active
+	// transaction records.
+	ATRCollectionName string
+	ATRScopeName      string
+
+	// CleanupInterval is the interval at which lost transactions are cleaned up in the
+	// background.  If zero, lost transactions are only cleaned up by CleanupLostEx.
+	CleanupInterval time.Duration
+}
+
+// Transactions provides multi-document ACID transactions.  Mutations are staged in
+// xattrs on the documents being modified, and each transaction attempt is tracked in
+// an active transaction record (ATR) document which determines whether the staged
+// mutations are committed or rolled back.
+type Transactions struct {
+	kv          transactionsKV
+	config      TransactionsConfig
+	closeNotify chan struct{}
+	cleanupDone chan struct{}
+}
+
+// NewTransactions creates a new Transactions which performs its operations using agent.
+func NewTransactions(agent *Agent, config TransactionsConfig) *Transactions {
+	return newTransactions(agent, config)
+}
+
+func newTransactions(kv transactionsKV, config TransactionsConfig) *Transactions {
+	if config.ExpirationTime == 0 {
+		config.ExpirationTime = defaultTransactionExpiration
+	}
+	if config.KeyValueTimeout == 0 {
+		config.KeyValueTimeout = defaultTransactionKvTimeout
+	}
+	if config.NumATRs <= 0 {
+		config.NumATRs = defaultTransactionNumATRs
+	}
+
+	t := &Transactions{
+		kv:          kv,
+		config:      config,
+		closeNotify: make(chan struct{}),
+	}
+
+	if config.CleanupInterval > 0 {
+		t.cleanupDone = make(chan struct{})
+		go t.cleanupLooper()
+	}
+
+	return t
+}
+
+// Close stops the background cleanup of lost transactions.
+func (t *Transactions) Close() {
+	close(t.closeNotify)
+	if t.cleanupDone != nil {
+		<-t.cleanupDone
+	}
+}
+
+type transactionState int
+
+const (
+	transactionStateActive = transactionState(iota)
+	transactionStateCommitted
+	transactionStateRolledBack
+)
+
+// transactionDocRecord identifies a document modified by an attempt.
+type transactionDocRecord struct {
+	Key            string `json:"id"`
+	CollectionName string `json:"col,omitempty"`
+	ScopeName      string `json:"scp,omitempty"`
+}
+
+type transactionXattrID struct {
+	Transaction string `json:"txn"`
+	Attempt     string `json:"atmpt"`
+}
+
+type transactionXattrATR struct {
+	Key            string `json:"key"`
+	CollectionName string `json:"coll,omitempty"`
+	ScopeName      string `json:"scp,omitempty"`
+}
+
+type transactionXattrOp struct {
+	Type    string          `json:"type"`
+	Staged  json.RawMessage `json:"stgd,omitempty"`
+	Created bool            `json:"crt,omitempty"`
+}
+
+// transactionXattr is the metadata staged in the xattr of a document modified by a transaction.
+type transactionXattr struct {
+	ID  transactionXattrID  `json:"id"`
+	ATR transactionXattrATR `json:"atr"`
+	Op  transactionXattrOp  `json:"op"`
+}
+
+// transactionATREntry is the entry for an attempt within an ATR document.
+type transactionATREntry struct {
+	State        string                 `json:"st"`
+	StartCas     string                 `json:"tst"`
+	ExpiryMillis int64                  `json:"exp"`
+	Inserts      []transactionDocRecord `json:"ins"`
+	Replaces     []transactionDocRecord `json:"rep"`
+	Removes      []transactionDocRecord `json:"rem"`
+}
+
+// isExpired returns whether the attempt has outlived its expiration time.  The start
+// time is the CAS of the mutation which created the entry, which is in nanoseconds.
+func (entry *transactionATREntry) isExpired(now time.Time) bool {
+	startCas, err := parseMacroCas(entry.StartCas)
+	if err != nil {
+		return false
+	}
+
+	expiry := int64(startCas) + entry.ExpiryMillis*int64(time.Millisecond)
+	return expiry < now.UnixNano()
+}
+
+// docs returns each of the documents modified by the attempt once.
+func (entry *transactionATREntry) docs() []transactionDocRecord {
+	var records []transactionDocRecord
+	seen := make(map[transactionDocRecord]bool)
+	for _, list := range [][]transactionDocRecord{entry.Inserts, entry.Replaces, entry.Removes} {
+		for _, record := range list {
+			if !seen[record] {
+				seen[record] = true
+				records = append(records, record)
+			}
+		}
+	}
+	return records
+}
+
+// parseMacroCas parses a CAS value which was expanded from the ${Mutation.CAS} macro,
+// which the server formats as the hex encoded little-endian bytes of the CAS.
+func parseMacroCas(value string) (Cas, error) {
+	casBytes, err := hex.DecodeString(strings.TrimPrefix(value, "0x"))
+	if err != nil {
+		return 0, err
+	}
+	if len(casBytes) != 8 {
+		return 0, ErrProtocol
+	}
+	return Cas(binary.LittleEndian.Uint64(casBytes)), nil
+}
+
+func transactionATRKey(atrIdx int) []byte {
+	return []byte(fmt.Sprintf("_txn:atr-%d", atrIdx))
+}
+
+func transactionAttemptPath(attemptID string) string {
+	return transactionATRAttemptsPath + "." + attemptID
+}
+
+// isSubDocMutateStatus returns whether an error from a sub-document mutation was
+// caused by one of its operations failing with the given status code.
+func isSubDocMutateStatus(err error, code StatusCode) bool {
+	if mutErr, ok := err.(SubDocMutateError); ok {
+		return IsErrorStatus(mutErr.Err, code)
+	}
+	return false
+}
+
+func (t *Transactions) lookupIn(opts LookupInOptions, cb LookupInExCallback) error {
+	opts.Deadline = time.Now().Add(t.config.KeyValueTimeout)
+	_, err := t.kv.LookupInEx(opts, cb)
+	return err
+}
+
+func (t *Transactions) mutateIn(opts MutateInOptions, cb MutateInExCallback) error {
+	opts.DurabilityLevel = t.config.DurabilityLevel
+	opts.Deadline = time.Now().Add(t.config.KeyValueTimeout)
+	_, err := t.kv.MutateInEx(opts, cb)
+	return err
+}
+
+// transactionDoc is a document read by a transaction, along with any staged metadata.
+// Deleted is set for a soft-deleted document, which is how a staged insert is stored.
+type transactionDoc struct {
+	Cas     Cas
+	Body    []byte
+	Meta    *transactionXattr
+	Deleted bool
+}
+
+// isDeletedDocErr returns whether the error from a sub-document operation indicates
+// that it was performed on a soft-deleted document.
+func isDeletedDocErr(err error) bool {
+	return IsErrorStatus(err, StatusSubDocSuccessDeleted) || IsErrorStatus(err, StatusSubDocMultiPathFailureDeleted)
+}
+
+// readDoc fetches a document along with its transaction metadata.  Soft-deleted documents
+// are also returned, so that inserts staged by transactions can be seen.
+func (t *Transactions) readDoc(record transactionDocRecord, cb func(*transactionDoc, error)) {
+	err := t.lookupIn(LookupInOptions{
+		Key:   []byte(record.Key),
+		Flags: SubdocDocFlagAccessDeleted,
+		Ops: []SubDocOp{
+			{
+				Op:    SubDocOpGet,
+				Flags: SubdocFlagXattrPath,
+				Path:  transactionXattrPath,
+			},
+			{
+				Op: SubDocOpGetDoc,
+			},
+		},
+		CollectionName: record.CollectionName,
+		ScopeName:      record.ScopeName,
+	}, func(res *LookupInResult, err error) {
+		if res == nil {
+			cb(nil, err)
+			return
+		}
+		deleted := isDeletedDocErr(err)
+		if res.Ops[1].Err != nil && !deleted {
+			cb(nil, res.Ops[1].Err)
+			return
+		}
+
+		doc := &transactionDoc{
+			Cas:     res.Cas,
+			Body:    res.Ops[1].Value,
+			Deleted: deleted,
+		}
+		if deleted {
+			doc.Body = nil
+		}
+
+		if res.Ops[0].Err == nil {
+			var meta transactionXattr
+			if err := json.Unmarshal(res.Ops[0].Value, &meta); err != nil {
+				cb(nil, err)
+				return
+			}
+			doc.Meta = &meta
+		} else if !IsErrorStatus(res.Ops[0].Err, StatusSubDocPathNotFound) {
+			cb(nil, res.Ops[0].Err)
+			return
+		}
+
+		cb(doc, nil)
+	})
+	if err != nil {
+		cb(nil, err)
+	}
+}
+
+// getATREntry fetches the entry for an attempt from an ATR document, returning a nil
+// entry if the attempt no longer has an entry.
+func (t *Transactions) getATREntry(atr transactionXattrATR, attemptID string,
+	cb func(*transactionATREntry, Cas, error)) {
+	err := t.lookupIn(LookupInOptions{
+		Key: []byte(atr.Key),
+		Ops: []SubDocOp{
+			{
+				Op:    SubDocOpGet,
+				Flags: SubdocFlagXattrPath,
+				Path:  transactionAttemptPath(attemptID),
+			},
+		},
+		CollectionName: atr.CollectionName,
+		ScopeName:      atr.ScopeName,
+	}, func(res *LookupInResult, err error) {
+		if res == nil {
+			if IsErrorStatus(err, StatusKeyNotFound) {
+				cb(nil, 0, nil)
+				return
+			}
+			cb(nil, 0, err)
+			return
+		}
+
+		if res.Ops[0].Err != nil {
+			if IsErrorStatus(res.Ops[0].Err, StatusSubDocPathNotFound) {
+				cb(nil, res.Cas, nil)
+				return
+			}
+			cb(nil, 0, res.Ops[0].Err)
+			return
+		}
+
+		var entry transactionATREntry
+		if err := json.Unmarshal(res.Ops[0].Value, &entry); err != nil {
+			cb(nil, 0, err)
+			return
+		}
+		cb(&entry, res.Cas, nil)
+	})
+	if err != nil {
+		cb(nil, 0, err)
+	}
+}
+
+// TransactionOptions encapsulates the parameters for beginning a transaction.
+type TransactionOptions struct {
+	// ExpirationTime overrides the expiration time specified in the TransactionsConfig.
+	ExpirationTime time.Duration
+}
+
+// Transaction is a single attempt at a transaction, created using BeginTransaction.
+// Operations on a transaction must be performed one at a time, and the transaction
+// must be finished by calling either CommitEx or RollbackEx.
+type Transaction struct {
+	parent     *Transactions
+	id         string
+	attemptID  string
+	expiration time.Duration
+	expiryTime time.Time
+
+	lock   sync.Mutex
+	state  transactionState
+	busy   bool
+	atr    *transactionXattrATR
+	staged []*transactionStagedMutation
+}
+
+type transactionStagedMutation struct {
+	record  transactionDocRecord
+	opType  string
+	value   []byte
+	created bool
+	cas     Cas
+}
+
+// BeginTransaction begins a new transaction.
+func (t *Transactions) BeginTransaction(opts TransactionOptions) *Transaction {
+	expiration := opts.ExpirationTime
+	if expiration == 0 {
+		expiration = t.config.ExpirationTime
+	}
+
+	return &Transaction{
+		parent:     t,
+		id:         formatCbUid(randomCbUid()) + formatCbUid(randomCbUid()),
+		attemptID:  formatCbUid(randomCbUid()) + formatCbUid(randomCbUid()),
+		expiration: expiration,
+		expiryTime: time.Now().Add(expiration),
+	}
+}
+
+// ID returns the unique identifier of the transaction.
+func (txn *Transaction) ID() string {
+	return txn.id
+}
+
+// AttemptID returns the unique identifier of this attempt at the transaction.
+func (txn *Transaction) AttemptID() string {
+	return txn.attemptID
+}
+
+// beginOp marks an operation as being in progress on the transaction.
+func (txn *Transaction) beginOp(allowExpired bool) error {
+	txn.lock.Lock()
+	defer txn.lock.Unlock()
+
+	if txn.state != transactionStateActive || txn.busy {
+		return ErrTransactionNotActive
+	}
+	if !allowExpired && time.Now().After(txn.expiryTime) {
+		return ErrTransactionExpired
+	}
+
+	txn.busy = true
+	return nil
+}
+
+func (txn *Transaction) endOp(state transactionState) {
+	txn.lock.Lock()
+	txn.busy = false
+	txn.state = state
+	txn.lock.Unlock()
+}
+
+func (txn *Transaction) findStaged(record transactionDocRecord) *transactionStagedMutation {
+	txn.lock.Lock()
+	defer txn.lock.Unlock()
+
+	for _, staged := range txn.staged {
+		if staged.record == record {
+			return staged
+		}
+	}
+	return nil
+}
+
+// TransactionGetOptions encapsulates the parameters for a transaction GetEx operation.
+type TransactionGetOptions struct {
+	Key            []byte
+	CollectionName string
+	ScopeName      string
+}
+
+// TransactionGetResult represents a document read or written by a transaction.
+type TransactionGetResult struct {
+	Key            []byte
+	CollectionName string
+	ScopeName      string
+	Value          []byte
+	Cas            Cas
+
+	meta *transactionXattr
+}
+
+func (res *TransactionGetResult) record() transactionDocRecord {
+	return transactionDocRecord{
+		Key:            string(res.Key),
+		CollectionName: res.CollectionName,
+		ScopeName:      res.ScopeName,
+	}
+}
+
+// TransactionGetCallback is invoked upon completion of a transaction GetEx operation.
+type TransactionGetCallback func(*TransactionGetResult, error)
+
+// TransactionMutationCallback is invoked upon completion of a transaction InsertEx,
+// ReplaceEx or RemoveEx operation.
+type TransactionMutationCallback func(*TransactionGetResult, error)
+
+// GetEx reads a document within the transaction.  Mutations staged by this transaction
+// are visible to it, as are mutations of other transactions once they have committed.
+func (txn *Transaction) GetEx(opts TransactionGetOptions, cb TransactionGetCallback) error {
+	if err := txn.beginOp(false); err != nil {
+		return err
+	}
+
+	done := func(res *TransactionGetResult, err error) {
+		txn.endOp(transactionStateActive)
+		cb(res, err)
+	}
+
+	record := transactionDocRecord{
+		Key:            string(opts.Key),
+		CollectionName: opts.CollectionName,
+		ScopeName:      opts.ScopeName,
+	}
+
+	if staged := txn.findStaged(record); staged != nil {
+		if staged.opType == transactionOpRemove {
+			done(nil, ErrKeyNotFound)
+			return nil
+		}
+
+		done(&TransactionGetResult{
+			Key:            opts.Key,
+			CollectionName: opts.CollectionName,
+			ScopeName:      opts.ScopeName,
+			Value:          staged.value,
+			Cas:            staged.cas,
+			meta:           txn.stagedMeta(staged),
+		}, nil)
+		return nil
+	}
+
+	txn.parent.readDoc(record, func(doc *transactionDoc, err error) {
+		if err != nil {
+			done(nil, err)
+			return
+		}
+
+		res := &TransactionGetResult{
+			Key:            opts.Key,
+			CollectionName: opts.CollectionName,
+			ScopeName:      opts.ScopeName,
+			Value:          doc.Body,
+			Cas:            doc.Cas,
+			meta:           doc.Meta,
+		}
+
+		if doc.Meta == nil {
+			if doc.Deleted {
+				done(nil, ErrKeyNotFound)
+				return
+			}
+			done(res, nil)
+			return
+		}
+
+		// The document has a mutation staged by another transaction, which is
+		// only visible once that transaction has committed.
+		txn.parent.getATREntry(doc.Meta.ATR, doc.Meta.ID.Attempt, func(entry *transactionATREntry, _ Cas, err error) {
+			if err != nil {
+				done(nil, err)
+				return
+			}
+
+			if entry != nil && entry.State == transactionATRStateCommitted {
+				if doc.Meta.Op.Type == transactionOpRemove {
+					done(nil, ErrKeyNotFound)
+					return
+				}
+				res.Value = doc.Meta.Op.Staged
+			} else if doc.Meta.Op.Created || doc.Deleted {
+				done(nil, ErrKeyNotFound)
+				return
+			}
+
+			done(res, nil)
+		})
+	})
+
+	return nil
+}
+
+func (txn *Transaction) stagedMeta(staged *transactionStagedMutation) *transactionXattr {
+	txn.lock.Lock()
+	atr := *txn.atr
+	txn.lock.Unlock()
+
+	return &transactionXattr{
+		ID: transactionXattrID{
+			Transaction: txn.id,
+			Attempt:     txn.attemptID,
+		},
+		ATR: atr,
+		Op: transactionXattrOp{
+			Type:    staged.opType,
+			Staged:  staged.value,
+			Created: staged.created,
+		},
+	}
+}
+
+// checkWriteConflict checks whether a document with the given staged metadata may be
+// modified by this transaction.  Mutations staged by other attempts may be overwritten
+// once that attempt has been rolled back, or was lost before it was committed.
+func (txn *Transaction) checkWriteConflict(meta *transactionXattr, cb func(error)) {
+	if meta == nil || meta.ID.Attempt == txn.attemptID {
+		cb(nil)
+		return
+	}
+
+	txn.parent.getATREntry(meta.ATR, meta.ID.Attempt, func(entry *transactionATREntry, _ Cas, err error) {
+		if err != nil {
+			cb(err)
+			return
+		}
+
+		if entry == nil || entry.State == transactionATRStateAborted {
+			cb(nil)
+			return
+		}
+		if entry.State == transactionATRStatePending && entry.isExpired(time.Now()) {
+			cb(nil)
+			return
+		}
+
+		cb(ErrTransactionWriteConflict)
+	})
+}
+
+// ensureATR creates the entry for this attempt in the ATR document chosen by the
+// vbucket of the first document modified by the transaction.
+func (txn *Transaction) ensureATR(record transactionDocRecord, cb func(error)) {
+	txn.lock.Lock()
+	hasATR := txn.atr != nil
+	txn.lock.Unlock()
+
+	if hasATR {
+		cb(nil)
+		return
+	}
+
+	t := txn.parent
+	vbID := t.kv.KeyToVbucket([]byte(record.Key))
+	atr := transactionXattrATR{
+		Key:            string(transactionATRKey(int(vbID) % t.config.NumATRs)),
+		CollectionName: t.config.ATRCollectionName,
+		ScopeName:      t.config.ATRScopeName,
+	}
+
+	attemptPath := transactionAttemptPath(txn.attemptID)
+	xattrFlags := SubdocFlagXattrPath | SubdocFlagMkDirP
+	err := t.mutateIn(MutateInOptions{
+		Key:   []byte(atr.Key),
+		Flags: SubdocDocFlagMkDoc,
+		Ops: []SubDocOp{
+			{
+				Op:    SubDocOpDictAdd,
+				Flags: xattrFlags | SubdocFlagExpandMacros,
+				Path:  attemptPath + ".tst",
+				Value: []byte("\"" + mutationCasMacro + "\""),
+			},
+			{
+				Op:    SubDocOpDictAdd,
+				Flags: xattrFlags,
+				Path:  attemptPath + ".exp",
+				Value: []byte(fmt.Sprintf("%d", txn.expiration/time.Millisecond)),
+			},
+			{
+				Op:    SubDocOpDictAdd,
+				Flags: xattrFlags,
+				Path:  attemptPath + ".st",
+				Value: []byte("\"" + transactionATRStatePending + "\""),
+			},
+			{
+				Op:    SubDocOpDictAdd,
+				Flags: xattrFlags,
+				Path:  attemptPath + ".ins",
+				Value: []byte("[]"),
+			},
+			{
+				Op:    SubDocOpDictAdd,
+				Flags: xattrFlags,
+				Path:  attemptPath + ".rep",
+				Value: []byte("[]"),
+			},
+			{
+				Op:    SubDocOpDictAdd,
+				Flags: xattrFlags,
+				Path:  attemptPath + ".rem",
+				Value: []byte("[]"),
+			},
+		},
+		CollectionName: atr.CollectionName,
+		ScopeName:      atr.ScopeName,
+	}, func(res *MutateInResult, err error) {
+		if err != nil {
+			cb(err)
+			return
+		}
+
+		txn.lock.Lock()
+		txn.atr = &atr
+		txn.lock.Unlock()
+		cb(nil)
+	})
+	if err != nil {
+		cb(err)
+	}
+}
+
+// recordDoc adds a document to the list of documents modified by this attempt in its
+// ATR entry, so that the mutation can be found if the transaction is lost.
+func (txn *Transaction) recordDoc(record transactionDocRecord, opType string, cb func(error)) {
+	listName := map[string]string{
+		transactionOpInsert:  "ins",
+		transactionOpReplace: "rep",
+		transactionOpRemove:  "rem",
+	}[opType]
+
+	recordBytes, err := json.Marshal(record)
+	if err != nil {
+		cb(err)
+		return
+	}
+
+	txn.lock.Lock()
+	atr := *txn.atr
+	txn.lock.Unlock()
+
+	err = txn.parent.mutateIn(MutateInOptions{
+		Key: []byte(atr.Key),
+		Ops: []SubDocOp{
+			{
+				Op:    SubDocOpArrayPushLast,
+				Flags: SubdocFlagXattrPath,
+				Path:  transactionAttemptPath(txn.attemptID) + "." + listName,
+				Value: recordBytes,
+			},
+		},
+		CollectionName: atr.CollectionName,
+		ScopeName:      atr.ScopeName,
+	}, func(res *MutateInResult, err error) {
+		if isSubDocMutateStatus(err, StatusSubDocPathNotFound) {
+			// Our entry was removed by the cleanup of lost transactions.
+			cb(ErrTransactionExpired)
+			return
+		}
+		cb(err)
+	})
+	if err != nil {
+		cb(err)
+	}
+}
+
+// stage writes a staged mutation into the xattr of a document.  A staged insert creates
+// the document as soft-deleted, so that it remains invisible outside of transactions
+// until it is committed.
+func (txn *Transaction) stage(staged *transactionStagedMutation, cas Cas, addDoc bool, cb func(Cas, error)) {
+	metaBytes, err := json.Marshal(txn.stagedMeta(staged))
+	if err != nil {
+		cb(0, err)
+		return
+	}
+
+	ops := []SubDocOp{
+		{
+			Op:    SubDocOpDictSet,
+			Flags: SubdocFlagXattrPath,
+			Path:  transactionXattrPath,
+			Value: metaBytes,
+		},
+	}
+
+	flags := SubdocDocFlagAccessDeleted
+	if addDoc {
+		flags |= SubdocDocFlagAddDoc | SubdocDocFlagCreateAsDeleted
+	}
+
+	err = txn.parent.mutateIn(MutateInOptions{
+		Key:            []byte(staged.record.Key),
+		Flags:          flags,
+		Cas:            cas,
+		Ops:            ops,
+		CollectionName: staged.record.CollectionName,
+		ScopeName:      staged.record.ScopeName,
+	}, func(res *MutateInResult, err error) {
+		if err != nil {
+			cb(0, err)
+			return
+		}
+		cb(res.Cas, nil)
+	})
+	if err != nil {
+		cb(0, err)
+	}
+}
+
+// stageMutation records a mutation in the ATR entry, then stages it on the document.
+func (txn *Transaction) stageMutation(staged *transactionStagedMutation, cas Cas, addDoc bool, cb func(error)) {
+	txn.ensureATR(staged.record, func(err error) {
+		if err != nil {
+			cb(err)
+			return
+		}
+
+		txn.recordDoc(staged.record, staged.opType, func(err error) {
+			if err != nil {
+				cb(err)
+				return
+			}
+
+			txn.stage(staged, cas, addDoc, func(cas Cas, err error) {
+				if err != nil {
+					cb(err)
+					return
+				}
+
+				staged.cas = cas
+
+				txn.lock.Lock()
+				found := false
+				for i, existing := range txn.staged {
+					if existing.record == staged.record {
+						txn.staged[i] = staged
+						found = true
+						break
+					}
+				}
+				if !found {
+					txn.staged = append(txn.staged, staged)
+				}
+				txn.lock.Unlock()
+
+				cb(nil)
+			})
+		})
+	})
+}
+
+func (txn *Transaction) stagedResult(staged *transactionStagedMutation) *TransactionGetResult {
+	return &TransactionGetResult{
+		Key:            []byte(staged.record.Key),
+		CollectionName: staged.record.CollectionName,
+		ScopeName:      staged.record.ScopeName,
+		Value:          staged.value,
+		Cas:            staged.cas,
+		meta:           txn.stagedMeta(staged),
+	}
+}
+
+// TransactionInsertOptions encapsulates the parameters for a transaction InsertEx operation.
+type TransactionInsertOptions struct {
+	Key            []byte
+	CollectionName string
+	ScopeName      string
+	Value          []byte
+}
+
+// InsertEx stages the insertion of a new document.  Until the transaction is committed
+// the document only exists as a soft-deleted document, which is invisible to readers.
+func (txn *Transaction) InsertEx(opts TransactionInsertOptions, cb TransactionMutationCallback) error {
+	if err := txn.beginOp(false); err != nil {
+		return err
+	}
+
+	done := func(res *TransactionGetResult, err error) {
+		txn.endOp(transactionStateActive)
+		cb(res, err)
+	}
+
+	record := transactionDocRecord{
+		Key:            string(opts.Key),
+		CollectionName: opts.CollectionName,
+		ScopeName:      opts.ScopeName,
+	}
+
+	staged := &transactionStagedMutation{
+		record:  record,
+		opType:  transactionOpInsert,
+		value:   opts.Value,
+		created: true,
+	}
+
+	if existing := txn.findStaged(record); existing != nil {
+		if existing.opType != transactionOpRemove {
+			done(nil, ErrKeyExists)
+			return nil
+		}
+
+		// Inserting a document this transaction removed replaces it instead.
+		staged.opType = transactionOpReplace
+		staged.created = existing.created
+		txn.stageMutation(staged, existing.cas, false, func(err error) {
+			if err != nil {
+				done(nil, err)
+				return
+			}
+			done(txn.stagedResult(staged), nil)
+		})
+		return nil
+	}
+
+	txn.stageMutation(staged, 0, true, func(err error) {
+		if err == nil {
+			done(txn.stagedResult(staged), nil)
+			return
+		}
+		if !IsErrorStatus(err, StatusKeyExists) {
+			done(nil, err)
+			return
+		}
+
+		// The document may be an insert staged by another transaction which can
+		// be overwritten if that transaction is no longer active.
+		txn.parent.readDoc(record, func(doc *transactionDoc, err error) {
+			if err != nil {
+				done(nil, err)
+				return
+			}
+			// A soft-deleted document without a staged insert is left behind once
+			// an insert has been rolled back, and can be reused.
+			if doc.Meta == nil && !doc.Deleted || doc.Meta != nil && !doc.Meta.Op.Created {
+				done(nil, ErrKeyExists)
+				return
+			}
+
+			txn.checkWriteConflict(doc.Meta, func(err error) {
+				if err != nil {
+					done(nil, err)
+					return
+				}
+
+				txn.stageMutation(staged, doc.Cas, false, func(err error) {
+					if IsErrorStatus(err, StatusKeyExists) {
+						err = ErrTransactionWriteConflict
+					}
+					if err != nil {
+						done(nil, err)
+						return
+					}
+					done(txn.stagedResult(staged), nil)
+				})
+			})
+		})
+	})
+
+	return nil
+}
+
+// TransactionReplaceOptions encapsulates the parameters for a transaction ReplaceEx operation.
+type TransactionReplaceOptions struct {
+	Document *TransactionGetResult
+	Value    []byte
+}
+
+// ReplaceEx stages the replacement of the value of a document previously read by GetEx.
+func (txn *Transaction) ReplaceEx(opts TransactionReplaceOptions, cb TransactionMutationCallback) error {
+	if opts.Document == nil {
+		return ErrInvalidArgs
+	}
+
+	return txn.mutateDoc(opts.Document, transactionOpReplace, opts.Value, cb)
+}
+
+// TransactionRemoveOptions encapsulates the parameters for a transaction RemoveEx operation.
+type TransactionRemoveOptions struct {
+	Document *TransactionGetResult
+}
+
+// RemoveEx stages the removal of a document previously read by GetEx.
+func (txn *Transaction) RemoveEx(opts TransactionRemoveOptions, cb TransactionMutationCallback) error {
+	if opts.Document == nil {
+		return ErrInvalidArgs
+	}
+
+	return txn.mutateDoc(opts.Document, transactionOpRemove, nil, cb)
+}
+
+func (txn *Transaction) mutateDoc(doc *TransactionGetResult, opType string, value []byte,
+	cb TransactionMutationCallback) error {
+	if err := txn.beginOp(false); err != nil {
+		return err
+	}
+
+	done := func(res *TransactionGetResult, err error) {
+		txn.endOp(transactionStateActive)
+		cb(res, err)
+	}
+
+	staged := &transactionStagedMutation{
+		record: doc.record(),
+		opType: opType,
+		value:  value,
+	}
+
+	cas := doc.Cas
+	if existing := txn.findStaged(staged.record); existing != nil {
+		if existing.opType == transactionOpRemove {
+			done(nil, ErrKeyNotFound)
+			return nil
+		}
+		if existing.opType == transactionOpInsert && opType == transactionOpReplace {
+			staged.opType = transactionOpInsert
+		}
+		staged.created = existing.created
+		cas = existing.cas
+	}
+
+	txn.checkWriteConflict(doc.meta, func(err error) {
+		if err != nil {
+			done(nil, err)
+			return
+		}
+
+		txn.stageMutation(staged, cas, false, func(err error) {
+			if IsErrorStatus(err, StatusKeyExists) {
+				// The document changed since it was read.
+				err = ErrTransactionDocumentModified
+			}
+			if err != nil {
+				done(nil, err)
+				return
+			}
+
+			res := txn.stagedResult(staged)
+			if staged.opType == transactionOpRemove {
+				res.Value = nil
+			}
+			done(res, nil)
+		})
+	})
+
+	return nil
+}
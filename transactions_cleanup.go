@@ -0,0 +1,180 @@
+package gocbcore
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// TransactionCleanupResult encapsulates the result of a CleanupLostEx operation.
+// NumIncomplete counts the attempts whose documents could not all be committed or
+// rolled back, which are completed by a later cleanup.
+type TransactionCleanupResult struct {
+	NumCommitted  int
+	NumRolledBack int
+	NumIncomplete int
+}
+
+// TransactionCleanupCallback is invoked upon completion of a CleanupLostEx operation.
+type TransactionCleanupCallback func(*TransactionCleanupResult, error)
+
+// cleanupATR completes any lost attempts found in a single ATR document, one at a time.
+func (t *Transactions) cleanupATR(atr transactionXattrATR, cb func(*TransactionCleanupResult, error)) {
+	result := &TransactionCleanupResult{}
+
+	err := t.lookupIn(LookupInOptions{
+		Key: []byte(atr.Key),
+		Ops: []SubDocOp{
+			{
+				Op:    SubDocOpGet,
+				Flags: SubdocFlagXattrPath,
+				Path:  transactionATRAttemptsPath,
+			},
+		},
+		CollectionName: atr.CollectionName,
+		ScopeName:      atr.ScopeName,
+	}, func(res *LookupInResult, err error) {
+		if res == nil {
+			if IsErrorStatus(err, StatusKeyNotFound) {
+				err = nil
+			}
+			cb(result, err)
+			return
+		}
+		if res.Ops[0].Err != nil {
+			if IsErrorStatus(res.Ops[0].Err, StatusSubDocPathNotFound) {
+				cb(result, nil)
+				return
+			}
+			cb(result, res.Ops[0].Err)
+			return
+		}
+
+		var entries map[string]*transactionATREntry
+		if err := json.Unmarshal(res.Ops[0].Value, &entries); err != nil {
+			cb(result, err)
+			return
+		}
+
+		now := time.Now()
+		var attemptIDs []string
+		for attemptID, entry := range entries {
+			if entry.isExpired(now) {
+				attemptIDs = append(attemptIDs, attemptID)
+			}
+		}
+
+		var firstErr error
+		var cleanupNext func(idx int)
+		cleanupNext = func(idx int) {
+			if idx >= len(attemptIDs) {
+				cb(result, firstErr)
+				return
+			}
+
+			attemptID := attemptIDs[idx]
+			entry := entries[attemptID]
+
+			toState := transactionATRStateAborted
+			if entry.State == transactionATRStateCommitted {
+				toState = transactionATRStateCommitted
+			}
+
+			t.completeAttempt(atr, attemptID, entry.docs(), entry.State, toState, func(err error) {
+				if err == errTransactionStateChanged || err == ErrTransactionExpired {
+					// The attempt was completed by someone else in the meantime.
+					err = nil
+				} else if err == errTransactionIncomplete {
+					result.NumIncomplete++
+					err = nil
+				} else if err == nil && toState == transactionATRStateCommitted {
+					result.NumCommitted++
+				} else if err == nil {
+					result.NumRolledBack++
+				}
+				if err != nil && firstErr == nil {
+					firstErr = err
+				}
+
+				cleanupNext(idx + 1)
+			})
+		}
+		cleanupNext(0)
+	})
+	if err != nil {
+		cb(result, err)
+	}
+}
+
+// CleanupLostEx finds transaction attempts which have outlived their expiration time,
+// for instance because the client performing them failed, and completes them.  Attempts
+// which had been committed are committed, and any others are rolled back.
+func (t *Transactions) CleanupLostEx(cb TransactionCleanupCallback) error {
+	var lock sync.Mutex
+	total := &TransactionCleanupResult{}
+	var firstErr error
+	remaining := t.config.NumATRs
+
+	for atrIdx := 0; atrIdx < t.config.NumATRs; atrIdx++ {
+		atr := transactionXattrATR{
+			Key:            string(transactionATRKey(atrIdx)),
+			CollectionName: t.config.ATRCollectionName,
+			ScopeName:      t.config.ATRScopeName,
+		}
+
+		t.cleanupATR(atr, func(res *TransactionCleanupResult, err error) {
+			lock.Lock()
+			total.NumCommitted += res.NumCommitted
+			total.NumRolledBack += res.NumRolledBack
+			total.NumIncomplete += res.NumIncomplete
+			if err != nil && firstErr == nil {
+				firstErr = err
+			}
+			remaining--
+			done := remaining == 0
+			lock.Unlock()
+
+			if done {
+				cb(total, firstErr)
+			}
+		})
+	}
+
+	return nil
+}
+
+func (t *Transactions) cleanupLooper() {
+	defer close(t.cleanupDone)
+
+	ticker := time.NewTicker(t.config.CleanupInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-t.closeNotify:
+			return
+		}
+
+		waitCh := make(chan struct{})
+		err := t.CleanupLostEx(func(res *TransactionCleanupResult, err error) {
+			if err != nil {
+				logDebugf("Failed to clean up lost transactions: %v", err)
+			} else if res.NumCommitted > 0 || res.NumRolledBack > 0 || res.NumIncomplete > 0 {
+				logDebugf("Cleaned up lost transactions, %d committed, %d rolled back and %d incomplete",
+					res.NumCommitted, res.NumRolledBack, res.NumIncomplete)
+			}
+			close(waitCh)
+		})
+		if err != nil {
+			continue
+		}
+
+		select {
+		case <-waitCh:
+		case <-t.closeNotify:
+			<-waitCh
+			return
+		}
+	}
+}
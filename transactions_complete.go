@@ -0,0 +1,465 @@
+package gocbcore
+
+import (
+	"encoding/json"
+)
+
+// maxTransactionCasRetries is the number of times a write to an ATR document or a staged
+// document is retried when the CAS changes underneath it.
+const maxTransactionCasRetries = 10
+
+// TransactionCompleteCallback is invoked upon completion of a transaction CommitEx or
+// RollbackEx operation.
+type TransactionCompleteCallback func(error)
+
+// setATRState moves the entry for an attempt from one state to another, failing with
+// errTransactionStateChanged if the entry is not in the expected state and with
+// ErrTransactionExpired if the entry no longer exists.
+func (t *Transactions) setATRState(atr transactionXattrATR, attemptID, fromState, toState string,
+	retries int, cb func(error)) {
+	t.getATREntry(atr, attemptID, func(entry *transactionATREntry, cas Cas, err error) {
+		if err != nil {
+			cb(err)
+			return
+		}
+		if entry == nil {
+			cb(ErrTransactionExpired)
+			return
+		}
+		if entry.State != fromState {
+			cb(errTransactionStateChanged)
+			return
+		}
+
+		err = t.mutateIn(MutateInOptions{
+			Key: []byte(atr.Key),
+			Cas: cas,
+			Ops: []SubDocOp{
+				{
+					Op:    SubDocOpReplace,
+					Flags: SubdocFlagXattrPath,
+					Path:  transactionAttemptPath(attemptID) + ".st",
+					Value: []byte("\"" + toState + "\""),
+				},
+			},
+			CollectionName: atr.CollectionName,
+			ScopeName:      atr.ScopeName,
+		}, func(res *MutateInResult, err error) {
+			if IsErrorStatus(err, StatusKeyExists) && retries > 0 {
+				// The ATR is shared with other attempts which may have modified it.
+				t.setATRState(atr, attemptID, fromState, toState, retries-1, cb)
+				return
+			}
+			if isSubDocMutateStatus(err, StatusSubDocPathNotFound) {
+				cb(ErrTransactionExpired)
+				return
+			}
+			cb(err)
+		})
+		if err != nil {
+			cb(err)
+		}
+	})
+}
+
+// removeATREntry removes the entry for an attempt once all of its staged mutations
+// have been committed or rolled back.
+func (t *Transactions) removeATREntry(atr transactionXattrATR, attemptID string, cb func(error)) {
+	err := t.mutateIn(MutateInOptions{
+		Key: []byte(atr.Key),
+		Ops: []SubDocOp{
+			{
+				Op:    SubDocOpDelete,
+				Flags: SubdocFlagXattrPath,
+				Path:  transactionAttemptPath(attemptID),
+			},
+		},
+		CollectionName: atr.CollectionName,
+		ScopeName:      atr.ScopeName,
+	}, func(res *MutateInResult, err error) {
+		if isSubDocMutateStatus(err, StatusSubDocPathNotFound) || IsErrorStatus(err, StatusKeyNotFound) {
+			err = nil
+		}
+		cb(err)
+	})
+	if err != nil {
+		cb(err)
+	}
+}
+
+// unstageDoc commits or rolls back the mutation staged on a document by an attempt.
+// Documents which no longer have a mutation staged by the attempt are ignored.
+func (t *Transactions) unstageDoc(attemptID string, record transactionDocRecord, commit bool,
+	retries int, cb func(error)) {
+	err := t.lookupIn(LookupInOptions{
+		Key:   []byte(record.Key),
+		Flags: SubdocDocFlagAccessDeleted,
+		Ops: []SubDocOp{
+			{
+				Op:    SubDocOpGet,
+				Flags: SubdocFlagXattrPath,
+				Path:  transactionXattrPath,
+			},
+		},
+		CollectionName: record.CollectionName,
+		ScopeName:      record.ScopeName,
+	}, func(res *LookupInResult, err error) {
+		if res == nil {
+			if IsErrorStatus(err, StatusKeyNotFound) {
+				err = nil
+			}
+			cb(err)
+			return
+		}
+		if res.Ops[0].Err != nil {
+			if IsErrorStatus(res.Ops[0].Err, StatusSubDocPathNotFound) {
+				cb(nil)
+				return
+			}
+			cb(res.Ops[0].Err)
+			return
+		}
+
+		var meta transactionXattr
+		if err := json.Unmarshal(res.Ops[0].Value, &meta); err != nil {
+			cb(err)
+			return
+		}
+		if meta.ID.Attempt != attemptID {
+			cb(nil)
+			return
+		}
+
+		// Documents created by the transaction are soft-deleted until they are committed.
+		flags := SubdocDocFlagAccessDeleted
+
+		var ops []SubDocOp
+		if commit && meta.Op.Type != transactionOpRemove {
+			if meta.Op.Created {
+				flags |= SubdocDocFlagReviveDocument
+			}
+			ops = []SubDocOp{
+				{
+					Op:    SubDocOpDelete,
+					Flags: SubdocFlagXattrPath,
+					Path:  transactionXattrPath,
+				},
+				{
+					Op:    SubDocOpSetDoc,
+					Value: meta.Op.Staged,
+				},
+			}
+		} else if commit && !meta.Op.Created {
+			ops = []SubDocOp{
+				{
+					Op: SubDocOpDeleteDoc,
+				},
+			}
+		} else {
+			ops = []SubDocOp{
+				{
+					Op:    SubDocOpDelete,
+					Flags: SubdocFlagXattrPath,
+					Path:  transactionXattrPath,
+				},
+			}
+		}
+
+		err = t.mutateIn(MutateInOptions{
+			Key:            []byte(record.Key),
+			Flags:          flags,
+			Cas:            res.Cas,
+			Ops:            ops,
+			CollectionName: record.CollectionName,
+			ScopeName:      record.ScopeName,
+		}, func(res *MutateInResult, err error) {
+			if IsErrorStatus(err, StatusKeyExists) && retries > 0 {
+				t.unstageDoc(attemptID, record, commit, retries-1, cb)
+				return
+			}
+			if IsErrorStatus(err, StatusKeyNotFound) {
+				err = nil
+			}
+			cb(err)
+		})
+		if err != nil {
+			cb(err)
+		}
+	})
+	if err != nil {
+		cb(err)
+	}
+}
+
+// unstageDocs commits or rolls back each of the documents in turn, returning the first
+// error encountered once all of the documents have been processed.
+func (t *Transactions) unstageDocs(attemptID string, records []transactionDocRecord, commit bool,
+	cb func(error)) {
+	var firstErr error
+	var unstageNext func(idx int)
+	unstageNext = func(idx int) {
+		if idx >= len(records) {
+			cb(firstErr)
+			return
+		}
+
+		t.unstageDoc(attemptID, records[idx], commit, maxTransactionCasRetries, func(err error) {
+			if err != nil && firstErr == nil {
+				firstErr = err
+			}
+			unstageNext(idx + 1)
+		})
+	}
+	unstageNext(0)
+}
+
+// completeAttempt moves an attempt into its final state, unstages all of its documents and
+// then removes its ATR entry.  If the documents cannot all be unstaged then the entry is
+// left in place, so that the attempt is completed by the cleanup of lost transactions,
+// and errTransactionIncomplete is returned.
+func (t *Transactions) completeAttempt(atr transactionXattrATR, attemptID string, records []transactionDocRecord,
+	fromState, toState string, cb func(error)) {
+	commit := toState == transactionATRStateCommitted
+
+	setState := func(cb func(error)) {
+		if fromState == toState {
+			cb(nil)
+			return
+		}
+		t.setATRState(atr, attemptID, fromState, toState, maxTransactionCasRetries, cb)
+	}
+
+	setState(func(err error) {
+		if err != nil {
+			cb(err)
+			return
+		}
+
+		t.unstageDocs(attemptID, records, commit, func(err error) {
+			if err != nil {
+				logWarnf("Failed to unstage documents for transaction attempt %s: %v", attemptID, err)
+				cb(errTransactionIncomplete)
+				return
+			}
+
+			t.removeATREntry(atr, attemptID, func(err error) {
+				if err != nil {
+					logWarnf("Failed to remove ATR entry for transaction attempt %s: %v", attemptID, err)
+				}
+				cb(nil)
+			})
+		})
+	})
+}
+
+func (txn *Transaction) stagedRecords() []transactionDocRecord {
+	txn.lock.Lock()
+	defer txn.lock.Unlock()
+
+	records := make([]transactionDocRecord, len(txn.staged))
+	for i, staged := range txn.staged {
+		records[i] = staged.record
+	}
+	return records
+}
+
+// verifyStaged checks that none of the staged documents have been modified outside of
+// the transaction since their mutations were staged.
+func (txn *Transaction) verifyStaged(cb func(error)) {
+	txn.lock.Lock()
+	staged := make([]*transactionStagedMutation, len(txn.staged))
+	copy(staged, txn.staged)
+	txn.lock.Unlock()
+
+	var verifyNext func(idx int)
+	verifyNext = func(idx int) {
+		if idx >= len(staged) {
+			cb(nil)
+			return
+		}
+
+		mutation := staged[idx]
+		err := txn.parent.lookupIn(LookupInOptions{
+			Key:   []byte(mutation.record.Key),
+			Flags: SubdocDocFlagAccessDeleted,
+			Ops: []SubDocOp{
+				{
+					Op:    SubDocOpGet,
+					Flags: SubdocFlagXattrPath,
+					Path:  transactionXattrPath + ".id.atmpt",
+				},
+			},
+			CollectionName: mutation.record.CollectionName,
+			ScopeName:      mutation.record.ScopeName,
+		}, func(res *LookupInResult, err error) {
+			if IsErrorStatus(err, StatusKeyNotFound) {
+				cb(ErrTransactionDocumentModified)
+				return
+			}
+			if res == nil {
+				cb(err)
+				return
+			}
+			if res.Cas != mutation.cas || res.Ops[0].Err != nil {
+				cb(ErrTransactionDocumentModified)
+				return
+			}
+
+			verifyNext(idx + 1)
+		})
+		if err != nil {
+			cb(err)
+		}
+	}
+	verifyNext(0)
+}
+
+// CommitEx commits the transaction, making all of its staged mutations visible.  If any
+// of the staged documents were modified outside of the transaction, it is rolled back
+// instead and ErrTransactionDocumentModified is returned.
+func (txn *Transaction) CommitEx(cb TransactionCompleteCallback) error {
+	if err := txn.beginOp(false); err != nil {
+		return err
+	}
+
+	txn.lock.Lock()
+	atr := txn.atr
+	txn.lock.Unlock()
+
+	if atr == nil {
+		txn.endOp(transactionStateCommitted)
+		cb(nil)
+		return nil
+	}
+
+	rollback := func(commitErr error) {
+		txn.rollbackAttempt(*atr, func(committed bool, err error) {
+			if committed {
+				// The commit was written despite the error, so it is complete.
+				txn.endOp(transactionStateCommitted)
+				cb(nil)
+				return
+			}
+			if err != nil {
+				logWarnf("Failed to roll back transaction attempt %s: %v", txn.attemptID, err)
+			}
+			txn.endOp(transactionStateRolledBack)
+			cb(commitErr)
+		})
+	}
+
+	txn.verifyStaged(func(err error) {
+		if err != nil {
+			rollback(err)
+			return
+		}
+
+		txn.parent.completeAttempt(*atr, txn.attemptID, txn.stagedRecords(),
+			transactionATRStatePending, transactionATRStateCommitted, func(err error) {
+				if err == errTransactionStateChanged {
+					// The attempt was rolled back by the cleanup of lost transactions.
+					err = ErrTransactionExpired
+				}
+				if err == errTransactionIncomplete {
+					// The commit has been written, the remaining documents are
+					// unstaged by the cleanup of lost transactions.
+					err = nil
+				}
+				if err != nil {
+					rollback(err)
+					return
+				}
+
+				txn.endOp(transactionStateCommitted)
+				cb(nil)
+			})
+	})
+
+	return nil
+}
+
+// rollbackAttempt rolls back the attempt, unless it has already been committed in which
+// case the commit is completed instead and committed is true.
+func (txn *Transaction) rollbackAttempt(atr transactionXattrATR, cb func(committed bool, err error)) {
+	t := txn.parent
+	t.getATREntry(atr, txn.attemptID, func(entry *transactionATREntry, _ Cas, err error) {
+		if err != nil {
+			cb(false, err)
+			return
+		}
+		if entry == nil {
+			// The attempt was already completed by the cleanup of lost transactions.
+			cb(false, nil)
+			return
+		}
+
+		// The outcome is decided once the final state is written, any documents which
+		// could not be unstaged are handled by the cleanup of lost transactions.
+		finish := func(committed bool, err error) {
+			if err == errTransactionIncomplete {
+				err = nil
+			}
+			cb(committed, err)
+		}
+
+		// The ATR entry also covers documents whose staging failed ambiguously.
+		records := entry.docs()
+		switch entry.State {
+		case transactionATRStateCommitted:
+			t.completeAttempt(atr, txn.attemptID, records, entry.State, entry.State, func(err error) {
+				finish(true, err)
+			})
+		case transactionATRStateAborted:
+			t.completeAttempt(atr, txn.attemptID, records, entry.State, entry.State, func(err error) {
+				finish(false, err)
+			})
+		default:
+			t.completeAttempt(atr, txn.attemptID, records, entry.State, transactionATRStateAborted, func(err error) {
+				if err == errTransactionStateChanged {
+					// Someone else changed the state before us, so act on the new state.
+					txn.rollbackAttempt(atr, cb)
+					return
+				}
+				if err == ErrTransactionExpired {
+					err = nil
+				}
+				finish(false, err)
+			})
+		}
+	})
+}
+
+// RollbackEx rolls back the transaction, discarding all of its staged mutations.
+func (txn *Transaction) RollbackEx(cb TransactionCompleteCallback) error {
+	if err := txn.beginOp(true); err != nil {
+		return err
+	}
+
+	txn.lock.Lock()
+	atr := txn.atr
+	txn.lock.Unlock()
+
+	if atr == nil {
+		txn.endOp(transactionStateRolledBack)
+		cb(nil)
+		return nil
+	}
+
+	txn.rollbackAttempt(*atr, func(committed bool, err error) {
+		if committed {
+			txn.endOp(transactionStateCommitted)
+			cb(ErrTransactionNotActive)
+			return
+		}
+		if err != nil {
+			txn.endOp(transactionStateActive)
+			cb(err)
+			return
+		}
+
+		txn.endOp(transactionStateRolledBack)
+		cb(nil)
+	})
+
+	return nil
+}
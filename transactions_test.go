@@ -0,0 +1,796 @@
+package gocbcore
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// standInDoc is a document stored by the standInKV server.  Soft-deleted documents are
+// only visible to operations which access deleted documents.
+type standInDoc struct {
+	body    interface{}
+	xattrs  map[string]interface{}
+	cas     Cas
+	deleted bool
+}
+
+// testTransactionExpiry is the expiration time of transactions which are abandoned by tests.
+const testTransactionExpiry = 50 * time.Millisecond
+
+// standInKV is an in-process stand-in for the sub-document operations used by
+// transactions.  Callbacks are invoked asynchronously, as they are by the Agent.
+// Mutations of the keys in failMutations fail with the given error.
+type standInKV struct {
+	lock          sync.Mutex
+	docs          map[string]*standInDoc
+	lastCas       Cas
+	failMutations map[string]error
+}
+
+func newStandInKV() *standInKV {
+	return &standInKV{
+		docs:          make(map[string]*standInDoc),
+		failMutations: make(map[string]error),
+	}
+}
+
+func (kv *standInKV) failMutation(key string, err error) {
+	kv.lock.Lock()
+	defer kv.lock.Unlock()
+
+	if err == nil {
+		delete(kv.failMutations, key)
+		return
+	}
+	kv.failMutations[key] = err
+}
+
+func standInDocID(key []byte, collectionName, scopeName string) string {
+	return scopeName + "/" + collectionName + "/" + string(key)
+}
+
+func (kv *standInKV) nextCas() Cas {
+	cas := Cas(time.Now().UnixNano())
+	if cas <= kv.lastCas {
+		cas = kv.lastCas + 1
+	}
+	kv.lastCas = cas
+	return cas
+}
+
+func (kv *standInKV) KeyToVbucket(key []byte) uint16 {
+	return uint16(cbCrc(key) % 1024)
+}
+
+// set performs a non-transactional write of a document body, which discards any xattrs.
+func (kv *standInKV) set(key string, body string) {
+	kv.lock.Lock()
+	defer kv.lock.Unlock()
+
+	var value interface{}
+	if err := json.Unmarshal([]byte(body), &value); err != nil {
+		panic(err)
+	}
+	kv.docs[standInDocID([]byte(key), "", "")] = &standInDoc{
+		body:   value,
+		xattrs: make(map[string]interface{}),
+		cas:    kv.nextCas(),
+	}
+}
+
+// get returns the body of a document and whether it has any transaction metadata.
+func (kv *standInKV) get(key string) (string, bool, bool) {
+	kv.lock.Lock()
+	defer kv.lock.Unlock()
+
+	doc, ok := kv.docs[standInDocID([]byte(key), "", "")]
+	if !ok || doc.deleted {
+		return "", false, false
+	}
+	body, _ := json.Marshal(doc.body)
+	_, hasMeta := doc.xattrs[transactionXattrPath]
+	return string(body), hasMeta, true
+}
+
+func standInFindPath(root interface{}, path string) (interface{}, bool) {
+	for _, part := range strings.Split(path, ".") {
+		obj, ok := root.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		root, ok = obj[part]
+		if !ok {
+			return nil, false
+		}
+	}
+	return root, true
+}
+
+// standInParent returns the object holding the final part of path, along with that part.
+func standInParent(root map[string]interface{}, path string, mkDirP bool) (map[string]interface{}, string, bool) {
+	parts := strings.Split(path, ".")
+	for _, part := range parts[:len(parts)-1] {
+		next, ok := root[part]
+		if !ok {
+			if !mkDirP {
+				return nil, "", false
+			}
+			next = make(map[string]interface{})
+			root[part] = next
+		}
+		root, ok = next.(map[string]interface{})
+		if !ok {
+			return nil, "", false
+		}
+	}
+	return root, parts[len(parts)-1], true
+}
+
+func standInCopy(value interface{}) interface{} {
+	bytes, _ := json.Marshal(value)
+	var out interface{}
+	_ = json.Unmarshal(bytes, &out)
+	return out
+}
+
+func (kv *standInKV) LookupInEx(opts LookupInOptions, cb LookupInExCallback) (PendingOp, error) {
+	kv.lock.Lock()
+	defer kv.lock.Unlock()
+
+	doc, ok := kv.docs[standInDocID(opts.Key, opts.CollectionName, opts.ScopeName)]
+	if !ok || doc.deleted && opts.Flags&SubdocDocFlagAccessDeleted == 0 {
+		go cb(nil, ErrKeyNotFound)
+		return &testPendingOp{}, nil
+	}
+
+	res := &LookupInResult{
+		Cas: doc.cas,
+		Ops: make([]SubDocResult, len(opts.Ops)),
+	}
+	var err error
+	for i, op := range opts.Ops {
+		var value interface{}
+		found := true
+		if op.Op == SubDocOpGetDoc {
+			value = doc.body
+		} else if op.Flags&SubdocFlagXattrPath != 0 {
+			value, found = standInFindPath(map[string]interface{}(doc.xattrs), op.Path)
+		} else {
+			value, found = standInFindPath(doc.body, op.Path)
+		}
+
+		if !found {
+			res.Ops[i].Err = ErrSubDocPathNotFound
+			err = ErrSubDocBadMulti
+			continue
+		}
+		res.Ops[i].Value, _ = json.Marshal(value)
+	}
+	if doc.deleted && err != nil {
+		err = ErrSubDocMultiPathFailureDeleted
+	} else if doc.deleted {
+		err = ErrSubDocSuccessDeleted
+	}
+
+	go cb(res, err)
+	return &testPendingOp{}, nil
+}
+
+func (kv *standInKV) MutateInEx(opts MutateInOptions, cb MutateInExCallback) (PendingOp, error) {
+	kv.lock.Lock()
+	defer kv.lock.Unlock()
+
+	if err := kv.failMutations[string(opts.Key)]; err != nil {
+		go cb(nil, err)
+		return &testPendingOp{}, nil
+	}
+
+	docID := standInDocID(opts.Key, opts.CollectionName, opts.ScopeName)
+	doc, ok := kv.docs[docID]
+	if ok && doc.deleted && opts.Flags&SubdocDocFlagAccessDeleted == 0 {
+		ok = false
+	}
+	if ok && opts.Flags&SubdocDocFlagAddDoc != 0 {
+		go cb(nil, ErrKeyExists)
+		return &testPendingOp{}, nil
+	}
+	if !ok {
+		if opts.Flags&(SubdocDocFlagMkDoc|SubdocDocFlagAddDoc) == 0 {
+			go cb(nil, ErrKeyNotFound)
+			return &testPendingOp{}, nil
+		}
+		doc = &standInDoc{
+			body:    map[string]interface{}{},
+			xattrs:  make(map[string]interface{}),
+			deleted: opts.Flags&SubdocDocFlagCreateAsDeleted != 0,
+		}
+	} else if opts.Cas != 0 && opts.Cas != doc.cas {
+		go cb(nil, ErrKeyExists)
+		return &testPendingOp{}, nil
+	}
+
+	softDeleted := doc.deleted
+	if opts.Flags&SubdocDocFlagReviveDocument != 0 {
+		if !softDeleted {
+			go cb(nil, ErrInvalidArgs)
+			return &testPendingOp{}, nil
+		}
+		softDeleted = false
+	}
+
+	newCas := kv.nextCas()
+	body := standInCopy(doc.body)
+	xattrs := standInCopy(doc.xattrs).(map[string]interface{})
+	deleted := false
+
+	for i, op := range opts.Ops {
+		var value interface{}
+		if op.Value != nil {
+			if err := json.Unmarshal(op.Value, &value); err != nil {
+				go cb(nil, SubDocMutateError{Err: ErrSubDocCantInsert, OpIndex: i})
+				return &testPendingOp{}, nil
+			}
+		}
+		if op.Flags&SubdocFlagExpandMacros != 0 && value == mutationCasMacro {
+			casBytes := make([]byte, 8)
+			binary.LittleEndian.PutUint64(casBytes, uint64(newCas))
+			value = "0x" + hex.EncodeToString(casBytes)
+		}
+
+		switch op.Op {
+		case SubDocOpSetDoc:
+			body = value
+			continue
+		case SubDocOpDeleteDoc:
+			deleted = true
+			continue
+		}
+
+		root := xattrs
+		if op.Flags&SubdocFlagXattrPath == 0 {
+			root, ok = body.(map[string]interface{})
+			if !ok {
+				go cb(nil, SubDocMutateError{Err: ErrSubDocPathMismatch, OpIndex: i})
+				return &testPendingOp{}, nil
+			}
+		}
+
+		parent, name, ok := standInParent(root, op.Path, op.Flags&SubdocFlagMkDirP != 0)
+		if !ok {
+			go cb(nil, SubDocMutateError{Err: ErrSubDocPathNotFound, OpIndex: i})
+			return &testPendingOp{}, nil
+		}
+		existing, exists := parent[name]
+
+		var opErr error
+		switch op.Op {
+		case SubDocOpDictAdd:
+			if exists {
+				opErr = ErrSubDocPathExists
+			} else {
+				parent[name] = value
+			}
+		case SubDocOpDictSet:
+			parent[name] = value
+		case SubDocOpReplace:
+			if !exists {
+				opErr = ErrSubDocPathNotFound
+			} else {
+				parent[name] = value
+			}
+		case SubDocOpDelete:
+			if !exists {
+				opErr = ErrSubDocPathNotFound
+			} else {
+				delete(parent, name)
+			}
+		case SubDocOpArrayPushLast:
+			arr, isArr := existing.([]interface{})
+			if !exists {
+				opErr = ErrSubDocPathNotFound
+			} else if !isArr {
+				opErr = ErrSubDocPathMismatch
+			} else {
+				parent[name] = append(arr, value)
+			}
+		default:
+			opErr = ErrInvalidArgs
+		}
+		if opErr != nil {
+			go cb(nil, SubDocMutateError{Err: opErr, OpIndex: i})
+			return &testPendingOp{}, nil
+		}
+	}
+
+	if deleted {
+		delete(kv.docs, docID)
+	} else {
+		kv.docs[docID] = &standInDoc{
+			body:    body,
+			xattrs:  xattrs,
+			cas:     newCas,
+			deleted: softDeleted,
+		}
+	}
+
+	go cb(&MutateInResult{
+		Cas: newCas,
+		Ops: make([]SubDocResult, len(opts.Ops)),
+	}, nil)
+	return &testPendingOp{}, nil
+}
+
+func (kv *standInKV) numATREntries() int {
+	kv.lock.Lock()
+	defer kv.lock.Unlock()
+
+	numEntries := 0
+	for docID, doc := range kv.docs {
+		if !strings.HasPrefix(docID, "//_txn:atr-") {
+			continue
+		}
+		if attempts, ok := doc.xattrs[transactionATRAttemptsPath].(map[string]interface{}); ok {
+			numEntries += len(attempts)
+		}
+	}
+	return numEntries
+}
+
+func newTestTransactions() (*Transactions, *standInKV) {
+	kv := newStandInKV()
+	return newTransactions(kv, TransactionsConfig{
+		NumATRs: 16,
+	}), kv
+}
+
+func txnWaitErr(t *testing.T, fn func(cb func(error)) error) error {
+	errCh := make(chan error, 1)
+	err := fn(func(err error) {
+		errCh <- err
+	})
+	if err != nil {
+		return err
+	}
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timed out waiting for transaction operation")
+		return nil
+	}
+}
+
+func txnGet(t *testing.T, txn *Transaction, key string) (*TransactionGetResult, error) {
+	var res *TransactionGetResult
+	err := txnWaitErr(t, func(cb func(error)) error {
+		return txn.GetEx(TransactionGetOptions{
+			Key: []byte(key),
+		}, func(r *TransactionGetResult, err error) {
+			res = r
+			cb(err)
+		})
+	})
+	return res, err
+}
+
+func txnInsert(t *testing.T, txn *Transaction, key, value string) error {
+	return txnWaitErr(t, func(cb func(error)) error {
+		return txn.InsertEx(TransactionInsertOptions{
+			Key:   []byte(key),
+			Value: []byte(value),
+		}, func(r *TransactionGetResult, err error) {
+			cb(err)
+		})
+	})
+}
+
+func txnReplace(t *testing.T, txn *Transaction, doc *TransactionGetResult, value string) error {
+	return txnWaitErr(t, func(cb func(error)) error {
+		return txn.ReplaceEx(TransactionReplaceOptions{
+			Document: doc,
+			Value:    []byte(value),
+		}, func(r *TransactionGetResult, err error) {
+			cb(err)
+		})
+	})
+}
+
+func txnRemove(t *testing.T, txn *Transaction, doc *TransactionGetResult) error {
+	return txnWaitErr(t, func(cb func(error)) error {
+		return txn.RemoveEx(TransactionRemoveOptions{
+			Document: doc,
+		}, func(r *TransactionGetResult, err error) {
+			cb(err)
+		})
+	})
+}
+
+func txnCommit(t *testing.T, txn *Transaction) error {
+	return txnWaitErr(t, func(cb func(error)) error {
+		return txn.CommitEx(TransactionCompleteCallback(cb))
+	})
+}
+
+func txnRollback(t *testing.T, txn *Transaction) error {
+	return txnWaitErr(t, func(cb func(error)) error {
+		return txn.RollbackEx(TransactionCompleteCallback(cb))
+	})
+}
+
+func txnCleanup(t *testing.T, txns *Transactions) *TransactionCleanupResult {
+	var res *TransactionCleanupResult
+	err := txnWaitErr(t, func(cb func(error)) error {
+		return txns.CleanupLostEx(func(r *TransactionCleanupResult, err error) {
+			res = r
+			cb(err)
+		})
+	})
+	if err != nil {
+		t.Fatalf("Cleanup failed: %v", err)
+	}
+	return res
+}
+
+func assertStandInDoc(t *testing.T, kv *standInKV, key, expected string) {
+	body, hasMeta, exists := kv.get(key)
+	if expected == "" {
+		if exists {
+			t.Fatalf("Expected %s to not exist but got %s", key, body)
+		}
+		return
+	}
+	if !exists {
+		t.Fatalf("Expected %s to exist", key)
+	}
+	if body != expected {
+		t.Fatalf("Expected %s to be %s but got %s", key, expected, body)
+	}
+	if hasMeta {
+		t.Fatalf("Expected %s to have no staged mutation", key)
+	}
+}
+
+func TestParseMacroCas(t *testing.T) {
+	cas, err := parseMacroCas("0x0100000000000000")
+	if err != nil || cas != Cas(1) {
+		t.Fatalf("Expected cas of 1 but got %d, %v", cas, err)
+	}
+
+	_, err = parseMacroCas("0x01")
+	if err == nil {
+		t.Fatalf("Expected an error for a truncated cas")
+	}
+}
+
+func TestTransactionCommit(t *testing.T) {
+	txns, kv := newTestTransactions()
+	kv.set("replaced", `{"v":1}`)
+	kv.set("removed", `{"v":1}`)
+
+	txn := txns.BeginTransaction(TransactionOptions{})
+	if err := txnInsert(t, txn, "inserted", `{"v":2}`); err != nil {
+		t.Fatalf("Insert failed: %v", err)
+	}
+
+	// A staged insert must not be visible outside of transactions.
+	assertStandInDoc(t, kv, "inserted", "")
+
+	doc, err := txnGet(t, txn, "replaced")
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if err := txnReplace(t, txn, doc, `{"v":2}`); err != nil {
+		t.Fatalf("Replace failed: %v", err)
+	}
+
+	doc, err = txnGet(t, txn, "removed")
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if err := txnRemove(t, txn, doc); err != nil {
+		t.Fatalf("Remove failed: %v", err)
+	}
+
+	// The transaction should read its own writes.
+	doc, err = txnGet(t, txn, "replaced")
+	if err != nil || string(doc.Value) != `{"v":2}` {
+		t.Fatalf("Expected to read own replace but got %v", err)
+	}
+	if _, err = txnGet(t, txn, "removed"); err != ErrKeyNotFound {
+		t.Fatalf("Expected to read own remove but got %v", err)
+	}
+
+	// Other transactions should not see the staged mutations.
+	other := txns.BeginTransaction(TransactionOptions{})
+	doc, err = txnGet(t, other, "replaced")
+	if err != nil || string(doc.Value) != `{"v":1}` {
+		t.Fatalf("Expected staged replace to be invisible but got %v", err)
+	}
+	if _, err = txnGet(t, other, "inserted"); err != ErrKeyNotFound {
+		t.Fatalf("Expected staged insert to be invisible but got %v", err)
+	}
+
+	if err := txnCommit(t, txn); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+
+	assertStandInDoc(t, kv, "inserted", `{"v":2}`)
+	assertStandInDoc(t, kv, "replaced", `{"v":2}`)
+	assertStandInDoc(t, kv, "removed", "")
+	if kv.numATREntries() != 0 {
+		t.Fatalf("Expected the ATR entry to be removed")
+	}
+
+	if err := txnCommit(t, txn); err != ErrTransactionNotActive {
+		t.Fatalf("Expected a second commit to fail but got %v", err)
+	}
+}
+
+func TestTransactionRollback(t *testing.T) {
+	txns, kv := newTestTransactions()
+	kv.set("replaced", `{"v":1}`)
+
+	txn := txns.BeginTransaction(TransactionOptions{})
+	if err := txnInsert(t, txn, "inserted", `{"v":2}`); err != nil {
+		t.Fatalf("Insert failed: %v", err)
+	}
+	doc, err := txnGet(t, txn, "replaced")
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if err := txnReplace(t, txn, doc, `{"v":2}`); err != nil {
+		t.Fatalf("Replace failed: %v", err)
+	}
+
+	if err := txnRollback(t, txn); err != nil {
+		t.Fatalf("Rollback failed: %v", err)
+	}
+
+	assertStandInDoc(t, kv, "inserted", "")
+	assertStandInDoc(t, kv, "replaced", `{"v":1}`)
+	if kv.numATREntries() != 0 {
+		t.Fatalf("Expected the ATR entry to be removed")
+	}
+}
+
+func TestTransactionWriteConflict(t *testing.T) {
+	txns, kv := newTestTransactions()
+	kv.set("doc", `{"v":1}`)
+
+	txn1 := txns.BeginTransaction(TransactionOptions{})
+	doc, err := txnGet(t, txn1, "doc")
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if err := txnReplace(t, txn1, doc, `{"v":2}`); err != nil {
+		t.Fatalf("Replace failed: %v", err)
+	}
+
+	txn2 := txns.BeginTransaction(TransactionOptions{})
+	doc, err = txnGet(t, txn2, "doc")
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if err := txnReplace(t, txn2, doc, `{"v":3}`); err != ErrTransactionWriteConflict {
+		t.Fatalf("Expected a write conflict but got %v", err)
+	}
+	if err := txnInsert(t, txn2, "doc", `{"v":3}`); err != ErrKeyExists {
+		t.Fatalf("Expected key exists but got %v", err)
+	}
+
+	if err := txnCommit(t, txn1); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+
+	// Once committed, the new value is visible and can be modified.
+	doc, err = txnGet(t, txn2, "doc")
+	if err != nil || string(doc.Value) != `{"v":2}` {
+		t.Fatalf("Expected to read the committed value but got %v", err)
+	}
+	if err := txnReplace(t, txn2, doc, `{"v":3}`); err != nil {
+		t.Fatalf("Replace failed: %v", err)
+	}
+	if err := txnCommit(t, txn2); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+
+	assertStandInDoc(t, kv, "doc", `{"v":3}`)
+}
+
+func TestTransactionNonTransactionalWrite(t *testing.T) {
+	txns, kv := newTestTransactions()
+	kv.set("read", `{"v":1}`)
+	kv.set("staged", `{"v":1}`)
+
+	// A write between the read and the staging is detected when staging.
+	txn := txns.BeginTransaction(TransactionOptions{})
+	doc, err := txnGet(t, txn, "read")
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	kv.set("read", `{"v":"external"}`)
+	if err := txnReplace(t, txn, doc, `{"v":2}`); err != ErrTransactionDocumentModified {
+		t.Fatalf("Expected document modified but got %v", err)
+	}
+
+	// A write after the staging is detected when committing.
+	doc, err = txnGet(t, txn, "staged")
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if err := txnReplace(t, txn, doc, `{"v":2}`); err != nil {
+		t.Fatalf("Replace failed: %v", err)
+	}
+	kv.set("staged", `{"v":"external"}`)
+
+	if err := txnCommit(t, txn); err != ErrTransactionDocumentModified {
+		t.Fatalf("Expected document modified but got %v", err)
+	}
+
+	assertStandInDoc(t, kv, "read", `{"v":"external"}`)
+	assertStandInDoc(t, kv, "staged", `{"v":"external"}`)
+	if kv.numATREntries() != 0 {
+		t.Fatalf("Expected the ATR entry to be removed")
+	}
+}
+
+func TestTransactionCleanupLost(t *testing.T) {
+	txns, kv := newTestTransactions()
+	kv.set("pending", `{"v":1}`)
+	kv.set("committed", `{"v":1}`)
+
+	// Stage a mutation and then abandon the transaction.
+	pending := txns.BeginTransaction(TransactionOptions{
+		ExpirationTime: testTransactionExpiry,
+	})
+	doc, err := txnGet(t, pending, "pending")
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if err := txnReplace(t, pending, doc, `{"v":2}`); err != nil {
+		t.Fatalf("Replace failed: %v", err)
+	}
+	if err := txnInsert(t, pending, "pendingInsert", `{"v":2}`); err != nil {
+		t.Fatalf("Insert failed: %v", err)
+	}
+
+	// Commit a transaction but abandon it before it is unstaged.
+	committed := txns.BeginTransaction(TransactionOptions{
+		ExpirationTime: testTransactionExpiry,
+	})
+	doc, err = txnGet(t, committed, "committed")
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if err := txnReplace(t, committed, doc, `{"v":2}`); err != nil {
+		t.Fatalf("Replace failed: %v", err)
+	}
+	err = txnWaitErr(t, func(cb func(error)) error {
+		txns.setATRState(*committed.atr, committed.attemptID, transactionATRStatePending,
+			transactionATRStateCommitted, 0, cb)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to commit ATR entry: %v", err)
+	}
+
+	time.Sleep(2 * testTransactionExpiry)
+
+	res := txnCleanup(t, txns)
+	if res.NumCommitted != 1 || res.NumRolledBack != 1 {
+		t.Fatalf("Expected one commit and one rollback but got %d and %d", res.NumCommitted, res.NumRolledBack)
+	}
+
+	assertStandInDoc(t, kv, "pending", `{"v":1}`)
+	assertStandInDoc(t, kv, "pendingInsert", "")
+	assertStandInDoc(t, kv, "committed", `{"v":2}`)
+	if kv.numATREntries() != 0 {
+		t.Fatalf("Expected the ATR entries to be removed")
+	}
+
+	// The owner of a cleaned up transaction can no longer commit it.
+	if err := txnCommit(t, pending); err != ErrTransactionExpired {
+		t.Fatalf("Expected expired but got %v", err)
+	}
+}
+
+func TestTransactionCleanupIncomplete(t *testing.T) {
+	txns, kv := newTestTransactions()
+	kv.set("committed", `{"v":1}`)
+
+	// Commit a transaction but abandon it before it is unstaged.
+	committed := txns.BeginTransaction(TransactionOptions{
+		ExpirationTime: testTransactionExpiry,
+	})
+	doc, err := txnGet(t, committed, "committed")
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if err := txnReplace(t, committed, doc, `{"v":2}`); err != nil {
+		t.Fatalf("Replace failed: %v", err)
+	}
+	err = txnWaitErr(t, func(cb func(error)) error {
+		txns.setATRState(*committed.atr, committed.attemptID, transactionATRStatePending,
+			transactionATRStateCommitted, 0, cb)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to commit ATR entry: %v", err)
+	}
+
+	time.Sleep(2 * testTransactionExpiry)
+
+	// The document cannot be unstaged, so the attempt must be left for a later cleanup.
+	kv.failMutation("committed", ErrTmpFail)
+	res := txnCleanup(t, txns)
+	if res.NumCommitted != 0 || res.NumIncomplete != 1 {
+		t.Fatalf("Expected one incomplete attempt but got %d committed and %d incomplete",
+			res.NumCommitted, res.NumIncomplete)
+	}
+	if kv.numATREntries() != 1 {
+		t.Fatalf("Expected the ATR entry to be kept")
+	}
+
+	kv.failMutation("committed", nil)
+	res = txnCleanup(t, txns)
+	if res.NumCommitted != 1 || res.NumIncomplete != 0 {
+		t.Fatalf("Expected one commit but got %d committed and %d incomplete",
+			res.NumCommitted, res.NumIncomplete)
+	}
+	assertStandInDoc(t, kv, "committed", `{"v":2}`)
+	if kv.numATREntries() != 0 {
+		t.Fatalf("Expected the ATR entry to be removed")
+	}
+}
+
+func TestTransactionOverwriteLost(t *testing.T) {
+	txns, kv := newTestTransactions()
+	kv.set("doc", `{"v":1}`)
+
+	lost := txns.BeginTransaction(TransactionOptions{
+		ExpirationTime: testTransactionExpiry,
+	})
+	doc, err := txnGet(t, lost, "doc")
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if err := txnReplace(t, lost, doc, `{"v":2}`); err != nil {
+		t.Fatalf("Replace failed: %v", err)
+	}
+	if err := txnInsert(t, lost, "inserted", `{"v":2}`); err != nil {
+		t.Fatalf("Insert failed: %v", err)
+	}
+
+	time.Sleep(2 * testTransactionExpiry)
+
+	// Mutations staged by an expired transaction do not block others.
+	txn := txns.BeginTransaction(TransactionOptions{})
+	doc, err = txnGet(t, txn, "doc")
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if err := txnReplace(t, txn, doc, `{"v":3}`); err != nil {
+		t.Fatalf("Replace failed: %v", err)
+	}
+	if err := txnInsert(t, txn, "inserted", `{"v":3}`); err != nil {
+		t.Fatalf("Insert failed: %v", err)
+	}
+	if err := txnCommit(t, txn); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+
+	// Cleaning up the lost transaction must not undo the newer one.
+	res := txnCleanup(t, txns)
+	if res.NumRolledBack != 1 {
+		t.Fatalf("Expected one rollback but got %d", res.NumRolledBack)
+	}
+
+	assertStandInDoc(t, kv, "doc", `{"v":3}`)
+	assertStandInDoc(t, kv, "inserted", `{"v":3}`)
+}
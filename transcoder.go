@@ -0,0 +1,245 @@
+package gocbcore
+
+import (
+	"encoding/json"
+)
+
+// Transcoder provides an interface for transforming Go values to and from the
+// raw bytes, flags and datatype stored alongside a document.
+type Transcoder interface {
+	// Decode decodes a document value, along with its flags and datatype,
+	// into the value pointed to by out.
+	Decode(value []byte, flags uint32, datatype uint8, out interface{}) error
+
+	// Encode encodes a Go value into the bytes, flags and datatype to store.
+	Encode(value interface{}) ([]byte, uint32, uint8, error)
+}
+
+// JSONTranscoder implements the default transcoding behaviour and stores all
+// values as JSON.  Values which are already JSON encoded may be passed as a
+// []byte or json.RawMessage, in which case they are stored unmodified.
+type JSONTranscoder struct {
+}
+
+// NewJSONTranscoder returns a new JSONTranscoder.
+func NewJSONTranscoder() *JSONTranscoder {
+	return &JSONTranscoder{}
+}
+
+// Decode applies JSON transcoding behaviour to decode into a Go type.
+func (t *JSONTranscoder) Decode(value []byte, flags uint32, datatype uint8, out interface{}) error {
+	valueType, compression := DecodeCommonFlags(flags)
+
+	if compression != NoCompression {
+		return ErrUnsupportedTranscoderFormat
+	}
+
+	if valueType != JsonType {
+		return ErrUnsupportedTranscoderFormat
+	}
+
+	return decodeJSONValue(value, out)
+}
+
+// Encode applies JSON transcoding behaviour to encode a Go type.
+func (t *JSONTranscoder) Encode(value interface{}) ([]byte, uint32, uint8, error) {
+	var bytes []byte
+
+	switch typedValue := value.(type) {
+	case []byte:
+		bytes = typedValue
+	case *[]byte:
+		bytes = *typedValue
+	case json.RawMessage:
+		bytes = typedValue
+	case *json.RawMessage:
+		bytes = *typedValue
+	default:
+		var err error
+		bytes, err = json.Marshal(value)
+		if err != nil {
+			return nil, 0, 0, err
+		}
+	}
+
+	return bytes, EncodeCommonFlags(JsonType, NoCompression), uint8(DatatypeFlagJson), nil
+}
+
+// RawBinaryTranscoder stores and retrieves values as raw binary data.  Only
+// []byte values are supported.
+type RawBinaryTranscoder struct {
+}
+
+// NewRawBinaryTranscoder returns a new RawBinaryTranscoder.
+func NewRawBinaryTranscoder() *RawBinaryTranscoder {
+	return &RawBinaryTranscoder{}
+}
+
+// Decode applies raw binary transcoding behaviour to decode into a Go type.
+func (t *RawBinaryTranscoder) Decode(value []byte, flags uint32, datatype uint8, out interface{}) error {
+	valueType, compression := DecodeCommonFlags(flags)
+
+	if compression != NoCompression {
+		return ErrUnsupportedTranscoderFormat
+	}
+
+	if valueType != BinaryType {
+		return ErrUnsupportedTranscoderFormat
+	}
+
+	return decodeBinaryValue(value, out)
+}
+
+// Encode applies raw binary transcoding behaviour to encode a Go type.
+func (t *RawBinaryTranscoder) Encode(value interface{}) ([]byte, uint32, uint8, error) {
+	var bytes []byte
+
+	switch typedValue := value.(type) {
+	case []byte:
+		bytes = typedValue
+	case *[]byte:
+		bytes = *typedValue
+	default:
+		return nil, 0, 0, ErrInvalidTranscoderValue
+	}
+
+	return bytes, EncodeCommonFlags(BinaryType, NoCompression), 0, nil
+}
+
+// RawStringTranscoder stores and retrieves values as raw string data.  Only
+// string values are supported.
+type RawStringTranscoder struct {
+}
+
+// NewRawStringTranscoder returns a new RawStringTranscoder.
+func NewRawStringTranscoder() *RawStringTranscoder {
+	return &RawStringTranscoder{}
+}
+
+// Decode applies raw string transcoding behaviour to decode into a Go type.
+func (t *RawStringTranscoder) Decode(value []byte, flags uint32, datatype uint8, out interface{}) error {
+	valueType, compression := DecodeCommonFlags(flags)
+
+	if compression != NoCompression {
+		return ErrUnsupportedTranscoderFormat
+	}
+
+	if valueType != StringType {
+		return ErrUnsupportedTranscoderFormat
+	}
+
+	return decodeStringValue(value, out)
+}
+
+// Encode applies raw string transcoding behaviour to encode a Go type.
+func (t *RawStringTranscoder) Encode(value interface{}) ([]byte, uint32, uint8, error) {
+	var bytes []byte
+
+	switch typedValue := value.(type) {
+	case string:
+		bytes = []byte(typedValue)
+	case *string:
+		bytes = []byte(*typedValue)
+	default:
+		return nil, 0, 0, ErrInvalidTranscoderValue
+	}
+
+	return bytes, EncodeCommonFlags(StringType, NoCompression), 0, nil
+}
+
+// LegacyTranscoder implements the behaviour of the transcoders used prior to
+// the introduction of common flags.  []byte values are stored as binary data,
+// string values as string data and all other values as JSON.  When decoding,
+// the format is chosen based on the flags of the document, with documents
+// carrying legacy (zero) flags being treated as JSON.
+type LegacyTranscoder struct {
+}
+
+// NewLegacyTranscoder returns a new LegacyTranscoder.
+func NewLegacyTranscoder() *LegacyTranscoder {
+	return &LegacyTranscoder{}
+}
+
+// Decode applies legacy transcoding behaviour to decode into a Go type.
+func (t *LegacyTranscoder) Decode(value []byte, flags uint32, datatype uint8, out interface{}) error {
+	valueType, compression := DecodeCommonFlags(flags)
+
+	if compression != NoCompression {
+		return ErrUnsupportedTranscoderFormat
+	}
+
+	switch valueType {
+	case BinaryType:
+		return decodeBinaryValue(value, out)
+	case StringType:
+		return decodeStringValue(value, out)
+	case JsonType:
+		return decodeJSONValue(value, out)
+	}
+
+	return ErrUnsupportedTranscoderFormat
+}
+
+// Encode applies legacy transcoding behaviour to encode a Go type.
+func (t *LegacyTranscoder) Encode(value interface{}) ([]byte, uint32, uint8, error) {
+	switch typedValue := value.(type) {
+	case []byte:
+		return typedValue, EncodeCommonFlags(BinaryType, NoCompression), 0, nil
+	case *[]byte:
+		return *typedValue, EncodeCommonFlags(BinaryType, NoCompression), 0, nil
+	case string:
+		return []byte(typedValue), EncodeCommonFlags(StringType, NoCompression), 0, nil
+	case *string:
+		return []byte(*typedValue), EncodeCommonFlags(StringType, NoCompression), 0, nil
+	case json.RawMessage:
+		return typedValue, EncodeCommonFlags(JsonType, NoCompression), uint8(DatatypeFlagJson), nil
+	case *json.RawMessage:
+		return *typedValue, EncodeCommonFlags(JsonType, NoCompression), uint8(DatatypeFlagJson), nil
+	}
+
+	bytes, err := json.Marshal(value)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+
+	return bytes, EncodeCommonFlags(JsonType, NoCompression), uint8(DatatypeFlagJson), nil
+}
+
+func decodeJSONValue(value []byte, out interface{}) error {
+	switch typedOut := out.(type) {
+	case *[]byte:
+		*typedOut = value
+		return nil
+	case *json.RawMessage:
+		*typedOut = value
+		return nil
+	}
+
+	return json.Unmarshal(value, out)
+}
+
+func decodeBinaryValue(value []byte, out interface{}) error {
+	switch typedOut := out.(type) {
+	case *[]byte:
+		*typedOut = value
+		return nil
+	case *interface{}:
+		*typedOut = value
+		return nil
+	}
+
+	return ErrInvalidTranscoderValue
+}
+
+func decodeStringValue(value []byte, out interface{}) error {
+	switch typedOut := out.(type) {
+	case *string:
+		*typedOut = string(value)
+		return nil
+	case *interface{}:
+		*typedOut = string(value)
+		return nil
+	}
+
+	return ErrInvalidTranscoderValue
+}
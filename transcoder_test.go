@@ -0,0 +1,137 @@
+package gocbcore
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestJSONTranscoder(t *testing.T) {
+	tc := NewJSONTranscoder()
+
+	type testDoc struct {
+		Name string `json:"name"`
+	}
+
+	value, flags, datatype, err := tc.Encode(testDoc{Name: "frank"})
+	if err != nil {
+		t.Fatalf("Failed to encode value: %v", err)
+	}
+	if string(value) != `{"name":"frank"}` {
+		t.Fatalf("Unexpected encoded value %s", value)
+	}
+	if flags != EncodeCommonFlags(JsonType, NoCompression) {
+		t.Fatalf("Unexpected flags %x", flags)
+	}
+	if datatype != uint8(DatatypeFlagJson) {
+		t.Fatalf("Expected the JSON datatype flag to be set")
+	}
+
+	var doc testDoc
+	if err := tc.Decode(value, flags, datatype, &doc); err != nil {
+		t.Fatalf("Failed to decode value: %v", err)
+	}
+	if doc.Name != "frank" {
+		t.Fatalf("Decoded the wrong value")
+	}
+
+	// Legacy flags are treated as JSON
+	if err := tc.Decode(value, 0, 0, &doc); err != nil {
+		t.Fatalf("Failed to decode value with legacy flags: %v", err)
+	}
+
+	// Pre-encoded JSON is stored unmodified
+	raw := []byte(`{"a":1}`)
+	value, _, _, err = tc.Encode(raw)
+	if err != nil || !bytes.Equal(value, raw) {
+		t.Fatalf("Expected raw JSON bytes to be stored unmodified")
+	}
+
+	if err := tc.Decode(value, EncodeCommonFlags(BinaryType, NoCompression), 0, &doc); err != ErrUnsupportedTranscoderFormat {
+		t.Fatalf("Expected unsupported format error but got %v", err)
+	}
+}
+
+func TestRawBinaryTranscoder(t *testing.T) {
+	tc := NewRawBinaryTranscoder()
+
+	value, flags, datatype, err := tc.Encode([]byte{0x01, 0x02})
+	if err != nil {
+		t.Fatalf("Failed to encode value: %v", err)
+	}
+	if flags != EncodeCommonFlags(BinaryType, NoCompression) || datatype != 0 {
+		t.Fatalf("Unexpected flags %x or datatype %x", flags, datatype)
+	}
+
+	var out []byte
+	if err := tc.Decode(value, flags, datatype, &out); err != nil {
+		t.Fatalf("Failed to decode value: %v", err)
+	}
+	if !bytes.Equal(out, []byte{0x01, 0x02}) {
+		t.Fatalf("Decoded the wrong value")
+	}
+
+	if _, _, _, err := tc.Encode("string"); err != ErrInvalidTranscoderValue {
+		t.Fatalf("Expected invalid value error but got %v", err)
+	}
+}
+
+func TestRawStringTranscoder(t *testing.T) {
+	tc := NewRawStringTranscoder()
+
+	value, flags, datatype, err := tc.Encode("hello")
+	if err != nil {
+		t.Fatalf("Failed to encode value: %v", err)
+	}
+	if flags != EncodeCommonFlags(StringType, NoCompression) || datatype != 0 {
+		t.Fatalf("Unexpected flags %x or datatype %x", flags, datatype)
+	}
+
+	var out string
+	if err := tc.Decode(value, flags, datatype, &out); err != nil {
+		t.Fatalf("Failed to decode value: %v", err)
+	}
+	if out != "hello" {
+		t.Fatalf("Decoded the wrong value")
+	}
+
+	if _, _, _, err := tc.Encode([]byte("hello")); err != ErrInvalidTranscoderValue {
+		t.Fatalf("Expected invalid value error but got %v", err)
+	}
+}
+
+func TestLegacyTranscoder(t *testing.T) {
+	tc := NewLegacyTranscoder()
+
+	_, flags, _, _ := tc.Encode([]byte("bin"))
+	if flags != EncodeCommonFlags(BinaryType, NoCompression) {
+		t.Fatalf("Expected []byte to be encoded as binary")
+	}
+	_, flags, _, _ = tc.Encode("str")
+	if flags != EncodeCommonFlags(StringType, NoCompression) {
+		t.Fatalf("Expected string to be encoded as string")
+	}
+	_, flags, datatype, _ := tc.Encode(map[string]int{"a": 1})
+	if flags != EncodeCommonFlags(JsonType, NoCompression) || datatype != uint8(DatatypeFlagJson) {
+		t.Fatalf("Expected other values to be encoded as JSON")
+	}
+
+	var out interface{}
+	if err := tc.Decode([]byte("str"), EncodeCommonFlags(StringType, NoCompression), 0, &out); err != nil {
+		t.Fatalf("Failed to decode value: %v", err)
+	}
+	if out != "str" {
+		t.Fatalf("Decoded the wrong value")
+	}
+
+	var doc map[string]int
+	if err := tc.Decode([]byte(`{"a":1}`), 0, 0, &doc); err != nil {
+		t.Fatalf("Failed to decode legacy JSON value: %v", err)
+	}
+	if doc["a"] != 1 {
+		t.Fatalf("Decoded the wrong value")
+	}
+
+	if err := tc.Decode([]byte("x"), 0x1234, 0, &out); err != ErrUnsupportedTranscoderFormat {
+		t.Fatalf("Expected unsupported format error but got %v", err)
+	}
+}
@@ -1,5 +1,30 @@
 package gocbcore
 
+// VbucketMapType specifies which of the vbucket maps in a cluster configuration was
+// used to route a request.
+type VbucketMapType uint8
+
+const (
+	// VbucketMapCurrent indicates the map of the current owners of the vbuckets.
+	VbucketMapCurrent = VbucketMapType(0)
+
+	// VbucketMapForward indicates the fast-forward map, which describes the owners the
+	// vbuckets will have once an in-progress rebalance has completed.
+	VbucketMapForward = VbucketMapType(1)
+)
+
+// String returns a human readable name for the vbucket map type.
+func (mapType VbucketMapType) String() string {
+	switch mapType {
+	case VbucketMapCurrent:
+		return "Current"
+	case VbucketMapForward:
+		return "Forward"
+	}
+
+	return "Unknown"
+}
+
 type vbucketMap struct {
 	entries     [][]int
 	numReplicas int